@@ -1,6 +1,9 @@
 package server
 
-import "github.com/daptin/daptin/server/resource"
+import (
+	"github.com/daptin/daptin/server/resource"
+	log "github.com/sirupsen/logrus"
+)
 
 func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.ConfigStore) []resource.ActionPerformerInterface {
 	performers := make([]resource.ActionPerformerInterface, 0)
@@ -33,6 +36,46 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create generate jwt performer")
 	performers = append(performers, generateJwtPerformer)
 
+	otpLoginVerifyPerformer, err := resource.NewOtpLoginVerifyActionPerformer(configStore, cruds)
+	resource.CheckErr(err, "Failed to create otp login verify performer")
+	performers = append(performers, otpLoginVerifyPerformer)
+
+	otpGeneratePerformer, err := resource.NewOtpGenerateActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create otp generate performer")
+	performers = append(performers, otpGeneratePerformer)
+
+	otpVerifyPerformer, err := resource.NewOtpVerifyActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create otp verify performer")
+	performers = append(performers, otpVerifyPerformer)
+
+	otpDisablePerformer, err := resource.NewOtpDisableActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create otp disable performer")
+	performers = append(performers, otpDisablePerformer)
+
+	accountUnlockPerformer, err := resource.NewAccountUnlockActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create account unlock performer")
+	performers = append(performers, accountUnlockPerformer)
+
+	userGroupInvitePerformer, err := resource.NewUserGroupInviteActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create usergroup invite performer")
+	performers = append(performers, userGroupInvitePerformer)
+
+	invitationRespondPerformer, err := resource.NewUserGroupInvitationRespondActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create usergroup invitation respond performer")
+	performers = append(performers, invitationRespondPerformer)
+
+	memberRolePerformer, err := resource.NewUserGroupMemberRoleActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create usergroup member role performer")
+	performers = append(performers, memberRolePerformer)
+
+	userGroupLeavePerformer, err := resource.NewUserGroupLeaveActionPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create usergroup leave performer")
+	performers = append(performers, userGroupLeavePerformer)
+
+	impersonateUserPerformer, err := resource.NewImpersonateUserActionPerformer(configStore, cruds)
+	resource.CheckErr(err, "Failed to create impersonate user performer")
+	performers = append(performers, impersonateUserPerformer)
+
 	NewNetworkRequestPerformer, err := resource.NewNetworkRequestPerformer(initConfig, cruds)
 	resource.CheckErr(err, "Failed to create generate network request performer")
 	performers = append(performers, NewNetworkRequestPerformer)
@@ -61,5 +104,40 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create restart performer")
 	performers = append(performers, fileUploadPerformer)
 
+	replayPerformer, err := resource.NewActionReplayPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create action replay performer")
+	performers = append(performers, replayPerformer)
+
+	exchangeRedrivePerformer, err := resource.NewExchangeRedrivePerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create exchange redrive performer")
+	performers = append(performers, exchangeRedrivePerformer)
+
+	exchangeDeliveriesPerformer, err := resource.NewExchangeDeliveriesPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create exchange deliveries performer")
+	performers = append(performers, exchangeDeliveriesPerformer)
+
+	names := make(map[string]bool)
+	for _, performer := range performers {
+		if performer != nil {
+			names[performer.Name()] = true
+		}
+	}
+
+	for _, scriptedPerformer := range initConfig.ScriptedPerformers {
+		if names[scriptedPerformer.Name] {
+			log.Errorf("Scripted performer [%v] has the name of another performer, it is left out", scriptedPerformer.Name)
+			continue
+		}
+		scriptPerformer, err := resource.NewScriptActionPerformer(scriptedPerformer, cruds)
+		if err != nil {
+			log.Errorf("Failed to create scripted performer [%v]: %v", scriptedPerformer.Name, err)
+			continue
+		}
+		names[scriptedPerformer.Name] = true
+		performers = append(performers, scriptPerformer)
+	}
+
+	replayPerformer.SetActionPerformers(performers)
+
 	return performers
 }
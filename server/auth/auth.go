@@ -185,6 +185,7 @@ type AuthMiddleWare struct {
 	userCrud          api2go.CRUD
 	userGroupCrud     api2go.CRUD
 	userUserGroupCrud api2go.CRUD
+	isAdmin           func(userReferenceId string) bool
 }
 
 func NewAuthMiddlewareBuilder(db *sqlx.DB) *AuthMiddleWare {
@@ -205,6 +206,12 @@ func (a *AuthMiddleWare) SetUserUserGroupCrud(curd api2go.CRUD) {
 	a.userUserGroupCrud = curd
 }
 
+// SetAdminCheck sets how the administrator is recognised, impersonation tokens are only honoured while the
+// impersonator is still the administrator
+func (a *AuthMiddleWare) SetAdminCheck(isAdmin func(userReferenceId string) bool) {
+	a.isAdmin = isAdmin
+}
+
 func NewAuthMiddleware(db *sqlx.DB, userCrud api2go.CRUD, userGroupCrud api2go.CRUD, userUserGroupCrud api2go.CRUD) *AuthMiddleWare {
 	return &AuthMiddleWare{
 		db:                db,
@@ -354,6 +361,32 @@ func (a *AuthMiddleWare) AuthCheckMiddleware(c *gin.Context) {
 				UserReferenceId: referenceId,
 				Groups:          userGroups,
 			}
+
+			// a token issued by the impersonate_user action acts as the user in the email claim, the
+			// administrator who asked for it is kept along so writes can be attributed to them
+			impersonatorEmail, ok := userToken.Claims.(jwt.MapClaims)["impersonator"].(string)
+			if ok && impersonatorEmail != "" {
+				impersonator := SessionUser{}
+				err = a.db.QueryRowx("select u.id, u.reference_id from user u where email = ?", impersonatorEmail).
+					Scan(&impersonator.UserId, &impersonator.UserReferenceId)
+				if err != nil {
+					log.Errorf("Failed to load impersonator [%v]: %v", impersonatorEmail, err)
+					c.AbortWithStatus(401)
+					return
+				}
+				if a.isAdmin == nil || !a.isAdmin(impersonator.UserReferenceId) {
+					log.Infof("Refusing impersonation token of [%v], who is no longer the administrator", impersonatorEmail)
+					c.AbortWithStatus(401)
+					return
+				}
+				user.Impersonator = &impersonator
+			}
+
+			tenant, ok := userToken.Claims.(jwt.MapClaims)["tenant"].(string)
+			if ok {
+				user.Tenant = tenant
+			}
+
 			ct := c.Request.Context()
 			ct = context.WithValue(ct, "user", user)
 			newRequest := c.Request.WithContext(ct)
@@ -369,6 +402,10 @@ type SessionUser struct {
 	UserId          int64
 	UserReferenceId string
 	Groups          []GroupPermission
+	// set when an administrator is impersonating this user
+	Impersonator *SessionUser
+	// reference id of the tenant the token was issued for
+	Tenant string
 }
 
 type GroupPermission struct {
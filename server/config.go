@@ -15,7 +15,7 @@ import (
 func CreateConfigHandler(configStore *resource.ConfigStore) func(context *gin.Context) {
 
 	return func(c *gin.Context) {
-		webConfig := configStore.GetWebConfig()
+		webConfig := configStore.GetWebConfigForTenant(resource.TenantFromRequest(api2go.Request{PlainRequest: c.Request}))
 		c.JSON(200, webConfig)
 	}
 }
@@ -34,6 +34,7 @@ func loadConfigFiles() (resource.CmsConfig, []error) {
 		StateMachineDescriptions: make([]resource.LoopbookFsmDescription, 0),
 		Streams:                  make([]resource.StreamContract, 0),
 		Marketplaces:             make([]resource.Marketplace, 0),
+		Triggers:                 make([]resource.Trigger, 0),
 	}
 
 	globalInitConfig.Tables = append(globalInitConfig.Tables, resource.StandardTables...)
@@ -42,6 +43,7 @@ func loadConfigFiles() (resource.CmsConfig, []error) {
 	globalInitConfig.Marketplaces = append(globalInitConfig.Marketplaces, resource.StandardMarketplaces...)
 	globalInitConfig.StateMachineDescriptions = append(globalInitConfig.StateMachineDescriptions, resource.SystemSmds...)
 	globalInitConfig.ExchangeContracts = append(globalInitConfig.ExchangeContracts, resource.SystemExchanges...)
+	globalInitConfig.RestExchanges = append(globalInitConfig.RestExchanges, resource.StandardRestExchanges...)
 
 	files, err := filepath.Glob("schema_*.*")
 	log.Infof("Found files to load: %v", files)
@@ -88,6 +90,9 @@ func loadConfigFiles() (resource.CmsConfig, []error) {
 		globalInitConfig.Actions = append(globalInitConfig.Actions, initConfig.Actions...)
 		globalInitConfig.StateMachineDescriptions = append(globalInitConfig.StateMachineDescriptions, initConfig.StateMachineDescriptions...)
 		globalInitConfig.ExchangeContracts = append(globalInitConfig.ExchangeContracts, initConfig.ExchangeContracts...)
+		globalInitConfig.RestExchanges = append(globalInitConfig.RestExchanges, initConfig.RestExchanges...)
+		globalInitConfig.Triggers = append(globalInitConfig.Triggers, initConfig.Triggers...)
+		globalInitConfig.ScriptedPerformers = append(globalInitConfig.ScriptedPerformers, initConfig.ScriptedPerformers...)
 
 		for _, table := range initConfig.Tables {
 			log.Infof("Table: %v: %v", table.TableName, table.Columns)
@@ -110,6 +115,8 @@ func loadConfigFiles() (resource.CmsConfig, []error) {
 	}
 
 	globalInitConfig.Validator = validator.New()
+	globalInitConfig.Validator.RegisterValidation("cron", resource.ValidateCronExpression)
+	globalInitConfig.Validator.RegisterValidation("public_url", resource.ValidatePublicUrl)
 	return globalInitConfig, errs
 
 }
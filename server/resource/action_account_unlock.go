@@ -0,0 +1,67 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// AccountUnlockActionPerformer lets the administrator clear the failed sign in count and lock on an account,
+// and optionally the block on a client ip
+type AccountUnlockActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *AccountUnlockActionPerformer) Name() string {
+	return "account.unlock"
+}
+
+func (d *AccountUnlockActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	u, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok || !d.cruds["user"].IsAdmin(u["reference_id"].(string)) {
+		return nil, []error{errors.New("Only the administrator can unlock accounts")}
+	}
+
+	account, ok := inFieldMap["account"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("No account to unlock")}
+	}
+
+	accountId, err := d.cruds["user"].GetReferenceIdToId("user", account["reference_id"].(string))
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	err = d.cruds["user"].ResetLoginFailures(int64(accountId))
+	if err != nil {
+		log.Errorf("Failed to unlock account [%v]: %v", account["email"], err)
+		return nil, []error{err}
+	}
+
+	payload := map[string]interface{}{
+		"email":       account["email"],
+		"unlocked_by": u["email"],
+	}
+
+	clientIp, _ := inFieldMap["client_ip"].(string)
+	if clientIp != "" {
+		ipLoginThrottle.Reset(clientIp)
+		payload["client_ip"] = clientIp
+	}
+
+	d.cruds["user"].AddTimelineEvent("user", "login.account.unlocked", "Account unlocked", payload)
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Account unlocked", "Success")),
+	}, nil
+}
+
+func NewAccountUnlockActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := AccountUnlockActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,344 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"strings"
+)
+
+// ActionPlan is the result of a dry run of an action, what each outcome would do. Nothing is written and
+// no performer is run.
+type ActionPlan struct {
+	DryRun bool
+	// false when any outcome has a problem, the action would fail or stop there
+	Valid    bool
+	Outcomes []PlannedOutcome
+}
+
+// PlannedOutcome is a single run of an outcome in a dry run, an outcome with ForEach has one for every item
+type PlannedOutcome struct {
+	Type      string
+	Method    string
+	Reference string `json:",omitempty"`
+	// the item of the ForEach list this run is for
+	Index *int `json:",omitempty"`
+	// the Condition of the outcome is not true, it would not run
+	Skipped bool `json:",omitempty"`
+	// the row to create or the values to change, the performer input or the response to send
+	Attributes interface{} `json:",omitempty"`
+	// the current values of the columns an update changes
+	Previous map[string]interface{} `json:",omitempty"`
+	Problems []string               `json:",omitempty"`
+}
+
+// PlanActionOutcomes builds the outcomes of an action like ExecuteActionOutcomes does, and checks them
+// instead of running them: the table and columns of the rows to create, the rows to update and delete, the
+// permissions of the user on them and the performers to execute. The rows a create would return are made
+// up for the next outcomes, the results of performers are not known. Every outcome is planned, also after
+// one with a problem.
+func PlanActionOutcomes(ctx context.Context, action Action, inFieldMap map[string]interface{}, cruds map[string]*DbResource, actionHandlerMap map[string]ActionPerformerInterface) ActionPlan {
+
+	plan := ActionPlan{
+		DryRun:   true,
+		Valid:    true,
+		Outcomes: make([]PlannedOutcome, 0),
+	}
+	// rows created by earlier outcomes of the plan, by type and reference id
+	plannedRows := make(map[string]bool)
+
+	for _, outcome := range action.OutFields {
+
+		items, isLoop, err := outcomeItems(outcome, inFieldMap)
+		if err != nil {
+			plan.add(PlannedOutcome{
+				Type:      outcome.Type,
+				Method:    outcome.Method,
+				Reference: outcome.Reference,
+				Problems:  []string{"Failed to evaluate ForEach: " + err.Error()},
+			})
+			continue
+		}
+
+		previousItem, hadItem := inFieldMap["item"]
+		previousIndex, hadIndex := inFieldMap["index"]
+		results := make([]interface{}, 0)
+
+		for index, item := range items {
+
+			planned := PlannedOutcome{
+				Type:      outcome.Type,
+				Method:    outcome.Method,
+				Reference: outcome.Reference,
+			}
+
+			if isLoop {
+				inFieldMap["item"] = item
+				inFieldMap["index"] = index
+				itemIndex := index
+				planned.Index = &itemIndex
+			}
+
+			run, err := outcomeConditionHolds(outcome, inFieldMap)
+			if err != nil {
+				planned.Problems = append(planned.Problems, "Failed to evaluate Condition: "+err.Error())
+				plan.add(planned)
+				continue
+			}
+			if !run {
+				planned.Skipped = true
+				plan.add(planned)
+				continue
+			}
+
+			result := planOutcome(ctx, &planned, outcome, inFieldMap, cruds, actionHandlerMap, plannedRows)
+			plan.add(planned)
+
+			if !isLoop {
+				setOutcomeResult(inFieldMap, outcome.Reference, result)
+			} else if result != nil {
+				results = append(results, result)
+			}
+		}
+
+		if isLoop {
+			restoreInField(inFieldMap, "item", previousItem, hadItem)
+			restoreInField(inFieldMap, "index", previousIndex, hadIndex)
+			setOutcomeResult(inFieldMap, outcome.Reference, results)
+		}
+	}
+
+	return plan
+}
+
+func (plan *ActionPlan) add(planned PlannedOutcome) {
+	if len(planned.Problems) > 0 {
+		plan.Valid = false
+	}
+	plan.Outcomes = append(plan.Outcomes, planned)
+}
+
+// planOutcome builds and checks a single outcome, it returns the result the outcome would have for the next
+// outcomes when it is known
+func planOutcome(ctx context.Context, planned *PlannedOutcome, outcome Outcome, inFieldMap map[string]interface{}, cruds map[string]*DbResource, actionHandlerMap map[string]ActionPerformerInterface, plannedRows map[string]bool) interface{} {
+
+	// building the schema update outcome writes the uploaded files
+	if outcome.Type == "system_json_schema_update" {
+		attrs, err := buildActionContext(outcome.Attributes, inFieldMap)
+		if err != nil {
+			planned.Problems = append(planned.Problems, "Failed to build outcome: "+err.Error())
+			return nil
+		}
+		planned.Attributes = sanitizeExecutionValue("", attrs)
+		return nil
+	}
+
+	model, _, err := BuildOutcome(inFieldMap, outcome)
+	if err != nil {
+		planned.Problems = append(planned.Problems, "Failed to build outcome: "+err.Error())
+		return nil
+	}
+	planned.Attributes = sanitizeExecutionValue("", model.Data)
+
+	sessionUser, _ := ctx.Value("user").(auth.SessionUser)
+
+	switch outcome.Method {
+	case "POST", "UPDATE", "DELETE":
+		dbResource, ok := cruds[outcome.Type]
+		if !ok {
+			planned.Problems = append(planned.Problems, "No such table "+outcome.Type)
+			return nil
+		}
+
+		method := map[string]string{"POST": "POST", "UPDATE": "PATCH", "DELETE": "DELETE"}[outcome.Method]
+		if !dbResource.canOnTable(sessionUser, method) {
+			planned.Problems = append(planned.Problems, fmt.Sprintf("Not allowed to %v %v", strings.ToLower(outcome.Method), outcome.Type))
+		}
+
+		if outcome.Method == "POST" {
+			planned.Problems = append(planned.Problems, dbResource.checkPlannedValues(ctx, model.Data, true, plannedRows)...)
+			row := make(map[string]interface{})
+			for key, value := range model.Data {
+				row[key] = value
+			}
+			row["reference_id"] = uuid.NewV4().String()
+			plannedRows[outcome.Type+":"+row["reference_id"].(string)] = true
+			return row
+		}
+
+		referenceId, _ := model.Data["reference_id"].(string)
+		if referenceId == "" {
+			planned.Problems = append(planned.Problems, "No reference_id of the row to "+strings.ToLower(outcome.Method))
+			return nil
+		}
+		if plannedRows[outcome.Type+":"+referenceId] {
+			// created by an earlier outcome of the plan
+			return model.Data
+		}
+
+		// a row which is not there, in another tenant or not to be changed by the user gets the same answer,
+		// so a dry run does not tell which rows exist
+		existing, err := dbResource.GetReferenceIdToObjectInContext(ctx, outcome.Type, referenceId)
+		if err != nil || existing == nil || !dbResource.canOnRow(sessionUser, method, existing) {
+			planned.Problems = append(planned.Problems, fmt.Sprintf("Cannot %v [%v][%v]", strings.ToLower(outcome.Method), outcome.Type, referenceId))
+			return nil
+		}
+
+		if outcome.Method == "DELETE" {
+			return nil
+		}
+
+		planned.Problems = append(planned.Problems, dbResource.checkPlannedValues(ctx, model.Data, false, plannedRows)...)
+		updated := make(map[string]interface{})
+		for key, value := range existing {
+			updated[key] = value
+		}
+		for key, value := range model.Data {
+			updated[key] = value
+		}
+
+		// the previous values are only the columns the user can read, as a read through the api returns them
+		readable, err := dbResource.FindOne(referenceId, scriptRequest(ctx, "GET", nil))
+		if err != nil || readable == nil || readable.Result() == nil {
+			return updated
+		}
+		previous := readable.Result().(*api2go.Api2GoModel).Data
+		planned.Previous = make(map[string]interface{})
+		for key := range model.Data {
+			if previousValue, ok := previous[key]; ok && key != "reference_id" {
+				planned.Previous[key] = sanitizeExecutionValue(key, previousValue)
+			}
+		}
+		return updated
+
+	case "EXECUTE":
+		if _, ok := actionHandlerMap[model.GetName()]; !ok {
+			planned.Problems = append(planned.Problems, "No such performer "+model.GetName())
+		}
+
+	case "ACTIONRESPONSE":
+
+	default:
+		planned.Problems = append(planned.Problems, "Unknown outcome method "+outcome.Method)
+	}
+
+	return nil
+}
+
+// canOnTable checks the permission of the user on the table for the request method, like
+// TableAccessPermissionChecker
+func (dr *DbResource) canOnTable(sessionUser auth.SessionUser, method string) bool {
+
+	tableName := dr.model.GetName()
+	tableOwnership := dr.GetObjectPermissionByWhereClause("world", "table_name", tableName)
+	roles := dr.GetRolePermissions(sessionUser)
+
+	switch method {
+	case "POST":
+		return tableOwnership.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.CreateStrict)
+	case "PATCH":
+		return tableOwnership.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.UpdateStrict)
+	case "DELETE":
+		return tableOwnership.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.DeleteStrict)
+	}
+	return false
+}
+
+// canOnRow checks the permission of the user on the row for the request method, like
+// ObjectAccessPermissionChecker
+func (dr *DbResource) canOnRow(sessionUser auth.SessionUser, method string, row map[string]interface{}) bool {
+
+	tableName := dr.model.GetName()
+	permission := dr.GetRowPermission(row)
+	roles := dr.GetRolePermissions(sessionUser)
+
+	switch method {
+	case "PATCH":
+		return permission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.UpdateStrict)
+	case "DELETE":
+		return permission.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.DeleteStrict)
+	}
+	return false
+}
+
+// checkPlannedValues checks the values of a row to create or update against the columns of the table: the
+// rows referred to exist and can be referred to, the values are valid for the column type and, for a new
+// row, the columns without a default are set
+func (dr *DbResource) checkPlannedValues(ctx context.Context, values map[string]interface{}, isNew bool, plannedRows map[string]bool) []string {
+
+	problems := make([]string, 0)
+	sessionUser, _ := ctx.Value("user").(auth.SessionUser)
+	req := api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: "GET",
+		}).WithContext(ctx),
+	}
+
+	for _, col := range dr.model.GetColumns() {
+
+		if dr.isGeneratedColumn(col) {
+			continue
+		}
+
+		val, ok := values[col.ColumnName]
+		if !ok || val == nil {
+			if isNew && !col.IsNullable && col.DefaultValue == "" {
+				problems = append(problems, col.ColumnName+" is required")
+			}
+			continue
+		}
+
+		if col.IsForeignKey {
+			referenceId, _ := val.(string)
+			foreignTable := col.ForeignKeyData.TableName
+			if referenceId == "" || plannedRows[foreignTable+":"+referenceId] {
+				continue
+			}
+			referred, err := dr.GetReferenceIdToObjectInContext(ctx, foreignTable, referenceId)
+			if err != nil || referred == nil || !dr.isReferableInTenant(foreignTable, referenceId, req) ||
+				!dr.GetObjectPermission(foreignTable, referenceId).CanRefer(sessionUser.UserReferenceId, sessionUser.Groups) {
+				problems = append(problems, fmt.Sprintf("%v: cannot refer to [%v][%v]", col.ColumnName, foreignTable, referenceId))
+			}
+			continue
+		}
+
+		switch val.(type) {
+		case string, float64, bool:
+		case []interface{}, map[string]interface{}:
+			if !strings.HasPrefix(col.ColumnType, "file") {
+				continue
+			}
+		default:
+			continue
+		}
+
+		_, err := convertInputValue(val, col)
+		if err != nil {
+			problems = append(problems, col.ColumnName+" "+err.Error())
+		}
+	}
+
+	return problems
+}
+
+// isGeneratedColumn is true for the columns Create sets itself, whatever the values given
+func (dr *DbResource) isGeneratedColumn(col api2go.ColumnInfo) bool {
+
+	if col.IsAutoIncrement {
+		return true
+	}
+
+	switch col.ColumnName {
+	case "created_at", "updated_at", "reference_id", "permission":
+		return true
+	case "user_id":
+		return dr.model.GetName() != "user_user_id_has_usergroup_usergroup_id"
+	case TenantColumnName:
+		return dr.tenantScoped
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"reflect"
+	"testing"
+)
+
+var testDryRunAction = Action{
+	Name:   "rename",
+	OnType: "note",
+	OutFields: []Outcome{
+		{
+			Type:       "note",
+			Method:     "UPDATE",
+			Attributes: map[string]interface{}{"reference_id": "~note_id", "title": "~title"},
+		},
+	},
+}
+
+func TestDryRunShowsTheSameProblemForMissingAndForbiddenRows(t *testing.T) {
+
+	dr := newTenantTestResource(t)
+	defer dr.db.Close()
+
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "bob", "email": "bob@example.com", "reference_id": "user-3"})
+	insertTestRow(t, dr.db, "world", map[string]interface{}{
+		"table_name":        "note",
+		"world_schema_json": "{}",
+		"permission":        auth.NewPermission(auth.CRUD|auth.Execute, auth.CRUD|auth.Execute, auth.CRUD|auth.Execute).IntValue(),
+	})
+	_, err := dr.db.Exec("update note set user_id = 2")
+	if err != nil {
+		t.Fatalf("Failed to set the owner of the notes: %v", err)
+	}
+
+	tenant := &Tenant{Id: 1, Name: "first"}
+	owner := auth.SessionUser{UserId: 2, UserReferenceId: "user-2"}
+	other := auth.SessionUser{UserId: 3, UserReferenceId: "user-3"}
+
+	plan := PlanActionOutcomes(NewTenantContext(owner, tenant), testDryRunAction, map[string]interface{}{"note_id": "note-1", "title": "renamed"}, dr.cruds, nil)
+	if !plan.Valid || len(plan.Outcomes) != 1 {
+		t.Fatalf("Expected the owner to be allowed to rename the note, got %v", plan)
+	}
+	if !reflect.DeepEqual(plan.Outcomes[0].Previous, map[string]interface{}{"title": "first"}) {
+		t.Errorf("Expected the current title, got %v", plan.Outcomes[0].Previous)
+	}
+
+	cases := []struct {
+		name     string
+		user     auth.SessionUser
+		noteId   string
+		expected string
+	}{
+		{"row which does not exist", owner, "note-9", "Cannot update [note][note-9]"},
+		{"row of another tenant", owner, "note-2", "Cannot update [note][note-2]"},
+		{"row of another user", other, "note-1", "Cannot update [note][note-1]"},
+	}
+	for _, c := range cases {
+		plan := PlanActionOutcomes(NewTenantContext(c.user, tenant), testDryRunAction, map[string]interface{}{"note_id": c.noteId, "title": "renamed"}, dr.cruds, nil)
+		if plan.Valid || len(plan.Outcomes) != 1 {
+			t.Errorf("[%v]: expected a problem, got %v", c.name, plan)
+			continue
+		}
+		planned := plan.Outcomes[0]
+		if !reflect.DeepEqual(planned.Problems, []string{c.expected}) {
+			t.Errorf("[%v]: expected %v, got %v", c.name, c.expected, planned.Problems)
+		}
+		if planned.Previous != nil {
+			t.Errorf("[%v]: expected no current values, got %v", c.name, planned.Previous)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ExchangeDeliveriesPerformer shows how the deliveries of an exchange are doing: the number of deliveries in
+// the outbox by status and the latest dead letters with their errors. Only the administrator can see them.
+type ExchangeDeliveriesPerformer struct {
+	cruds  map[string]*DbResource
+	outbox *ExchangeOutbox
+}
+
+func (d *ExchangeDeliveriesPerformer) Name() string {
+	return "exchange.deliveries"
+}
+
+func (d *ExchangeDeliveriesPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	admin, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok || !d.cruds["user"].IsAdmin(admin["reference_id"].(string)) {
+		return nil, []error{errors.New("Only the administrator can see exchange deliveries")}
+	}
+
+	exchange, ok := inFieldMap["exchange"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("No exchange to show the deliveries of")}
+	}
+	exchangeName, _ := exchange["name"].(string)
+
+	status, err := d.outbox.Status(exchangeName)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return []ActionResponse{
+		NewActionResponse("exchange.deliveries", status),
+	}, nil
+}
+
+func NewExchangeDeliveriesPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (*ExchangeDeliveriesPerformer, error) {
+
+	handler := ExchangeDeliveriesPerformer{
+		cruds:  cruds,
+		outbox: NewExchangeOutbox(initConfig, &cruds),
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExchangeRedrivePerformer queues failed exchange deliveries again, a single dead letter or all the dead
+// letters of an exchange. Only the administrator can re-drive deliveries.
+type ExchangeRedrivePerformer struct {
+	cruds  map[string]*DbResource
+	outbox *ExchangeOutbox
+}
+
+func (d *ExchangeRedrivePerformer) Name() string {
+	return "exchange.redrive"
+}
+
+func (d *ExchangeRedrivePerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	admin, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok || !d.cruds["user"].IsAdmin(admin["reference_id"].(string)) {
+		return nil, []error{errors.New("Only the administrator can re-drive exchange deliveries")}
+	}
+
+	deadLetterIds := make([]string, 0)
+	exchangeName := ""
+
+	if deadLetter, ok := inFieldMap["dead_letter"].(map[string]interface{}); ok {
+		deadLetterIds = append(deadLetterIds, deadLetter["reference_id"].(string))
+		exchangeName, _ = deadLetter["exchange_name"].(string)
+	} else if exchange, ok := inFieldMap["exchange"].(map[string]interface{}); ok {
+		exchangeName, _ = exchange["name"].(string)
+		var err error
+		deadLetterIds, err = d.outbox.DeadLetters(exchangeName)
+		if err != nil {
+			return nil, []error{err}
+		}
+	} else {
+		return nil, []error{errors.New("No dead letter or exchange to re-drive")}
+	}
+
+	redriven := 0
+	for _, deadLetterId := range deadLetterIds {
+		err := d.outbox.Redrive(deadLetterId)
+		if err != nil {
+			log.Errorf("Failed to re-drive exchange delivery [%v]: %v", deadLetterId, err)
+			return nil, []error{err}
+		}
+		redriven += 1
+	}
+
+	d.cruds["exchange_dead_letter"].AddTimelineEvent("exchange_dead_letter", "exchange.redriven", "Exchange deliveries re-driven", map[string]interface{}{
+		"redriven_by": admin["email"],
+		"exchange":    exchangeName,
+		"count":       redriven,
+	})
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", fmt.Sprintf("Queued %d deliveries again", redriven), "Re-driven")),
+	}, nil
+}
+
+func NewExchangeRedrivePerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (*ExchangeRedrivePerformer, error) {
+
+	handler := ExchangeRedrivePerformer{
+		cruds:  cruds,
+		outbox: NewExchangeOutbox(initConfig, &cruds),
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,298 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	ActionExecutionStatusCompleted = "completed"
+	ActionExecutionStatusFailed    = "failed"
+	ActionExecutionStatusQueued    = "queued"
+)
+
+// inputs and response values which are not kept in the execution log are replaced with this
+const ActionExecutionRedacted = "[redacted]"
+
+// longer strings, like uploaded files, are not kept in the execution log
+const actionExecutionMaxValueLength = 1000
+
+// names of values which are never kept in the execution log
+var actionExecutionSecretNames = []string{"password", "secret", "token"}
+
+// response types whose attributes are kept in the execution log. Other responses, like the token set on
+// the client, an otp enrollment or an invitation, are kept without their attributes.
+var actionExecutionLoggedResponses = map[string]bool{
+	"client.notify":       true,
+	"error":               true,
+	"job":                 true,
+	"exchange.deliveries": true,
+}
+
+// ActionExecution is a run of an action, for the action_execution log
+type ActionExecution struct {
+	Action             Action
+	Request            ActionRequest
+	UserId             int64
+	SubjectReferenceId string
+	Responses          []ActionResponse
+	Error              error
+	StartedAt          time.Time
+	// reference id of the execution this one replays
+	ReplayOf string
+}
+
+// Status is failed when the action returned an error or an error response, queued when it was queued as a
+// job
+func (ae ActionExecution) Status() string {
+
+	if ae.Error != nil {
+		return ActionExecutionStatusFailed
+	}
+
+	for _, response := range ae.Responses {
+		if response.ResponseType == "error" {
+			return ActionExecutionStatusFailed
+		}
+		if response.ResponseType == "job" {
+			return ActionExecutionStatusQueued
+		}
+		notification, ok := response.Attributes.(map[string]interface{})
+		if ok && response.ResponseType == "client.notify" && notification["type"] == "error" {
+			return ActionExecutionStatusFailed
+		}
+	}
+
+	return ActionExecutionStatusCompleted
+}
+
+// RecordActionExecution adds the execution to the action_execution log, readable by the user who ran the
+// action and the administrator. Passwords, secrets, tokens and files are not kept. A failure to record is
+// logged, it does not fail the action.
+func (dr *DbResource) RecordActionExecution(execution ActionExecution) string {
+
+	input, _ := json.Marshal(sanitizeActionInput(execution.Action, execution.Request.Attributes))
+	responses, _ := json.Marshal(sanitizeExecutionResponses(execution.Responses))
+
+	errorMessage := ""
+	if execution.Error != nil {
+		errorMessage = execution.Error.Error()
+	}
+
+	referenceId := uuid.NewV4().String()
+	now := time.Now()
+
+	cols := []string{"reference_id", "permission", "created_at", "action_name", "on_type", "status", "input", "responses", "error_message", "duration_ms", "client_ip"}
+	vals := []interface{}{referenceId, auth.NewPermission(auth.None, auth.None, auth.Read).IntValue(), now, execution.Request.Action, execution.Request.Type,
+		execution.Status(), string(input), string(responses), errorMessage, int64(now.Sub(execution.StartedAt) / time.Millisecond), execution.Request.ClientIp}
+
+	if execution.SubjectReferenceId != "" {
+		cols = append(cols, "subject_reference_id")
+		vals = append(vals, execution.SubjectReferenceId)
+	}
+	if execution.ReplayOf != "" {
+		cols = append(cols, "replay_of")
+		vals = append(vals, execution.ReplayOf)
+	}
+	if execution.UserId != 0 {
+		cols = append(cols, "user_id")
+		vals = append(vals, execution.UserId)
+	}
+
+	s, v, err := squirrel.Insert("action_execution").Columns(cols...).Values(vals...).ToSql()
+	if err == nil {
+		_, err = dr.db.Exec(s, v...)
+	}
+	if err != nil {
+		log.Errorf("Failed to record execution of [%v][%v]: %v", execution.Request.Type, execution.Request.Action, err)
+		return ""
+	}
+
+	return referenceId
+}
+
+// sanitizeActionInput is the input of an action without its password and file fields
+func sanitizeActionInput(action Action, attributes map[string]interface{}) map[string]interface{} {
+
+	columnTypes := make(map[string]string)
+	for _, inField := range action.InFields {
+		columnTypes[inField.ColumnName] = inField.ColumnType
+	}
+
+	input := make(map[string]interface{})
+	for key, value := range attributes {
+		columnType := columnTypes[key]
+		if columnType == "password" || strings.HasPrefix(columnType, "file") {
+			input[key] = ActionExecutionRedacted
+			continue
+		}
+		input[key] = sanitizeExecutionValue(key, value)
+	}
+
+	return input
+}
+
+// sanitizeExecutionValue is a copy of the value with secrets and long strings replaced
+func sanitizeExecutionValue(name string, value interface{}) interface{} {
+
+	lowerName := strings.ToLower(name)
+	for _, secretName := range actionExecutionSecretNames {
+		if strings.Contains(lowerName, secretName) {
+			return ActionExecutionRedacted
+		}
+	}
+
+	switch typed := value.(type) {
+	case string:
+		if len(typed) > actionExecutionMaxValueLength {
+			return ActionExecutionRedacted
+		}
+		return typed
+	case []byte:
+		return sanitizeExecutionValue(name, string(typed))
+	}
+
+	// maps and lists of any type, a []string of recovery codes as much as a []interface{}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Map:
+		if reflected.Type().Key().Kind() != reflect.String {
+			return ActionExecutionRedacted
+		}
+		copied := make(map[string]interface{})
+		for _, key := range reflected.MapKeys() {
+			copied[key.String()] = sanitizeExecutionValue(key.String(), reflected.MapIndex(key).Interface())
+		}
+		return copied
+	case reflect.Slice, reflect.Array:
+		copied := make([]interface{}, 0, reflected.Len())
+		for i := 0; i < reflected.Len(); i++ {
+			copied = append(copied, sanitizeExecutionValue(name, reflected.Index(i).Interface()))
+		}
+		return copied
+	}
+
+	return value
+}
+
+// sanitizeExecutionResponses is a copy of the responses for the execution log, with the attributes of
+// the response types which are not in actionExecutionLoggedResponses replaced
+func sanitizeExecutionResponses(responses []ActionResponse) []interface{} {
+
+	copied := make([]interface{}, 0, len(responses))
+	for _, response := range responses {
+		var attributes interface{} = ActionExecutionRedacted
+		if actionExecutionLoggedResponses[response.ResponseType] {
+			attributes = sanitizeExecutionValue("", plainExecutionValue(response.Attributes))
+		}
+		copied = append(copied, map[string]interface{}{
+			"ResponseType": response.ResponseType,
+			"Attributes":   attributes,
+		})
+	}
+	return copied
+}
+
+// plainExecutionValue turns response attributes of any type into maps and lists, so they can be sanitized
+func plainExecutionValue(value interface{}) interface{} {
+
+	switch typed := value.(type) {
+	case nil, string, bool, int, int64, float64, map[string]interface{}, []interface{}:
+		return value
+	case *api2go.Api2GoModel:
+		return typed.Data
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	var plain interface{}
+	json.Unmarshal(encoded, &plain)
+	return plain
+}
+
+// storedActionExecution is an execution read back from the log, to be replayed
+type storedActionExecution struct {
+	ReferenceId        string
+	ActionName         string
+	OnType             string
+	SubjectReferenceId string
+	UserId             int64
+	Input              map[string]interface{}
+}
+
+func (dr *DbResource) getActionExecution(referenceId string) (*storedActionExecution, error) {
+
+	var execution struct {
+		ReferenceId        string  `db:"reference_id"`
+		ActionName         string  `db:"action_name"`
+		OnType             string  `db:"on_type"`
+		SubjectReferenceId *string `db:"subject_reference_id"`
+		UserId             *int64  `db:"user_id"`
+		Input              *string `db:"input"`
+	}
+
+	s, v, err := squirrel.Select("reference_id", "action_name", "on_type", "subject_reference_id", "user_id", "input").
+		From("action_execution").Where(squirrel.Eq{"reference_id": referenceId}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = dr.db.QueryRowx(s, v...).StructScan(&execution)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := storedActionExecution{
+		ReferenceId: execution.ReferenceId,
+		ActionName:  execution.ActionName,
+		OnType:      execution.OnType,
+		Input:       make(map[string]interface{}),
+	}
+	if execution.SubjectReferenceId != nil {
+		stored.SubjectReferenceId = *execution.SubjectReferenceId
+	}
+	if execution.UserId != nil {
+		stored.UserId = *execution.UserId
+	}
+	if execution.Input != nil && *execution.Input != "" {
+		err = json.Unmarshal([]byte(*execution.Input), &stored.Input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &stored, nil
+}
+
+// isRedacted tells if a value of the input was not kept, an execution with such inputs cannot be replayed
+func isRedacted(value interface{}) bool {
+
+	switch typed := value.(type) {
+	case string:
+		return typed == ActionExecutionRedacted
+	case map[string]interface{}:
+		for _, item := range typed {
+			if isRedacted(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range typed {
+			if isRedacted(item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,43 @@
+package resource
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeExecutionResponses(t *testing.T) {
+
+	responses := []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Enrolled", "Success")),
+		NewActionResponse("client.store.set", map[string]interface{}{"key": "token", "value": "a jwt token"}),
+		NewActionResponse("otp.enrollment", map[string]interface{}{
+			"uri":            "otpauth://totp/daptin:user?secret=ABCDEF",
+			"recovery_codes": []string{"code-1", "code-2"},
+		}),
+	}
+
+	sanitized, _ := json.Marshal(sanitizeExecutionResponses(responses))
+	for _, secret := range []string{"a jwt token", "otpauth", "code-1"} {
+		if strings.Contains(string(sanitized), secret) {
+			t.Errorf("[%v] should not be kept, got %v", secret, string(sanitized))
+		}
+	}
+	if !strings.Contains(string(sanitized), "Enrolled") || !strings.Contains(string(sanitized), "otp.enrollment") {
+		t.Errorf("Notifications and the type of every response should be kept, got %v", string(sanitized))
+	}
+}
+
+func TestSanitizeExecutionValueWalksLists(t *testing.T) {
+
+	value := sanitizeExecutionValue("", map[string]interface{}{
+		"names":  []string{"one", strings.Repeat("a", actionExecutionMaxValueLength+1)},
+		"nested": []map[string]string{{"api_token": "value"}},
+	})
+
+	encoded, _ := json.Marshal(value)
+	expected := `{"names":["one","[redacted]"],"nested":[{"api_token":"[redacted]"}]}`
+	if string(encoded) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(encoded))
+	}
+}
@@ -155,6 +155,22 @@ func (d *FileUploadActionPerformer) DoAction(request ActionRequest, inFields map
 
 	fsrc, fdst := cmd.NewFsSrcDst(args)
 
+	if request.Job != nil {
+		// run as a job, the copy is waited for so that its outcome is recorded on the job
+		if fsrc == nil || fdst == nil {
+			return nil, []error{errors.New("Source or destination is null")}
+		}
+		request.Job.Log("Uploading files to " + rootPath)
+		err = fs.CopyDir(fdst, fsrc)
+		os.RemoveAll(tempDirectoryPath)
+		if err != nil {
+			return nil, []error{err}
+		}
+		return []ActionResponse{
+			NewActionResponse("client.notify", NewClientNotification("success", "Files uploaded", "Success")),
+		}, nil
+	}
+
 	go cmd.Run(true, true, nil, func() error {
 		if fsrc == nil || fdst == nil {
 			log.Errorf("Source or destination is null")
@@ -11,8 +11,9 @@ import (
 )
 
 type GenerateJwtTokenActionPerformer struct {
-	cruds  map[string]*DbResource
-	secret []byte
+	cruds       map[string]*DbResource
+	secret      []byte
+	configStore *ConfigStore
 }
 
 func (d *GenerateJwtTokenActionPerformer) Name() string {
@@ -28,68 +29,209 @@ func (d *GenerateJwtTokenActionPerformer) DoAction(request ActionRequest, inFiel
 
 	existingUsers, _, err := d.cruds["user"].GetRowsByWhereClause("user", squirrel.Eq{"email": email})
 
-	responseAttrs := make(map[string]interface{})
-	if err != nil || len(existingUsers) < 1 {
-		responseAttrs["type"] = "error"
-		responseAttrs["message"] = "Invalid username or password"
-		responseAttrs["title"] = "Failed"
-		actionResponse := NewActionResponse("client.notify", responseAttrs)
-		responses = append(responses, actionResponse)
-	} else {
-		existingUser := existingUsers[0]
-		if BcryptCheckStringHash(password.(string), existingUser["password"].(string)) {
-
-			// Create a new token object, specifying signing method and the claims
-			// you would like it to contain.
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-				"email":   existingUser["email"],
-				"name":    existingUser["name"],
-				"nbf":     time.Now().Unix(),
-				"exp":     time.Now().Add(60 * time.Minute).Unix(),
-				"iss":     "daptin",
-				"picture": fmt.Sprintf("https://www.gravatar.com/avatar/%s&d=monsterid", GetMD5Hash(strings.ToLower(existingUser["email"].(string)))),
-				"iat":     time.Now(),
-				"jti":     uuid.NewV4().String(),
-			})
-
-			// Sign and get the complete encoded token as a string using the secret
-			tokenString, err := token.SignedString(d.secret)
-			fmt.Printf("%v %v", tokenString, err)
+	var existingUser map[string]interface{}
+	if err == nil && len(existingUsers) > 0 {
+		existingUser = existingUsers[0]
+	}
+
+	throttled := d.cruds["user"].CheckLoginThrottle(existingUser, request.ClientIp)
+	if throttled != nil {
+		return throttled, nil
+	}
+
+	if !d.checkLocalPassword(existingUser, password) {
+		ldapUser, ok := d.authenticateLdap(email, password)
+		if !ok {
+			d.cruds["user"].RecordLoginFailure(existingUser, email, request.ClientIp)
+			responseAttrs := make(map[string]interface{})
+			responseAttrs["type"] = "error"
+			responseAttrs["title"] = "Failed"
+			responseAttrs["message"] = "Invalid username or password"
+			responses = append(responses, NewActionResponse("client.notify", responseAttrs))
+			return responses, nil
+		}
+		existingUser = ldapUser
+	}
+
+	userId := existingUser["id"].(int64)
+	otpEnabled := IsTruthy(existingUser["otp_enabled"])
+
+	// failures are cleared only after the second factor, otherwise a known password could be
+	// used to reset the counter between guesses of the code
+	if otpEnabled || d.cruds["user"].IsOtpRequiredForUser(userId) {
+		return d.otpChallengeResponses(existingUser, otpEnabled)
+	}
+
+	err = d.cruds["user"].ResetLoginFailures(userId)
+	if err != nil {
+		log.Errorf("Failed to reset login failures for [%v]: %v", email, err)
+	}
+
+	tokenResponses, err := CreateJwtTokenResponses(existingUser, d.secret)
+	if err != nil {
+		return nil, []error{err}
+	}
+	responses = append(responses, tokenResponses...)
+
+	return responses, nil
+}
+
+// checkLocalPassword checks the password stored in daptin, users provisioned by an external provider have none
+func (d *GenerateJwtTokenActionPerformer) checkLocalPassword(existingUser map[string]interface{}, password interface{}) bool {
+	if existingUser == nil {
+		return false
+	}
+	hash, ok := existingUser["password"].(string)
+	passwordString, isString := password.(string)
+	if !ok || hash == "" || !isString {
+		return false
+	}
+	return BcryptCheckStringHash(passwordString, hash)
+}
+
+// authenticateLdap checks the password against the ldap server when ldap sign in is enabled, and returns the
+// daptin user for the directory entry, creating it on the first sign in and syncing its mapped usergroups
+func (d *GenerateJwtTokenActionPerformer) authenticateLdap(email interface{}, password interface{}) (map[string]interface{}, bool) {
+
+	ldapConfig := LoadLdapConfig(d.configStore)
+	if !ldapConfig.Enabled {
+		return nil, false
+	}
+
+	emailString, _ := email.(string)
+	passwordString, _ := password.(string)
+
+	authenticator := NewLdapAuthenticator(ldapConfig)
+	ldapUser, err := authenticator.Authenticate(emailString, passwordString)
+	if err != nil {
+		if err != ErrLdapInvalidCredentials {
+			log.Errorf("Ldap sign in failed for [%v]: %v", emailString, err)
+		}
+		return nil, false
+	}
+
+	user, err := d.cruds["user"].ProvisionExternalUser("ldap", ldapUser.Email, ldapUser.Name,
+		authenticator.MappedGroups(ldapUser), authenticator.ManagedGroups())
+	if err != nil {
+		log.Errorf("Failed to provision ldap user [%v]: %v", ldapUser.Dn, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// otpChallengeResponses is the first half of a two factor sign in. Instead of a token the client
+// gets a short lived challenge which has to be sent back with a code to the signin_2fa action. Users who
+// are required to use 2fa but have not enrolled yet get the secret to enroll with along with the challenge.
+// An enrollment which was started before is continued with the same secret, and the recovery codes are
+// only handed out by signin_2fa once a code from the authenticator app was verified.
+func (d *GenerateJwtTokenActionPerformer) otpChallengeResponses(existingUser map[string]interface{}, otpEnabled bool) ([]ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+	email := existingUser["email"].(string)
+
+	if !otpEnabled {
+		secret, err := d.cruds["user"].GetOtpSecret(existingUser)
+		if err != nil {
+			key, err := GenerateOtpKey(email)
 			if err != nil {
-				log.Errorf("Failed to sign string: %v", err)
+				log.Errorf("Failed to generate otp key: %v", err)
 				return nil, []error{err}
 			}
+			secret = key.Secret()
 
-			responseAttrs = make(map[string]interface{})
-			responseAttrs["value"] = string(tokenString)
-			responseAttrs["key"] = "token"
-			actionResponse := NewActionResponse("client.store.set", responseAttrs)
-			responses = append(responses, actionResponse)
+			err = d.cruds["user"].StoreOtpSecret(existingUser["id"].(int64), secret, "")
+			if err != nil {
+				log.Errorf("Failed to store otp secret: %v", err)
+				return nil, []error{err}
+			}
+		}
 
-			notificationAttrs := make(map[string]string)
-			notificationAttrs["message"] = "Logged in"
-			notificationAttrs["title"] = "Success"
-			notificationAttrs["type"] = "success"
-			responses = append(responses, NewActionResponse("client.notify", notificationAttrs))
+		responses = append(responses, NewOtpEnrollmentResponse(OtpKeyUrl(email, secret), secret, nil))
+	}
 
-			responseAttrs = make(map[string]interface{})
-			responseAttrs["location"] = "/"
-			responseAttrs["window"] = "self"
-			responseAttrs["delay"] = 2000
+	challenge, err := NewOtpChallengeToken(email, d.secret)
+	if err != nil {
+		log.Errorf("Failed to sign otp challenge: %v", err)
+		return nil, []error{err}
+	}
 
-			responses = append(responses, NewActionResponse("client.redirect", responseAttrs))
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["value"] = challenge
+	responseAttrs["key"] = "otp_challenge"
+	responses = append(responses, NewActionResponse("client.store.set", responseAttrs))
 
-		} else {
-			responseAttrs = make(map[string]interface{})
-			responseAttrs["type"] = "error"
-			responseAttrs["title"] = "Failed"
-			responseAttrs["message"] = "Invalid username or password"
-			responses = append(responses, NewActionResponse("client.notify", responseAttrs))
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", "Enter the code from your authenticator app", "Two factor authentication")))
 
-		}
+	responseAttrs = make(map[string]interface{})
+	responseAttrs["location"] = "/act/user/signin_2fa"
+	responseAttrs["window"] = "self"
+	responseAttrs["delay"] = 0
+	responses = append(responses, NewActionResponse("client.redirect", responseAttrs))
 
+	return responses, nil
+}
+
+// CreateJwtToken signs the session token for a user who has completed sign in
+func CreateJwtToken(existingUser map[string]interface{}, secret []byte) (string, error) {
+
+	// Create a new token object, specifying signing method and the claims
+	// you would like it to contain.
+	claims := jwt.MapClaims{
+		"email":   existingUser["email"],
+		"name":    existingUser["name"],
+		"nbf":     time.Now().Unix(),
+		"exp":     time.Now().Add(60 * time.Minute).Unix(),
+		"iss":     "daptin",
+		"picture": fmt.Sprintf("https://www.gravatar.com/avatar/%s&d=monsterid", GetMD5Hash(strings.ToLower(existingUser["email"].(string)))),
+		"iat":     time.Now(),
+		"jti":     uuid.NewV4().String(),
+	}
+	if tenant, ok := existingUser[TenantColumnName].(string); ok && tenant != "" {
+		claims["tenant"] = tenant
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// Sign and get the complete encoded token as a string using the secret
+	tokenString, err := token.SignedString(secret)
+	if err != nil {
+		log.Errorf("Failed to sign string: %v", err)
+		return "", err
 	}
 
+	return tokenString, nil
+}
+
+// CreateJwtTokenResponses issues the session token for a user who has completed sign in
+func CreateJwtTokenResponses(existingUser map[string]interface{}, secret []byte) ([]ActionResponse, error) {
+
+	responses := make([]ActionResponse, 0)
+
+	tokenString, err := CreateJwtToken(existingUser, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["value"] = string(tokenString)
+	responseAttrs["key"] = "token"
+	actionResponse := NewActionResponse("client.store.set", responseAttrs)
+	responses = append(responses, actionResponse)
+
+	notificationAttrs := make(map[string]string)
+	notificationAttrs["message"] = "Logged in"
+	notificationAttrs["title"] = "Success"
+	notificationAttrs["type"] = "success"
+	responses = append(responses, NewActionResponse("client.notify", notificationAttrs))
+
+	responseAttrs = make(map[string]interface{})
+	responseAttrs["location"] = "/"
+	responseAttrs["window"] = "self"
+	responseAttrs["delay"] = 2000
+
+	responses = append(responses, NewActionResponse("client.redirect", responseAttrs))
+
 	return responses, nil
 }
 
@@ -98,8 +240,9 @@ func NewGenerateJwtTokenPerformer(configStore *ConfigStore, cruds map[string]*Db
 	secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
 
 	handler := GenerateJwtTokenActionPerformer{
-		secret: []byte(secret),
-		cruds:  cruds,
+		secret:      []byte(secret),
+		cruds:       cruds,
+		configStore: configStore,
 	}
 
 	return &handler, nil
@@ -62,13 +62,16 @@ func (d *RandomDataGeneratePerformer) DoAction(request ActionRequest, inFields m
 	req := api2go.Request{
 		PlainRequest: httpRequest,
 	}
-	for _, row := range rows {
+	for i, row := range rows {
 
 		_, err := d.cruds[tableName].Create(api2go.NewApi2GoModelWithData(tableName, nil, 0, nil, row), req)
 		if err != nil {
 			log.Errorf("Was about to insert this fake object: %v", row)
 			log.Errorf("Failed to fake insert into table [%v] : %v", tableName, err)
 		}
+		if request.Job != nil && (i+1)%100 == 0 {
+			request.Job.Progress((i + 1) * 100 / count)
+		}
 	}
 	return responses, nil
 }
@@ -1,14 +1,15 @@
 package resource
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/artpar/api2go"
 	"github.com/daptin/daptin/server/auth"
-	"github.com/dop251/goja"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
 	"gopkg.in/gin-gonic/gin.v1"
 	//"io"
 	"crypto/md5"
@@ -18,6 +19,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/artpar/conform"
 	english "github.com/go-playground/locales/en"
@@ -38,6 +40,7 @@ func CreateGuestActionListHandler(initConfig *CmsConfig, cruds map[string]*DbRes
 
 	guestActions["user:signup"] = actionMap["user:signup"]
 	guestActions["user:signin"] = actionMap["user:signin"]
+	guestActions["user:signin_2fa"] = actionMap["user:signin_2fa"]
 
 	return func(c *gin.Context) {
 
@@ -59,6 +62,8 @@ type ActionPerformerInterface interface {
 type DaptinError struct {
 	Message string
 	Code    string
+	// the problem with each invalid input field
+	Fields map[string]string `json:",omitempty"`
 }
 
 func NewDaptinError(str string, code string) DaptinError {
@@ -85,17 +90,11 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 		actionMap[ac.OnType+":"+ac.Name] = ac
 	}
 
-	actionHandlerMap := make(map[string]ActionPerformerInterface)
-
-	for _, actionPerformer := range actionPerformers {
-		if actionPerformer == nil {
-			continue
-		}
-		actionHandlerMap[actionPerformer.Name()] = actionPerformer
-	}
+	actionHandlerMap := ActionPerformerMap(actionPerformers)
 
 	return func(ginContext *gin.Context) {
 
+		startedAt := time.Now()
 		actionName := ginContext.Param("actionName")
 		//log.Infof("Action name: %v", actionName)
 
@@ -110,6 +109,7 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 
 		actionRequest.Type = ginContext.Param("typename")
 		actionRequest.Action = actionName
+		actionRequest.ClientIp = ginContext.ClientIP()
 
 		if actionRequest.Attributes == nil {
 			actionRequest.Attributes = make(map[string]interface{})
@@ -157,15 +157,9 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 			}
 
 			subjectInstanceMap["__type"] = subjectInstance.GetName()
-			permission := cruds[actionRequest.Type].GetRowPermission(subjectInstanceMap)
-
-			if !permission.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
-				ginContext.AbortWithError(403, errors.New("Forbidden"))
-				return
-			}
 		}
 
-		if !cruds["world"].IsUserActionAllowed(sessionUser.UserReferenceId, sessionUser.Groups, actionRequest.Type, actionRequest.Action) {
+		if !CanExecuteAction(cruds, sessionUser, actionRequest.Type, actionRequest.Action, subjectInstanceMap) {
 			ginContext.AbortWithError(403, errors.New("Forbidden"))
 			return
 		}
@@ -174,21 +168,11 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 
 		action, err := cruds["action"].GetActionByName(actionRequest.Type, actionRequest.Action)
 
+		queryValues := ginContext.Request.URL.Query()
 		for _, field := range action.InFields {
 			_, ok := actionRequest.Attributes[field.ColumnName]
-			if !ok {
-				actionRequest.Attributes[field.ColumnName] = ginContext.Query(field.ColumnName)
-			}
-		}
-
-		for _, validation := range action.Validations {
-			errs := initConfig.Validator.VarWithValue(actionRequest.Attributes[validation.ColumnName], actionRequest.Attributes, validation.Tags)
-			if errs != nil {
-				validationErrors := errs.(validator.ValidationErrors)
-				firstError := validationErrors[0]
-				ginContext.JSON(400, NewDaptinError(validation.ColumnName+": "+firstError.Translate(trans), "validation-failed"))
-				//ginContext.AbortWithError(400, errors.New(validationErrors[0].Translate(en1)))
-				return
+			if _, inQuery := queryValues[field.ColumnName]; !ok && inQuery {
+				actionRequest.Attributes[field.ColumnName] = queryValues.Get(field.ColumnName)
 			}
 		}
 
@@ -208,11 +192,39 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 
 		inFieldMap, err := GetValidatedInFields(actionRequest, action)
 
-		if err != nil {
+		invalidFields := make(map[string]string)
+		if inputError, ok := err.(*ActionInputError); ok {
+			invalidFields = inputError.Fields
+		} else if err != nil {
 			ginContext.AbortWithError(400, err)
 			return
 		}
 
+		for _, validation := range action.Validations {
+			if _, invalid := invalidFields[validation.ColumnName]; invalid {
+				continue
+			}
+			value, ok := inFieldMap[validation.ColumnName]
+			if !ok {
+				value = actionRequest.Attributes[validation.ColumnName]
+			}
+			errs := initConfig.Validator.VarWithValue(value, actionRequest.Attributes, validation.Tags)
+			if errs != nil {
+				validationErrors := errs.(validator.ValidationErrors)
+				invalidFields[validation.ColumnName] = validationErrors[0].Translate(trans)
+			}
+		}
+
+		if len(invalidFields) > 0 {
+			inputError := &ActionInputError{Fields: invalidFields}
+			ginContext.JSON(400, DaptinError{
+				Message: inputError.Error(),
+				Code:    "validation-failed",
+				Fields:  invalidFields,
+			})
+			return
+		}
+
 		if sessionUser.UserReferenceId != "" {
 			user, err := cruds["user"].GetReferenceIdToObject("user", sessionUser.UserReferenceId)
 			if err != nil {
@@ -227,128 +239,510 @@ func CreatePostActionHandler(initConfig *CmsConfig, configStore *ConfigStore, cr
 			inFieldMap["subject"] = subjectInstanceMap
 		}
 
-		responses := make([]ActionResponse, 0)
-
-	OutFields:
-		for _, outcome := range action.OutFields {
-			var res api2go.Responder
-			var responses1 []ActionResponse
-			var errors1 []error
-			var actionResponse ActionResponse
+		if actionRequest.DryRun || ginContext.Query("dry_run") == "true" {
+			log.Infof("Dry run of action [%v]", actionName)
+			ginContext.JSON(200, PlanActionOutcomes(ginContext.Request.Context(), action, inFieldMap, cruds, actionHandlerMap))
+			return
+		}
 
-			model, request, err := BuildOutcome(inFieldMap, outcome)
+		if action.IsAsync {
+			jobReferenceId, err := cruds["job"].EnqueueActionJob(actionRequest, sessionUser, subjectInstanceReferenceIdString(subjectInstanceMap), TenantFromRequest(req))
 			if err != nil {
-				log.Errorf("Failed to build outcome: %v", err)
-				responses = append(responses, NewActionResponse("error", "Failed to build outcome "+outcome.Type))
-				continue
+				log.Errorf("Failed to queue [%v] as a job: %v", actionName, err)
+				ginContext.AbortWithError(500, err)
+				return
 			}
 
-			request.PlainRequest = request.PlainRequest.WithContext(ginContext.Request.Context())
+			responses := []ActionResponse{
+				NewActionResponse("client.notify", NewClientNotification("success", "Queued as a job", "Queued")),
+				NewActionResponse("job", map[string]interface{}{
+					"reference_id": jobReferenceId,
+					"status":       JobStatusQueued,
+					"status_url":   "/job/" + jobReferenceId,
+				}),
+			}
+			cruds["action_execution"].RecordActionExecution(ActionExecution{
+				Action:             action,
+				Request:            actionRequest,
+				UserId:             sessionUser.UserId,
+				SubjectReferenceId: subjectInstanceReferenceIdString(subjectInstanceMap),
+				Responses:          responses,
+				StartedAt:          startedAt,
+			})
+
+			ginContext.JSON(202, responses)
+			return
+		}
 
-			dbResource, ok := cruds[outcome.Type]
-			if !ok {
-				//log.Errorf("No DbResource for type [%v]", outcome.Type)
+		responses, err := ExecuteActionOutcomes(ginContext.Request.Context(), action, actionRequest, inFieldMap, cruds, actionHandlerMap)
+
+		cruds["action_execution"].RecordActionExecution(ActionExecution{
+			Action:             action,
+			Request:            actionRequest,
+			UserId:             sessionUser.UserId,
+			SubjectReferenceId: subjectInstanceReferenceIdString(subjectInstanceMap),
+			Responses:          responses,
+			Error:              err,
+			StartedAt:          startedAt,
+		})
+
+		if err != nil {
+			ginContext.AbortWithError(500, err)
+			return
+		}
+
+		//log.Infof("Final responses: %v", responses)
+
+		ginContext.JSON(200, responses)
+
+	}
+}
+
+func subjectInstanceReferenceIdString(subjectInstanceMap map[string]interface{}) string {
+	if subjectInstanceMap == nil {
+		return ""
+	}
+	referenceId, _ := subjectInstanceMap["reference_id"].(string)
+	return referenceId
+}
+
+// ActionPerformerMap indexes the performers by the outcome type they execute
+func ActionPerformerMap(actionPerformers []ActionPerformerInterface) map[string]ActionPerformerInterface {
+
+	actionHandlerMap := make(map[string]ActionPerformerInterface)
+
+	for _, actionPerformer := range actionPerformers {
+		if actionPerformer == nil {
+			continue
+		}
+		actionHandlerMap[actionPerformer.Name()] = actionPerformer
+	}
+
+	return actionHandlerMap
+}
+
+// CanExecuteAction checks that the user is allowed the action, and can execute on the subject instance when
+// there is one. A role granting the action allows it either way.
+func CanExecuteAction(cruds map[string]*DbResource, sessionUser auth.SessionUser, typeName string, actionName string, subject map[string]interface{}) bool {
+
+	roles := cruds["world"].GetRolePermissions(sessionUser)
+	if roles.CanExecuteAction(typeName, actionName) {
+		return true
+	}
+
+	if subject != nil && !cruds[typeName].GetRowPermission(subject).CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+		return false
+	}
+
+	return cruds["world"].IsUserActionAllowed(sessionUser.UserReferenceId, sessionUser.Groups, typeName, actionName)
+}
+
+// ExecuteActionAs runs an action outside of a http request, as the user with the given reference id (a
+// guest when empty) and on the instance with subjectReferenceId when set. The caller checks that the user
+// may run it, see CanExecuteAction.
+func ExecuteActionAs(cruds map[string]*DbResource, actionHandlerMap map[string]ActionPerformerInterface, actionRequest ActionRequest, userReferenceId string, subjectReferenceId string, tenant *Tenant) ([]ActionResponse, error) {
+
+	action, err := cruds["action"].GetActionByName(actionRequest.Type, actionRequest.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	inFieldMap, err := GetValidatedInFields(actionRequest, action)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionUser auth.SessionUser
+	if userReferenceId != "" {
+		var user map[string]interface{}
+		sessionUser, user, err = cruds["user"].GetSessionUser(userReferenceId)
+		if err != nil {
+			return nil, err
+		}
+		inFieldMap["user"] = user
+	}
+	ctx := NewTenantContext(sessionUser, tenant)
+	if actionRequest.TriggerDepth > 0 {
+		ctx = context.WithValue(ctx, "trigger_depth", actionRequest.TriggerDepth)
+	}
+
+	if subjectReferenceId != "" {
+		subject, err := cruds[actionRequest.Type].GetReferenceIdToObjectInContext(ctx, actionRequest.Type, subjectReferenceId)
+		if err != nil {
+			return nil, err
+		}
+		subject["__type"] = actionRequest.Type
+		inFieldMap[actionRequest.Type+"_id"] = subjectReferenceId
+		inFieldMap["subject"] = subject
+	}
+
+	return ExecuteActionOutcomes(ctx, action, actionRequest, inFieldMap, cruds, actionHandlerMap)
+}
+
+// ExecuteActionOutcomes runs the outcomes of an action one after the other, with the request context of
+// the user the action is run for. Outcomes with a Condition which is not true are skipped, and outcomes
+// with ForEach run once for every item of the list. A failed create, update or delete, or a ForEach,
+// Condition or attribute which fails to evaluate, stops the action with a response saying so, a failed
+// performer stops it with the error, unless the outcome has ContinueOnError. When the action has
+// RollbackOnError the rows written by the earlier outcomes are changed back before it stops.
+func ExecuteActionOutcomes(ctx context.Context, action Action, actionRequest ActionRequest, inFieldMap map[string]interface{}, cruds map[string]*DbResource, actionHandlerMap map[string]ActionPerformerInterface) ([]ActionResponse, error) {
+
+	responses := make([]ActionResponse, 0)
+	undoLog := make([]outcomeUndo, 0)
+	actionRequest.RequestContext = ctx
+
+	stop := func(err error) ([]ActionResponse, error) {
+		if action.RollbackOnError {
+			rollbackOutcomes(ctx, cruds, undoLog)
+			responses = append(responses, NewActionResponse("client.notify", NewClientNotification("warning", "Changes made by the earlier steps were rolled back", "Rolled back")))
+		}
+		return responses, err
+	}
+
+	for _, outcome := range action.OutFields {
+
+		items, isLoop, err := outcomeItems(outcome, inFieldMap)
+		if err != nil {
+			log.Errorf("Failed to evaluate ForEach of outcome [%v]: %v", outcome.Type, err)
+			responses = append(responses, NewActionResponse("error", "Failed to build outcome "+outcome.Type+": "+err.Error()))
+			if outcome.ContinueOnError {
+				continue
 			}
+			return stop(nil)
+		}
 
-			log.Infof("Next outcome method: %v", outcome.Method)
-			switch outcome.Method {
-			case "POST":
-				res, err = dbResource.Create(model, request)
-				if err != nil {
+		previousItem, hadItem := inFieldMap["item"]
+		previousIndex, hadIndex := inFieldMap["index"]
 
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to create "+model.GetName()+". "+err.Error(), "Failed"))
-					responses = append(responses, actionResponse)
-					break OutFields
-				} else {
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("success", "Created "+model.GetName(), "Success"))
-				}
-				responses = append(responses, actionResponse)
-			case "UPDATE":
-				res, err = dbResource.Update(model, request)
-				if err != nil {
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to update "+model.GetName()+". "+err.Error(), "Failed"))
-					responses = append(responses, actionResponse)
-					break OutFields
-				} else {
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("success", "Created "+model.GetName(), "Success"))
-				}
-				responses = append(responses, actionResponse)
-			case "DELETE":
-				res, err = dbResource.Delete(model.Data["reference_id"].(string), request)
-				if err != nil {
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("error", "Failed to delete "+model.GetName(), "Failed"))
-					responses = append(responses, actionResponse)
-					break OutFields
-				} else {
-					actionResponse = NewActionResponse("client.notify", NewClientNotification("success", "Created "+model.GetName(), "Success"))
+		results := make([]interface{}, 0)
+		var failed bool
+		var outcomeErr error
+
+		for index, item := range items {
+
+			if isLoop {
+				inFieldMap["item"] = item
+				inFieldMap["index"] = index
+			}
+
+			run, err := outcomeConditionHolds(outcome, inFieldMap)
+			if err != nil {
+				log.Errorf("Failed to evaluate condition of outcome [%v]: %v", outcome.Type, err)
+				responses = append(responses, NewActionResponse("error", "Failed to build outcome "+outcome.Type+": "+err.Error()))
+				if outcome.ContinueOnError {
+					continue
 				}
-				responses = append(responses, actionResponse)
-			case "EXECUTE":
-				//res, err = cruds[outcome.Type].Create(model, request)
-
-				performer, ok := actionHandlerMap[model.GetName()]
-				if !ok {
-					log.Errorf("Invalid outcome method: [%v]%v", outcome.Method, model.GetName())
-					//return ginContext.AbortWithError(500, errors.New("Invalid outcome"))
-				} else {
-					responses1, errors1 = performer.DoAction(actionRequest, model.Data)
-					responses = append(responses, responses1...)
-					if errors1 != nil && len(errors1) > 0 {
-						err = errors1[0]
+				failed = true
+				break
+			}
+			if !run {
+				continue
+			}
+
+			outcomeResponses, result, undo, outcomeFailed, err := executeOutcome(ctx, outcome, actionRequest, inFieldMap, cruds, actionHandlerMap)
+			responses = append(responses, outcomeResponses...)
+			undoLog = append(undoLog, undo...)
+
+			if !isLoop {
+				setOutcomeResult(inFieldMap, outcome.Reference, result)
+			} else if result != nil {
+				results = append(results, result)
+			}
+
+			if outcomeFailed || err != nil {
+				if outcome.ContinueOnError {
+					log.Infof("Outcome [%v] failed, continuing: %v", outcome.Type, err)
+					if err != nil {
+						responses = append(responses, NewActionResponse("client.notify", NewClientNotification("error", err.Error(), "Failed")))
 					}
+					continue
 				}
+				failed = outcomeFailed
+				outcomeErr = err
+				break
+			}
+		}
 
-			case "ACTIONRESPONSE":
-				//res, err = cruds[outcome.Type].Create(model, request)
-				log.Infof("Create action response: ", model.GetName())
-				var actionResponse ActionResponse
-				switch model.GetName() {
-				case "client.notify":
-					actionResponse = NewActionResponse("client.notify", model.Data)
-				case "client.redirect":
-					actionResponse = NewActionResponse("client.redirect", model.Data)
-				case "client.store.set":
-					actionResponse = NewActionResponse("client.store.set", model.Data)
-				case "error":
-					actionResponse = NewActionResponse("error", model.Data)
-				default:
-					log.Errorf("Unknown action response type: %v", model.GetName())
-				}
-				responses = append(responses, actionResponse)
+		if isLoop {
+			restoreInField(inFieldMap, "item", previousItem, hadItem)
+			restoreInField(inFieldMap, "index", previousIndex, hadIndex)
+			setOutcomeResult(inFieldMap, outcome.Reference, results)
+		}
+
+		if failed || outcomeErr != nil {
+			return stop(outcomeErr)
+		}
+	}
 
-			default:
-				log.Errorf("Unknown outcome method: %v", outcome.Method)
+	return responses, nil
+}
 
-			}
-			if res != nil && res.Result() != nil {
-				inFieldMap[outcome.Reference] = res.Result().(*api2go.Api2GoModel).Data
+// outcomeUndo is a row written by an outcome, with its values from before the change. A created row has no
+// previous values and is deleted on rollback.
+type outcomeUndo struct {
+	typeName    string
+	referenceId string
+	previous    map[string]interface{}
+}
+
+// outcomeItems is the list an outcome is run for, the ForEach list or a single run
+func outcomeItems(outcome Outcome, inFieldMap map[string]interface{}) ([]interface{}, bool, error) {
+
+	if outcome.ForEach == "" {
+		return []interface{}{nil}, false, nil
+	}
+
+	value, err := evaluateString(outcome.ForEach, inFieldMap)
+	if err != nil {
+		return nil, true, err
+	}
+	if value == nil {
+		return []interface{}{}, true, nil
+	}
 
-				if outcome.Reference == "" {
-					inFieldMap["subject"] = inFieldMap[outcome.Reference]
+	kind := reflect.TypeOf(value).Kind()
+	if kind != reflect.Slice && kind != reflect.Array {
+		return nil, true, fmt.Errorf("ForEach [%v] is not a list", outcome.ForEach)
+	}
+
+	list := reflect.ValueOf(value)
+	items := make([]interface{}, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		items[i] = list.Index(i).Interface()
+	}
+	return items, true, nil
+}
+
+func outcomeConditionHolds(outcome Outcome, inFieldMap map[string]interface{}) (bool, error) {
+
+	if outcome.Condition == "" {
+		return true, nil
+	}
+
+	result, err := runSandboxedJavascript(strings.TrimPrefix(outcome.Condition, "!"), inFieldMap)
+	if err != nil {
+		return false, err
+	}
+	isTrue, ok := result.(bool)
+	return ok && isTrue, nil
+}
+
+// setOutcomeResult makes the result of an outcome available to the next outcomes under its reference, the
+// items of a list also as reference[i]
+func setOutcomeResult(inFieldMap map[string]interface{}, reference string, result interface{}) {
+
+	if result == nil {
+		return
+	}
+
+	if list, ok := result.([]interface{}); ok {
+		for i, item := range list {
+			inFieldMap[fmt.Sprintf("%v[%v]", reference, i)] = item
+		}
+		inFieldMap[reference] = list
+		return
+	}
+
+	inFieldMap[reference] = result
+	if reference == "" {
+		inFieldMap["subject"] = result
+	}
+}
+
+func restoreInField(inFieldMap map[string]interface{}, key string, value interface{}, existed bool) {
+	if existed {
+		inFieldMap[key] = value
+	} else {
+		delete(inFieldMap, key)
+	}
+}
+
+// executeOutcome runs a single outcome. It returns the responses, the result for the next outcomes, the
+// rows it wrote, whether the outcome failed to build or its create, update or delete failed, and the error
+// of a failed performer.
+func executeOutcome(ctx context.Context, outcome Outcome, actionRequest ActionRequest, inFieldMap map[string]interface{}, cruds map[string]*DbResource, actionHandlerMap map[string]ActionPerformerInterface) ([]ActionResponse, interface{}, []outcomeUndo, bool, error) {
+
+	responses := make([]ActionResponse, 0)
+	undo := make([]outcomeUndo, 0)
+	var res api2go.Responder
+	var result interface{}
+
+	model, request, err := BuildOutcome(inFieldMap, outcome)
+	if err != nil {
+		log.Errorf("Failed to build outcome: %v", err)
+		responses = append(responses, NewActionResponse("error", "Failed to build outcome "+outcome.Type+": "+err.Error()))
+		return responses, nil, undo, true, nil
+	}
+
+	request.PlainRequest = request.PlainRequest.WithContext(ctx)
+
+	dbResource, ok := cruds[outcome.Type]
+	if !ok {
+		//log.Errorf("No DbResource for type [%v]", outcome.Type)
+	}
+
+	log.Infof("Next outcome method: %v", outcome.Method)
+	switch outcome.Method {
+	case "POST":
+		res, err = dbResource.Create(model, request)
+		if err != nil {
+			responses = append(responses, NewActionResponse("client.notify", NewClientNotification("error", "Failed to create "+model.GetName()+". "+err.Error(), "Failed")))
+			return responses, nil, undo, true, nil
+		}
+		responses = append(responses, NewActionResponse("client.notify", NewClientNotification("success", "Created "+model.GetName(), "Success")))
+		if res != nil && res.Result() != nil {
+			createdId, _ := res.Result().(*api2go.Api2GoModel).Data["reference_id"].(string)
+			undo = append(undo, outcomeUndo{typeName: outcome.Type, referenceId: createdId})
+		}
+	case "UPDATE":
+		referenceId, _ := model.Data["reference_id"].(string)
+		// the values of the columns this outcome changes, to put back on rollback
+		var changed map[string]interface{}
+		previous, _ := dbResource.GetReferenceIdToObjectInContext(ctx, outcome.Type, referenceId)
+		if previous != nil {
+			changed = map[string]interface{}{
+				"reference_id": referenceId,
+			}
+			for key := range model.Data {
+				if value, ok := previous[key]; ok {
+					changed[key] = value
 				}
 			}
+		}
+		res, err = dbResource.Update(model, request)
+		if err != nil {
+			responses = append(responses, NewActionResponse("client.notify", NewClientNotification("error", "Failed to update "+model.GetName()+". "+err.Error(), "Failed")))
+			return responses, nil, undo, true, nil
+		}
+		responses = append(responses, NewActionResponse("client.notify", NewClientNotification("success", "Updated "+model.GetName(), "Success")))
+		if changed != nil {
+			undo = append(undo, outcomeUndo{typeName: outcome.Type, referenceId: referenceId, previous: changed})
+		}
+	case "DELETE":
+		res, err = dbResource.Delete(model.Data["reference_id"].(string), request)
+		if err != nil {
+			responses = append(responses, NewActionResponse("client.notify", NewClientNotification("error", "Failed to delete "+model.GetName(), "Failed")))
+			return responses, nil, undo, true, nil
+		}
+		responses = append(responses, NewActionResponse("client.notify", NewClientNotification("success", "Deleted "+model.GetName(), "Success")))
+	case "EXECUTE":
+		//res, err = cruds[outcome.Type].Create(model, request)
 
-			if len(responses1) > 0 {
+		performer, ok := actionHandlerMap[model.GetName()]
+		if !ok {
+			log.Errorf("Invalid outcome method: [%v]%v", outcome.Method, model.GetName())
+			//return ginContext.AbortWithError(500, errors.New("Invalid outcome"))
+		} else {
+			performerResponses, errs := performer.DoAction(actionRequest, model.Data)
+			responses = append(responses, performerResponses...)
+			if len(errs) > 0 {
+				err = errs[0]
+			}
+			if len(performerResponses) > 0 {
 				lst := make([]interface{}, 0)
-				for i, res := range responses1 {
-					inFieldMap[fmt.Sprintf("%v[%v]", outcome.Reference, i)] = res.Attributes
-					lst = append(lst, res.Attributes)
+				for _, performerResponse := range performerResponses {
+					lst = append(lst, performerResponse.Attributes)
 				}
-				inFieldMap[outcome.Reference] = lst
+				result = lst
 			}
+		}
 
-			if err != nil {
-				ginContext.AbortWithError(500, err)
-				return
-			}
+	case "ACTIONRESPONSE":
+		//res, err = cruds[outcome.Type].Create(model, request)
+		log.Infof("Create action response: ", model.GetName())
+		var actionResponse ActionResponse
+		switch model.GetName() {
+		case "client.notify":
+			actionResponse = NewActionResponse("client.notify", model.Data)
+		case "client.redirect":
+			actionResponse = NewActionResponse("client.redirect", model.Data)
+		case "client.store.set":
+			actionResponse = NewActionResponse("client.store.set", model.Data)
+		case "error":
+			actionResponse = NewActionResponse("error", model.Data)
+		default:
+			log.Errorf("Unknown action response type: %v", model.GetName())
 		}
+		responses = append(responses, actionResponse)
 
-		//log.Infof("Final responses: %v", responses)
+	default:
+		log.Errorf("Unknown outcome method: %v", outcome.Method)
 
-		ginContext.JSON(200, responses)
+	}
+
+	if res != nil && res.Result() != nil {
+		result = res.Result().(*api2go.Api2GoModel).Data
+	}
+
+	return responses, result, undo, false, err
+}
+
+// rollbackOutcomes deletes the rows created and changes back the rows updated by the outcomes which ran,
+// latest first. Deleted rows cannot be brought back. A failure is logged and the rollback goes on.
+// Password and encrypted columns hold the stored hash or cipher text, going through Update would hash or
+// encrypt them again, so they are written back as they are.
+func rollbackOutcomes(ctx context.Context, cruds map[string]*DbResource, undoLog []outcomeUndo) {
+
+	for i := len(undoLog) - 1; i >= 0; i-- {
+		undo := undoLog[i]
+		if undo.referenceId == "" {
+			continue
+		}
+
+		request := api2go.Request{
+			PlainRequest: (&http.Request{
+				Method: "POST",
+			}).WithContext(ctx),
+		}
+
+		var err error
+		if undo.previous == nil {
+			request.PlainRequest.Method = "DELETE"
+			_, err = cruds[undo.typeName].Delete(undo.referenceId, request)
+		} else {
+			err = restoreOutcomeRow(cruds[undo.typeName], undo, request)
+		}
+
+		if err != nil {
+			log.Errorf("Failed to roll back [%v][%v]: %v", undo.typeName, undo.referenceId, err)
+		}
+	}
+}
+
+func restoreOutcomeRow(dbResource *DbResource, undo outcomeUndo, request api2go.Request) error {
+
+	columnMap := dbResource.model.GetColumnMap()
+	values := make(map[string]interface{})
+	stored := make(map[string]interface{})
+	for key, value := range undo.previous {
+		column, ok := columnMap[key]
+		if ok && (column.ColumnType == "password" || column.ColumnType == "encrypted") {
+			stored[key] = value
+			continue
+		}
+		values[key] = value
+	}
+
+	if len(values) > 1 {
+		model := api2go.NewApi2GoModelWithData(undo.typeName, nil, auth.DEFAULT_PERMISSION.IntValue(), nil, values)
+		_, err := dbResource.Update(model, request)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(stored) == 0 {
+		return nil
+	}
 
+	s, v, err := squirrel.Update(undo.typeName).SetMap(stored).Where(squirrel.Eq{"reference_id": undo.referenceId}).ToSql()
+	if err != nil {
+		return err
 	}
+	_, err = dbResource.db.Exec(s, v...)
+	return err
 }
+
 func NewClientNotification(notificationType string, message string, title string) map[string]interface{} {
 
 	m := make(map[string]interface{})
@@ -497,23 +891,6 @@ func BuildOutcome(inFieldMap map[string]interface{}, outcome Outcome) (*api2go.A
 
 }
 
-func runUnsafeJavascript(unsafe string, contextMap map[string]interface{}) (interface{}, error) {
-
-	vm := goja.New()
-
-	//vm.ToValue(contextMap)
-	for key, val := range contextMap {
-		vm.Set(key, val)
-	}
-	v, err := vm.RunString(unsafe) // Here be dragons (risky code)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return v.Export(), nil
-}
-
 func buildActionContext(outcomeAttributes interface{}, inFieldMap map[string]interface{}) (interface{}, error) {
 
 	var data interface{}
@@ -608,7 +985,7 @@ func evaluateString(fieldString string, inFieldMap map[string]interface{}) (inte
 
 	if fieldString[0] == '!' {
 
-		res, err := runUnsafeJavascript(fieldString[1:], inFieldMap)
+		res, err := runSandboxedJavascript(fieldString[1:], inFieldMap)
 		if err != nil {
 			return nil, err
 		}
@@ -677,23 +1054,3 @@ func evaluateString(fieldString string, inFieldMap map[string]interface{}) (inte
 	return val, nil
 
 }
-
-func GetValidatedInFields(actionRequest ActionRequest, action Action) (map[string]interface{}, error) {
-
-	dataMap := actionRequest.Attributes
-	finalDataMap := make(map[string]interface{})
-	for _, inField := range action.InFields {
-		val, ok := dataMap[inField.ColumnName]
-		if ok {
-			finalDataMap[inField.ColumnName] = val
-
-		} else if inField.DefaultValue != "" {
-		} else if inField.IsNullable {
-
-		} else {
-			return nil, errors.New(fmt.Sprintf("Field %s cannot be blank", inField.Name))
-		}
-	}
-
-	return finalDataMap, nil
-}
@@ -0,0 +1,174 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"github.com/daptin/daptin/server/auth"
+	"testing"
+)
+
+// failingPerformer is an action performer which always fails
+type failingPerformer struct {
+}
+
+func (p *failingPerformer) Name() string {
+	return "test.fail"
+}
+
+func (p *failingPerformer) DoAction(request ActionRequest, inFields map[string]interface{}) ([]ActionResponse, []error) {
+	return nil, []error{errors.New("test failure")}
+}
+
+func outcomeTestContext() context.Context {
+	return context.WithValue(context.Background(), "user", auth.SessionUser{UserId: 2, UserReferenceId: "user-2"})
+}
+
+func TestOutcomeForEachRunsForEveryItem(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+
+	performer := &recordingPerformer{}
+	action := Action{
+		Name:   "tag",
+		OnType: "note",
+		OutFields: []Outcome{
+			{
+				Type:       "test.record",
+				Method:     "EXECUTE",
+				Reference:  "tagged",
+				ForEach:    "~tags",
+				Attributes: map[string]interface{}{"tag": "~item", "position": "~index"},
+			},
+		},
+	}
+	inFieldMap := map[string]interface{}{
+		"tags": []interface{}{"red", "blue"},
+		"item": "outer",
+	}
+
+	_, err := ExecuteActionOutcomes(outcomeTestContext(), action, ActionRequest{}, inFieldMap, cruds, ActionPerformerMap([]ActionPerformerInterface{performer}))
+	if err != nil {
+		t.Fatalf("Failed to run outcomes: %v", err)
+	}
+
+	if len(performer.calls) != 2 {
+		t.Fatalf("Expected a run for every item, got %v", performer.calls)
+	}
+	for i, tag := range []string{"red", "blue"} {
+		call := performer.calls[i]
+		if call.inFields["tag"] != tag || call.inFields["position"] != i {
+			t.Errorf("Expected item [%v] at [%v], got %v", tag, i, call.inFields)
+		}
+	}
+
+	if inFieldMap["item"] != "outer" {
+		t.Errorf("Expected the item from before the loop to be put back, got [%v]", inFieldMap["item"])
+	}
+	if _, ok := inFieldMap["index"]; ok {
+		t.Errorf("Expected the index to be removed after the loop, got [%v]", inFieldMap["index"])
+	}
+	results, ok := inFieldMap["tagged"].([]interface{})
+	if !ok || len(results) != 2 {
+		t.Errorf("Expected the results of every run under the reference, got %v", inFieldMap["tagged"])
+	}
+}
+
+func TestOutcomeIsSkippedWhenConditionIsFalse(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+
+	performer := &recordingPerformer{}
+	action := Action{
+		Name:   "record",
+		OnType: "note",
+		OutFields: []Outcome{
+			{
+				Type:       "test.record",
+				Method:     "EXECUTE",
+				Condition:  "!title == 'skip'",
+				Attributes: map[string]interface{}{"step": "conditional"},
+			},
+			{
+				Type:       "test.record",
+				Method:     "EXECUTE",
+				Attributes: map[string]interface{}{"step": "always"},
+			},
+		},
+	}
+	actionHandlerMap := ActionPerformerMap([]ActionPerformerInterface{performer})
+
+	_, err := ExecuteActionOutcomes(outcomeTestContext(), action, ActionRequest{}, map[string]interface{}{"title": "run"}, cruds, actionHandlerMap)
+	if err != nil {
+		t.Fatalf("Failed to run outcomes: %v", err)
+	}
+	if len(performer.calls) != 1 || performer.calls[0].inFields["step"] != "always" {
+		t.Fatalf("Expected only the outcome without a condition to run, got %v", performer.calls)
+	}
+
+	_, err = ExecuteActionOutcomes(outcomeTestContext(), action, ActionRequest{}, map[string]interface{}{"title": "skip"}, cruds, actionHandlerMap)
+	if err != nil {
+		t.Fatalf("Failed to run outcomes: %v", err)
+	}
+	if len(performer.calls) != 3 || performer.calls[1].inFields["step"] != "conditional" {
+		t.Errorf("Expected both outcomes to run when the condition holds, got %v", performer.calls)
+	}
+}
+
+func TestFailedOutcomeRollsBackEarlierOutcomes(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+
+	action := Action{
+		Name:            "rewrite",
+		OnType:          "note",
+		RollbackOnError: true,
+		OutFields: []Outcome{
+			{
+				Type:       "note",
+				Method:     "POST",
+				Attributes: map[string]interface{}{"title": "~title"},
+			},
+			{
+				Type:       "note",
+				Method:     "UPDATE",
+				Attributes: map[string]interface{}{"reference_id": "note-1", "title": "~title"},
+			},
+			{
+				Type:       "test.fail",
+				Method:     "EXECUTE",
+				Attributes: map[string]interface{}{},
+			},
+		},
+	}
+
+	responses, err := ExecuteActionOutcomes(outcomeTestContext(), action, ActionRequest{}, map[string]interface{}{"title": "changed"}, cruds, ActionPerformerMap([]ActionPerformerInterface{&failingPerformer{}}))
+	if err == nil {
+		t.Fatalf("Expected the error of the failed outcome")
+	}
+
+	var count int
+	err = cruds["note"].db.QueryRowx("select count(*) from note").Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count notes: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the created note to be deleted, %v notes left", count)
+	}
+
+	var title string
+	err = cruds["note"].db.QueryRowx("select title from note where reference_id = 'note-1'").Scan(&title)
+	if err != nil {
+		t.Fatalf("Failed to read note: %v", err)
+	}
+	if title != "run" {
+		t.Errorf("Expected the updated note to be changed back, got [%v]", title)
+	}
+
+	last := responses[len(responses)-1].Attributes.(map[string]interface{})
+	if last["title"] != "Rolled back" {
+		t.Errorf("Expected the rollback to be reported, got %v", responses)
+	}
+}
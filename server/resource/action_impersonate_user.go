@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImpersonateUserActionPerformer lets the administrator act as another user to see what they see. The token
+// it issues is valid for ImpersonationTokenValidity and every write made with it is recorded in the timeline.
+type ImpersonateUserActionPerformer struct {
+	cruds  map[string]*DbResource
+	secret []byte
+}
+
+func (d *ImpersonateUserActionPerformer) Name() string {
+	return "user.impersonate"
+}
+
+func (d *ImpersonateUserActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	admin, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok || !d.cruds["user"].IsAdmin(admin["reference_id"].(string)) {
+		return nil, []error{errors.New("Only the administrator can impersonate users")}
+	}
+
+	target, ok := inFieldMap["target"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("No user to impersonate")}
+	}
+
+	if target["reference_id"] == admin["reference_id"] {
+		return nil, []error{errors.New("Cannot impersonate yourself")}
+	}
+
+	token, err := CreateImpersonationToken(admin, target, d.secret)
+	if err != nil {
+		log.Errorf("Failed to sign impersonation token: %v", err)
+		return nil, []error{err}
+	}
+
+	d.cruds["user"].AddTimelineEvent("user", "impersonation.started", "Impersonation started", map[string]interface{}{
+		"impersonator":      admin["email"],
+		"email":             target["email"],
+		"user_reference_id": target["reference_id"],
+	})
+
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["value"] = token
+	responseAttrs["key"] = "token"
+
+	redirectAttrs := make(map[string]interface{})
+	redirectAttrs["location"] = "/"
+	redirectAttrs["window"] = "self"
+	redirectAttrs["delay"] = 1000
+
+	return []ActionResponse{
+		NewActionResponse("client.store.set", responseAttrs),
+		NewActionResponse("client.notify", NewClientNotification("success", "Now acting as "+target["email"].(string), "Impersonating")),
+		NewActionResponse("client.redirect", redirectAttrs),
+	}, nil
+}
+
+func NewImpersonateUserActionPerformer(configStore *ConfigStore, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
+
+	handler := ImpersonateUserActionPerformer{
+		cruds:  cruds,
+		secret: []byte(secret),
+	}
+
+	return &handler, nil
+
+}
@@ -89,8 +89,15 @@ func (d *ImportDataPerformer) DoAction(request ActionRequest, inFields map[strin
 
 	}
 
+	tablesDone := 0
 	for tableName, importedDatas := range imports {
 
+		if request.Job != nil {
+			request.Job.Progress(tablesDone * 100 / len(imports))
+			request.Job.Log("Importing " + tableName)
+		}
+		tablesDone += 1
+
 		if truncate_before_insert {
 			err := d.cruds[tableName].TruncateTable(tableName)
 			if err != nil {
@@ -0,0 +1,196 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/artpar/api2go"
+	"gopkg.in/go-playground/validator.v9"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the column types only check single values, they need no custom validations
+var inputValidator = validator.New()
+
+// ActionInputError lists the input fields of an action which are missing or invalid, with the problem of
+// each
+type ActionInputError struct {
+	Fields map[string]string
+}
+
+func (e *ActionInputError) Error() string {
+
+	names := make([]string, 0)
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := make([]string, 0)
+	for _, name := range names {
+		problems = append(problems, name+": "+e.Fields[name])
+	}
+	return "Invalid input, " + strings.Join(problems, ", ")
+}
+
+// GetValidatedInFields checks every input field of the action against its column type and returns them
+// converted: numbers as float64, truefalse as bool, files as a list of {name, file, type} and everything
+// else as text. A missing field gets its default value, truefalse fields are false and other fields fail
+// unless they are nullable. An empty string counts as missing.
+func GetValidatedInFields(actionRequest ActionRequest, action Action) (map[string]interface{}, error) {
+
+	dataMap := actionRequest.Attributes
+	finalDataMap := make(map[string]interface{})
+	problems := make(map[string]string)
+
+	for _, inField := range action.InFields {
+
+		val, ok := dataMap[inField.ColumnName]
+		if !ok || val == nil || val == "" {
+			switch {
+			case inField.DefaultValue != "":
+				val = strings.Trim(inField.DefaultValue, "'")
+			case inField.ColumnType == "truefalse":
+				val = false
+			case inField.IsNullable:
+				continue
+			default:
+				problems[inField.ColumnName] = "is required"
+				continue
+			}
+		}
+
+		converted, err := convertInputValue(val, inField)
+		if err != nil {
+			problems[inField.ColumnName] = err.Error()
+			continue
+		}
+		finalDataMap[inField.ColumnName] = converted
+	}
+
+	if len(problems) > 0 {
+		return nil, &ActionInputError{Fields: problems}
+	}
+
+	return finalDataMap, nil
+}
+
+// convertInputValue converts the value to the type of the column and checks it with the validations of the
+// column type
+func convertInputValue(val interface{}, inField api2go.ColumnInfo) (interface{}, error) {
+
+	columnType := inField.ColumnType
+
+	if strings.HasPrefix(columnType, "file") {
+		return convertInputFiles(val)
+	}
+
+	var converted interface{}
+
+	switch {
+	case columnType == "truefalse":
+		switch typed := val.(type) {
+		case bool:
+			converted = typed
+		case float64:
+			converted = typed != 0
+		case string:
+			lower := strings.ToLower(strings.TrimSpace(typed))
+			parsed, err := strconv.ParseBool(lower)
+			if err != nil {
+				if lower != "on" && lower != "off" {
+					return nil, fmt.Errorf("should be true or false")
+				}
+				parsed = lower == "on"
+			}
+			converted = parsed
+		default:
+			return nil, fmt.Errorf("should be true or false")
+		}
+
+	case ColumnManager != nil && ColumnManager.GetBlueprintType(columnType) == "number":
+		switch typed := val.(type) {
+		case float64:
+			converted = typed
+		case int:
+			converted = float64(typed)
+		case int64:
+			converted = float64(typed)
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+			if err != nil {
+				return nil, fmt.Errorf("should be a number")
+			}
+			converted = parsed
+		default:
+			return nil, fmt.Errorf("should be a number")
+		}
+
+	case columnType == "json":
+		switch typed := val.(type) {
+		case string:
+			var parsed interface{}
+			if json.Unmarshal([]byte(typed), &parsed) != nil {
+				return nil, fmt.Errorf("should be json")
+			}
+			converted = typed
+		default:
+			converted = typed
+		}
+
+	default:
+		switch typed := val.(type) {
+		case string:
+			converted = typed
+		case float64:
+			converted = strconv.FormatFloat(typed, 'f', -1, 64)
+		case bool:
+			converted = strconv.FormatBool(typed)
+		default:
+			return nil, fmt.Errorf("should be text")
+		}
+	}
+
+	if ColumnManager != nil {
+		err := ColumnManager.IsValidValue(converted, columnType, inputValidator)
+		if err != nil {
+			return nil, fmt.Errorf("is not a valid %v", columnType)
+		}
+	}
+
+	return converted, nil
+}
+
+// convertInputFiles is the list of uploaded files, a single file is taken as a list of one
+func convertInputFiles(val interface{}) ([]interface{}, error) {
+
+	var files []interface{}
+	switch typed := val.(type) {
+	case []interface{}:
+		files = typed
+	case map[string]interface{}:
+		files = []interface{}{typed}
+	default:
+		return nil, fmt.Errorf("should be a list of files")
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("should have a file")
+	}
+
+	for _, file := range files {
+		fileMap, ok := file.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("should be a list of files")
+		}
+		if name, ok := fileMap["name"].(string); !ok || name == "" {
+			return nil, fmt.Errorf("has a file without a name")
+		}
+		if contents, ok := fileMap["file"].(string); !ok || contents == "" {
+			return nil, fmt.Errorf("has a file without contents")
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"reflect"
+	"testing"
+)
+
+var testInputAction = Action{
+	Name:   "publish",
+	OnType: "note",
+	InFields: []api2go.ColumnInfo{
+		{Name: "count", ColumnName: "count", ColumnType: "measurement"},
+		{Name: "public", ColumnName: "public", ColumnType: "truefalse"},
+		{Name: "notify", ColumnName: "notify", ColumnType: "truefalse"},
+		{Name: "month", ColumnName: "month", ColumnType: "month"},
+		{Name: "title", ColumnName: "title", ColumnType: "label"},
+		{Name: "status", ColumnName: "status", ColumnType: "label", DefaultValue: "'draft'"},
+		{Name: "summary", ColumnName: "summary", ColumnType: "content", IsNullable: true},
+		{Name: "settings", ColumnName: "settings", ColumnType: "json"},
+		{Name: "attachment", ColumnName: "attachment", ColumnType: "file.*"},
+	},
+}
+
+func TestGetValidatedInFieldsConvertsByColumnType(t *testing.T) {
+
+	InitialiseColumnManager()
+
+	attachment := map[string]interface{}{"name": "a.txt", "file": "aGVsbG8=", "type": "text/plain"}
+	inFields, err := GetValidatedInFields(ActionRequest{
+		Attributes: map[string]interface{}{
+			"count":      "12.5",
+			"public":     "on",
+			"month":      float64(3),
+			"title":      float64(42),
+			"status":     "",
+			"settings":   `{"color": "red"}`,
+			"attachment": attachment,
+			"unknown":    "left out",
+		},
+	}, testInputAction)
+	if err != nil {
+		t.Fatalf("Expected the input to be valid: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"count":      12.5,
+		"public":     true,
+		"notify":     false,
+		"month":      float64(3),
+		"title":      "42",
+		"status":     "draft",
+		"settings":   `{"color": "red"}`,
+		"attachment": []interface{}{attachment},
+	}
+	if !reflect.DeepEqual(inFields, expected) {
+		t.Errorf("Expected %v, got %v", expected, inFields)
+	}
+}
+
+func TestGetValidatedInFieldsListsEveryProblem(t *testing.T) {
+
+	InitialiseColumnManager()
+
+	_, err := GetValidatedInFields(ActionRequest{
+		Attributes: map[string]interface{}{
+			"count":      "twelve",
+			"public":     "maybe",
+			"month":      "13",
+			"settings":   "{",
+			"attachment": []interface{}{map[string]interface{}{"file": "aGVsbG8="}},
+		},
+	}, testInputAction)
+
+	inputError, ok := err.(*ActionInputError)
+	if !ok {
+		t.Fatalf("Expected an input error, got %v", err)
+	}
+
+	expected := map[string]string{
+		"count":      "should be a number",
+		"public":     "should be true or false",
+		"month":      "is not a valid month",
+		"title":      "is required",
+		"settings":   "should be json",
+		"attachment": "has a file without a name",
+	}
+	if !reflect.DeepEqual(inputError.Fields, expected) {
+		t.Errorf("Expected %v, got %v", expected, inputError.Fields)
+	}
+}
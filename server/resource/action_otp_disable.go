@@ -0,0 +1,58 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type OtpDisableActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *OtpDisableActionPerformer) Name() string {
+	return "otp.disable"
+}
+
+func (d *OtpDisableActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	u, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("Unauthorized")}
+	}
+
+	if !IsTruthy(u["otp_enabled"]) {
+		return nil, []error{errors.New("Two factor authentication is not enabled")}
+	}
+
+	userId := u["id"].(int64)
+	if d.cruds["user"].IsOtpRequiredForUser(userId) {
+		return nil, []error{errors.New("Two factor authentication is required for your usergroup")}
+	}
+
+	code, _ := inFieldMap["otp"].(string)
+	if !d.cruds["user"].VerifyOtpCode(u, code) {
+		return []ActionResponse{
+			NewActionResponse("client.notify", NewClientNotification("error", "Invalid code", "Failed")),
+		}, nil
+	}
+
+	err := d.cruds["user"].SetOtpEnabled(userId, false)
+	if err != nil {
+		log.Errorf("Failed to disable otp for [%v]: %v", u["email"], err)
+		return nil, []error{err}
+	}
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Two factor authentication disabled", "Success")),
+	}, nil
+}
+
+func NewOtpDisableActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := OtpDisableActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type OtpGenerateActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *OtpGenerateActionPerformer) Name() string {
+	return "otp.generate"
+}
+
+func (d *OtpGenerateActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	u, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("Unauthorized")}
+	}
+
+	if IsTruthy(u["otp_enabled"]) {
+		return nil, []error{errors.New("Two factor authentication is already enabled")}
+	}
+
+	key, err := GenerateOtpKey(u["email"].(string))
+	if err != nil {
+		log.Errorf("Failed to generate otp key: %v", err)
+		return nil, []error{err}
+	}
+
+	recoveryCodes, recoveryCodesJson, err := GenerateRecoveryCodes(OtpRecoveryCodeCount)
+	if err != nil {
+		log.Errorf("Failed to generate recovery codes: %v", err)
+		return nil, []error{err}
+	}
+
+	err = d.cruds["user"].StoreOtpSecret(u["id"].(int64), key.Secret(), recoveryCodesJson)
+	if err != nil {
+		log.Errorf("Failed to store otp secret: %v", err)
+		return nil, []error{err}
+	}
+
+	responses := []ActionResponse{
+		NewOtpEnrollmentResponse(key.URL(), key.Secret(), recoveryCodes),
+		NewActionResponse("client.notify", NewClientNotification("success", "Scan the code in your authenticator app and verify it to finish", "Two factor authentication")),
+	}
+
+	return responses, nil
+}
+
+func NewOtpGenerateActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := OtpGenerateActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
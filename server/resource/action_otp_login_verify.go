@@ -0,0 +1,106 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+)
+
+// OtpLoginVerifyActionPerformer is the second step of signin for users with two factor authentication.
+// It takes the challenge issued by jwt.token along with a totp or recovery code and issues the session token.
+type OtpLoginVerifyActionPerformer struct {
+	cruds  map[string]*DbResource
+	secret []byte
+}
+
+func (d *OtpLoginVerifyActionPerformer) Name() string {
+	return "otp.login.verify"
+}
+
+func (d *OtpLoginVerifyActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	challenge, _ := inFieldMap["otp_challenge"].(string)
+	code, _ := inFieldMap["otp"].(string)
+
+	failed := []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("error", "Invalid or expired code", "Failed")),
+	}
+
+	email, err := ParseOtpChallengeToken(challenge, d.secret)
+	if err != nil {
+		log.Infof("Invalid otp challenge: %v", err)
+		return failed, nil
+	}
+
+	existingUsers, _, err := d.cruds["user"].GetRowsByWhereClause("user", squirrel.Eq{"email": email})
+	if err != nil || len(existingUsers) < 1 {
+		return failed, nil
+	}
+	existingUser := existingUsers[0]
+
+	throttled := d.cruds["user"].CheckLoginThrottle(existingUser, request.ClientIp)
+	if throttled != nil {
+		return throttled, nil
+	}
+
+	responses := make([]ActionResponse, 0)
+
+	if IsTruthy(existingUser["otp_enabled"]) {
+		if !d.cruds["user"].VerifyOtpCode(existingUser, code) {
+			d.cruds["user"].RecordLoginFailure(existingUser, email, request.ClientIp)
+			return failed, nil
+		}
+	} else {
+		// user was asked to enroll during signin, the first valid code completes the enrollment and gets
+		// the recovery codes
+		userId := existingUser["id"].(int64)
+		secret, err := d.cruds["user"].GetOtpSecret(existingUser)
+		if err != nil || !d.cruds["user"].ValidateOtpCode(userId, secret, code) {
+			d.cruds["user"].RecordLoginFailure(existingUser, email, request.ClientIp)
+			return failed, nil
+		}
+
+		recoveryCodes, recoveryCodesJson, err := GenerateRecoveryCodes(OtpRecoveryCodeCount)
+		if err != nil {
+			log.Errorf("Failed to generate recovery codes: %v", err)
+			return nil, []error{err}
+		}
+		err = d.cruds["user"].StoreOtpRecoveryCodes(userId, recoveryCodesJson)
+		if err != nil {
+			log.Errorf("Failed to store recovery codes for [%v]: %v", email, err)
+			return nil, []error{errors.New("Failed to enable two factor authentication")}
+		}
+
+		err = d.cruds["user"].SetOtpEnabled(userId, true)
+		if err != nil {
+			log.Errorf("Failed to enable otp for [%v]: %v", email, err)
+			return nil, []error{errors.New("Failed to enable two factor authentication")}
+		}
+		responses = append(responses, NewOtpEnrollmentResponse("", "", recoveryCodes))
+	}
+
+	err = d.cruds["user"].ResetLoginFailures(existingUser["id"].(int64))
+	if err != nil {
+		log.Errorf("Failed to reset login failures for [%v]: %v", email, err)
+	}
+
+	tokenResponses, err := CreateJwtTokenResponses(existingUser, d.secret)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return append(responses, tokenResponses...), nil
+}
+
+func NewOtpLoginVerifyActionPerformer(configStore *ConfigStore, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
+
+	handler := OtpLoginVerifyActionPerformer{
+		secret: []byte(secret),
+		cruds:  cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,57 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// OtpVerifyActionPerformer completes the enrollment started by otp.generate. Only a code from the
+// authenticator app is accepted here, recovery codes cannot be used to turn on 2fa.
+type OtpVerifyActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *OtpVerifyActionPerformer) Name() string {
+	return "otp.verify"
+}
+
+func (d *OtpVerifyActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	u, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("Unauthorized")}
+	}
+
+	code, _ := inFieldMap["otp"].(string)
+
+	secret, err := d.cruds["user"].GetOtpSecret(u)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if !d.cruds["user"].ValidateOtpCode(u["id"].(int64), secret, code) {
+		return []ActionResponse{
+			NewActionResponse("client.notify", NewClientNotification("error", "Invalid code", "Failed")),
+		}, nil
+	}
+
+	err = d.cruds["user"].SetOtpEnabled(u["id"].(int64), true)
+	if err != nil {
+		log.Errorf("Failed to enable otp for [%v]: %v", u["email"], err)
+		return nil, []error{err}
+	}
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Two factor authentication enabled", "Success")),
+	}, nil
+}
+
+func NewOtpVerifyActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := OtpVerifyActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
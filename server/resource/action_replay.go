@@ -0,0 +1,146 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// ActionReplayPerformer runs a past execution from the action_execution log again, with the same input, as
+// the user who ran it. Only the administrator can replay, and the user still needs to be allowed the
+// action. Executions with inputs which were not kept, like passwords and files, cannot be replayed.
+type ActionReplayPerformer struct {
+	cruds            map[string]*DbResource
+	actionHandlerMap map[string]ActionPerformerInterface
+}
+
+func (d *ActionReplayPerformer) Name() string {
+	return "action.replay"
+}
+
+// SetActionPerformers gives the performer the performers to run the replayed action with, they are all
+// created after it
+func (d *ActionReplayPerformer) SetActionPerformers(actionPerformers []ActionPerformerInterface) {
+	d.actionHandlerMap = ActionPerformerMap(actionPerformers)
+}
+
+func (d *ActionReplayPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	admin, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok || !d.cruds["user"].IsAdmin(admin["reference_id"].(string)) {
+		return nil, []error{errors.New("Only the administrator can replay actions")}
+	}
+
+	executionRow, ok := inFieldMap["execution"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("No execution to replay")}
+	}
+
+	execution, err := d.cruds["action_execution"].getActionExecution(executionRow["reference_id"].(string))
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if isRedacted(execution.Input) {
+		return nil, []error{errors.New("The input of this execution was not kept in full, it cannot be replayed")}
+	}
+
+	action, err := d.cruds["action"].GetActionByName(execution.OnType, execution.ActionName)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	userReferenceId := ""
+	if execution.UserId != 0 {
+		userReferenceId, err = d.cruds["user"].GetIdToReferenceId("user", execution.UserId)
+		if err != nil {
+			return nil, []error{errors.New("The user who ran this execution no longer exists")}
+		}
+	}
+
+	var sessionUser auth.SessionUser
+	if userReferenceId != "" {
+		sessionUser, _, err = d.cruds["user"].GetSessionUser(userReferenceId)
+		if err != nil {
+			return nil, []error{err}
+		}
+	}
+
+	tenant := d.cruds["tenant"].GetTenantOfUser(execution.UserId)
+
+	var subject map[string]interface{}
+	if execution.SubjectReferenceId != "" {
+		subject, err = d.cruds[execution.OnType].GetReferenceIdToObjectInContext(NewTenantContext(sessionUser, tenant), execution.OnType, execution.SubjectReferenceId)
+		if err != nil {
+			return nil, []error{errors.New("The subject of this execution no longer exists")}
+		}
+		subject["__type"] = execution.OnType
+	}
+
+	if !CanExecuteAction(d.cruds, sessionUser, execution.OnType, execution.ActionName, subject) {
+		return nil, []error{errors.New("The user who ran this execution is no longer allowed this action")}
+	}
+
+	actionRequest := ActionRequest{
+		Type:       execution.OnType,
+		Action:     execution.ActionName,
+		Attributes: execution.Input,
+		ClientIp:   request.ClientIp,
+	}
+	startedAt := time.Now()
+
+	log.Infof("Replaying execution [%v] of [%v][%v] for [%v]", execution.ReferenceId, execution.OnType, execution.ActionName, userReferenceId)
+
+	var responses []ActionResponse
+	if action.IsAsync {
+		var jobReferenceId string
+		jobReferenceId, err = d.cruds["job"].EnqueueActionJob(actionRequest, sessionUser, execution.SubjectReferenceId, tenant)
+		if err == nil {
+			responses = []ActionResponse{
+				NewActionResponse("job", map[string]interface{}{
+					"reference_id": jobReferenceId,
+					"status":       JobStatusQueued,
+				}),
+			}
+		}
+	} else {
+		responses, err = ExecuteActionAs(d.cruds, d.actionHandlerMap, actionRequest, userReferenceId, execution.SubjectReferenceId, tenant)
+	}
+
+	replayReferenceId := d.cruds["action_execution"].RecordActionExecution(ActionExecution{
+		Action:             action,
+		Request:            actionRequest,
+		UserId:             execution.UserId,
+		SubjectReferenceId: execution.SubjectReferenceId,
+		Responses:          responses,
+		Error:              err,
+		StartedAt:          startedAt,
+		ReplayOf:           execution.ReferenceId,
+	})
+
+	d.cruds["action_execution"].AddTimelineEvent("action_execution", "action.replayed", "Action replayed", map[string]interface{}{
+		"replayed_by":  admin["email"],
+		"execution_id": execution.ReferenceId,
+		"replay_id":    replayReferenceId,
+		"action_name":  execution.ActionName,
+		"on_type":      execution.OnType,
+	})
+
+	if err != nil {
+		log.Errorf("Replay of execution [%v] failed: %v", execution.ReferenceId, err)
+		return responses, []error{err}
+	}
+
+	return append(responses, NewActionResponse("client.notify", NewClientNotification("success", "Replayed "+execution.ActionName, "Replayed"))), nil
+}
+
+func NewActionReplayPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (*ActionReplayPerformer, error) {
+
+	handler := ActionReplayPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -0,0 +1,337 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/dop251/goja"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// scripts of performers call the database and other services, ScriptTimeout is too short for them
+const ScriptPerformerTimeout = 30 * time.Second
+
+// timeout of a single http.request call
+const scriptHttpTimeout = 10 * time.Second
+
+// response bodies of http.request are cut off after this many bytes
+const scriptHttpMaxResponse = 5 << 20
+
+// ScriptedPerformer is an action performer written in javascript, declared in the schema files. Outcomes
+// run it with the EXECUTE method, like the performers written in go.
+//
+//	ScriptedPerformers:
+//	- Name: order.invoice
+//	  Script: |
+//	    var order = daptin.find("order", input.order_id);
+//	    var invoice = daptin.create("invoice", {order_id: order.reference_id, total: order.total});
+//	    return [response("client.notify", {type: "success", title: "Done", message: "Invoice created"})];
+type ScriptedPerformer struct {
+	Name   string
+	Script string
+}
+
+// ScriptActionPerformer runs the script of a ScriptedPerformer in the sandbox. Rows are read and written
+// through DbResource as the user running the action, so the same permissions apply as on the api.
+type ScriptActionPerformer struct {
+	name   string
+	source string
+	cruds  map[string]*DbResource
+	client *http.Client
+}
+
+func (d *ScriptActionPerformer) Name() string {
+	return d.name
+}
+
+func (d *ScriptActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	ctx := request.RequestContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sessionUser, _ := ctx.Value("user").(auth.SessionUser)
+
+	scriptContext := map[string]interface{}{
+		"input": inFieldMap,
+		"user": map[string]interface{}{
+			"reference_id": sessionUser.UserReferenceId,
+		},
+	}
+
+	result, err := runScript(d.source, scriptContext, func(vm *goja.Runtime) {
+		vm.Set("daptin", d.daptinLibrary(vm, ctx))
+		vm.Set("http", d.httpLibrary(vm, ctx))
+		vm.Set("response", func(responseType string, attributes interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"ResponseType": responseType,
+				"Attributes":   attributes,
+			}
+		})
+	}, ScriptPerformerTimeout)
+	if err != nil {
+		log.Errorf("Script performer [%v] failed: %v", d.name, err)
+		return nil, []error{err}
+	}
+
+	responses, err := scriptResponses(result)
+	if err != nil {
+		log.Errorf("Script performer [%v] returned invalid responses: %v", d.name, err)
+		return nil, []error{err}
+	}
+
+	return responses, nil
+}
+
+// scriptResponses reads the list of responses returned by a script, made with response(type, attributes)
+func scriptResponses(result interface{}) ([]ActionResponse, error) {
+
+	responses := make([]ActionResponse, 0)
+	if result == nil {
+		return responses, nil
+	}
+
+	list, ok := result.([]interface{})
+	if !ok {
+		return nil, errors.New("script should return a list of responses")
+	}
+
+	for _, item := range list {
+		response, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid response [%v]", item)
+		}
+		responseType, ok := response["ResponseType"].(string)
+		if !ok || responseType == "" {
+			return nil, fmt.Errorf("response without a type [%v]", item)
+		}
+		responses = append(responses, NewActionResponse(responseType, response["Attributes"]))
+	}
+
+	return responses, nil
+}
+
+func scriptRequest(ctx context.Context, method string, queryParams map[string][]string) api2go.Request {
+	return api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: method,
+		}).WithContext(ctx),
+		QueryParams: queryParams,
+	}
+}
+
+// scriptRow is a row as plain values for the script, a failure is thrown in the script
+func scriptRow(vm *goja.Runtime, row map[string]interface{}) interface{} {
+	if row == nil {
+		return nil
+	}
+	value, err := scriptValue(row)
+	if err != nil {
+		panic(vm.ToValue(err.Error()))
+	}
+	return value
+}
+
+// daptinLibrary is the daptin object of the script, with the rows the user can see and change
+func (d *ScriptActionPerformer) daptinLibrary(vm *goja.Runtime, ctx context.Context) *goja.Object {
+
+	resourceOf := func(typeName string) *DbResource {
+		dr, ok := d.cruds[typeName]
+		if !ok {
+			panic(vm.ToValue("unknown type " + typeName))
+		}
+		return dr
+	}
+
+	daptinLib := vm.NewObject()
+
+	daptinLib.Set("find", func(typeName string, referenceId string) interface{} {
+		res, err := resourceOf(typeName).FindOne(referenceId, scriptRequest(ctx, "GET", nil))
+		if err != nil || res == nil || res.Result() == nil {
+			return nil
+		}
+		return scriptRow(vm, res.Result().(*api2go.Api2GoModel).Data)
+	})
+
+	// query can have filter, page, size and sort, like the page[number], page[size] and sort parameters of
+	// the api
+	daptinLib.Set("findAll", func(typeName string, query map[string]interface{}) []interface{} {
+
+		queryParams := make(map[string][]string)
+		for key, param := range map[string]string{
+			"filter": "filter",
+			"page":   "page[number]",
+			"size":   "page[size]",
+			"sort":   "sort",
+		} {
+			if value, ok := query[key]; ok && value != nil {
+				queryParams[param] = []string{fmt.Sprintf("%v", value)}
+			}
+		}
+
+		_, res, err := resourceOf(typeName).PaginatedFindAll(scriptRequest(ctx, "GET", queryParams))
+		if err != nil {
+			panic(vm.ToValue(err.Error()))
+		}
+
+		rows := make([]interface{}, 0)
+		models, _ := res.Result().([]*api2go.Api2GoModel)
+		for _, model := range models {
+			rows = append(rows, scriptRow(vm, model.Data))
+		}
+		return rows
+	})
+
+	daptinLib.Set("create", func(typeName string, attributes map[string]interface{}) interface{} {
+		model := api2go.NewApi2GoModelWithData(typeName, nil, auth.DEFAULT_PERMISSION.IntValue(), nil, attributes)
+		res, err := resourceOf(typeName).Create(model, scriptRequest(ctx, "POST", nil))
+		if err != nil {
+			panic(vm.ToValue("failed to create " + typeName + ": " + err.Error()))
+		}
+		return scriptRow(vm, res.Result().(*api2go.Api2GoModel).Data)
+	})
+
+	daptinLib.Set("update", func(typeName string, referenceId string, attributes map[string]interface{}) interface{} {
+		attributes["reference_id"] = referenceId
+		model := api2go.NewApi2GoModelWithData(typeName, nil, auth.DEFAULT_PERMISSION.IntValue(), nil, attributes)
+		res, err := resourceOf(typeName).Update(model, scriptRequest(ctx, "PATCH", nil))
+		if err != nil {
+			panic(vm.ToValue("failed to update " + typeName + ": " + err.Error()))
+		}
+		if res == nil || res.Result() == nil {
+			return nil
+		}
+		return scriptRow(vm, res.Result().(*api2go.Api2GoModel).Data)
+	})
+
+	daptinLib.Set("delete", func(typeName string, referenceId string) {
+		_, err := resourceOf(typeName).Delete(referenceId, scriptRequest(ctx, "DELETE", nil))
+		if err != nil {
+			panic(vm.ToValue("failed to delete " + typeName + ": " + err.Error()))
+		}
+	})
+
+	return daptinLib
+}
+
+// httpLibrary is the http object of the script. http.request takes method, url, headers, query and body,
+// an object body is sent as json. It returns status, headers, body and json, when the body is json.
+// Like webhooks, it only connects to public addresses and the networks in webhook.allowed_networks.
+func (d *ScriptActionPerformer) httpLibrary(vm *goja.Runtime, ctx context.Context) *goja.Object {
+
+	httpLib := vm.NewObject()
+
+	httpLib.Set("request", func(options map[string]interface{}) map[string]interface{} {
+
+		method, _ := options["method"].(string)
+		if method == "" {
+			method = "GET"
+		}
+		requestUrl, _ := options["url"].(string)
+		parsedUrl, err := url.Parse(requestUrl)
+		if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") {
+			panic(vm.ToValue("invalid url " + requestUrl))
+		}
+
+		if query, ok := options["query"].(map[string]interface{}); ok {
+			values := parsedUrl.Query()
+			for key, value := range query {
+				values.Set(key, fmt.Sprintf("%v", value))
+			}
+			parsedUrl.RawQuery = values.Encode()
+		}
+
+		var body io.Reader
+		isJson := false
+		switch requestBody := options["body"].(type) {
+		case nil:
+		case string:
+			body = strings.NewReader(requestBody)
+		default:
+			encoded, err := json.Marshal(requestBody)
+			if err != nil {
+				panic(vm.ToValue("invalid body: " + err.Error()))
+			}
+			body = bytes.NewReader(encoded)
+			isJson = true
+		}
+
+		req, err := http.NewRequest(strings.ToUpper(method), parsedUrl.String(), body)
+		if err != nil {
+			panic(vm.ToValue(err.Error()))
+		}
+		req = req.WithContext(ctx)
+		if isJson {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if headers, ok := options["headers"].(map[string]interface{}); ok {
+			for key, value := range headers {
+				req.Header.Set(key, fmt.Sprintf("%v", value))
+			}
+		}
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			panic(vm.ToValue("request failed: " + err.Error()))
+		}
+		defer resp.Body.Close()
+
+		responseBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, scriptHttpMaxResponse))
+		if err != nil {
+			panic(vm.ToValue("failed to read response: " + err.Error()))
+		}
+
+		responseHeaders := make(map[string]interface{})
+		for key := range resp.Header {
+			responseHeaders[key] = resp.Header.Get(key)
+		}
+
+		result := map[string]interface{}{
+			"status":  resp.StatusCode,
+			"headers": responseHeaders,
+			"body":    string(responseBody),
+		}
+		var parsed interface{}
+		if json.Unmarshal(responseBody, &parsed) == nil {
+			result["json"] = parsed
+		}
+		return result
+	})
+
+	return httpLib
+}
+
+// NewScriptActionPerformer compiles the script, a script which does not compile fails at boot instead of
+// on the first run
+func NewScriptActionPerformer(performer ScriptedPerformer, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	if performer.Name == "" {
+		return nil, errors.New("Scripted performer without a name")
+	}
+
+	// the script is the body of a function, so it can return the responses
+	source := "(function () {" + performer.Script + "\n})()"
+	_, err := compileScript(source)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := ScriptActionPerformer{
+		name:   performer.Name,
+		source: source,
+		cruds:  cruds,
+		client: NewOutboundHttpClient(scriptHttpTimeout),
+	}
+
+	return &handler, nil
+}
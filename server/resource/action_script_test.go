@@ -0,0 +1,111 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestScriptPerformer(t *testing.T, script string, cruds map[string]*DbResource) ActionPerformerInterface {
+	performer, err := NewScriptActionPerformer(ScriptedPerformer{Name: "note.script", Script: script}, cruds)
+	if err != nil {
+		t.Fatalf("Failed to compile script: %v", err)
+	}
+	return performer
+}
+
+func scriptTestRequest(userId int64, userReferenceId string) ActionRequest {
+	return ActionRequest{
+		RequestContext: context.WithValue(context.Background(), "user", auth.SessionUser{UserId: userId, UserReferenceId: userReferenceId}),
+	}
+}
+
+func TestScriptReadsAndWritesWithTheCallersPermissions(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+	insertTestRow(t, cruds["note"].db, "user", map[string]interface{}{"name": "bob", "email": "bob@example.com", "reference_id": "user-3"})
+
+	objectPermissionChecker := &ObjectAccessPermissionChecker{}
+	cruds["note"].ms = &MiddlewareSet{
+		BeforeFindOne: []DatabaseRequestInterceptor{objectPermissionChecker},
+		AfterFindOne:  []DatabaseRequestInterceptor{objectPermissionChecker},
+		BeforeUpdate:  []DatabaseRequestInterceptor{objectPermissionChecker},
+		AfterUpdate:   []DatabaseRequestInterceptor{objectPermissionChecker},
+	}
+
+	reader := newTestScriptPerformer(t, `
+		var note = daptin.find("note", input.note_id);
+		return [response("client.notify", {title: note ? note.title : "hidden"})];`, cruds)
+	writer := newTestScriptPerformer(t, `
+		daptin.update("note", input.note_id, {title: "changed"});
+		return [];`, cruds)
+	inFields := map[string]interface{}{"note_id": "note-1"}
+
+	titleReadBy := func(userId int64, userReferenceId string) interface{} {
+		responses, errs := reader.DoAction(scriptTestRequest(userId, userReferenceId), inFields)
+		if len(errs) > 0 || len(responses) != 1 {
+			t.Fatalf("Failed to run script: %v", errs)
+		}
+		return responses[0].Attributes.(map[string]interface{})["title"]
+	}
+
+	if title := titleReadBy(2, "user-2"); title != "run" {
+		t.Errorf("Expected the owner to read the note, got [%v]", title)
+	}
+	if title := titleReadBy(3, "user-3"); title != "hidden" {
+		t.Errorf("Expected another user not to read the note, got [%v]", title)
+	}
+
+	var title string
+	_, errs := writer.DoAction(scriptTestRequest(3, "user-3"), inFields)
+	if len(errs) == 0 {
+		t.Errorf("Expected another user to fail to update the note")
+	}
+	cruds["note"].db.QueryRowx("select title from note where reference_id = 'note-1'").Scan(&title)
+	if title != "run" {
+		t.Errorf("Expected the note to be left as it was, got [%v]", title)
+	}
+
+	_, errs = writer.DoAction(scriptTestRequest(2, "user-2"), inFields)
+	if len(errs) > 0 {
+		t.Fatalf("Expected the owner to update the note: %v", errs)
+	}
+	cruds["note"].db.QueryRowx("select title from note where reference_id = 'note-1'").Scan(&title)
+	if title != "changed" {
+		t.Errorf("Expected the note to be updated, got [%v]", title)
+	}
+}
+
+func TestScriptHttpOnlyReachesPublicAddresses(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	performer := newTestScriptPerformer(t, `
+		var sent = http.request({url: input.url});
+		return [response("client.notify", {status: sent.status})];`, nil)
+	inFields := map[string]interface{}{"url": server.URL}
+
+	_, errs := performer.DoAction(scriptTestRequest(2, "user-2"), inFields)
+	if len(errs) == 0 || !strings.Contains(errs[0].Error(), ErrDisallowedAddress.Error()) {
+		t.Errorf("Expected a request to a loopback address to be refused, got %v", errs)
+	}
+
+	SetOutboundAllowedNetworks("127.0.0.1/32")
+	defer SetOutboundAllowedNetworks("")
+
+	responses, errs := performer.DoAction(scriptTestRequest(2, "user-2"), inFields)
+	if len(errs) > 0 || len(responses) != 1 {
+		t.Fatalf("Expected a request to an allowed network to be sent, got %v", errs)
+	}
+	if status := responses[0].Attributes.(map[string]interface{})["status"]; fmt.Sprintf("%v", status) != "200" {
+		t.Errorf("Expected the status of the response, got [%v]", status)
+	}
+}
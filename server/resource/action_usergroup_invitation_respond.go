@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// UserGroupInvitationRespondActionPerformer accepts or declines an invitation. The invitation can only be
+// used by the signed in user it was sent to.
+type UserGroupInvitationRespondActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *UserGroupInvitationRespondActionPerformer) Name() string {
+	return "usergroup.invitation.respond"
+}
+
+func (d *UserGroupInvitationRespondActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	user, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, []error{errors.New("Sign in to respond to the invitation")}
+	}
+
+	token, _ := inFieldMap["token"].(string)
+	if token == "" {
+		return nil, []error{errors.New("Invalid invitation")}
+	}
+
+	dr := d.cruds["usergroup"]
+
+	invitation, err := dr.GetPendingInvitation(token)
+	if err == ErrInvitationExpired {
+		return nil, []error{err}
+	}
+	if err != nil {
+		return nil, []error{errors.New("Invalid invitation")}
+	}
+
+	email, _ := user["email"].(string)
+	if !strings.EqualFold(email, invitation["email"].(string)) {
+		log.Infof("Invitation for [%v] used by [%v]", invitation["email"], email)
+		return nil, []error{errors.New("Invalid invitation")}
+	}
+
+	invitationId := invitation["id"].(int64)
+	userGroupId := invitation["usergroup_id"].(int64)
+
+	userId, err := d.cruds["user"].GetReferenceIdToId("user", user["reference_id"].(string))
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	userGroupReferenceId, _ := dr.GetIdToReferenceId("usergroup", userGroupId)
+
+	response, _ := inFieldMap["response"].(string)
+	if response != "accept" {
+		ok, err := dr.RespondToInvitation(invitationId, InvitationStatusDeclined)
+		if err != nil {
+			return nil, []error{err}
+		}
+		if !ok {
+			return nil, []error{ErrInvitationUsed}
+		}
+		dr.AddTimelineEvent("usergroup", "usergroup.invitation.declined", "Invitation declined by "+email, map[string]interface{}{
+			"email":        email,
+			"usergroup_id": userGroupReferenceId,
+		})
+		return []ActionResponse{
+			NewActionResponse("client.notify", NewClientNotification("success", "Invitation declined", "Success")),
+		}, nil
+	}
+
+	err = dr.AcceptInvitation(invitationId, int64(userId), userGroupId, invitation["member_role"].(string))
+	if err == ErrInvitationUsed {
+		return nil, []error{err}
+	}
+	if err != nil {
+		log.Errorf("Failed to add [%v] to usergroup [%v]: %v", email, userGroupId, err)
+		return nil, []error{err}
+	}
+
+	dr.AddTimelineEvent("usergroup", "usergroup.joined", email+" joined a usergroup", map[string]interface{}{
+		"email":        email,
+		"member_role":  invitation["member_role"],
+		"usergroup_id": userGroupReferenceId,
+	})
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "You are now a member of the usergroup", "Success")),
+	}, nil
+}
+
+func NewUserGroupInvitationRespondActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := UserGroupInvitationRespondActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
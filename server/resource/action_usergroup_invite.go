@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UserGroupInviteActionPerformer creates an invitation to a usergroup for an email. Only the administrator,
+// the owner of the usergroup and its admins can invite, and only the administrator and the owner can
+// invite someone as an admin.
+type UserGroupInviteActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *UserGroupInviteActionPerformer) Name() string {
+	return "usergroup.invite"
+}
+
+func (d *UserGroupInviteActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	user, userId, userGroupId, err := actionUserAndGroup(d.cruds, inFieldMap)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	dr := d.cruds["usergroup"]
+	userReferenceId := user["reference_id"].(string)
+
+	if !dr.CanManageUserGroup(userReferenceId, userId, userGroupId) {
+		return nil, []error{errors.New("Only the owner or an admin of the usergroup can invite")}
+	}
+
+	email, _ := inFieldMap["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || strings.Index(email, "@") < 1 {
+		return nil, []error{errors.New("Invalid email")}
+	}
+
+	memberRole, _ := inFieldMap["member_role"].(string)
+	if memberRole == "" {
+		memberRole = UserGroupMemberRoleMember
+	}
+	if !IsValidUserGroupMemberRole(memberRole) {
+		return nil, []error{errors.New("Member role should be member or admin")}
+	}
+	if memberRole == UserGroupMemberRoleAdmin && !dr.IsAdmin(userReferenceId) && dr.GetUserGroupOwnerId(userGroupId) != userId {
+		return nil, []error{errors.New("Only the owner of the usergroup can invite admins")}
+	}
+
+	token, tokenHash, err := NewInvitationToken()
+	if err != nil {
+		log.Errorf("Failed to generate invitation token: %v", err)
+		return nil, []error{err}
+	}
+
+	s, v, err := squirrel.Update("usergroup_invitation").
+		Set("status", InvitationStatusRevoked).
+		Where(squirrel.Eq{"email": email, "usergroup_id": userGroupId, "status": InvitationStatusPending}).ToSql()
+	if err == nil {
+		_, err = dr.db.Exec(s, v...)
+	}
+	if err != nil {
+		log.Errorf("Failed to revoke earlier invitations for [%v]: %v", email, err)
+	}
+
+	expiresAt := time.Now().Add(UserGroupInvitationValidity)
+
+	s, v, err = squirrel.Insert("usergroup_invitation").
+		Columns("email", "token_hash", "member_role", "status", "expires_at", "usergroup_id", "user_id", "reference_id", "permission", "created_at").
+		Values(email, tokenHash, memberRole, InvitationStatusPending, expiresAt, userGroupId, userId, uuid.NewV4().String(), auth.DEFAULT_PERMISSION, time.Now()).ToSql()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to create invitation for [%v]: %v", email, err)
+		return nil, []error{err}
+	}
+
+	userGroup := inFieldMap["usergroup"].(map[string]interface{})
+	dr.AddTimelineEvent("usergroup", "usergroup.invited", "Invitation sent to "+email, map[string]interface{}{
+		"email":        email,
+		"usergroup":    userGroup["name"],
+		"member_role":  memberRole,
+		"invited_by":   user["email"],
+		"usergroup_id": userGroup["reference_id"],
+	})
+
+	// the token is not stored, it is returned once so it can be sent to the invitee
+	responseAttrs := make(map[string]interface{})
+	responseAttrs["email"] = email
+	responseAttrs["token"] = token
+	responseAttrs["member_role"] = memberRole
+	responseAttrs["expires_at"] = expiresAt
+	responseAttrs["accept_url"] = "/act/usergroup/accept_group_invitation?token=" + url.QueryEscape(token)
+
+	return []ActionResponse{
+		NewActionResponse("usergroup.invitation", responseAttrs),
+		NewActionResponse("client.notify", NewClientNotification("success", "Invitation created for "+email, "Success")),
+	}, nil
+}
+
+func NewUserGroupInviteActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := UserGroupInviteActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
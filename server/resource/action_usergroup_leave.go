@@ -0,0 +1,60 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// UserGroupLeaveActionPerformer removes the signed in user from a usergroup. The owner cannot leave, the
+// usergroup would be left without anyone to manage it.
+type UserGroupLeaveActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *UserGroupLeaveActionPerformer) Name() string {
+	return "usergroup.leave"
+}
+
+func (d *UserGroupLeaveActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	user, userId, userGroupId, err := actionUserAndGroup(d.cruds, inFieldMap)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	dr := d.cruds["usergroup"]
+
+	if _, isMember := dr.GetUserGroupMemberRole(userId, userGroupId); !isMember {
+		return nil, []error{errors.New("You are not a member of this usergroup")}
+	}
+
+	if dr.GetUserGroupOwnerId(userGroupId) == userId {
+		return nil, []error{errors.New("The owner cannot leave the usergroup")}
+	}
+
+	err = dr.RemoveUserFromGroup(userId, userGroupId)
+	if err != nil {
+		log.Errorf("Failed to remove [%v] from usergroup [%v]: %v", user["email"], userGroupId, err)
+		return nil, []error{err}
+	}
+
+	userGroup := inFieldMap["usergroup"].(map[string]interface{})
+	dr.AddTimelineEvent("usergroup", "usergroup.left", "Member left a usergroup", map[string]interface{}{
+		"email":        user["email"],
+		"usergroup_id": userGroup["reference_id"],
+	})
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "You have left the usergroup", "Success")),
+	}, nil
+}
+
+func NewUserGroupLeaveActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := UserGroupLeaveActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
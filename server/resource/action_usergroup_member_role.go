@@ -0,0 +1,89 @@
+package resource
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// UserGroupMemberRoleActionPerformer changes the role of a member of a usergroup. The same rules as for
+// invitations apply, and the owner of the usergroup has no member role to change.
+type UserGroupMemberRoleActionPerformer struct {
+	cruds map[string]*DbResource
+}
+
+func (d *UserGroupMemberRoleActionPerformer) Name() string {
+	return "usergroup.member.role"
+}
+
+func (d *UserGroupMemberRoleActionPerformer) DoAction(request ActionRequest, inFieldMap map[string]interface{}) ([]ActionResponse, []error) {
+
+	user, userId, userGroupId, err := actionUserAndGroup(d.cruds, inFieldMap)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	dr := d.cruds["usergroup"]
+	userReferenceId := user["reference_id"].(string)
+	isOwner := dr.IsAdmin(userReferenceId) || dr.GetUserGroupOwnerId(userGroupId) == userId
+
+	if !dr.CanManageUserGroup(userReferenceId, userId, userGroupId) {
+		return nil, []error{errors.New("Only the owner or an admin of the usergroup can change member roles")}
+	}
+
+	memberRole, _ := inFieldMap["member_role"].(string)
+	if !IsValidUserGroupMemberRole(memberRole) {
+		return nil, []error{errors.New("Member role should be member or admin")}
+	}
+
+	email, _ := inFieldMap["email"].(string)
+	email = strings.TrimSpace(email)
+
+	var memberId int64
+	err = dr.db.QueryRowx("select id from user where email = ?", email).Scan(&memberId)
+	if err != nil {
+		return nil, []error{errors.New("No such member")}
+	}
+
+	currentRole, isMember := dr.GetUserGroupMemberRole(memberId, userGroupId)
+	if !isMember {
+		return nil, []error{errors.New("No such member")}
+	}
+
+	if dr.GetUserGroupOwnerId(userGroupId) == memberId {
+		return nil, []error{errors.New("The owner of the usergroup has no member role")}
+	}
+
+	// admins of the usergroup manage members, promoting and demoting admins is left to the owner
+	if !isOwner && (memberRole == UserGroupMemberRoleAdmin || currentRole == UserGroupMemberRoleAdmin) {
+		return nil, []error{errors.New("Only the owner of the usergroup can change admins")}
+	}
+
+	err = dr.SetUserGroupMemberRole(memberId, userGroupId, memberRole)
+	if err != nil {
+		log.Errorf("Failed to change member role of [%v]: %v", email, err)
+		return nil, []error{err}
+	}
+
+	userGroup := inFieldMap["usergroup"].(map[string]interface{})
+	dr.AddTimelineEvent("usergroup", "usergroup.member.role", "Member role of "+email+" set to "+memberRole, map[string]interface{}{
+		"email":        email,
+		"member_role":  memberRole,
+		"changed_by":   user["email"],
+		"usergroup_id": userGroup["reference_id"],
+	})
+
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Member role changed", "Success")),
+	}, nil
+}
+
+func NewUserGroupMemberRoleActionPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := UserGroupMemberRoleActionPerformer{
+		cruds: cruds,
+	}
+
+	return &handler, nil
+
+}
@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"context"
 	"github.com/artpar/api2go"
 )
 
@@ -9,6 +10,13 @@ type Outcome struct {
 	Method     string
 	Reference  string
 	Attributes map[string]interface{}
+	// a javascript expression like "!subject.status == 'open'", the outcome is skipped when it is not true
+	Condition string
+	// a list to run the outcome for, like "~items" or "!subject.tags.split(',')". Each run sees the
+	// list item as item and its position as index, the results are kept as a list under Reference
+	ForEach string
+	// a failed outcome stops the action, unless this is set
+	ContinueOnError bool
 }
 
 type Action struct {
@@ -21,6 +29,11 @@ type Action struct {
 	OutFields        []Outcome
 	Validations      []ColumnTag
 	Conformations    []ColumnTag
+	// async actions are queued as a job and run by the job workers
+	IsAsync bool
+	// when an outcome fails, the rows created by the earlier outcomes are deleted and the rows they
+	// updated are changed back
+	RollbackOnError bool
 }
 
 type ActionRow struct {
@@ -36,4 +49,14 @@ type ActionRequest struct {
 	Type       string
 	Action     string
 	Attributes map[string]interface{}
+	ClientIp   string `json:"-"`
+	// set when the action is run as a job, for performers to report their progress
+	Job JobReporter `json:"-"`
+	// set when the action is run by a trigger, how many triggers deep its writes are
+	TriggerDepth int `json:"-"`
+	// the context the action runs in, with the user and tenant, for performers which read or write rows
+	// as the user
+	RequestContext context.Context `json:"-"`
+	// only plan the outcomes and return what they would do, see PlanActionOutcomes
+	DryRun bool `json:"dry_run"`
 }
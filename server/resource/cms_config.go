@@ -1,11 +1,13 @@
 package resource
 
 import (
+	"fmt"
 	"github.com/artpar/api2go"
 	"github.com/jmoiron/sqlx"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/Masterminds/squirrel.v1"
 	"gopkg.in/go-playground/validator.v9"
+	"strings"
 	"time"
 )
 
@@ -16,6 +18,9 @@ type CmsConfig struct {
 	Relations                []api2go.TableRelation
 	Actions                  []Action
 	ExchangeContracts        []ExchangeContract
+	RestExchanges            []RestExchange
+	Triggers                 []Trigger
+	ScriptedPerformers       []ScriptedPerformer
 	Hostname                 string
 	Validator                *validator.Validate
 	SubSites                 map[string]SubSiteInformation
@@ -119,9 +124,8 @@ var ConfigTableStructure = TableInfo{
 			Name:       "Value",
 			ColumnName: "value",
 			ColumnType: "string",
-			DataType:   "varchar(100)",
+			DataType:   "text",
 			IsNullable: true,
-			IsIndexed:  true,
 		},
 		{
 			Name:       "ValueType",
@@ -135,9 +139,8 @@ var ConfigTableStructure = TableInfo{
 			Name:       "PreviousValue",
 			ColumnName: "previousvalue",
 			ColumnType: "string",
-			DataType:   "varchar(100)",
+			DataType:   "text",
 			IsNullable: true,
-			IsIndexed:  true,
 		},
 		{
 			Name:         "CreatedAt",
@@ -221,7 +224,7 @@ func (c *ConfigStore) SetConfigValueFor(key string, val string, configtype strin
 
 	CheckErr(err, "Failed to create config select query")
 
-	err = c.db.QueryRowx(s, v...).Scan(&val)
+	err = c.db.QueryRowx(s, v...).Scan(&previousValue)
 
 	if err != nil {
 
@@ -241,7 +244,7 @@ func (c *ConfigStore) SetConfigValueFor(key string, val string, configtype strin
 
 		s, v, err := squirrel.Update(settingsTableName).
 			Set("value", val).
-			Set("previous_value", previousValue).
+			Set("previousvalue", previousValue).
 			Where(squirrel.Eq{"name": key}).
 			Where(squirrel.Eq{"configstate": "enabled"}).
 			Where(squirrel.Eq{"configtype": configtype}).
@@ -256,6 +259,37 @@ func (c *ConfigStore) SetConfigValueFor(key string, val string, configtype strin
 
 }
 
+const tenantConfigPrefix = "tenant."
+
+// values for a tenant are kept next to the global ones, under the name prefixed with the tenant
+func tenantConfigName(tenant *Tenant, key string) string {
+	return tenantConfigPrefix + tenant.ReferenceId + "." + key
+}
+
+// GetWebConfigForTenant is the web config with the values of the tenant in place of the global ones. Values
+// of other tenants are left out.
+func (c *ConfigStore) GetWebConfigForTenant(tenant *Tenant) map[string]string {
+
+	webConfig := c.GetWebConfig()
+	tenantValues := make(map[string]string)
+
+	for name, val := range webConfig {
+		if !strings.HasPrefix(name, tenantConfigPrefix) {
+			continue
+		}
+		delete(webConfig, name)
+		if tenant != nil && strings.HasPrefix(name, tenantConfigName(tenant, "")) {
+			tenantValues[strings.TrimPrefix(name, tenantConfigName(tenant, ""))] = val
+		}
+	}
+
+	for name, val := range tenantValues {
+		webConfig[name] = val
+	}
+
+	return webConfig
+}
+
 func NewConfigStore(db *sqlx.DB) (*ConfigStore, error) {
 	var cs ConfigStore
 	s, v, err := squirrel.Select("count(*)").From(settingsTableName).ToSql()
@@ -274,6 +308,8 @@ func NewConfigStore(db *sqlx.DB) (*ConfigStore, error) {
 		_, err = db.Exec(createTableQuery)
 		CheckErr(err, "Failed to create config table")
 
+	} else {
+		widenConfigValueColumns(db)
 	}
 
 	return &ConfigStore{
@@ -282,3 +318,47 @@ func NewConfigStore(db *sqlx.DB) (*ConfigStore, error) {
 	}, nil
 
 }
+
+// values were varchar(100) in earlier versions, which is too short for keys and certificates. sqlite does
+// not enforce the length so only mysql and postgres tables need to be changed, and only once: the column
+// type is checked first so a widened table is left alone on later starts.
+func widenConfigValueColumns(db *sqlx.DB) {
+
+	var alter string
+	switch db.DriverName() {
+	case "mysql":
+		alter = "alter table " + settingsTableName + " modify %s text"
+	case "postgres":
+		alter = "alter table " + settingsTableName + " alter column %s type text"
+	default:
+		return
+	}
+
+	for _, column := range []string{"value", "previousvalue"} {
+
+		var dataType string
+		err := db.QueryRowx(db.Rebind("select data_type from information_schema.columns"+
+			" where table_name = ? and column_name = ? and table_schema = "+currentSchemaFunction(db.DriverName())),
+			settingsTableName, column).Scan(&dataType)
+		if err != nil {
+			log.Errorf("Failed to check the type of config column [%v]: %v", column, err)
+			continue
+		}
+		if strings.ToLower(dataType) == "text" {
+			continue
+		}
+
+		log.Infof("Changing config column [%v] from %v to text", column, dataType)
+		_, err = db.Exec(fmt.Sprintf(alter, column))
+		if err != nil {
+			log.Errorf("Failed to widen config value column: %v", err)
+		}
+	}
+}
+
+func currentSchemaFunction(driverName string) string {
+	if driverName == "postgres" {
+		return "current_schema()"
+	}
+	return "database()"
+}
@@ -333,7 +333,7 @@ func (ctm *ColumnTypeManager) GetFakedata(colTypeName string) string {
 	return fmt.Sprintf("%v", ctm.ColumnMap[colTypeName].Fake())
 }
 
-func (ctm *ColumnTypeManager) IsValidValue(val string, colType string, validator *validator2.Validate) error {
+func (ctm *ColumnTypeManager) IsValidValue(val interface{}, colType string, validator *validator2.Validate) error {
 	if ctm.ColumnMap[colType].Validations == nil || len(ctm.ColumnMap[colType].Validations) < 1 {
 		return nil
 	}
@@ -64,6 +64,13 @@ var StandardRelations = []api2go.TableRelation{
 	api2go.NewTableRelation("timeline", "belongs_to", "world"),
 	api2go.NewTableRelation("cloud_store", "has_one", "oauth_token"),
 	api2go.NewTableRelation("site", "has_one", "cloud_store"),
+	api2go.NewTableRelation("role_grant", "belongs_to", "role"),
+	api2go.NewTableRelation("user", "has_many", "role"),
+	api2go.NewTableRelation("usergroup", "has_many", "role"),
+	api2go.NewTableRelation("usergroup_invitation", "belongs_to", "usergroup"),
+	api2go.NewTableRelation("user", "has_one", "tenant"),
+	api2go.NewTableRelation("schedule_run", "belongs_to", "schedule"),
+	api2go.NewTableRelation("webhook_delivery", "belongs_to", "webhook"),
 }
 
 var SystemSmds = []LoopbookFsmDescription{}
@@ -135,6 +142,7 @@ var SystemActions = []Action{
 		Label:            "Generate random data",
 		OnType:           "world",
 		InstanceOptional: false,
+		IsAsync:          true,
 		InFields: []api2go.ColumnInfo{
 			{
 				Name:       "Number of records",
@@ -215,6 +223,7 @@ var SystemActions = []Action{
 		Label:            "Import data from dump",
 		OnType:           "world",
 		InstanceOptional: true,
+		IsAsync:          true,
 		InFields: []api2go.ColumnInfo{
 			{
 				Name:       "JSON Dump file",
@@ -246,6 +255,7 @@ var SystemActions = []Action{
 		Label:            "Upload file to external store",
 		OnType:           "cloud_store",
 		InstanceOptional: false,
+		IsAsync:          true,
 		InFields: []api2go.ColumnInfo{
 			{
 				Name:       "File",
@@ -490,6 +500,332 @@ var SystemActions = []Action{
 			},
 		},
 	},
+	{
+		Name:             "signin_2fa",
+		Label:            "Verify sign in code",
+		InstanceOptional: true,
+		OnType:           "user",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "otp_challenge",
+				ColumnName: "otp_challenge",
+				ColumnType: "hidden",
+				IsNullable: false,
+			},
+			{
+				Name:       "otp",
+				ColumnName: "otp",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "otp.login.verify",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"otp_challenge": "~otp_challenge",
+					"otp":           "~otp",
+				},
+			},
+		},
+	},
+	{
+		Name:             "enable_2fa",
+		Label:            "Enable two factor authentication",
+		InstanceOptional: true,
+		OnType:           "user",
+		InFields:         []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "otp.generate",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user": "~user",
+				},
+			},
+		},
+	},
+	{
+		Name:             "verify_2fa",
+		Label:            "Verify two factor authentication",
+		InstanceOptional: true,
+		OnType:           "user",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "otp",
+				ColumnName: "otp",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "otp.verify",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user": "~user",
+					"otp":  "~otp",
+				},
+			},
+		},
+	},
+	{
+		Name:             "disable_2fa",
+		Label:            "Disable two factor authentication",
+		InstanceOptional: true,
+		OnType:           "user",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "otp",
+				ColumnName: "otp",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "otp.disable",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user": "~user",
+					"otp":  "~otp",
+				},
+			},
+		},
+	},
+	{
+		Name:   "unlock_account",
+		Label:  "Unlock account",
+		OnType: "user",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "client_ip",
+				ColumnName: "client_ip",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "account.unlock",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":      "~user",
+					"account":   "~subject",
+					"client_ip": "~client_ip",
+				},
+			},
+		},
+	},
+	{
+		Name:     "replay_execution",
+		Label:    "Replay",
+		OnType:   "action_execution",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "action.replay",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":      "~user",
+					"execution": "~subject",
+				},
+			},
+		},
+	},
+	{
+		Name:     "retry_delivery",
+		Label:    "Retry delivery",
+		OnType:   "exchange_dead_letter",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "exchange.redrive",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":        "~user",
+					"dead_letter": "~subject",
+				},
+			},
+		},
+	},
+	{
+		Name:     "retry_failed_deliveries",
+		Label:    "Retry failed deliveries",
+		OnType:   "data_exchange",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "exchange.redrive",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":     "~user",
+					"exchange": "~subject",
+				},
+			},
+		},
+	},
+	{
+		Name:     "delivery_status",
+		Label:    "Delivery status",
+		OnType:   "data_exchange",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "exchange.deliveries",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":     "~user",
+					"exchange": "~subject",
+				},
+			},
+		},
+	},
+	{
+		Name:     "impersonate_user",
+		Label:    "Impersonate user",
+		OnType:   "user",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "user.impersonate",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":   "~user",
+					"target": "~subject",
+				},
+			},
+		},
+	},
+	{
+		Name:   "invite_to_group",
+		Label:  "Invite to group",
+		OnType: "usergroup",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "email",
+				ColumnName: "email",
+				ColumnType: "email",
+				IsNullable: false,
+			},
+			{
+				Name:       "member_role",
+				ColumnName: "member_role",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "usergroup.invite",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":        "~user",
+					"usergroup":   "~subject",
+					"email":       "~email",
+					"member_role": "~member_role",
+				},
+			},
+		},
+	},
+	{
+		Name:             "accept_group_invitation",
+		Label:            "Accept invitation",
+		OnType:           "usergroup",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "token",
+				ColumnName: "token",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "usergroup.invitation.respond",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":     "~user",
+					"token":    "~token",
+					"response": "accept",
+				},
+			},
+		},
+	},
+	{
+		Name:             "decline_group_invitation",
+		Label:            "Decline invitation",
+		OnType:           "usergroup",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "token",
+				ColumnName: "token",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "usergroup.invitation.respond",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":     "~user",
+					"token":    "~token",
+					"response": "decline",
+				},
+			},
+		},
+	},
+	{
+		Name:   "change_member_role",
+		Label:  "Change member role",
+		OnType: "usergroup",
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "email",
+				ColumnName: "email",
+				ColumnType: "email",
+				IsNullable: false,
+			},
+			{
+				Name:       "member_role",
+				ColumnName: "member_role",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "usergroup.member.role",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":        "~user",
+					"usergroup":   "~subject",
+					"email":       "~email",
+					"member_role": "~member_role",
+				},
+			},
+		},
+	},
+	{
+		Name:     "leave_group",
+		Label:    "Leave group",
+		OnType:   "usergroup",
+		InFields: []api2go.ColumnInfo{},
+		OutFields: []Outcome{
+			{
+				Type:   "usergroup.leave",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"user":      "~user",
+					"usergroup": "~subject",
+				},
+			},
+		},
+	},
 	{
 		Name:     "oauth.login.begin",
 		Label:    "Authenticate via OAuth",
@@ -893,6 +1229,80 @@ var StandardTables = []TableInfo{
 				IsNullable:   false,
 				DefaultValue: "false",
 			},
+			{
+				Name:         "otp_enabled",
+				ColumnName:   "otp_enabled",
+				DataType:     "boolean",
+				ColumnType:   "truefalse",
+				IsNullable:   false,
+				DefaultValue: "false",
+			},
+			{
+				Name:           "otp_secret",
+				ColumnName:     "otp_secret",
+				DataType:       "varchar(200)",
+				ColumnType:     "content",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:           "otp_recovery_codes",
+				ColumnName:     "otp_recovery_codes",
+				DataType:       "text",
+				ColumnType:     "content",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:           "otp_last_step",
+				ColumnName:     "otp_last_step",
+				DataType:       "int(11)",
+				ColumnType:     "measurement",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:           "failed_login_count",
+				ColumnName:     "failed_login_count",
+				DataType:       "int(4)",
+				ColumnType:     "measurement",
+				IsNullable:     false,
+				DefaultValue:   "0",
+				ExcludeFromApi: true,
+			},
+			{
+				Name:           "last_failed_login_at",
+				ColumnName:     "last_failed_login_at",
+				DataType:       "timestamp",
+				ColumnType:     "datetime",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:       "locked_until",
+				ColumnName: "locked_until",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "provider",
+				ColumnName: "provider",
+				DataType:   "varchar(50)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+		// only administrators link a user to an external identity provider or move them to another tenant
+		FieldPermissions: []FieldPermission{
+			{
+				ColumnName: "provider",
+				WritableBy: []string{"admin"},
+			},
+			{
+				ColumnName: TenantColumnName,
+				WritableBy: []string{"admin"},
+			},
 		},
 		Validations: []ColumnTag{
 			{
@@ -925,15 +1335,125 @@ var StandardTables = []TableInfo{
 				DataType:   "varchar(80)",
 				ColumnType: "name",
 			},
+			{
+				Name:         "requires_2fa",
+				ColumnName:   "requires_2fa",
+				DataType:     "boolean",
+				ColumnType:   "truefalse",
+				IsNullable:   false,
+				DefaultValue: "false",
+			},
 		},
 	},
 	{
-		TableName: "action",
+		TableName: "role",
 		Columns: []api2go.ColumnInfo{
 			{
-				Name:       "action_name",
+				Name:       "name",
+				ColumnName: "name",
 				IsIndexed:  true,
-				ColumnName: "action_name",
+				IsUnique:   true,
+				DataType:   "varchar(100)",
+				ColumnType: "name",
+			},
+		},
+	},
+	{
+		TableName: "role_grant",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "table_name",
+				ColumnName: "table_name",
+				IsIndexed:  true,
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:         "grant_permission",
+				ColumnName:   "grant_permission",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "0",
+			},
+		},
+	},
+	{
+		// the join table itself is generated from the user has_many usergroup relation, these are the columns
+		// added to it. A member without a role is a plain member, the role is changed through the usergroup
+		// actions and not by editing the row.
+		TableName: "user_user_id_has_usergroup_usergroup_id",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "member_role",
+				ColumnName: "member_role",
+				ColumnType: "label",
+				DataType:   "varchar(20)",
+				IsNullable: true,
+			},
+		},
+		FieldPermissions: []FieldPermission{
+			{
+				ColumnName: "member_role",
+				WritableBy: []string{"admin"},
+			},
+		},
+	},
+	{
+		TableName: "usergroup_invitation",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "email",
+				ColumnName: "email",
+				IsIndexed:  true,
+				DataType:   "varchar(100)",
+				ColumnType: "email",
+			},
+			{
+				Name:           "token_hash",
+				ColumnName:     "token_hash",
+				IsIndexed:      true,
+				DataType:       "varchar(64)",
+				ColumnType:     "label",
+				ExcludeFromApi: true,
+			},
+			{
+				Name:         "member_role",
+				ColumnName:   "member_role",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				DefaultValue: "'member'",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				DefaultValue: "'pending'",
+			},
+			{
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "action",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "action_name",
+				IsIndexed:  true,
+				ColumnName: "action_name",
 				DataType:   "varchar(100)",
 				ColumnType: "name",
 			},
@@ -1056,6 +1576,54 @@ var StandardTables = []TableInfo{
 			},
 		},
 	},
+	{
+		TableName: "saml_connect",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				IsUnique:   true,
+				IsIndexed:  true,
+				DataType:   "varchar(80)",
+				ColumnType: "name",
+			},
+			{
+				Name:       "idp_metadata",
+				ColumnName: "idp_metadata",
+				DataType:   "text",
+				ColumnType: "content",
+			},
+			{
+				Name:         "email_attribute",
+				ColumnName:   "email_attribute",
+				DataType:     "varchar(200)",
+				ColumnType:   "name",
+				DefaultValue: "'email'",
+			},
+			{
+				Name:         "name_attribute",
+				ColumnName:   "name_attribute",
+				DataType:     "varchar(200)",
+				ColumnType:   "name",
+				DefaultValue: "'displayName'",
+			},
+			{
+				Name:         "group_attribute",
+				ColumnName:   "group_attribute",
+				DataType:     "varchar(200)",
+				ColumnType:   "name",
+				DefaultValue: "'groups'",
+			},
+			{
+				Name:       "group_mapping",
+				ColumnName: "group_mapping",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+		},
+	},
 	{
 		TableName: "data_exchange",
 		IsHidden:  true,
@@ -1105,6 +1673,143 @@ var StandardTables = []TableInfo{
 			},
 		},
 	},
+	{
+		TableName: "exchange_outbox",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "exchange_name",
+				ColumnName: "exchange_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "payload",
+				ColumnName: "payload",
+				DataType:   "text",
+				ColumnType: "json",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				IsIndexed:    true,
+				DefaultValue: "'pending'",
+			},
+			{
+				Name:         "attempts",
+				ColumnName:   "attempts",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "next_attempt_at",
+				ColumnName: "next_attempt_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsIndexed:  true,
+				IsNullable: true,
+			},
+			{
+				Name:       "delivered_at",
+				ColumnName: "delivered_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_code",
+				ColumnName: "response_code",
+				DataType:   "int(4)",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_body",
+				ColumnName: "response_body",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_error",
+				ColumnName: "last_error",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "exchange_dead_letter",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "exchange_name",
+				ColumnName: "exchange_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "outbox_reference_id",
+				ColumnName: "outbox_reference_id",
+				DataType:   "varchar(64)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "payload",
+				ColumnName: "payload",
+				DataType:   "text",
+				ColumnType: "json",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				IsIndexed:    true,
+				DefaultValue: "'dead'",
+			},
+			{
+				Name:       "attempts",
+				ColumnName: "attempts",
+				DataType:   "int(4)",
+				ColumnType: "measurement",
+			},
+			{
+				Name:       "response_code",
+				ColumnName: "response_code",
+				DataType:   "int(4)",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_body",
+				ColumnName: "response_body",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_error",
+				ColumnName: "last_error",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:       "redriven_at",
+				ColumnName: "redriven_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+		},
+	},
 	{
 		TableName: "oauth_token",
 		IsHidden:  true,
@@ -1171,6 +1876,659 @@ var StandardTables = []TableInfo{
 			},
 		},
 	},
+	{
+		TableName: "job",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "on_type",
+				ColumnName: "on_type",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				IsIndexed:    true,
+				DefaultValue: "'queued'",
+			},
+			{
+				Name:         "progress",
+				ColumnName:   "progress",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:         "attempts",
+				ColumnName:   "attempts",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:         "max_attempts",
+				ColumnName:   "max_attempts",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "3",
+			},
+			{
+				Name:         "run_after",
+				ColumnName:   "run_after",
+				DataType:     "timestamp",
+				ColumnType:   "datetime",
+				IsIndexed:    true,
+				DefaultValue: "current_timestamp",
+			},
+			{
+				Name:       "started_at",
+				ColumnName: "started_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "completed_at",
+				ColumnName: "completed_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:           "locked_by",
+				ColumnName:     "locked_by",
+				DataType:       "varchar(200)",
+				ColumnType:     "label",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:           "locked_at",
+				ColumnName:     "locked_at",
+				DataType:       "timestamp",
+				ColumnType:     "datetime",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			// the encrypted request, and the responses, of an import can be well over the 64KB of a mysql text
+			{
+				Name:           "request",
+				ColumnName:     "request",
+				DataType:       "longtext",
+				ColumnType:     "encrypted",
+				ExcludeFromApi: true,
+			},
+			{
+				Name:       "log_entries",
+				ColumnName: "log_entries",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "responses",
+				ColumnName: "responses",
+				DataType:   "longtext",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_error",
+				ColumnName: "last_error",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "schedule",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "cron_expression",
+				ColumnName: "cron_expression",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "on_type",
+				ColumnName: "on_type",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "target_reference_id",
+				ColumnName: "target_reference_id",
+				DataType:   "varchar(64)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "attributes",
+				ColumnName: "attributes",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:         "enabled",
+				ColumnName:   "enabled",
+				DataType:     "bool",
+				IsNullable:   false,
+				DefaultValue: "true",
+				ColumnType:   "truefalse",
+			},
+			{
+				Name:       "next_run_at",
+				ColumnName: "next_run_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+				IsIndexed:  true,
+			},
+			{
+				Name:       "last_run_at",
+				ColumnName: "last_run_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_status",
+				ColumnName: "last_status",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:         "failure_count",
+				ColumnName:   "failure_count",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+		},
+		// the run state is kept by the scheduler
+		FieldPermissions: []FieldPermission{
+			{
+				ColumnName: "next_run_at",
+				WritableBy: []string{"none"},
+			},
+			{
+				ColumnName: "last_run_at",
+				WritableBy: []string{"none"},
+			},
+			{
+				ColumnName: "last_status",
+				WritableBy: []string{"none"},
+			},
+			{
+				ColumnName: "failure_count",
+				WritableBy: []string{"none"},
+			},
+		},
+		Validations: []ColumnTag{
+			{
+				ColumnName: "name",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "cron_expression",
+				Tags:       "required,cron",
+			},
+			{
+				ColumnName: "on_type",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "action_name",
+				Tags:       "required",
+			},
+		},
+	},
+	{
+		TableName: "schedule_run",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "status",
+				ColumnName: "status",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "started_at",
+				ColumnName: "started_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+			},
+			{
+				Name:       "completed_at",
+				ColumnName: "completed_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "responses",
+				ColumnName: "responses",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "error_message",
+				ColumnName: "error_message",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "notification",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "kind",
+				ColumnName: "kind",
+				DataType:   "varchar(50)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "title",
+				ColumnName: "title",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "message",
+				ColumnName: "message",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:         "is_read",
+				ColumnName:   "is_read",
+				DataType:     "bool",
+				ColumnType:   "truefalse",
+				DefaultValue: "false",
+			},
+		},
+	},
+	{
+		TableName: "webhook",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "url",
+				ColumnName: "url",
+				DataType:   "varchar(500)",
+				ColumnType: "url",
+			},
+			{
+				Name:           "secret",
+				ColumnName:     "secret",
+				DataType:       "varchar(500)",
+				ColumnType:     "encrypted",
+				IsNullable:     true,
+				ExcludeFromApi: true,
+			},
+			{
+				Name:       "table_names",
+				ColumnName: "table_names",
+				DataType:   "text",
+				ColumnType: "content",
+			},
+			{
+				Name:         "events",
+				ColumnName:   "events",
+				DataType:     "varchar(100)",
+				ColumnType:   "label",
+				DefaultValue: "'create,update,delete'",
+			},
+			{
+				Name:         "enabled",
+				ColumnName:   "enabled",
+				DataType:     "bool",
+				IsNullable:   false,
+				DefaultValue: "true",
+				ColumnType:   "truefalse",
+			},
+		},
+		Validations: []ColumnTag{
+			{
+				ColumnName: "name",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "url",
+				Tags:       "required,url,public_url",
+			},
+			{
+				ColumnName: "table_names",
+				Tags:       "required",
+			},
+		},
+	},
+	{
+		TableName: "webhook_delivery",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "event",
+				ColumnName: "event",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "table_name",
+				ColumnName: "table_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "object_reference_id",
+				ColumnName: "object_reference_id",
+				DataType:   "varchar(64)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "payload",
+				ColumnName: "payload",
+				DataType:   "text",
+				ColumnType: "json",
+			},
+			{
+				Name:         "status",
+				ColumnName:   "status",
+				DataType:     "varchar(20)",
+				ColumnType:   "label",
+				IsIndexed:    true,
+				DefaultValue: "'pending'",
+			},
+			{
+				Name:         "attempts",
+				ColumnName:   "attempts",
+				DataType:     "int(4)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "next_attempt_at",
+				ColumnName: "next_attempt_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsIndexed:  true,
+				IsNullable: true,
+			},
+			{
+				Name:       "delivered_at",
+				ColumnName: "delivered_at",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_code",
+				ColumnName: "response_code",
+				DataType:   "int(4)",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_body",
+				ColumnName: "response_body",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:       "last_error",
+				ColumnName: "last_error",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "incoming_webhook",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				IsUnique:   true,
+				IsIndexed:  true,
+				DataType:   "varchar(80)",
+				ColumnType: "name",
+			},
+			{
+				Name:           "secret",
+				ColumnName:     "secret",
+				DataType:       "varchar(500)",
+				ColumnType:     "encrypted",
+				ExcludeFromApi: true,
+			},
+			{
+				Name:       "signature_header",
+				ColumnName: "signature_header",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "signature_prefix",
+				ColumnName: "signature_prefix",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "timestamp_header",
+				ColumnName: "timestamp_header",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "timestamp_tolerance",
+				ColumnName: "timestamp_tolerance",
+				DataType:   "int(11)",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+			{
+				Name:       "on_type",
+				ColumnName: "on_type",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "attributes",
+				ColumnName: "attributes",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "service_user_email",
+				ColumnName: "service_user_email",
+				DataType:   "varchar(200)",
+				ColumnType: "email",
+				IsNullable: true,
+			},
+			{
+				Name:         "enabled",
+				ColumnName:   "enabled",
+				DataType:     "bool",
+				IsNullable:   false,
+				DefaultValue: "true",
+				ColumnType:   "truefalse",
+			},
+		},
+		Validations: []ColumnTag{
+			{
+				ColumnName: "name",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "secret",
+				Tags:       "required,min=16",
+			},
+			{
+				ColumnName: "on_type",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "action_name",
+				Tags:       "required",
+			},
+		},
+	},
+	{
+		TableName: "action_execution",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "on_type",
+				ColumnName: "on_type",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "subject_reference_id",
+				ColumnName: "subject_reference_id",
+				DataType:   "varchar(64)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "status",
+				ColumnName: "status",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "input",
+				ColumnName: "input",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "responses",
+				ColumnName: "responses",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "error_message",
+				ColumnName: "error_message",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
+			{
+				Name:         "duration_ms",
+				ColumnName:   "duration_ms",
+				DataType:     "int(11)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "client_ip",
+				ColumnName: "client_ip",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "replay_of",
+				ColumnName: "replay_of",
+				DataType:   "varchar(64)",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "tenant",
+		IsHidden:  true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				IsUnique:   true,
+				IsIndexed:  true,
+				DataType:   "varchar(80)",
+				ColumnType: "name",
+			},
+			{
+				Name:       "hostname",
+				ColumnName: "hostname",
+				IsUnique:   true,
+				IsNullable: true,
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "enabled",
+				ColumnName:   "enabled",
+				DataType:     "bool",
+				IsNullable:   false,
+				DefaultValue: "true",
+				ColumnType:   "truefalse",
+			},
+		},
+	},
 	{
 		TableName: "site",
 		IsHidden:  true,
@@ -1268,6 +2626,9 @@ type TableInfo struct {
 	IsAuditEnabled         bool   `db:"is_audit_enabled"`
 	Validations            []ColumnTag
 	Conformations          []ColumnTag
+	RowSecurityPolicy      string
+	FieldPermissions       []FieldPermission
+	IsTenantScoped         bool
 }
 
 func (ti *TableInfo) AddRelation(relations ...api2go.TableRelation) {
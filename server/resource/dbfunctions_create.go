@@ -47,6 +47,32 @@ func CreateUniqueConstraints(initConfig *CmsConfig, db *sqlx.DB) {
 		}
 
 	}
+
+	// the key of an exchange to a daptin table identifies the copy of a source row, so two deliveries of
+	// the same row cannot both create one
+	for _, exchange := range initConfig.ExchangeContracts {
+
+		targetName, _ := exchange.TargetAttributes["name"].(string)
+		key, _ := exchange.Options["key"].(string)
+		if exchange.TargetType != "self" || targetName == "" || key == "" {
+			continue
+		}
+
+		cols := []string{key}
+		for _, table := range initConfig.Tables {
+			if table.TableName == targetName && table.IsTenantScoped {
+				cols = append(cols, TenantColumnName)
+			}
+		}
+
+		indexName := "u" + GetMD5Hash("index_exchange_"+targetName+"_"+strings.Join(cols, "_")+"_unique")
+		alterTable := "create unique index " + indexName + " on " + targetName + "(" + strings.Join(cols, ", ") + ")"
+		log.Infof("Create unique index sql: %v", alterTable)
+		_, err := db.Exec(alterTable)
+		if err != nil {
+			log.Infof("Exchange [%v]: Failed to create unique key index on [%v]: %v", exchange.Name, targetName, err)
+		}
+	}
 }
 
 func CreateIndexes(initConfig *CmsConfig, db *sqlx.DB) {
@@ -246,6 +272,44 @@ func CheckAuditTables(config *CmsConfig, db *sqlx.DB) {
 
 }
 
+// mergeDeclaredJoinTable copies the extra columns and field permissions of tables declared with the name of the
+// join table, like user_user_id_has_usergroup_usergroup_id in StandardTables, into the generated join table.
+// A declaration which is not a join table itself is dropped, the generated table takes its place.
+func mergeDeclaredJoinTable(tables []TableInfo, joinTable *TableInfo) []TableInfo {
+
+	remaining := make([]TableInfo, 0, len(tables))
+
+	for _, table := range tables {
+		if table.TableName != joinTable.TableName {
+			remaining = append(remaining, table)
+			continue
+		}
+
+		for _, column := range table.Columns {
+			exists := false
+			for _, c := range joinTable.Columns {
+				if c.ColumnName == column.ColumnName {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				joinTable.Columns = append(joinTable.Columns, column)
+			}
+		}
+
+		if len(table.FieldPermissions) > 0 {
+			joinTable.FieldPermissions = table.FieldPermissions
+		}
+
+		if table.IsJoinTable {
+			remaining = append(remaining, table)
+		}
+	}
+
+	return remaining
+}
+
 func convertRelationsToColumns(relations []api2go.TableRelation, config *CmsConfig) {
 
 	existingRelationMap := make(map[string]bool)
@@ -370,6 +434,9 @@ func convertRelationsToColumns(relations []api2go.TableRelation, config *CmsConf
 			}
 
 			newTable.Columns = append(newTable.Columns, col2)
+
+			config.Tables = mergeDeclaredJoinTable(config.Tables, &newTable)
+
 			newTable.AddRelation(relation)
 			//newTable.Relations = append(newTable.Relations, relation)
 			log.Infof("Add column [%v] to table [%v]", col1.ColumnName, newTable.TableName)
@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -306,6 +307,41 @@ func (dbResource *DbResource) CanBecomeAdmin() bool {
 
 }
 
+// IsAdmin is true for the user who owns the system, the one picked by GetAdminUserIdAndUserGroupId
+func (dbResource *DbResource) IsAdmin(userReferenceId string) bool {
+
+	if userReferenceId == "" {
+		return false
+	}
+
+	userId, err := dbResource.GetReferenceIdToId("user", userReferenceId)
+	if err != nil {
+		return false
+	}
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dbResource.db)
+	return adminUserId == int64(userId)
+
+}
+
+// GetSessionUser loads a user the way the auth middleware does for their requests, for work done on their
+// behalf outside of a request
+func (dbResource *DbResource) GetSessionUser(userReferenceId string) (auth.SessionUser, map[string]interface{}, error) {
+
+	user, err := dbResource.GetReferenceIdToObject("user", userReferenceId)
+	if err != nil {
+		return auth.SessionUser{}, nil, err
+	}
+
+	userId := user["id"].(int64)
+
+	return auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: userReferenceId,
+		Groups:          dbResource.GetObjectGroupsByObjectId("user", userId),
+	}, user, nil
+}
+
 func (dbResource *DbResource) BecomeAdmin(userId int64) bool {
 
 	if !dbResource.CanBecomeAdmin() {
@@ -348,7 +384,7 @@ func (dbResource *DbResource) BecomeAdmin(userId int64) bool {
 	}
 
 	_, err = dbResource.db.Exec("update action set permission = ?", auth.NewPermission(auth.None, auth.Read|auth.Execute, auth.Create|auth.Execute).IntValue())
-	_, err = dbResource.db.Exec("update action set permission = ? where action_name in 'signin'", auth.NewPermission(auth.Peek|auth.Execute, auth.Read|auth.Execute, auth.Create|auth.Execute).IntValue())
+	_, err = dbResource.db.Exec("update action set permission = ? where action_name in ('signin', 'signin_2fa')", auth.NewPermission(auth.Peek|auth.Execute, auth.Read|auth.Execute, auth.Create|auth.Execute).IntValue())
 
 	if err != nil {
 		log.Errorf("Failed to update audit permissions: %v", err)
@@ -419,13 +455,27 @@ func (dr *DbResource) GetRowPermission(row map[string]interface{}) PermissionIns
 	return perm
 }
 
+// GetRowsByWhereClause is not restricted to a tenant, it is for system work. Rows loaded on behalf of a user
+// go through GetRowsByWhereClauseInContext.
 func (dr *DbResource) GetRowsByWhereClause(typeName string, where ...squirrel.Eq) ([]map[string]interface{}, [][]map[string]interface{}, error) {
+	return dr.getRowsByWhereClause(typeName, nil, where...)
+}
+
+// GetRowsByWhereClauseInContext is GetRowsByWhereClause restricted to the tenant of ctx, see TenantWhereFor
+func (dr *DbResource) GetRowsByWhereClauseInContext(ctx context.Context, typeName string, where ...squirrel.Eq) ([]map[string]interface{}, [][]map[string]interface{}, error) {
+	return dr.getRowsByWhereClause(typeName, dr.tenantWhereOf(ctx, typeName), where...)
+}
+
+func (dr *DbResource) getRowsByWhereClause(typeName string, tenantWhere squirrel.Sqlizer, where ...squirrel.Eq) ([]map[string]interface{}, [][]map[string]interface{}, error) {
 
 	stmt := squirrel.Select("*").From(typeName)
 
 	for _, w := range where {
 		stmt = stmt.Where(w)
 	}
+	if tenantWhere != nil {
+		stmt = stmt.Where(tenantWhere)
+	}
 
 	s, q, err := stmt.ToSql()
 
@@ -580,9 +630,25 @@ func (dr *DbResource) GetAllRawObjects(typeName string) ([]map[string]interface{
 	return m, err
 }
 
+// GetReferenceIdToObject is not restricted to a tenant, it is for system work. Rows loaded on behalf of a user
+// go through GetReferenceIdToObjectInContext.
 func (dr *DbResource) GetReferenceIdToObject(typeName string, referenceId string) (map[string]interface{}, error) {
+	return dr.getReferenceIdToObject(typeName, referenceId, nil)
+}
+
+// GetReferenceIdToObjectInContext is GetReferenceIdToObject restricted to the tenant of ctx, a row of another
+// tenant is not found
+func (dr *DbResource) GetReferenceIdToObjectInContext(ctx context.Context, typeName string, referenceId string) (map[string]interface{}, error) {
+	return dr.getReferenceIdToObject(typeName, referenceId, dr.tenantWhereOf(ctx, typeName))
+}
+
+func (dr *DbResource) getReferenceIdToObject(typeName string, referenceId string, tenantWhere squirrel.Sqlizer) (map[string]interface{}, error) {
 	//log.Infof("Get Object by reference id [%v][%v]", typeName, referenceId)
-	s, q, err := squirrel.Select("*").From(typeName).Where(squirrel.Eq{"reference_id": referenceId}).ToSql()
+	query := squirrel.Select("*").From(typeName).Where(squirrel.Eq{"reference_id": referenceId})
+	if tenantWhere != nil {
+		query = query.Where(tenantWhere)
+	}
+	s, q, err := query.ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -13,6 +13,10 @@ type DbResource struct {
 	ms           *MiddlewareSet
 	configStore  *ConfigStore
 	contextCache map[string]interface{}
+
+	rowSecurityPolicy *RowSecurityPolicy
+	tenantScoped      bool
+	fieldPermissions  []FieldPermission
 }
 
 func NewDbResource(model *api2go.Api2GoModel, db *sqlx.DB, ms *MiddlewareSet, cruds map[string]*DbResource, configStore *ConfigStore) *DbResource {
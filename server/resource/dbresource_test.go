@@ -0,0 +1,135 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/satori/go.uuid"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"testing"
+)
+
+// newTestDbResources creates the standard tables, and any extra tables a test needs, in an in memory sqlite
+// database the same way the server does at startup, and returns a resource for each of them
+func newTestDbResources(t *testing.T, extraTables ...TableInfo) map[string]*DbResource {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	// every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+
+	tables := make([]TableInfo, 0)
+	for _, table := range append(append([]TableInfo{}, StandardTables...), extraTables...) {
+		table.Columns = append([]api2go.ColumnInfo{}, table.Columns...)
+		tables = append(tables, table)
+	}
+	config := CmsConfig{
+		Tables:    tables,
+		Relations: append([]api2go.TableRelation{}, StandardRelations...),
+	}
+
+	CheckTenantColumns(&config)
+	CheckRelations(&config, db)
+	CheckAuditTables(&config, db)
+
+	for i := range config.Tables {
+		table := &config.Tables[i]
+		CreateAMapOfColumnsWeWantInTheFinalTable(table)
+		_, err = db.Exec(MakeCreateTableQuery(table, db.DriverName()))
+		if err != nil {
+			t.Fatalf("Failed to create table %v: %v", table.TableName, err)
+		}
+	}
+
+	configStore, err := NewConfigStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create config store: %v", err)
+	}
+
+	cruds := make(map[string]*DbResource)
+	for _, table := range config.Tables {
+		model := api2go.NewApi2GoModel(table.TableName, table.Columns, table.DefaultPermission, table.Relations)
+		dr := NewDbResource(model, db, &MiddlewareSet{}, cruds, configStore)
+		dr.SetTenantScoped(table.IsTenantScoped)
+		dr.SetFieldPermissions(table.FieldPermissions)
+		cruds[table.TableName] = dr
+	}
+
+	return cruds
+}
+
+// insertTestRow adds a row to a table created by newTestDbResources, filling in the reference id and the permission
+// when the test does not care about them
+func insertTestRow(t *testing.T, db *sqlx.DB, tableName string, row map[string]interface{}) {
+
+	if _, ok := row["reference_id"]; !ok {
+		row["reference_id"] = uuid.NewV4().String()
+	}
+	if _, ok := row["permission"]; !ok {
+		row["permission"] = auth.DEFAULT_PERMISSION
+	}
+
+	columns := make([]string, 0)
+	values := make([]interface{}, 0)
+	for column, value := range row {
+		columns = append(columns, column)
+		values = append(values, value)
+	}
+	s, v, err := squirrel.Insert(tableName).Columns(columns...).Values(values...).ToSql()
+	if err != nil {
+		t.Fatalf("Failed to create insert query for %v: %v", tableName, err)
+	}
+	_, err = db.Exec(s, v...)
+	if err != nil {
+		t.Fatalf("Failed to insert into %v %v: %v", tableName, row, err)
+	}
+}
+
+// insertTestAction stores an action the way the server does at startup, so it can be found by GetActionByName
+func insertTestAction(t *testing.T, db *sqlx.DB, action Action) {
+
+	var worldId int64
+	err := db.QueryRowx("select id from world where table_name = ?", action.OnType).Scan(&worldId)
+	if err != nil {
+		insertTestRow(t, db, "world", map[string]interface{}{"table_name": action.OnType, "world_schema_json": "{}"})
+		err = db.QueryRowx("select id from world where table_name = ?", action.OnType).Scan(&worldId)
+		if err != nil {
+			t.Fatalf("Failed to read world row of %v: %v", action.OnType, err)
+		}
+	}
+
+	schema, err := json.Marshal(action)
+	if err != nil {
+		t.Fatalf("Failed to marshal action %v: %v", action.Name, err)
+	}
+	insertTestRow(t, db, "action", map[string]interface{}{
+		"action_name":   action.Name,
+		"label":         action.Name,
+		"action_schema": string(schema),
+		"world_id":      worldId,
+	})
+}
+
+// recordingPerformer is an action performer which keeps the user and the input of every call
+type recordingPerformer struct {
+	calls []recordedCall
+}
+
+type recordedCall struct {
+	userReferenceId string
+	inFields        map[string]interface{}
+}
+
+func (p *recordingPerformer) Name() string {
+	return "test.record"
+}
+
+func (p *recordingPerformer) DoAction(request ActionRequest, inFields map[string]interface{}) ([]ActionResponse, []error) {
+	user, _ := request.RequestContext.Value("user").(auth.SessionUser)
+	p.calls = append(p.calls, recordedCall{userReferenceId: user.UserReferenceId, inFields: inFields})
+	return []ActionResponse{NewActionResponse("client.notify", NewClientNotification("success", "Recorded", "Success"))}, nil
+}
@@ -1,5 +1,8 @@
 package resource
 
-func NewCreateEventHandler() DatabaseRequestInterceptor {
-	return &eventHandlerMiddleware{}
+func NewCreateEventHandler(listeners []EventListener) DatabaseRequestInterceptor {
+	return &eventHandlerMiddleware{
+		event:     TriggerEventCreate,
+		listeners: listeners,
+	}
 }
@@ -1,5 +1,8 @@
 package resource
 
-func NewDeleteEventHandler() DatabaseRequestInterceptor {
-	return &eventHandlerMiddleware{}
+func NewDeleteEventHandler(listeners []EventListener) DatabaseRequestInterceptor {
+	return &eventHandlerMiddleware{
+		event:     TriggerEventDelete,
+		listeners: listeners,
+	}
 }
@@ -1,5 +1,8 @@
 package resource
 
-func NewUpdateEventHandler() DatabaseRequestInterceptor {
-	return &eventHandlerMiddleware{}
+func NewUpdateEventHandler(listeners []EventListener) DatabaseRequestInterceptor {
+	return &eventHandlerMiddleware{
+		event:     TriggerEventUpdate,
+		listeners: listeners,
+	}
 }
@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"context"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	//"bytes"
@@ -54,6 +55,9 @@ type ExchangeExecution struct {
 	ExchangeContract ExchangeContract
 	oauthToken       *oauth2.Token
 	oauthConfig      *oauth2.Config
+	cruds            map[string]*DbResource
+	// the context of the change the exchange runs for
+	ctx context.Context
 }
 
 func (ec *ExchangeExecution) Execute(inFields map[string]interface{}, data []map[string]interface{}) (err error) {
@@ -62,8 +66,11 @@ func (ec *ExchangeExecution) Execute(inFields map[string]interface{}, data []map
 
 	switch ec.ExchangeContract.TargetType {
 	case "self":
-		log.Errorf("self in target, not yet implemented")
-		return errors.New("self in target, not yet implemented")
+		handler, err = NewSelfExchangeHandler(ec.ExchangeContract, ec.cruds, ec.ctx)
+		if err != nil {
+			return err
+		}
+		break
 	default:
 		handler, err = NewRestExchangeHandler(ec.ExchangeContract, ec.oauthToken, ec.oauthConfig)
 		if err != nil {
@@ -78,23 +85,32 @@ func (ec *ExchangeExecution) Execute(inFields map[string]interface{}, data []map
 		inFields[k] = v
 	}
 
-	inFields["oauthClientId"] = ec.oauthConfig.ClientID
+	if ec.oauthConfig != nil {
+		inFields["oauthClientId"] = ec.oauthConfig.ClientID
+	}
 
+	// every row is tried, the first failure is returned
+	var firstErr error
 	for _, row := range data {
 		err = handler.ExecuteTarget(row, inFields)
 		if err != nil {
 			log.Errorf("Failed to execute target for [%v]: %v", row["__type"], err)
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
 
-	return nil
+	return firstErr
 }
 
-func NewExchangeExecution(exchange ExchangeContract, oauthToken *oauth2.Token, oauthConfig *oauth2.Config) *ExchangeExecution {
+func NewExchangeExecution(exchange ExchangeContract, oauthToken *oauth2.Token, oauthConfig *oauth2.Config, cruds map[string]*DbResource, ctx context.Context) *ExchangeExecution {
 
 	return &ExchangeExecution{
 		ExchangeContract: exchange,
 		oauthToken:       oauthToken,
 		oauthConfig:      oauthConfig,
+		cruds:            cruds,
+		ctx:              ctx,
 	}
 }
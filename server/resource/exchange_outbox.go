@@ -0,0 +1,420 @@
+package resource
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"time"
+)
+
+const (
+	ExchangeDeliveryPending   = DeliveryPending
+	ExchangeDeliverySending   = DeliverySending
+	ExchangeDeliveryDelivered = DeliveryDelivered
+	ExchangeDeliveryFailed    = DeliveryFailed
+)
+
+const (
+	ExchangeDeadLetterDead     = "dead"
+	ExchangeDeadLetterRedriven = "redriven"
+)
+
+// a failed delivery is tried again after ExchangeRetryBackoff, doubled on every attempt up to
+// ExchangeMaxRetryBackoff, after ExchangeMaxAttempts it is moved to the dead letters
+const ExchangeMaxAttempts = 6
+const ExchangeRetryBackoff = 30 * time.Second
+const ExchangeMaxRetryBackoff = time.Hour
+
+const ExchangePollInterval = 5 * time.Second
+const ExchangeRequestTimeout = 30 * time.Second
+const ExchangeWorkerCount = 4
+
+// a delivery still sending after this long was abandoned by an instance which stopped
+const ExchangeSendTimeout = 5 * time.Minute
+
+var exchangeDeliveries = deliveryTable{
+	name:            "exchange_outbox",
+	maxAttempts:     ExchangeMaxAttempts,
+	retryBackoff:    ExchangeRetryBackoff,
+	maxRetryBackoff: ExchangeMaxRetryBackoff,
+	sendTimeout:     ExchangeSendTimeout,
+}
+
+// exchangeDeliveryPayload is what is kept of a change to run an exchange for it later, in the tenant it was
+// made in
+type exchangeDeliveryPayload struct {
+	Row           map[string]interface{}
+	Tenant        *Tenant
+	ExchangeDepth int
+}
+
+// ExchangeOutbox records a delivery for every row an exchange runs for, and runs them in the background.
+// Deliveries are kept in the exchange_outbox table, so they survive a restart, and are claimed with a
+// conditional update so several instances can share them. A failed delivery is tried again with a backoff,
+// and once it has failed ExchangeMaxAttempts times it is copied to exchange_dead_letter with the last error
+// and response, where an administrator can re-drive it.
+type ExchangeOutbox struct {
+	cruds     *map[string]*DbResource
+	exchanges map[string]ExchangeContract
+}
+
+func NewExchangeOutbox(cmsConfig *CmsConfig, cruds *map[string]*DbResource) *ExchangeOutbox {
+
+	exchanges := make(map[string]ExchangeContract)
+	for _, exchange := range cmsConfig.ExchangeContracts {
+		exchanges[exchange.Name] = exchange
+	}
+
+	return &ExchangeOutbox{
+		cruds:     cruds,
+		exchanges: exchanges,
+	}
+}
+
+func (eo *ExchangeOutbox) db() *sqlx.DB {
+	return (*eo.cruds)["exchange_outbox"].db
+}
+
+// Enqueue records a delivery of the row to the exchange, owned by the administrator
+func (eo *ExchangeOutbox) Enqueue(exchange ExchangeContract, row map[string]interface{}, tenant *Tenant, depth int) error {
+
+	payload, err := json.Marshal(exchangeDeliveryPayload{
+		Row:           row,
+		Tenant:        tenant,
+		ExchangeDepth: depth,
+	})
+	if err != nil {
+		return err
+	}
+
+	return eo.enqueuePayload(exchange.Name, string(payload))
+}
+
+func (eo *ExchangeOutbox) enqueuePayload(exchangeName string, payload string) error {
+
+	adminId, _ := GetAdminUserIdAndUserGroupId(eo.db())
+	now := time.Now()
+
+	s, v, err := squirrel.Insert("exchange_outbox").
+		Columns("reference_id", "permission", "created_at", "user_id", "exchange_name", "payload", "status",
+			"attempts", "next_attempt_at").
+		Values(uuid.NewV4().String(), auth.NewPermission(auth.None, auth.None, auth.Read|auth.Delete).IntValue(), now, adminId,
+			exchangeName, payload, ExchangeDeliveryPending, 0, now).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = eo.db().Exec(s, v...)
+	return err
+}
+
+// Start starts the workers running the pending deliveries, a slow exchange holds up one worker only
+func (eo *ExchangeOutbox) Start() {
+	OutboxWorker{
+		Name:            "exchange",
+		Workers:         ExchangeWorkerCount,
+		PollInterval:    ExchangePollInterval,
+		RequeueInterval: ExchangePollInterval,
+		Next:            eo.sendNextDelivery,
+		Requeue: func() {
+			exchangeDeliveries.requeueAbandoned(eo.db())
+		},
+	}.Start()
+}
+
+type exchangeDelivery struct {
+	Id           int64  `db:"id"`
+	DeliveryId   string `db:"reference_id"`
+	ExchangeName string `db:"exchange_name"`
+	Payload      string `db:"payload"`
+	Attempts     int    `db:"attempts"`
+}
+
+// sendNextDelivery claims and runs the next due delivery, it returns false when there is none
+func (eo *ExchangeOutbox) sendNextDelivery() bool {
+
+	db := eo.db()
+	now := time.Now()
+
+	s, v, err := squirrel.Select("id", "reference_id", "exchange_name", "payload", "attempts").
+		From("exchange_outbox").
+		Where(squirrel.Eq{"status": ExchangeDeliveryPending}).
+		Where(squirrel.Expr("next_attempt_at <= ?", now)).
+		OrderBy("next_attempt_at").Limit(1).ToSql()
+	CheckErr(err, "Failed to create exchange delivery select query")
+
+	var delivery exchangeDelivery
+	err = db.QueryRowx(s, v...).StructScan(&delivery)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("Failed to load exchange deliveries: %v", err)
+		}
+		return false
+	}
+
+	claimed, err := exchangeDeliveries.claim(db, delivery.Id, delivery.Attempts)
+	if err != nil {
+		log.Errorf("Failed to claim exchange delivery [%v]: %v", delivery.DeliveryId, err)
+		return false
+	}
+	if !claimed {
+		// another instance got it first
+		return true
+	}
+
+	attempts := delivery.Attempts + 1
+	err = eo.send(delivery)
+
+	var statusCode interface{}
+	var responseBody interface{}
+	if responseErr, ok := err.(*ExchangeResponseError); ok {
+		statusCode = responseErr.StatusCode
+		responseBody = responseErr.Body
+	}
+
+	description := fmt.Sprintf("Exchange delivery [%v] to [%v]", delivery.DeliveryId, delivery.ExchangeName)
+	if exchangeDeliveries.finish(db, delivery.Id, description, attempts, statusCode, responseBody, err) {
+		deadLetterErr := eo.addDeadLetter(delivery, attempts, statusCode, responseBody, err)
+		if deadLetterErr != nil {
+			log.Errorf("Failed to add dead letter for exchange delivery [%v]: %v", delivery.DeliveryId, deadLetterErr)
+		}
+	}
+
+	return true
+}
+
+func (eo *ExchangeOutbox) addDeadLetter(delivery exchangeDelivery, attempts int, statusCode interface{}, responseBody interface{}, deliveryErr error) error {
+
+	adminId, _ := GetAdminUserIdAndUserGroupId(eo.db())
+
+	s, v, err := squirrel.Insert("exchange_dead_letter").
+		Columns("reference_id", "permission", "created_at", "user_id", "exchange_name", "outbox_reference_id",
+			"payload", "status", "attempts", "response_code", "response_body", "last_error").
+		Values(uuid.NewV4().String(), auth.NewPermission(auth.None, auth.None, auth.Read|auth.Delete).IntValue(), time.Now(), adminId,
+			delivery.ExchangeName, delivery.DeliveryId, delivery.Payload, ExchangeDeadLetterDead, attempts, statusCode,
+			responseBody, deliveryErr.Error()).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = eo.db().Exec(s, v...)
+	return err
+}
+
+// send runs the exchange for the row of the delivery, in the tenant of the change
+func (eo *ExchangeOutbox) send(delivery exchangeDelivery) error {
+
+	exchange, ok := eo.exchanges[delivery.ExchangeName]
+	if !ok {
+		return fmt.Errorf("Unknown exchange [%v]", delivery.ExchangeName)
+	}
+
+	var payload exchangeDeliveryPayload
+	err := json.Unmarshal([]byte(delivery.Payload), &payload)
+	if err != nil {
+		return fmt.Errorf("Invalid payload: %v", err)
+	}
+
+	token, oauthConfig, err := eo.exchangeToken(exchange)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(context.Background(), "exchange_depth", payload.ExchangeDepth)
+	if payload.Tenant != nil {
+		ctx = context.WithValue(ctx, "tenant", *payload.Tenant)
+	}
+
+	exchangeExecution := NewExchangeExecution(exchange, token, oauthConfig, *eo.cruds, ctx)
+	return exchangeExecution.Execute(make(map[string]interface{}), []map[string]interface{}{payload.Row})
+}
+
+// exchangeToken is the oauth token of the exchange, refreshed when it has expired. Only exchanges with oauth
+// auth have a token, the others run without.
+func (eo *ExchangeOutbox) exchangeToken(exchange ExchangeContract) (*oauth2.Token, *oauth2.Config, error) {
+
+	if exchange.OauthTokenId == nil {
+		return nil, nil, nil
+	}
+
+	dr := (*eo.cruds)["oauth_token"]
+
+	token, err := dr.GetTokenByTokenId(*exchange.OauthTokenId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("No token for exchange [%v]: %v", exchange.Name, err)
+	}
+
+	oauthConfig, err := dr.GetOauthDescriptionByTokenId(*exchange.OauthTokenId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("No oauth description for exchange [%v]: %v", exchange.Name, err)
+	}
+
+	if !token.Valid() {
+		token, err = oauthConfig.TokenSource(context.Background(), token).Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get new access token for exchange [%v]: %v", exchange.Name, err)
+		}
+
+		err = dr.UpdateAccessTokenByTokenId(*exchange.OauthTokenId, token.AccessToken, token.Expiry.Unix())
+		CheckErr(err, "failed to update access token")
+	}
+
+	return token, oauthConfig, nil
+}
+
+// Redrive queues the delivery of a dead letter again, with a fresh set of attempts
+func (eo *ExchangeOutbox) Redrive(deadLetterReferenceId string) error {
+
+	db := eo.db()
+
+	s, v, err := squirrel.Select("exchange_name", "payload").
+		From("exchange_dead_letter").
+		Where(squirrel.Eq{"reference_id": deadLetterReferenceId}).
+		Where(squirrel.Eq{"status": ExchangeDeadLetterDead}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	var exchangeName, payload string
+	err = db.QueryRowx(s, v...).Scan(&exchangeName, &payload)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("No dead letter [%v] to re-drive", deadLetterReferenceId)
+	}
+	if err != nil {
+		return err
+	}
+
+	s, v, err = squirrel.Update("exchange_dead_letter").
+		Set("status", ExchangeDeadLetterRedriven).
+		Set("redriven_at", time.Now()).
+		Where(squirrel.Eq{"reference_id": deadLetterReferenceId}).
+		Where(squirrel.Eq{"status": ExchangeDeadLetterDead}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec(s, v...)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count != 1 {
+		// re-driven by someone else in between
+		return nil
+	}
+
+	return eo.enqueuePayload(exchangeName, payload)
+}
+
+// DeadLetters are the reference ids of the dead letters of the exchange which were not re-driven yet
+func (eo *ExchangeOutbox) DeadLetters(exchangeName string) ([]string, error) {
+
+	s, v, err := squirrel.Select("reference_id").
+		From("exchange_dead_letter").
+		Where(squirrel.Eq{"exchange_name": exchangeName}).
+		Where(squirrel.Eq{"status": ExchangeDeadLetterDead}).
+		OrderBy("created_at").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	referenceIds := make([]string, 0)
+	err = eo.db().Select(&referenceIds, s, v...)
+	return referenceIds, err
+}
+
+// ExchangeDeliveryStatus is a summary of the deliveries of an exchange
+type ExchangeDeliveryStatus struct {
+	Exchange string
+	// the number of deliveries in the outbox by status
+	Deliveries map[string]int
+	// the number of dead letters not re-driven yet
+	DeadLetters int
+	// the latest dead letters not re-driven yet
+	Latest []map[string]interface{}
+}
+
+const exchangeStatusLatestLimit = 10
+
+// Status counts the deliveries of the exchange and lists its latest dead letters
+func (eo *ExchangeOutbox) Status(exchangeName string) (ExchangeDeliveryStatus, error) {
+
+	db := eo.db()
+	status := ExchangeDeliveryStatus{
+		Exchange:   exchangeName,
+		Deliveries: make(map[string]int),
+		Latest:     make([]map[string]interface{}, 0),
+	}
+
+	s, v, err := squirrel.Select("status", "count(*)").
+		From("exchange_outbox").
+		Where(squirrel.Eq{"exchange_name": exchangeName}).
+		GroupBy("status").ToSql()
+	if err != nil {
+		return status, err
+	}
+
+	rows, err := db.Queryx(s, v...)
+	if err != nil {
+		return status, err
+	}
+	for rows.Next() {
+		var deliveryStatus string
+		var count int
+		err = rows.Scan(&deliveryStatus, &count)
+		if err != nil {
+			rows.Close()
+			return status, err
+		}
+		status.Deliveries[deliveryStatus] = count
+	}
+	rows.Close()
+
+	s, v, err = squirrel.Select("count(*)").
+		From("exchange_dead_letter").
+		Where(squirrel.Eq{"exchange_name": exchangeName}).
+		Where(squirrel.Eq{"status": ExchangeDeadLetterDead}).ToSql()
+	if err != nil {
+		return status, err
+	}
+	err = db.QueryRowx(s, v...).Scan(&status.DeadLetters)
+	if err != nil {
+		return status, err
+	}
+
+	s, v, err = squirrel.Select("reference_id", "created_at", "attempts", "response_code", "response_body", "last_error").
+		From("exchange_dead_letter").
+		Where(squirrel.Eq{"exchange_name": exchangeName}).
+		Where(squirrel.Eq{"status": ExchangeDeadLetterDead}).
+		OrderBy("created_at desc").Limit(exchangeStatusLatestLimit).ToSql()
+	if err != nil {
+		return status, err
+	}
+
+	rows, err = db.Queryx(s, v...)
+	if err != nil {
+		return status, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return status, err
+		}
+		for key, value := range row {
+			if bytes, ok := value.([]byte); ok {
+				row[key] = string(bytes)
+			}
+		}
+		status.Latest = append(status.Latest, row)
+	}
+
+	return status, nil
+}
@@ -2,10 +2,10 @@ package resource
 
 import (
 	"fmt"
+	"github.com/artpar/resty"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
-	"github.com/artpar/resty"
 	"strings"
 )
 
@@ -13,6 +13,63 @@ type ExternalExchange interface {
 	ExecuteTarget(row map[string]interface{}, inFieldMap map[string]interface{}) error
 }
 
+const (
+	RestExchangeAuthNone   = ""
+	RestExchangeAuthOauth  = "oauth"
+	RestExchangeAuthBasic  = "basic"
+	RestExchangeAuthApiKey = "apikey"
+)
+
+// only this much of the response body of a failed request is kept
+const exchangeResponseLogLimit = 1000
+
+// ExchangeResponseError is the failure of a request which got a response other than 2xx
+type ExchangeResponseError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ExchangeResponseError) Error() string {
+	return fmt.Sprintf("Exchange target responded with %d", e.StatusCode)
+}
+
+func truncateExchangeResponse(body string) string {
+	if len(body) > exchangeResponseLogLimit {
+		return body[:exchangeResponseLogLimit]
+	}
+	return body
+}
+
+// RestExchangeAuth is how the requests of a rest exchange are authenticated. oauth sends the access token
+// of the oauth token of the exchange, basic sends Username and Password, apikey sends Key in the Header
+// (Authorization if not set). Username, Password and Key are evaluated like the other values, so "~apiKey"
+// is the apiKey from the TargetAttributes of the exchange.
+type RestExchangeAuth struct {
+	Type     string
+	Username string
+	Password string
+	Header   string
+	Key      string
+}
+
+// RestExchange is a template of the requests sent to a rest api, an exchange with the template name as its
+// TargetType sends a request for every row. Url, Headers, QueryParams and Body take ~ and ! expressions,
+// like outcome attributes, with the row as subject and the TargetAttributes of the exchange. Without a
+// Body the row is sent as it is. Templates are declared in the schema files.
+//
+//	RestExchanges:
+//	- Name: crm-contact
+//	  Method: POST
+//	  Url: "~crmUrl"
+//	  Headers:
+//	    Accept: application/json
+//	  Body:
+//	    email: "~subject.email"
+//	    name: "!subject.first_name + ' ' + subject.last_name"
+//	  Auth:
+//	    Type: apikey
+//	    Header: X-Api-Key
+//	    Key: "~apiKey"
 type RestExchange struct {
 	Name        string
 	Method      string
@@ -20,9 +77,10 @@ type RestExchange struct {
 	Headers     map[string]interface{}
 	Body        map[string]interface{}
 	QueryParams map[string]interface{}
+	Auth        RestExchangeAuth
 }
 
-var restExchanges = []RestExchange{
+var StandardRestExchanges = []RestExchange{
 	{
 
 		Name:   "gsheet-append",
@@ -40,9 +98,139 @@ var restExchanges = []RestExchange{
 			"valueInputOption": "RAW",
 			"key":              "~appKey",
 		},
+		Auth: RestExchangeAuth{
+			Type: RestExchangeAuthOauth,
+		},
 	},
 }
 
+// the valid templates by name, set at boot by LoadRestExchanges
+var restExchanges = map[string]RestExchange{}
+
+// LoadRestExchanges checks the rest exchange templates in the config and keeps the valid ones for
+// NewRestExchangeHandler. Invalid templates are reported and left out, as are exchanges with a target type
+// which is neither self nor a template.
+func LoadRestExchanges(initConfig *CmsConfig) {
+
+	loaded := make(map[string]RestExchange)
+
+	for _, restExchange := range initConfig.RestExchanges {
+
+		if _, ok := loaded[restExchange.Name]; ok {
+			log.Errorf("Rest exchange [%v] is declared twice, the first one is used", restExchange.Name)
+			continue
+		}
+
+		err := ValidateRestExchange(restExchange)
+		if err != nil {
+			log.Errorf("Rest exchange [%v] is left out: %v", restExchange.Name, err)
+			continue
+		}
+
+		log.Infof("Rest exchange [%v] %v %v", restExchange.Name, restExchange.Method, restExchange.Url)
+		loaded[restExchange.Name] = restExchange
+	}
+
+	restExchanges = loaded
+
+	for _, exchange := range initConfig.ExchangeContracts {
+		if exchange.TargetType == "self" {
+			continue
+		}
+		if _, ok := loaded[exchange.TargetType]; !ok {
+			log.Errorf("Exchange [%v] has unknown target type [%v]", exchange.Name, exchange.TargetType)
+		}
+	}
+}
+
+// ValidateRestExchange checks that the template has a name, a known method and auth type, a url and that
+// its ! expressions compile
+func ValidateRestExchange(restExchange RestExchange) error {
+
+	if restExchange.Name == "" {
+		return errors.New("no name")
+	}
+	if restExchange.Name == "self" {
+		return errors.New("self is the name of the exchanges to daptin tables")
+	}
+
+	switch strings.ToUpper(restExchange.Method) {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return fmt.Errorf("unknown method [%v]", restExchange.Method)
+	}
+
+	if restExchange.Url == "" {
+		return errors.New("no url")
+	}
+
+	auth := restExchange.Auth
+	switch strings.ToLower(auth.Type) {
+	case RestExchangeAuthNone, RestExchangeAuthOauth:
+	case RestExchangeAuthBasic:
+		if auth.Username == "" {
+			return errors.New("basic auth without a username")
+		}
+	case RestExchangeAuthApiKey:
+		if auth.Key == "" {
+			return errors.New("apikey auth without a key")
+		}
+	default:
+		return fmt.Errorf("unknown auth type [%v], expected oauth, basic or apikey", auth.Type)
+	}
+
+	for name, value := range map[string]interface{}{
+		"url":          restExchange.Url,
+		"headers":      restExchange.Headers,
+		"query params": restExchange.QueryParams,
+		"body":         restExchange.Body,
+		"auth":         []string{auth.Username, auth.Password, auth.Key},
+	} {
+		err := checkTemplateExpressions(value)
+		if err != nil {
+			return fmt.Errorf("invalid expression in %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkTemplateExpressions compiles the ! expressions in the value, so a mistake is found at boot and not
+// on the first request
+func checkTemplateExpressions(value interface{}) error {
+
+	switch typed := value.(type) {
+	case string:
+		if strings.HasPrefix(typed, "!") {
+			_, err := compileScript(typed[1:])
+			return err
+		}
+	case []string:
+		for _, item := range typed {
+			if err := checkTemplateExpressions(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range typed {
+			if err := checkTemplateExpressions(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range typed {
+			if err := checkTemplateExpressions(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// restExchangeClient sends the requests of every rest exchange, the default client of resty has no timeout
+var restExchangeClient = resty.New().SetTimeout(ExchangeRequestTimeout)
+
 type RestExternalExchange struct {
 	oauthToken          *oauth2.Token
 	exchangeContract    ExchangeContract
@@ -50,48 +238,104 @@ type RestExternalExchange struct {
 	oauthConfig         *oauth2.Config
 }
 
-func (g *RestExternalExchange) ExecuteTarget(row map[string]interface{}, inFieldMap map[string]interface{}) error {
-
-	log.Infof("Execute rest external exchange")
+// stringValues evaluates the template values, like the headers, as strings. Values which are not set are
+// left out.
+func stringValues(template map[string]interface{}, inFieldMap map[string]interface{}) (map[string]string, error) {
 
-	headersMap := make(map[string]string)
+	stringMap := make(map[string]string)
+	if len(template) == 0 {
+		return stringMap, nil
+	}
 
-	headInterface, err := buildActionContext(g.exchangeInformation.Headers, inFieldMap)
+	valuesInterface, err := buildActionContext(template, inFieldMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	headers := headInterface.(map[string]interface{})
 
-	for k, v := range headers {
+	for k, v := range valuesInterface.(map[string]interface{}) {
 		if v == nil {
 			continue
 		}
-		headersMap[k] = v.(string)
+		stringMap[k] = fmt.Sprintf("%v", v)
 	}
 
-	queryParamsMap := make(map[string]string)
-	queryInterface, err := buildActionContext(g.exchangeInformation.QueryParams, inFieldMap)
-	if err != nil {
-		return err
+	return stringMap, nil
+}
+
+func evaluateTemplateString(template string, inFieldMap map[string]interface{}) (string, error) {
+
+	value, err := evaluateString(template, inFieldMap)
+	if err != nil || value == nil {
+		return "", err
 	}
-	queryParams := queryInterface.(map[string]interface{})
 
-	for k, v := range queryParams {
+	return fmt.Sprintf("%v", value), nil
+}
 
-		if v == nil {
-			continue
+// authenticate adds the credentials of the template auth to the request
+func (g *RestExternalExchange) authenticate(request *resty.Request, inFieldMap map[string]interface{}) error {
+
+	auth := g.exchangeInformation.Auth
+
+	switch strings.ToLower(auth.Type) {
+	case RestExchangeAuthOauth:
+		if g.oauthToken == nil {
+			return fmt.Errorf("exchange [%v] has no oauth token", g.exchangeContract.Name)
 		}
+		request.SetAuthToken(g.oauthToken.AccessToken)
 
-		queryParamsMap[k] = v.(string)
+	case RestExchangeAuthBasic:
+		username, err := evaluateTemplateString(auth.Username, inFieldMap)
+		if err != nil {
+			return err
+		}
+		password, err := evaluateTemplateString(auth.Password, inFieldMap)
+		if err != nil {
+			return err
+		}
+		request.SetBasicAuth(username, password)
+
+	case RestExchangeAuthApiKey:
+		key, err := evaluateTemplateString(auth.Key, inFieldMap)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("exchange [%v] has no api key", g.exchangeContract.Name)
+		}
+		header := auth.Header
+		if header == "" {
+			header = "Authorization"
+		}
+		request.SetHeader(header, key)
 	}
 
-	attrs := make(map[string]interface{})
-	urlStr, err := evaluateString(g.exchangeInformation.Url, inFieldMap)
+	return nil
+}
+
+func (g *RestExternalExchange) ExecuteTarget(row map[string]interface{}, inFieldMap map[string]interface{}) error {
+
+	log.Infof("Execute rest external exchange [%v]", g.exchangeInformation.Name)
+
+	inFieldMap["subject"] = row
+
+	headersMap, err := stringValues(g.exchangeInformation.Headers, inFieldMap)
 	if err != nil {
 		return err
 	}
-	attrs["url"] = urlStr
-	attrs["method"] = g.exchangeInformation.Method
+
+	queryParamsMap, err := stringValues(g.exchangeInformation.QueryParams, inFieldMap)
+	if err != nil {
+		return err
+	}
+
+	url, err := evaluateTemplateString(g.exchangeInformation.Url, inFieldMap)
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		return fmt.Errorf("exchange [%v] has no url", g.exchangeContract.Name)
+	}
 
 	body := g.exchangeInformation.Body
 
@@ -99,29 +343,24 @@ func (g *RestExternalExchange) ExecuteTarget(row map[string]interface{}, inField
 	if len(body) == 0 {
 		bodyMap = row
 	} else {
-		inFieldMap["subject"] = row
 		bodyMap, err = buildActionContext(body, inFieldMap)
 		if err != nil {
 			return err
 		}
 	}
 
-	buildAttrsInterface, err := buildActionContext(attrs, inFieldMap)
-	buildAttrs := buildAttrsInterface.(map[string]interface{})
-
-	url := buildAttrs["url"].(string)
-	method := buildAttrs["method"].(string)
-
-	resty.SetDebug(true)
-	client := resty.R()
+	client := restExchangeClient.R()
 	client.SetBody(bodyMap)
 
 	client.SetHeaders(headersMap)
 	client.SetQueryParams(queryParamsMap)
 
-	client.SetAuthToken(g.oauthToken.AccessToken)
+	err = g.authenticate(client, inFieldMap)
+	if err != nil {
+		return err
+	}
 
-	method = strings.ToLower(method)
+	method := strings.ToLower(g.exchangeInformation.Method)
 
 	var response *resty.Response
 
@@ -135,28 +374,34 @@ func (g *RestExternalExchange) ExecuteTarget(row map[string]interface{}, inField
 	case "put":
 		response, err = client.Put(url)
 		break
+	case "patch":
+		response, err = client.Patch(url)
+		break
 	case "delete":
 		response, err = client.Delete(url)
 		break
+	default:
+		return fmt.Errorf("exchange [%v] has an unknown method [%v]", g.exchangeContract.Name, g.exchangeInformation.Method)
+	}
+	if err != nil {
+		return err
+	}
 
+	log.Infof("Response from exchange [%v]: %v", g.exchangeContract.Name, response.StatusCode())
+
+	if response.StatusCode() < 200 || response.StatusCode() > 299 {
+		return &ExchangeResponseError{
+			StatusCode: response.StatusCode(),
+			Body:       truncateExchangeResponse(response.String()),
+		}
 	}
-	log.Infof("Response from exchange execution: %v", response.String())
-	log.Infof("Response from exchange execution: %v", err)
 
 	return nil
 }
 
 func NewRestExchangeHandler(exchangeContext ExchangeContract, oauthToken *oauth2.Token, oauthConfig *oauth2.Config) (ExternalExchange, error) {
 
-	found := false
-	var selected *RestExchange
-
-	for _, ra := range restExchanges {
-		if ra.Name == exchangeContext.TargetType {
-			found = true
-			selected = &ra
-		}
-	}
+	selected, found := restExchanges[exchangeContext.TargetType]
 
 	if !found {
 		return nil, errors.New(fmt.Sprintf("Unknown target type [%v]", exchangeContext.TargetType))
@@ -166,6 +411,6 @@ func NewRestExchangeHandler(exchangeContext ExchangeContract, oauthToken *oauth2
 		oauthToken:          oauthToken,
 		oauthConfig:         oauthConfig,
 		exchangeContract:    exchangeContext,
-		exchangeInformation: selected,
+		exchangeInformation: &selected,
 	}, nil
 }
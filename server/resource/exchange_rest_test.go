@@ -0,0 +1,102 @@
+package resource
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRestExchangesLeavesOutInvalidTemplates(t *testing.T) {
+
+	defer func() {
+		restExchanges = map[string]RestExchange{}
+	}()
+
+	LoadRestExchanges(&CmsConfig{
+		RestExchanges: []RestExchange{
+			{Name: "crm-contact", Method: "post", Url: "~crmUrl"},
+			{Name: "crm-contact", Method: "PUT", Url: "https://example.com/other"},
+			{Name: "self", Method: "POST", Url: "https://example.com"},
+			{Name: "unknown-method", Method: "FETCH", Url: "https://example.com"},
+			{Name: "no-url", Method: "POST"},
+			{Name: "no-username", Method: "POST", Url: "https://example.com", Auth: RestExchangeAuth{Type: RestExchangeAuthBasic}},
+			{Name: "no-key", Method: "POST", Url: "https://example.com", Auth: RestExchangeAuth{Type: RestExchangeAuthApiKey}},
+			{Name: "unknown-auth", Method: "POST", Url: "https://example.com", Auth: RestExchangeAuth{Type: "token"}},
+			{Name: "invalid-expression", Method: "POST", Url: "https://example.com", Body: map[string]interface{}{"name": "!subject."}},
+		},
+	})
+
+	if len(restExchanges) != 1 {
+		t.Errorf("Expected only the valid template to be loaded, got %v", restExchanges)
+	}
+	if restExchanges["crm-contact"].Url != "~crmUrl" {
+		t.Errorf("Expected the first template with a name to be used, got %v", restExchanges["crm-contact"])
+	}
+}
+
+func TestRestExchangeSendsTheTemplate(t *testing.T) {
+
+	defer func() {
+		restExchanges = map[string]RestExchange{}
+	}()
+
+	var received *http.Request
+	var receivedBody map[string]interface{}
+	status := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedBody)
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status": "done"}`))
+	}))
+	defer server.Close()
+
+	LoadRestExchanges(&CmsConfig{
+		RestExchanges: []RestExchange{
+			{
+				Name:        "crm-contact",
+				Method:      "POST",
+				Url:         "~crmUrl",
+				Headers:     map[string]interface{}{"Accept": "application/json"},
+				QueryParams: map[string]interface{}{"source": "daptin"},
+				Body: map[string]interface{}{
+					"email": "~subject.email",
+					"name":  "!subject.first_name + ' ' + subject.last_name",
+				},
+				Auth: RestExchangeAuth{Type: RestExchangeAuthApiKey, Header: "X-Api-Key", Key: "~apiKey"},
+			},
+		},
+	})
+
+	exchange, err := NewRestExchangeHandler(ExchangeContract{Name: "contacts", TargetType: "crm-contact"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create exchange: %v", err)
+	}
+
+	row := map[string]interface{}{"email": "ada@example.com", "first_name": "Ada", "last_name": "Lovelace"}
+	err = exchange.ExecuteTarget(row, map[string]interface{}{"crmUrl": server.URL + "/contacts", "apiKey": "key-1"})
+	if err != nil {
+		t.Fatalf("Failed to send the row: %v", err)
+	}
+
+	if received == nil || received.Method != "POST" || received.URL.Path != "/contacts" || received.URL.Query().Get("source") != "daptin" {
+		t.Fatalf("Expected a post to the url of the template, got %v", received)
+	}
+	if received.Header.Get("X-Api-Key") != "key-1" || received.Header.Get("Accept") != "application/json" {
+		t.Errorf("Expected the api key and the headers, got %v", received.Header)
+	}
+	expected := map[string]interface{}{"email": "ada@example.com", "name": "Ada Lovelace"}
+	if !reflect.DeepEqual(receivedBody, expected) {
+		t.Errorf("Expected the body of the template, got %v", receivedBody)
+	}
+
+	status = 500
+	err = exchange.ExecuteTarget(row, map[string]interface{}{"crmUrl": server.URL + "/contacts", "apiKey": "key-1"})
+	if responseError, ok := err.(*ExchangeResponseError); !ok || responseError.StatusCode != 500 {
+		t.Errorf("Expected the failed response as an error, got %v", err)
+	}
+}
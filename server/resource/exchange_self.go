@@ -0,0 +1,297 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exchanges to daptin tables write rows which can run exchanges again, up to this depth
+const MaxSelfExchangeDepth = 3
+
+// SelfExchange copies rows into a daptin table, the name in the TargetAttributes of the exchange. The
+// Attributes of the exchange map the source columns to the target columns, the values are converted to the
+// type of the target column. Without Attributes the columns with the same name are copied. When the
+// exchange Options have a key, the target column which identifies the copy, an existing row with the same
+// key is updated, otherwise a row is created for every source row.
+//
+//	ExchangeContracts:
+//	- Name: order-archive
+//	  SourceType: self
+//	  SourceAttributes:
+//	    name: order
+//	  TargetType: self
+//	  TargetAttributes:
+//	    name: order_archive
+//	  Attributes:
+//	  - SourceColumn: reference_id
+//	    TargetColumn: order_id
+//	  - SourceColumn: total
+//	    TargetColumn: total
+//	    TargetColumnType: measurement
+//	  Options:
+//	    key: order_id
+type SelfExchange struct {
+	exchangeContract ExchangeContract
+	cruds            map[string]*DbResource
+	ctx              context.Context
+}
+
+func NewSelfExchangeHandler(exchangeContract ExchangeContract, cruds map[string]*DbResource, ctx context.Context) (ExternalExchange, error) {
+
+	targetName, _ := exchangeContract.TargetAttributes["name"].(string)
+	if _, ok := cruds[targetName]; !ok {
+		return nil, fmt.Errorf("Unknown target table [%v]", targetName)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &SelfExchange{
+		exchangeContract: exchangeContract,
+		cruds:            cruds,
+		ctx:              ctx,
+	}, nil
+}
+
+// CheckSelfExchange checks that the target table of an exchange to daptin and the mapped columns exist
+func CheckSelfExchange(exchangeContract ExchangeContract, tables []TableInfo) error {
+
+	targetName, _ := exchangeContract.TargetAttributes["name"].(string)
+	if targetName == "" {
+		return errors.New("no target table name")
+	}
+
+	var target *TableInfo
+	for i := range tables {
+		if tables[i].TableName == targetName {
+			target = &tables[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("unknown target table [%v]", targetName)
+	}
+
+	columns := make(map[string]bool)
+	for _, column := range target.Columns {
+		columns[column.ColumnName] = true
+	}
+	for _, column := range StandardColumns {
+		columns[column.ColumnName] = true
+	}
+
+	for _, columnMap := range exchangeContract.Attributes {
+		if !columns[columnMap.TargetColumn] {
+			return fmt.Errorf("no column [%v] in [%v]", columnMap.TargetColumn, targetName)
+		}
+	}
+
+	if key, ok := exchangeContract.Options["key"].(string); ok && key != "" && !columns[key] {
+		return fmt.Errorf("no key column [%v] in [%v]", key, targetName)
+	}
+
+	return nil
+}
+
+func exchangeDepth(ctx context.Context) int {
+	depth, _ := ctx.Value("exchange_depth").(int)
+	return depth
+}
+
+// exchangeContext is the context the rows are written with, as the administrator, who owns the exchanges,
+// in the tenant of the change
+func (se *SelfExchange) exchangeContext() (context.Context, error) {
+
+	ctx := context.WithValue(se.ctx, "exchange_depth", exchangeDepth(se.ctx)+1)
+
+	adminId, _ := GetAdminUserIdAndUserGroupId(se.cruds["user"].db)
+	if adminId == 0 {
+		return ctx, nil
+	}
+
+	adminReferenceId, err := se.cruds["user"].GetIdToReferenceId("user", adminId)
+	if err != nil {
+		return nil, err
+	}
+	sessionUser, _, err := se.cruds["user"].GetSessionUser(adminReferenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, "user", sessionUser), nil
+}
+
+// targetRow is the row to write, the mapped columns of the source row converted to the target types
+func (se *SelfExchange) targetRow(target *DbResource, row map[string]interface{}) (map[string]interface{}, error) {
+
+	targetColumns := target.model.GetColumnMap()
+	columnMapping := se.exchangeContract.Attributes
+
+	if len(columnMapping) == 0 {
+		columnMapping = make([]ColumnMap, 0)
+		for name, column := range targetColumns {
+			if _, ok := row[name]; ok && !target.isGeneratedColumn(column) {
+				columnMapping = append(columnMapping, ColumnMap{
+					SourceColumn: name,
+					TargetColumn: name,
+				})
+			}
+		}
+	}
+
+	targetRow := make(map[string]interface{})
+
+	for _, columnMap := range columnMapping {
+
+		column, ok := targetColumns[columnMap.TargetColumn]
+		if !ok {
+			return nil, fmt.Errorf("no column [%v] in [%v]", columnMap.TargetColumn, target.model.GetName())
+		}
+
+		columnType := columnMap.TargetColumnType
+		if columnType == "" {
+			columnType = column.ColumnType
+		}
+
+		value, err := convertExchangeValue(row[columnMap.SourceColumn], column, columnType)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", columnMap.SourceColumn, err)
+		}
+		targetRow[columnMap.TargetColumn] = value
+	}
+
+	return targetRow, nil
+}
+
+// convertExchangeValue converts a value read from a row to the type of the target column, like an input of
+// an action. References to other rows and files are copied as they are.
+func convertExchangeValue(value interface{}, column api2go.ColumnInfo, columnType string) (interface{}, error) {
+
+	if value == nil || column.IsForeignKey || strings.HasPrefix(columnType, "file") {
+		return value, nil
+	}
+
+	switch typed := value.(type) {
+	case []byte:
+		value = string(typed)
+	case int:
+		value = float64(typed)
+	case int32:
+		value = float64(typed)
+	case int64:
+		value = float64(typed)
+	case float32:
+		value = float64(typed)
+	case time.Time:
+		value = typed.Format(time.RFC3339)
+	}
+
+	if value == "" {
+		return value, nil
+	}
+
+	column.ColumnType = columnType
+	return convertInputValue(value, column)
+}
+
+func (se *SelfExchange) ExecuteTarget(row map[string]interface{}, inFieldMap map[string]interface{}) error {
+
+	targetName := se.exchangeContract.TargetAttributes["name"].(string)
+	target := se.cruds[targetName]
+
+	if exchangeDepth(se.ctx) >= MaxSelfExchangeDepth {
+		log.Errorf("Exchange [%v] is %v exchanges deep, [%v] is not written", se.exchangeContract.Name, MaxSelfExchangeDepth, targetName)
+		return nil
+	}
+
+	targetRow, err := se.targetRow(target, row)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := se.exchangeContext()
+	if err != nil {
+		return err
+	}
+
+	existingReferenceId, err := se.existingTargetRow(ctx, target, targetRow)
+	if err != nil {
+		return err
+	}
+
+	if existingReferenceId == "" {
+		err = se.writeTarget(ctx, target, "POST", targetRow)
+		if err == nil {
+			return nil
+		}
+		// the key is unique, a delivery of the same row running at the same time may have created the copy
+		// first, which is then updated
+		existingReferenceId, _ = se.existingTargetRow(ctx, target, targetRow)
+		if existingReferenceId == "" {
+			return err
+		}
+	}
+
+	targetRow["reference_id"] = existingReferenceId
+	return se.writeTarget(ctx, target, "PATCH", targetRow)
+}
+
+// existingTargetRow is the reference id of the copy with the same key as the row, empty when there is none
+// or the exchange has no key
+func (se *SelfExchange) existingTargetRow(ctx context.Context, target *DbResource, targetRow map[string]interface{}) (string, error) {
+
+	key, ok := se.exchangeContract.Options["key"].(string)
+	if !ok || key == "" || targetRow[key] == nil {
+		return "", nil
+	}
+
+	keyValue := targetRow[key]
+	// references are stored as the id of the row
+	if column, ok := target.model.GetColumnMap()[key]; ok && column.IsForeignKey {
+		var err error
+		keyValue, err = target.GetReferenceIdToId(column.ForeignKeyData.TableName, fmt.Sprintf("%v", keyValue))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	existing, _, err := target.GetRowsByWhereClauseInContext(ctx, target.model.GetName(), squirrel.Eq{key: keyValue})
+	if err != nil || len(existing) == 0 {
+		return "", err
+	}
+	referenceId, _ := existing[0]["reference_id"].(string)
+	return referenceId, nil
+}
+
+func (se *SelfExchange) writeTarget(ctx context.Context, target *DbResource, method string, targetRow map[string]interface{}) error {
+
+	targetName := target.model.GetName()
+	request := api2go.Request{
+		PlainRequest: (&http.Request{
+			Method: method,
+		}).WithContext(ctx),
+	}
+	model := api2go.NewApi2GoModelWithData(targetName, nil, auth.DEFAULT_PERMISSION.IntValue(), nil, targetRow)
+
+	var err error
+	if method == "PATCH" {
+		_, err = target.Update(model, request)
+	} else {
+		_, err = target.Create(model, request)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write [%v]: %v", targetName, err)
+	}
+
+	log.Infof("Exchange [%v] wrote [%v] with %v", se.exchangeContract.Name, targetName, method)
+	return nil
+}
@@ -0,0 +1,138 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"testing"
+)
+
+var testArchiveTable = TableInfo{
+	TableName: "order_archive",
+	Columns: []api2go.ColumnInfo{
+		{Name: "order_ref", ColumnName: "order_ref", ColumnType: "label", DataType: "varchar(80)"},
+		{Name: "total", ColumnName: "total", ColumnType: "measurement", DataType: "float", IsNullable: true},
+	},
+}
+
+var testArchiveExchange = ExchangeContract{
+	Name:             "order-archive",
+	SourceType:       "self",
+	SourceAttributes: map[string]interface{}{"name": "order"},
+	TargetType:       "self",
+	TargetAttributes: map[string]interface{}{"name": "order_archive"},
+	Attributes: []ColumnMap{
+		{SourceColumn: "reference_id", TargetColumn: "order_ref"},
+		{SourceColumn: "total", TargetColumn: "total"},
+	},
+	Options: map[string]interface{}{"key": "order_ref"},
+}
+
+// concurrentDelivery copies the row just before it is created, like a delivery of the same row running at
+// the same time
+type concurrentDelivery struct {
+	t *testing.T
+}
+
+func (c *concurrentDelivery) String() string {
+	return "concurrentDelivery"
+}
+
+func (c *concurrentDelivery) InterceptBefore(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
+	insertTestRow(c.t, dr.db, "order_archive", map[string]interface{}{"order_ref": results[0]["order_ref"], "total": 1})
+	return results, nil
+}
+
+func (c *concurrentDelivery) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
+	return results, nil
+}
+
+func newSelfExchangeTestResources(t *testing.T) map[string]*DbResource {
+
+	cruds := newTestDbResources(t, testArchiveTable)
+	insertTestRow(t, cruds["user"].db, "user", map[string]interface{}{"name": "admin", "email": "admin@example.com", "reference_id": "admin-1"})
+	insertTestRow(t, cruds["user"].db, "usergroup", map[string]interface{}{"name": "administrators", "reference_id": "usergroup-1"})
+	CreateUniqueConstraints(&CmsConfig{
+		Tables:            []TableInfo{testArchiveTable},
+		ExchangeContracts: []ExchangeContract{testArchiveExchange},
+	}, cruds["user"].db)
+
+	return cruds
+}
+
+func archivedTotals(t *testing.T, dr *DbResource) map[string]float64 {
+
+	rows, err := dr.db.Queryx("select order_ref, total from order_archive")
+	if err != nil {
+		t.Fatalf("Failed to read the copies: %v", err)
+	}
+	defer rows.Close()
+
+	totals := make(map[string]float64)
+	for rows.Next() {
+		var orderRef string
+		var total float64
+		err = rows.Scan(&orderRef, &total)
+		if err != nil {
+			t.Fatalf("Failed to read a copy: %v", err)
+		}
+		totals[orderRef] = total
+	}
+	return totals
+}
+
+func TestSelfExchangeUpdatesTheCopyWithTheSameKey(t *testing.T) {
+
+	cruds := newSelfExchangeTestResources(t)
+	defer cruds["user"].db.Close()
+
+	exchange, err := NewSelfExchangeHandler(testArchiveExchange, cruds, nil)
+	if err != nil {
+		t.Fatalf("Failed to create exchange: %v", err)
+	}
+
+	for _, row := range []map[string]interface{}{
+		{"reference_id": "order-1", "total": 10},
+		{"reference_id": "order-2", "total": 20},
+		{"reference_id": "order-1", "total": 12},
+	} {
+		err = exchange.ExecuteTarget(row, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("Failed to copy %v: %v", row, err)
+		}
+	}
+
+	totals := archivedTotals(t, cruds["order_archive"])
+	if len(totals) != 2 || totals["order-1"] != 12 || totals["order-2"] != 20 {
+		t.Errorf("Expected one copy of every order with its last total, got %v", totals)
+	}
+}
+
+func TestSelfExchangeKeyIsUnique(t *testing.T) {
+
+	cruds := newSelfExchangeTestResources(t)
+	defer cruds["user"].db.Close()
+
+	insertTestRow(t, cruds["order_archive"].db, "order_archive", map[string]interface{}{"order_ref": "order-1", "total": 10})
+	_, err := cruds["order_archive"].db.Exec("insert into order_archive (order_ref, total, reference_id, permission) values ('order-1', 11, 'copy-2', 0)")
+	if err == nil {
+		t.Fatalf("Expected a second copy with the same key to be refused")
+	}
+
+	// the copy is made by another delivery after this one found none, the create fails on the key and the
+	// copy is updated instead
+	cruds["order_archive"].ms = &MiddlewareSet{
+		BeforeCreate: []DatabaseRequestInterceptor{&concurrentDelivery{t: t}},
+	}
+	exchange, err := NewSelfExchangeHandler(testArchiveExchange, cruds, nil)
+	if err != nil {
+		t.Fatalf("Failed to create exchange: %v", err)
+	}
+	err = exchange.ExecuteTarget(map[string]interface{}{"reference_id": "order-2", "total": 20}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed to copy the order: %v", err)
+	}
+
+	totals := archivedTotals(t, cruds["order_archive"])
+	if len(totals) != 2 || totals["order-2"] != 20 {
+		t.Errorf("Expected the copy made by the other delivery to be updated, got %v", totals)
+	}
+}
@@ -0,0 +1,384 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"gopkg.in/gin-gonic/gin.v1"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// set on responses which are a replay of the response to an earlier request with the same key
+const IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+// how long a key is remembered, unless the idempotency.window backend config is set, like "1h"
+const IdempotencyDefaultWindow = 24 * time.Hour
+
+const idempotencyMaxKeyLength = 200
+
+// how often keys past their window are removed
+const idempotencyCleanupInterval = 10 * time.Minute
+
+const (
+	idempotencyStatePending = "pending"
+	idempotencyStateDone    = "done"
+	// done, but the response carried secrets and was not kept
+	idempotencyStateWithheld = "withheld"
+)
+
+var idempotencyTableName = "_idempotency_key"
+
+var IdempotencyTableStructure = TableInfo{
+	TableName: idempotencyTableName,
+	Columns: []api2go.ColumnInfo{
+		{
+			Name:            "id",
+			ColumnName:      "id",
+			ColumnType:      "id",
+			DataType:        "INTEGER",
+			IsPrimaryKey:    true,
+			IsAutoIncrement: true,
+		},
+		{
+			Name:       "key_hash",
+			ColumnName: "key_hash",
+			ColumnType: "label",
+			DataType:   "varchar(64)",
+			IsNullable: false,
+		},
+		{
+			Name:       "request_hash",
+			ColumnName: "request_hash",
+			ColumnType: "label",
+			DataType:   "varchar(64)",
+			IsNullable: false,
+		},
+		{
+			Name:       "state",
+			ColumnName: "state",
+			ColumnType: "label",
+			DataType:   "varchar(20)",
+			IsNullable: false,
+		},
+		{
+			Name:       "response_code",
+			ColumnName: "response_code",
+			ColumnType: "measurement",
+			DataType:   "int(4)",
+			IsNullable: true,
+		},
+		{
+			Name:       "content_type",
+			ColumnName: "content_type",
+			ColumnType: "label",
+			DataType:   "varchar(100)",
+			IsNullable: true,
+		},
+		{
+			Name:       "response_body",
+			ColumnName: "response_body",
+			ColumnType: "content",
+			DataType:   "text",
+			IsNullable: true,
+		},
+		{
+			Name:       "expires_at",
+			ColumnName: "expires_at",
+			ColumnType: "datetime",
+			DataType:   "timestamp",
+			IsNullable: false,
+		},
+	},
+}
+
+// IdempotencyStore remembers the responses to action and create requests sent with an Idempotency-Key
+// header. A request repeated with the same key gets the first response back instead of running again, so
+// retried requests do not sign up or create twice. Keys belong to the user sending them, or to the client
+// ip for guests.
+type IdempotencyStore struct {
+	db     *sqlx.DB
+	window time.Duration
+}
+
+func NewIdempotencyStore(db *sqlx.DB, configStore *ConfigStore) *IdempotencyStore {
+
+	var count int
+	err := db.QueryRowx("select count(*) from " + idempotencyTableName).Scan(&count)
+	if err != nil {
+		log.Infof("Creating table %v: %v", idempotencyTableName, err)
+		_, err = db.Exec(MakeCreateTableQuery(&IdempotencyTableStructure, db.DriverName()))
+		CheckErr(err, "Failed to create idempotency key table")
+		_, err = db.Exec("create unique index " + idempotencyTableName + "_key_hash on " + idempotencyTableName + "(key_hash)")
+		CheckErr(err, "Failed to create idempotency key index")
+	}
+
+	window := IdempotencyDefaultWindow
+	configuredWindow, err := configStore.GetConfigValueFor("idempotency.window", "backend")
+	if err == nil && configuredWindow != "" {
+		parsed, err := time.ParseDuration(configuredWindow)
+		if err != nil || parsed <= 0 {
+			log.Errorf("Invalid idempotency.window [%v], using %v", configuredWindow, IdempotencyDefaultWindow)
+		} else {
+			window = parsed
+		}
+	}
+
+	return &IdempotencyStore{
+		db:     db,
+		window: window,
+	}
+}
+
+// Start removes the keys past their window in the background
+func (is *IdempotencyStore) Start() {
+	go func() {
+		for range time.Tick(idempotencyCleanupInterval) {
+			s, v, err := squirrel.Delete(idempotencyTableName).Where(squirrel.Expr("expires_at < ?", time.Now())).ToSql()
+			if err == nil {
+				_, err = is.db.Exec(s, v...)
+			}
+			if err != nil {
+				log.Errorf("Failed to remove expired idempotency keys: %v", err)
+			}
+		}
+	}()
+}
+
+// isIdempotentRoute is true for the requests a key applies to, actions and creates
+func isIdempotentRoute(method string, path string) bool {
+
+	if method != "POST" {
+		return false
+	}
+	if strings.HasPrefix(path, "/action/") {
+		return true
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	return len(parts) == 2 && parts[0] == "api"
+}
+
+func idempotencyHash(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+type storedIdempotentResponse struct {
+	RequestHash  string         `db:"request_hash"`
+	State        string         `db:"state"`
+	ResponseCode sql.NullInt64  `db:"response_code"`
+	ContentType  sql.NullString `db:"content_type"`
+	ResponseBody sql.NullString `db:"response_body"`
+	ExpiresAt    time.Time      `db:"expires_at"`
+}
+
+// claim stores the key as pending, it returns the stored response when the key was already used
+func (is *IdempotencyStore) claim(keyHash string, requestHash string) (*storedIdempotentResponse, error) {
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		s, v, err := squirrel.Insert(idempotencyTableName).
+			Columns("key_hash", "request_hash", "state", "expires_at").
+			Values(keyHash, requestHash, idempotencyStatePending, time.Now().Add(is.window)).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		_, err = is.db.Exec(s, v...)
+		if err == nil {
+			return nil, nil
+		}
+
+		var stored storedIdempotentResponse
+		s, v, err = squirrel.Select("request_hash", "state", "response_code", "content_type", "response_body", "expires_at").
+			From(idempotencyTableName).Where(squirrel.Eq{"key_hash": keyHash}).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		err = is.db.QueryRowx(s, v...).StructScan(&stored)
+		if err == sql.ErrNoRows {
+			// removed in between, claim it again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if stored.ExpiresAt.After(time.Now()) {
+			return &stored, nil
+		}
+
+		is.forget(keyHash)
+	}
+
+	return nil, errors.New("Failed to store idempotency key")
+}
+
+func (is *IdempotencyStore) forget(keyHash string) {
+	s, v, err := squirrel.Delete(idempotencyTableName).Where(squirrel.Eq{"key_hash": keyHash}).ToSql()
+	if err == nil {
+		_, err = is.db.Exec(s, v...)
+	}
+	if err != nil {
+		log.Errorf("Failed to remove idempotency key: %v", err)
+	}
+}
+
+func (is *IdempotencyStore) complete(keyHash string, code int, contentType string, body []byte) {
+
+	query := squirrel.Update(idempotencyTableName).Where(squirrel.Eq{"key_hash": keyHash})
+	if isKeptIdempotentResponse(body) {
+		query = query.Set("state", idempotencyStateDone).
+			Set("response_code", code).
+			Set("content_type", contentType).
+			Set("response_body", string(body))
+	} else {
+		query = query.Set("state", idempotencyStateWithheld)
+	}
+
+	s, v, err := query.ToSql()
+	if err == nil {
+		_, err = is.db.Exec(s, v...)
+	}
+	if err != nil {
+		log.Errorf("Failed to store response for idempotency key: %v", err)
+	}
+}
+
+// the action responses stored with a key, those kept in the execution log and redirects, which only carry
+// where the client goes next
+var idempotencyKeptResponses = map[string]bool{
+	"client.redirect": true,
+}
+
+// isKeptIdempotentResponse is false for action responses which carry a token, an otp secret or anything
+// else not kept, those are not stored in clear with the key
+func isKeptIdempotentResponse(body []byte) bool {
+
+	var responses []ActionResponse
+	if json.Unmarshal(body, &responses) != nil {
+		return true
+	}
+	for _, response := range responses {
+		if !actionExecutionLoggedResponses[response.ResponseType] && !idempotencyKeptResponses[response.ResponseType] {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotentResponseWriter keeps a copy of the response so it can be stored with the key
+type idempotentResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *idempotentResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotentResponseWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// IdempotencyMiddleware answers a repeated action or create request with the stored response. A key used
+// again with a different request is refused with 422, and 409 is returned while the first request is still
+// running, or when the first response was withheld because it carried secrets. Server errors are not
+// stored, the request can be retried with the same key.
+func (is *IdempotencyStore) IdempotencyMiddleware(c *gin.Context) {
+
+	key := strings.TrimSpace(c.Request.Header.Get(IdempotencyKeyHeader))
+	if key == "" || !isIdempotentRoute(c.Request.Method, c.Request.URL.Path) {
+		c.Next()
+		return
+	}
+	if len(key) > idempotencyMaxKeyLength {
+		c.JSON(400, NewDaptinError("Idempotency-Key is too long", "invalid-idempotency-key"))
+		c.Abort()
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(400)
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	owner := "guest:" + c.ClientIP()
+	if user, ok := c.Request.Context().Value("user").(auth.SessionUser); ok && user.UserReferenceId != "" {
+		owner = "user:" + user.UserReferenceId
+	}
+
+	keyHash := idempotencyHash(owner, key)
+	requestHash := idempotencyHash(c.Request.Method, c.Request.URL.RequestURI(), string(body))
+
+	stored, err := is.claim(keyHash, requestHash)
+	if err != nil {
+		log.Errorf("Failed to check idempotency key: %v", err)
+		c.AbortWithStatus(500)
+		return
+	}
+
+	if stored != nil {
+		if stored.RequestHash != requestHash {
+			c.JSON(422, NewDaptinError("Idempotency-Key was used for a different request", "idempotency-key-reused"))
+			c.Abort()
+			return
+		}
+		if stored.State == idempotencyStateWithheld {
+			c.JSON(409, NewDaptinError("A request with this Idempotency-Key was already completed, its response is not kept", "idempotency-key-used"))
+			c.Abort()
+			return
+		}
+		if stored.State != idempotencyStateDone {
+			c.JSON(409, NewDaptinError("A request with this Idempotency-Key is still running", "idempotency-key-in-use"))
+			c.Abort()
+			return
+		}
+		log.Infof("Replaying response for idempotency key on [%v]", c.Request.URL.Path)
+		c.Header(IdempotencyReplayedHeader, "true")
+		c.Data(int(stored.ResponseCode.Int64), stored.ContentType.String, []byte(stored.ResponseBody.String))
+		c.Abort()
+		return
+	}
+
+	writer := &idempotentResponseWriter{
+		ResponseWriter: c.Writer,
+		body:           &bytes.Buffer{},
+	}
+	c.Writer = writer
+
+	// a server error, or a panic in the handlers, leaves the key free
+	completed := false
+	defer func() {
+		if !completed {
+			is.forget(keyHash)
+		}
+	}()
+
+	c.Next()
+
+	if writer.Status() >= 500 {
+		return
+	}
+	is.complete(keyHash, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes())
+	completed = true
+}
@@ -0,0 +1,107 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"gopkg.in/gin-gonic/gin.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyWithholdsSecretResponses(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("create table " + idempotencyTableName + " (id integer primary key, key_hash varchar(64) unique, request_hash varchar(64)," +
+		" state varchar(20), response_code int, content_type varchar(100), response_body text, expires_at timestamp)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	store := &IdempotencyStore{db: db, window: time.Hour}
+
+	notification, _ := json.Marshal([]ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("success", "Signed up", "Success")),
+	})
+	token, _ := json.Marshal([]ActionResponse{
+		NewActionResponse("client.store.set", map[string]interface{}{"key": "token", "value": "a jwt token"}),
+	})
+
+	for keyHash, body := range map[string][]byte{"notify": notification, "token": token} {
+		stored, err := store.claim(keyHash, "request")
+		if err != nil || stored != nil {
+			t.Fatalf("[%v]: expected a new key, got %v %v", keyHash, stored, err)
+		}
+		store.complete(keyHash, 200, "application/json", body)
+	}
+
+	stored, err := store.claim("notify", "request")
+	if err != nil || stored == nil || stored.State != idempotencyStateDone || stored.ResponseBody.String != string(notification) {
+		t.Errorf("Expected the notification to be kept, got %v %v", stored, err)
+	}
+
+	stored, err = store.claim("token", "request")
+	if err != nil || stored == nil || stored.State != idempotencyStateWithheld || stored.ResponseBody.Valid {
+		t.Errorf("Expected the token response to be withheld, got %v %v", stored, err)
+	}
+}
+
+func TestRetriedSignupGetsTheFirstResponse(t *testing.T) {
+
+	cruds := newTestDbResources(t)
+	defer cruds["user"].db.Close()
+	store := NewIdempotencyStore(cruds["user"].db, cruds["user"].configStore)
+
+	// the responses of the signup action
+	var signupOutcomes []ActionResponse
+	for _, action := range SystemActions {
+		if action.Name != "signup" {
+			continue
+		}
+		for _, outcome := range action.OutFields {
+			if outcome.Method == "ACTIONRESPONSE" {
+				signupOutcomes = append(signupOutcomes, NewActionResponse(outcome.Type, outcome.Attributes))
+			}
+		}
+	}
+	if len(signupOutcomes) == 0 {
+		t.Fatalf("Expected the signup action to respond")
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(store.IdempotencyMiddleware)
+	signups := 0
+	router.POST("/action/user/signup", func(c *gin.Context) {
+		signups++
+		c.JSON(200, signupOutcomes)
+	})
+
+	signup := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/action/user/signup", strings.NewReader(`{"attributes": {"email": "ada@example.com"}}`))
+		request.Header.Set(IdempotencyKeyHeader, "signup-1")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := signup()
+	retried := signup()
+
+	if signups != 1 {
+		t.Errorf("Expected the retried signup not to run again, ran %v times", signups)
+	}
+	if retried.Code != http.StatusOK || retried.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Fatalf("Expected the first response to be replayed, got %v %v", retried.Code, retried.Body.String())
+	}
+	if retried.Body.String() != first.Body.String() {
+		t.Errorf("Expected the same response as the first signup, got %v", retried.Body.String())
+	}
+}
@@ -0,0 +1,105 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+// impersonation tokens are kept short since they carry the administrator's authority
+const ImpersonationTokenValidity = 15 * time.Minute
+
+// CreateImpersonationToken signs a session token for the target user which also names the administrator
+// in the impersonator claim
+func CreateImpersonationToken(admin map[string]interface{}, target map[string]interface{}, secret []byte) (string, error) {
+
+	claims := jwt.MapClaims{
+		"email":        target["email"],
+		"name":         target["name"],
+		"impersonator": admin["email"],
+		"nbf":          time.Now().Unix(),
+		"exp":          time.Now().Add(ImpersonationTokenValidity).Unix(),
+		"iss":          "daptin",
+		"picture":      fmt.Sprintf("https://www.gravatar.com/avatar/%s&d=monsterid", GetMD5Hash(strings.ToLower(target["email"].(string)))),
+		"iat":          time.Now(),
+		"jti":          uuid.NewV4().String(),
+	}
+	if tenant, ok := target[TenantColumnName].(string); ok && tenant != "" {
+		claims["tenant"] = tenant
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString(secret)
+}
+
+func impersonatorOf(req *api2go.Request) *auth.SessionUser {
+	if req == nil || req.PlainRequest == nil {
+		return nil
+	}
+	user := req.PlainRequest.Context().Value("user")
+	if user == nil {
+		return nil
+	}
+	return user.(auth.SessionUser).Impersonator
+}
+
+// ImpersonationAuditMiddleware records every write made with an impersonation token in the timeline,
+// along with the administrator who made it
+type ImpersonationAuditMiddleware struct {
+}
+
+func (iam *ImpersonationAuditMiddleware) String() string {
+	return "ImpersonationAuditMiddleware"
+}
+
+// deletes are recorded before they happen, the row is gone by the time the after middlewares run and they
+// get no results
+func (iam *ImpersonationAuditMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
+	if strings.ToLower(req.PlainRequest.Method) == "delete" {
+		iam.record(dr, req, objects)
+	}
+	return objects, nil
+}
+
+func (iam *ImpersonationAuditMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
+	if strings.ToLower(req.PlainRequest.Method) != "delete" {
+		iam.record(dr, req, results)
+	}
+	return results, nil
+}
+
+func (iam *ImpersonationAuditMiddleware) record(dr *DbResource, req *api2go.Request, rows []map[string]interface{}) {
+
+	impersonator := impersonatorOf(req)
+	if impersonator == nil {
+		return
+	}
+
+	tableName := dr.model.GetName()
+	if EndsWithCheck(tableName, "_audit") || tableName == "timeline" {
+		return
+	}
+
+	user := req.PlainRequest.Context().Value("user").(auth.SessionUser)
+	method := strings.ToLower(req.PlainRequest.Method)
+
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		dr.AddTimelineEvent(tableName, "impersonation."+method, fmt.Sprintf("%v on %v while impersonating", method, tableName), map[string]interface{}{
+			"impersonator_reference_id": impersonator.UserReferenceId,
+			"user_reference_id":         user.UserReferenceId,
+			"reference_id":              row["reference_id"],
+			"method":                    method,
+		})
+	}
+}
+
+func NewImpersonationAuditMiddleware() DatabaseRequestInterceptor {
+	return &ImpersonationAuditMiddleware{}
+}
@@ -0,0 +1,62 @@
+package resource
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImpersonatedUpdateIsAttributedToTheAdministrator(t *testing.T) {
+
+	cruds := newTestDbResources(t, TableInfo{
+		TableName: "note",
+		Columns: []api2go.ColumnInfo{
+			{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(80)"},
+		},
+	})
+	dr := cruds["note"]
+	defer dr.db.Close()
+	dr.ms = &MiddlewareSet{
+		AfterUpdate: []DatabaseRequestInterceptor{NewImpersonationAuditMiddleware()},
+	}
+
+	insertTestRow(t, dr.db, "world", map[string]interface{}{"table_name": "note", "world_schema_json": "{}"})
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "admin", "email": "admin@example.com", "reference_id": "admin-1"})
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "ada", "email": "ada@example.com", "reference_id": "user-2"})
+	insertTestRow(t, dr.db, "note", map[string]interface{}{"title": "first", "reference_id": "note-1", "user_id": 2})
+
+	admin := auth.SessionUser{UserId: 1, UserReferenceId: "admin-1"}
+	user := auth.SessionUser{UserId: 2, UserReferenceId: "user-2", Impersonator: &admin}
+	plainRequest := httptest.NewRequest("PATCH", "/api/note/note-1", nil)
+	plainRequest = plainRequest.WithContext(context.WithValue(plainRequest.Context(), "user", user))
+
+	note := api2go.NewApi2GoModelWithData("note", nil, 0, nil, map[string]interface{}{
+		"reference_id": "note-1",
+		"title":        "changed",
+	})
+	_, err := dr.Update(note, api2go.Request{PlainRequest: plainRequest})
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	var auditUserId int64
+	err = dr.db.QueryRowx("select user_id from note_audit").Scan(&auditUserId)
+	if err != nil {
+		t.Fatalf("Expected an audit row for the update: %v", err)
+	}
+	if auditUserId != 1 {
+		t.Errorf("Expected the audit row to be owned by the administrator, got %v", auditUserId)
+	}
+
+	var payload string
+	err = dr.db.QueryRowx("select payload from timeline where event_type = 'impersonation.patch'").Scan(&payload)
+	if err != nil {
+		t.Fatalf("Expected the update in the timeline: %v", err)
+	}
+	if !strings.Contains(payload, `"impersonator_reference_id":"admin-1"`) || !strings.Contains(payload, `"user_reference_id":"user-2"`) {
+		t.Errorf("Expected the administrator and the user in the timeline, got %v", payload)
+	}
+}
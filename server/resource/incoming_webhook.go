@@ -0,0 +1,332 @@
+package resource
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"gopkg.in/gin-gonic/gin.v1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bodies larger than this are refused
+const IncomingWebhookMaxBody = 1 << 20
+
+// the signature prefix used when the webhook does not set one
+const IncomingWebhookDefaultSignaturePrefix = "sha256="
+
+// a signed timestamp older or newer than this is refused, unless the webhook sets its own tolerance
+const IncomingWebhookTimestampTolerance = 5 * time.Minute
+
+// IncomingWebhook lets an external system run an action by posting to /webhook/<name>. The body is
+// verified with the secret, mapped to the input fields of the action and the action is run as the service
+// user of the webhook. With a TimestampHeader, the request carries the unix time it was signed at in that
+// header and the signature is over <timestamp>.<body>.
+type IncomingWebhook struct {
+	Id                 int64
+	ReferenceId        string
+	Name               string
+	Secret             string
+	SignatureHeader    string
+	SignaturePrefix    string
+	TimestampHeader    string
+	TimestampTolerance time.Duration
+	OnType             string
+	ActionName         string
+	Attributes         map[string]interface{}
+	ServiceUserEmail   string
+	OwnerId            int64
+}
+
+func (dr *DbResource) GetIncomingWebhook(name string) (*IncomingWebhook, error) {
+
+	s, v, err := squirrel.Select("id", "reference_id", "name", "secret", "signature_header", "signature_prefix",
+		"timestamp_header", "timestamp_tolerance", "on_type", "action_name", "attributes", "service_user_email", "user_id").
+		From("incoming_webhook").
+		Where(squirrel.Eq{"name": name}).
+		Where(squirrel.Eq{"enabled": true}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var webhook IncomingWebhook
+	var signatureHeader, signaturePrefix, timestampHeader, attributes, serviceUserEmail sql.NullString
+	var ownerId, timestampTolerance sql.NullInt64
+	err = dr.db.QueryRowx(s, v...).Scan(&webhook.Id, &webhook.ReferenceId, &webhook.Name, &webhook.Secret,
+		&signatureHeader, &signaturePrefix, &timestampHeader, &timestampTolerance, &webhook.OnType, &webhook.ActionName,
+		&attributes, &serviceUserEmail, &ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret is stored encrypted
+	encryptionSecret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret, err = Decrypt([]byte(encryptionSecret), webhook.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.SignatureHeader = signatureHeader.String
+	if webhook.SignatureHeader == "" {
+		webhook.SignatureHeader = WebhookSignatureHeader
+	}
+	webhook.SignaturePrefix = IncomingWebhookDefaultSignaturePrefix
+	if signaturePrefix.Valid {
+		webhook.SignaturePrefix = signaturePrefix.String
+	}
+	webhook.TimestampHeader = timestampHeader.String
+	webhook.TimestampTolerance = IncomingWebhookTimestampTolerance
+	if timestampTolerance.Valid && timestampTolerance.Int64 > 0 {
+		webhook.TimestampTolerance = time.Duration(timestampTolerance.Int64) * time.Second
+	}
+	webhook.ServiceUserEmail = serviceUserEmail.String
+	webhook.OwnerId = ownerId.Int64
+
+	webhook.Attributes = make(map[string]interface{})
+	if attributes.String != "" {
+		err = json.Unmarshal([]byte(attributes.String), &webhook.Attributes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// VerifySignature checks the hex encoded HMAC-SHA256 of the body in the signature header, after the
+// prefix, like sha256= for GitHub
+func (iw *IncomingWebhook) VerifySignature(body []byte, signature string) bool {
+
+	if iw.Secret == "" || !strings.HasPrefix(signature, iw.SignaturePrefix) {
+		return false
+	}
+
+	received, err := hex.DecodeString(strings.TrimPrefix(signature, iw.SignaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(iw.Secret))
+	mac.Write(body)
+	return hmac.Equal(received, mac.Sum(nil))
+}
+
+// VerifyRequest checks the signature of the request, and when the webhook has a timestamp header that the
+// signed timestamp is within the tolerance of now, so a captured request cannot be replayed later
+func (iw *IncomingWebhook) VerifyRequest(body []byte, header http.Header, now time.Time) bool {
+
+	if iw.TimestampHeader == "" {
+		return iw.VerifySignature(body, header.Get(iw.SignatureHeader))
+	}
+
+	timestamp := strings.TrimSpace(header.Get(iw.TimestampHeader))
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > iw.TimestampTolerance || age < -iw.TimestampTolerance {
+		return false
+	}
+
+	signed := append([]byte(timestamp+"."), body...)
+	return iw.VerifySignature(signed, header.Get(iw.SignatureHeader))
+}
+
+// serviceUser is the user the action runs as. The owner of the webhook can pick only themselves, the
+// administrator can pick any user.
+func (iw *IncomingWebhook) serviceUser(cruds map[string]*DbResource) (string, error) {
+
+	if iw.OwnerId == 0 {
+		return "", errors.New("Webhook has no owner")
+	}
+
+	ownerReferenceId, err := cruds["user"].GetIdToReferenceId("user", iw.OwnerId)
+	if err != nil {
+		return "", err
+	}
+
+	if iw.ServiceUserEmail == "" {
+		return ownerReferenceId, nil
+	}
+
+	users, _, err := cruds["user"].GetRowsByWhereClause("user", squirrel.Eq{"email": iw.ServiceUserEmail})
+	if err != nil || len(users) == 0 {
+		return "", errors.New("Service user not found")
+	}
+	serviceUserReferenceId := users[0]["reference_id"].(string)
+
+	if serviceUserReferenceId != ownerReferenceId && !cruds["user"].IsAdmin(ownerReferenceId) {
+		return "", errors.New("Only the administrator can run webhooks as another user")
+	}
+
+	return serviceUserReferenceId, nil
+}
+
+// webhookPayload is the body as a map, for json and form bodies
+func webhookPayload(body []byte, contentType string) map[string]interface{} {
+
+	payload := make(map[string]interface{})
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err == nil {
+			for key := range values {
+				payload[key] = values.Get(key)
+			}
+		}
+		return payload
+	}
+
+	json.Unmarshal(body, &payload)
+	return payload
+}
+
+// CreateIncomingWebhookHandler runs the action of the webhook for a signed request. The attributes of the
+// webhook are evaluated with payload (the body), headers and query, the same way as outcome attributes.
+func CreateIncomingWebhookHandler(cruds map[string]*DbResource, actionPerformers []ActionPerformerInterface) func(*gin.Context) {
+
+	actionHandlerMap := ActionPerformerMap(actionPerformers)
+
+	return func(c *gin.Context) {
+
+		name := c.Param("name")
+		webhook, err := cruds["incoming_webhook"].GetIncomingWebhook(name)
+		if err != nil {
+			c.AbortWithStatus(404)
+			return
+		}
+		if _, ok := cruds[webhook.OnType]; !ok {
+			log.Errorf("Incoming webhook [%v] is for unknown type [%v]", name, webhook.OnType)
+			c.AbortWithStatus(404)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, IncomingWebhookMaxBody+1))
+		if err != nil {
+			c.AbortWithStatus(400)
+			return
+		}
+		if len(body) > IncomingWebhookMaxBody {
+			c.AbortWithStatus(413)
+			return
+		}
+
+		if !webhook.VerifyRequest(body, c.Request.Header, time.Now()) {
+			log.Infof("Invalid signature for incoming webhook [%v] from [%v]", name, c.ClientIP())
+			c.AbortWithStatus(401)
+			return
+		}
+
+		headers := make(map[string]interface{})
+		for key := range c.Request.Header {
+			headers[key] = c.Request.Header.Get(key)
+		}
+		query := make(map[string]interface{})
+		for key := range c.Request.URL.Query() {
+			query[key] = c.Request.URL.Query().Get(key)
+		}
+
+		webhookContext := map[string]interface{}{
+			"payload": webhookPayload(body, c.Request.Header.Get("Content-Type")),
+			"headers": headers,
+			"query":   query,
+		}
+
+		attributes := make(map[string]interface{})
+		if len(webhook.Attributes) > 0 {
+			mapped, err := buildActionContext(webhook.Attributes, webhookContext)
+			if err != nil {
+				log.Errorf("Failed to map payload of incoming webhook [%v]: %v", name, err)
+				c.JSON(400, NewDaptinError("Failed to map payload: "+err.Error(), "mapping-failed"))
+				return
+			}
+			attributes = mapped.(map[string]interface{})
+		}
+
+		userReferenceId, err := webhook.serviceUser(cruds)
+		if err != nil {
+			log.Errorf("Incoming webhook [%v] cannot run: %v", name, err)
+			c.AbortWithStatus(403)
+			return
+		}
+		sessionUser, _, err := cruds["user"].GetSessionUser(userReferenceId)
+		if err != nil {
+			c.AbortWithStatus(403)
+			return
+		}
+
+		tenant := cruds["tenant"].GetTenantOfUser(sessionUser.UserId)
+
+		subjectReferenceId, _ := attributes[webhook.OnType+"_id"].(string)
+		var subject map[string]interface{}
+		if subjectReferenceId != "" {
+			subject, err = cruds[webhook.OnType].GetReferenceIdToObjectInContext(NewTenantContext(sessionUser, tenant), webhook.OnType, subjectReferenceId)
+			if err != nil {
+				c.AbortWithStatus(404)
+				return
+			}
+			subject["__type"] = webhook.OnType
+		}
+
+		if !CanExecuteAction(cruds, sessionUser, webhook.OnType, webhook.ActionName, subject) {
+			log.Infof("Service user of incoming webhook [%v] cannot run [%v][%v]", name, webhook.OnType, webhook.ActionName)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		action, err := cruds["action"].GetActionByName(webhook.OnType, webhook.ActionName)
+		if err != nil {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		actionRequest := ActionRequest{
+			Type:       webhook.OnType,
+			Action:     webhook.ActionName,
+			Attributes: attributes,
+			ClientIp:   c.ClientIP(),
+		}
+
+		log.Infof("Incoming webhook [%v] runs [%v][%v] as [%v]", name, webhook.OnType, webhook.ActionName, userReferenceId)
+
+		if action.IsAsync {
+			jobReferenceId, err := cruds["job"].EnqueueActionJob(actionRequest, sessionUser, subjectReferenceId, tenant)
+			if err != nil {
+				log.Errorf("Failed to queue incoming webhook [%v] as a job: %v", name, err)
+				c.AbortWithStatus(500)
+				return
+			}
+			c.JSON(202, []ActionResponse{
+				NewActionResponse("job", map[string]interface{}{
+					"reference_id": jobReferenceId,
+					"status":       JobStatusQueued,
+				}),
+			})
+			return
+		}
+
+		responses, err := ExecuteActionAs(cruds, actionHandlerMap, actionRequest, userReferenceId, subjectReferenceId, tenant)
+		if err != nil {
+			log.Errorf("Incoming webhook [%v] failed: %v", name, err)
+			c.JSON(500, NewDaptinError(err.Error(), "action-failed"))
+			return
+		}
+
+		c.JSON(200, responses)
+	}
+}
@@ -0,0 +1,159 @@
+package resource
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testIncomingWebhookSignature(secret string, content string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIncomingWebhookVerifySignature(t *testing.T) {
+
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	signature := testIncomingWebhookSignature("a long enough secret", string(body))
+
+	webhook := &IncomingWebhook{
+		Secret:          "a long enough secret",
+		SignaturePrefix: "sha256=",
+	}
+
+	cases := []struct {
+		signature string
+		valid     bool
+	}{
+		{"sha256=" + signature, true},
+		{signature, false},
+		{"sha1=" + signature, false},
+		{"sha256=" + testIncomingWebhookSignature("another secret", string(body)), false},
+		{"sha256=not hex", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if webhook.VerifySignature(body, c.signature) != c.valid {
+			t.Errorf("[%v]: expected valid %v", c.signature, c.valid)
+		}
+	}
+
+	if webhook.VerifySignature([]byte(`{"ref":"refs/heads/other"}`), "sha256="+signature) {
+		t.Errorf("A changed body should not be accepted")
+	}
+
+	// some providers send the bare hex
+	webhook.SignaturePrefix = ""
+	if !webhook.VerifySignature(body, signature) {
+		t.Errorf("A signature without prefix should be accepted for a webhook without prefix")
+	}
+
+	webhook.Secret = ""
+	if webhook.VerifySignature(body, "") {
+		t.Errorf("A webhook without a secret should accept nothing")
+	}
+}
+
+func TestIncomingWebhookVerifyRequestTimestamp(t *testing.T) {
+
+	body := []byte(`{"amount":100}`)
+	now := time.Unix(1500000000, 0)
+
+	webhook := &IncomingWebhook{
+		Secret:             "a long enough secret",
+		SignatureHeader:    "X-Signature",
+		SignaturePrefix:    "sha256=",
+		TimestampHeader:    "X-Timestamp",
+		TimestampTolerance: IncomingWebhookTimestampTolerance,
+	}
+
+	request := func(signedAt time.Time, content string) http.Header {
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		header := http.Header{}
+		header.Set("X-Timestamp", timestamp)
+		header.Set("X-Signature", "sha256="+testIncomingWebhookSignature(webhook.Secret, timestamp+"."+content))
+		return header
+	}
+
+	if !webhook.VerifyRequest(body, request(now.Add(-time.Minute), string(body)), now) {
+		t.Errorf("A recent request should be accepted")
+	}
+	if webhook.VerifyRequest(body, request(now.Add(-time.Hour), string(body)), now) {
+		t.Errorf("A replayed request should be refused")
+	}
+	if webhook.VerifyRequest(body, request(now.Add(time.Hour), string(body)), now) {
+		t.Errorf("A request from the future should be refused")
+	}
+
+	// the timestamp is part of what is signed, it cannot be moved forward
+	header := request(now.Add(-time.Hour), string(body))
+	header.Set("X-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	if webhook.VerifyRequest(body, header, now) {
+		t.Errorf("A request with a changed timestamp should be refused")
+	}
+
+	header = request(now, string(body))
+	header.Del("X-Timestamp")
+	if webhook.VerifyRequest(body, header, now) {
+		t.Errorf("A request without a timestamp should be refused")
+	}
+}
+
+func TestGetIncomingWebhookDefaults(t *testing.T) {
+
+	dr := newTestDbResources(t)["incoming_webhook"]
+	defer dr.db.Close()
+
+	encryptionSecret := "0123456789abcdef0123456789abcdef"
+	err := dr.configStore.SetConfigValueFor("encryption.secret", encryptionSecret, "backend")
+	if err != nil {
+		t.Fatalf("Failed to set encryption secret: %v", err)
+	}
+	secret, err := Encrypt([]byte(encryptionSecret), "a long enough secret")
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+
+	insertTestRow(t, dr.db, "incoming_webhook", map[string]interface{}{
+		"reference_id": "hook-1",
+		"name":         "plain",
+		"secret":       secret,
+		"on_type":      "project",
+		"action_name":  "record",
+	})
+	insertTestRow(t, dr.db, "incoming_webhook", map[string]interface{}{
+		"reference_id":        "hook-2",
+		"name":                "stripe",
+		"secret":              secret,
+		"signature_header":    "X-Signature",
+		"signature_prefix":    "",
+		"timestamp_header":    "X-Timestamp",
+		"timestamp_tolerance": 60,
+		"on_type":             "project",
+		"action_name":         "record",
+	})
+
+	webhook, err := dr.GetIncomingWebhook("plain")
+	if err != nil {
+		t.Fatalf("Failed to load webhook: %v", err)
+	}
+	if webhook.SignatureHeader != WebhookSignatureHeader || webhook.SignaturePrefix != "sha256=" || webhook.TimestampHeader != "" {
+		t.Errorf("Expected the defaults, got [%v] [%v] [%v]", webhook.SignatureHeader, webhook.SignaturePrefix, webhook.TimestampHeader)
+	}
+	if webhook.Secret != "a long enough secret" {
+		t.Errorf("Expected the secret to be decrypted, got [%v]", webhook.Secret)
+	}
+
+	webhook, err = dr.GetIncomingWebhook("stripe")
+	if err != nil {
+		t.Fatalf("Failed to load webhook: %v", err)
+	}
+	if webhook.SignaturePrefix != "" || webhook.TimestampHeader != "X-Timestamp" || webhook.TimestampTolerance != time.Minute {
+		t.Errorf("Expected the settings of the webhook, got %v", webhook)
+	}
+}
@@ -0,0 +1,508 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"gopkg.in/gin-gonic/gin.v1"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// a failed job is tried again after JobRetryBackoff, doubled on every attempt up to JobMaxRetryBackoff
+const JobDefaultMaxAttempts = 3
+const JobRetryBackoff = 30 * time.Second
+const JobMaxRetryBackoff = 30 * time.Minute
+
+// a running job is locked by its worker, the lock is refreshed every JobHeartbeatInterval and whenever the
+// job reports progress or logs. A job whose lock was not refreshed for JobLockTimeout is taken to be
+// abandoned by a worker which stopped, and queued again. Abandoned jobs are looked for every JobRequeueInterval.
+const JobLockTimeout = 10 * time.Minute
+const JobHeartbeatInterval = time.Minute
+const JobRequeueInterval = time.Minute
+
+const JobPollInterval = 2 * time.Second
+const JobWorkerCount = 4
+
+// JobReporter is given to performers run as part of a job, through ActionRequest.Job
+type JobReporter interface {
+	// Progress sets the progress of the job, in percent
+	Progress(percent int)
+	Log(message string)
+}
+
+// everything needed to run the action again in a worker, the permission checks and validations are done
+// before the job is queued
+type actionJobPayload struct {
+	Request            ActionRequest
+	ClientIp           string
+	UserReferenceId    string
+	SubjectReferenceId string
+	Tenant             *Tenant
+	TriggerDepth       int
+}
+
+// EnqueueActionJob stores the action request as a queued job owned by the user, and returns the reference
+// id of the job. The request can carry passwords and other secrets, it is stored encrypted.
+func (dr *DbResource) EnqueueActionJob(actionRequest ActionRequest, sessionUser auth.SessionUser, subjectReferenceId string, tenant *Tenant) (string, error) {
+
+	payloadJson, err := json.Marshal(actionJobPayload{
+		Request:            actionRequest,
+		ClientIp:           actionRequest.ClientIp,
+		UserReferenceId:    sessionUser.UserReferenceId,
+		SubjectReferenceId: subjectReferenceId,
+		Tenant:             tenant,
+		TriggerDepth:       actionRequest.TriggerDepth,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return "", err
+	}
+	payload, err := Encrypt([]byte(secret), string(payloadJson))
+	if err != nil {
+		return "", err
+	}
+
+	referenceId := uuid.NewV4().String()
+	now := time.Now()
+
+	cols := []string{"reference_id", "permission", "created_at", "on_type", "action_name", "status", "progress", "attempts", "max_attempts", "run_after", "request", "log_entries"}
+	vals := []interface{}{referenceId, auth.NewPermission(auth.None, auth.None, auth.Read|auth.Delete).IntValue(), now, actionRequest.Type, actionRequest.Action, JobStatusQueued, 0, 0, JobDefaultMaxAttempts, now, payload, "[]"}
+
+	if sessionUser.UserId != 0 {
+		cols = append(cols, "user_id")
+		vals = append(vals, sessionUser.UserId)
+	}
+
+	s, v, err := squirrel.Insert("job").Columns(cols...).Values(vals...).ToSql()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("Queued [%v][%v] as job [%v]", actionRequest.Type, actionRequest.Action, referenceId)
+	return referenceId, nil
+}
+
+// GetJobStatus returns the job for its owner or the administrator
+func (dr *DbResource) GetJobStatus(referenceId string, sessionUser auth.SessionUser) (map[string]interface{}, error) {
+
+	var job struct {
+		UserId      *int64     `db:"user_id"`
+		ReferenceId string     `db:"reference_id"`
+		OnType      string     `db:"on_type"`
+		ActionName  string     `db:"action_name"`
+		Status      string     `db:"status"`
+		Progress    int        `db:"progress"`
+		Attempts    int        `db:"attempts"`
+		MaxAttempts int        `db:"max_attempts"`
+		Logs        *string    `db:"log_entries"`
+		Responses   *string    `db:"responses"`
+		Error       *string    `db:"last_error"`
+		CreatedAt   time.Time  `db:"created_at"`
+		StartedAt   *time.Time `db:"started_at"`
+		CompletedAt *time.Time `db:"completed_at"`
+	}
+
+	err := dr.db.QueryRowx("select user_id, reference_id, on_type, action_name, status, progress, attempts, max_attempts,"+
+		" log_entries, responses, last_error, created_at, started_at, completed_at from job where reference_id = ?", referenceId).StructScan(&job)
+	if err != nil {
+		return nil, errors.New("No such job")
+	}
+
+	if (job.UserId == nil || *job.UserId != sessionUser.UserId) && !dr.IsAdmin(sessionUser.UserReferenceId) {
+		return nil, errors.New("No such job")
+	}
+
+	status := map[string]interface{}{
+		"reference_id": job.ReferenceId,
+		"on_type":      job.OnType,
+		"action_name":  job.ActionName,
+		"status":       job.Status,
+		"progress":     job.Progress,
+		"attempts":     job.Attempts,
+		"max_attempts": job.MaxAttempts,
+		"created_at":   job.CreatedAt,
+		"started_at":   job.StartedAt,
+		"completed_at": job.CompletedAt,
+		"last_error":   job.Error,
+	}
+
+	var logs, responses interface{}
+	if job.Logs != nil {
+		json.Unmarshal([]byte(*job.Logs), &logs)
+	}
+	if job.Responses != nil {
+		json.Unmarshal([]byte(*job.Responses), &responses)
+	}
+	status["log_entries"] = logs
+	status["responses"] = responses
+
+	return status, nil
+}
+
+func IsJobFinished(status string) bool {
+	return status == JobStatusCompleted || status == JobStatusFailed
+}
+
+// JobQueue runs the queued jobs with a pool of workers. Jobs are claimed with a conditional update, so
+// several daptin instances can work on the same queue.
+type JobQueue struct {
+	cruds            map[string]*DbResource
+	actionHandlerMap map[string]ActionPerformerInterface
+	workerId         string
+}
+
+func NewJobQueue(cruds map[string]*DbResource, actionPerformers []ActionPerformerInterface) *JobQueue {
+
+	hostname, _ := os.Hostname()
+
+	return &JobQueue{
+		cruds:            cruds,
+		actionHandlerMap: ActionPerformerMap(actionPerformers),
+		workerId:         hostname + "/" + uuid.NewV4().String(),
+	}
+}
+
+func (jq *JobQueue) db() *sqlx.DB {
+	return jq.cruds["job"].db
+}
+
+// Start starts the workers, they keep polling for due jobs
+func (jq *JobQueue) Start() {
+	log.Infof("Starting job queue as [%v]", jq.workerId)
+	OutboxWorker{
+		Name:            "job",
+		Workers:         JobWorkerCount,
+		PollInterval:    JobPollInterval,
+		RequeueInterval: JobRequeueInterval,
+		Next:            jq.runNextJob,
+		Requeue:         jq.requeueAbandonedJobs,
+	}.Start()
+}
+
+// runNextJob claims and runs the next due job, it returns false when there is none
+func (jq *JobQueue) runNextJob() bool {
+	jobId, payload, attempts, maxAttempts, ok := jq.claimNextJob()
+	if !ok {
+		return false
+	}
+
+	jq.runJob(jobId, payload, attempts, maxAttempts)
+	return true
+}
+
+func (jq *JobQueue) requeueAbandonedJobs() {
+
+	s, v, err := squirrel.Update("job").
+		Set("status", JobStatusQueued).
+		Set("locked_by", nil).
+		Set("locked_at", nil).
+		Where(squirrel.Eq{"status": JobStatusRunning}).
+		Where(squirrel.Expr("locked_at < ?", time.Now().Add(-JobLockTimeout))).ToSql()
+	CheckErr(err, "Failed to create abandoned job update query")
+
+	res, err := jq.db().Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to queue abandoned jobs again: %v", err)
+		return
+	}
+	if count, _ := res.RowsAffected(); count > 0 {
+		log.Infof("Queued %d abandoned jobs again", count)
+	}
+}
+
+// claimNextJob marks the next due job as running by this worker. Another worker might claim the same job
+// between the select and the update, the update only succeeds for one of them.
+func (jq *JobQueue) claimNextJob() (int64, string, int, int, bool) {
+
+	db := jq.db()
+
+	for {
+		var jobId int64
+		var payload string
+		var attempts, maxAttempts int
+
+		s, v, err := squirrel.Select("id", "request", "attempts", "max_attempts").From("job").
+			Where(squirrel.Eq{"status": JobStatusQueued}).
+			Where(squirrel.Expr("run_after <= ?", time.Now())).
+			OrderBy("run_after").Limit(1).ToSql()
+		CheckErr(err, "Failed to create job select query")
+
+		err = db.QueryRowx(s, v...).Scan(&jobId, &payload, &attempts, &maxAttempts)
+		if err != nil {
+			return 0, "", 0, 0, false
+		}
+
+		now := time.Now()
+		s, v, err = squirrel.Update("job").
+			Set("status", JobStatusRunning).
+			Set("locked_by", jq.workerId).
+			Set("locked_at", now).
+			Set("started_at", now).
+			Set("attempts", attempts+1).
+			Where(squirrel.Eq{"id": jobId}).
+			Where(squirrel.Eq{"status": JobStatusQueued}).ToSql()
+		CheckErr(err, "Failed to create job claim query")
+
+		res, err := db.Exec(s, v...)
+		if err != nil {
+			log.Errorf("Failed to claim job [%v]: %v", jobId, err)
+			return 0, "", 0, 0, false
+		}
+
+		if count, _ := res.RowsAffected(); count == 1 {
+			return jobId, payload, attempts + 1, maxAttempts, true
+		}
+	}
+}
+
+func (jq *JobQueue) runJob(jobId int64, payloadJson string, attempt int, maxAttempts int) {
+
+	reporter := newJobRun(jq.db(), jobId, jq.workerId)
+	reporter.Log(fmt.Sprintf("Attempt %d of %d started", attempt, maxAttempts))
+
+	done := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(JobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				reporter.refreshLock()
+			}
+		}
+	}()
+
+	responses, err := jq.executeJob(payloadJson, reporter)
+	close(done)
+
+	now := time.Now()
+	update := squirrel.Update("job").
+		Set("locked_by", nil).
+		Set("locked_at", nil)
+
+	if err == nil {
+		reporter.Log("Completed")
+		result, _ := json.Marshal(responses)
+		update = update.
+			Set("status", JobStatusCompleted).
+			Set("progress", 100).
+			Set("responses", string(result)).
+			Set("last_error", nil).
+			Set("completed_at", now)
+	} else if attempt < maxAttempts {
+		backoff := JobRetryBackoff << uint(attempt-1)
+		if backoff > JobMaxRetryBackoff || backoff <= 0 {
+			backoff = JobMaxRetryBackoff
+		}
+		reporter.Log(fmt.Sprintf("Failed: %v, trying again in %v", err, backoff))
+		update = update.
+			Set("status", JobStatusQueued).
+			Set("last_error", err.Error()).
+			Set("run_after", now.Add(backoff))
+	} else {
+		reporter.Log(fmt.Sprintf("Failed: %v", err))
+		result, _ := json.Marshal(responses)
+		update = update.
+			Set("status", JobStatusFailed).
+			Set("responses", string(result)).
+			Set("last_error", err.Error()).
+			Set("completed_at", now)
+	}
+
+	// a job which lost its lock was queued again and belongs to another worker now
+	s, v, queryErr := update.Where(squirrel.Eq{"id": jobId}).Where(squirrel.Eq{"locked_by": jq.workerId}).ToSql()
+	CheckErr(queryErr, "Failed to create job update query")
+
+	res, queryErr := jq.db().Exec(s, v...)
+	if queryErr != nil {
+		log.Errorf("Failed to store outcome of job [%v]: %v", jobId, queryErr)
+		return
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		log.Warnf("Job [%v] was taken over by another worker, outcome of this attempt is dropped", jobId)
+	}
+}
+
+// executeJob runs the action as the user who queued it, a panic in a performer fails the attempt
+func (jq *JobQueue) executeJob(payloadJson string, reporter *jobRun) (responses []ActionResponse, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Job panicked: %v", r)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	secret, err := jq.cruds["job"].configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return nil, err
+	}
+	payloadJson, err = Decrypt([]byte(secret), payloadJson)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload actionJobPayload
+	err = json.Unmarshal([]byte(payloadJson), &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	actionRequest := payload.Request
+	actionRequest.ClientIp = payload.ClientIp
+	actionRequest.Job = reporter
+	actionRequest.TriggerDepth = payload.TriggerDepth
+
+	return ExecuteActionAs(jq.cruds, jq.actionHandlerMap, actionRequest, payload.UserReferenceId, payload.SubjectReferenceId, payload.Tenant)
+}
+
+// jobRun keeps the progress and logs of a running job on its row, and the lock of the worker running it
+type jobRun struct {
+	db       *sqlx.DB
+	jobId    int64
+	workerId string
+	lock     sync.Mutex
+	logs     []map[string]interface{}
+}
+
+func newJobRun(db *sqlx.DB, jobId int64, workerId string) *jobRun {
+
+	run := &jobRun{
+		db:       db,
+		jobId:    jobId,
+		workerId: workerId,
+		logs:     make([]map[string]interface{}, 0),
+	}
+
+	// logs of earlier attempts are kept
+	var logs string
+	err := db.QueryRowx("select log_entries from job where id = ?", jobId).Scan(&logs)
+	if err == nil {
+		json.Unmarshal([]byte(logs), &run.logs)
+	}
+
+	return run
+}
+
+func (jr *jobRun) Progress(percent int) {
+
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	_, err := jr.db.Exec("update job set progress = ?, locked_at = ? where id = ? and locked_by = ?", percent, time.Now(), jr.jobId, jr.workerId)
+	if err != nil {
+		log.Errorf("Failed to update progress of job [%v]: %v", jr.jobId, err)
+	}
+}
+
+// refreshLock keeps the job from being taken as abandoned while it runs
+func (jr *jobRun) refreshLock() {
+	_, err := jr.db.Exec("update job set locked_at = ? where id = ? and locked_by = ?", time.Now(), jr.jobId, jr.workerId)
+	if err != nil {
+		log.Errorf("Failed to refresh lock of job [%v]: %v", jr.jobId, err)
+	}
+}
+
+func (jr *jobRun) Log(message string) {
+
+	jr.lock.Lock()
+	defer jr.lock.Unlock()
+
+	jr.logs = append(jr.logs, map[string]interface{}{
+		"time":    time.Now(),
+		"message": message,
+	})
+
+	logs, _ := json.Marshal(jr.logs)
+	_, err := jr.db.Exec("update job set log_entries = ?, locked_at = ? where id = ? and locked_by = ?", string(logs), time.Now(), jr.jobId, jr.workerId)
+	if err != nil {
+		log.Errorf("Failed to update logs of job [%v]: %v", jr.jobId, err)
+	}
+}
+
+func jobRequestUser(ginContext *gin.Context) auth.SessionUser {
+	sessionUser, _ := ginContext.Request.Context().Value("user").(auth.SessionUser)
+	return sessionUser
+}
+
+// CreateJobStatusHandler returns the status, progress, logs and result of a job to the user who queued it
+func CreateJobStatusHandler(cruds map[string]*DbResource) func(*gin.Context) {
+	return func(ginContext *gin.Context) {
+
+		status, err := cruds["job"].GetJobStatus(ginContext.Param("referenceId"), jobRequestUser(ginContext))
+		if err != nil {
+			ginContext.AbortWithError(404, err)
+			return
+		}
+
+		ginContext.JSON(200, status)
+	}
+}
+
+// CreateJobStreamHandler sends the status of a job as server sent events whenever it changes, until the
+// job is completed or failed
+func CreateJobStreamHandler(cruds map[string]*DbResource) func(*gin.Context) {
+	return func(ginContext *gin.Context) {
+
+		referenceId := ginContext.Param("referenceId")
+		sessionUser := jobRequestUser(ginContext)
+
+		status, err := cruds["job"].GetJobStatus(referenceId, sessionUser)
+		if err != nil {
+			ginContext.AbortWithError(404, err)
+			return
+		}
+
+		lastEvent := ""
+		ginContext.Stream(func(w io.Writer) bool {
+
+			event, _ := json.Marshal(status)
+			if string(event) != lastEvent {
+				ginContext.SSEvent("job", status)
+				lastEvent = string(event)
+			}
+
+			if IsJobFinished(status["status"].(string)) {
+				return false
+			}
+
+			time.Sleep(time.Second)
+
+			status, err = cruds["job"].GetJobStatus(referenceId, sessionUser)
+			if err != nil {
+				log.Errorf("Failed to get status of job [%v]: %v", referenceId, err)
+				return false
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/daptin/daptin/server/auth"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newJobTestResource(t *testing.T) *DbResource {
+
+	dr := newTestDbResources(t)["job"]
+
+	err := dr.configStore.SetConfigValueFor("encryption.secret", "0123456789abcdef0123456789abcdef", "backend")
+	if err != nil {
+		t.Fatalf("Failed to set encryption secret: %v", err)
+	}
+
+	return dr
+}
+
+func TestEnqueueActionJobEncryptsRequest(t *testing.T) {
+
+	dr := newJobTestResource(t)
+	defer dr.db.Close()
+
+	actionRequest := ActionRequest{
+		Type:   "user",
+		Action: "import_data",
+		Attributes: map[string]interface{}{
+			"password": "secret password",
+		},
+	}
+
+	_, err := dr.EnqueueActionJob(actionRequest, auth.SessionUser{UserId: 1, UserReferenceId: "user-1"}, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to queue job: %v", err)
+	}
+
+	var stored string
+	err = dr.db.QueryRowx("select request from job").Scan(&stored)
+	if err != nil {
+		t.Fatalf("Failed to read job: %v", err)
+	}
+	if strings.Contains(stored, "secret password") {
+		t.Errorf("The request should not be stored in clear")
+	}
+
+	decrypted, err := Decrypt([]byte("0123456789abcdef0123456789abcdef"), stored)
+	if err != nil {
+		t.Fatalf("Failed to decrypt request: %v", err)
+	}
+	var payload actionJobPayload
+	err = json.Unmarshal([]byte(decrypted), &payload)
+	if err != nil || payload.Request.Attributes["password"] != "secret password" || payload.UserReferenceId != "user-1" {
+		t.Errorf("Expected the request back, got %v %v", payload, err)
+	}
+}
+
+func TestJobRunRefreshesLock(t *testing.T) {
+
+	dr := newJobTestResource(t)
+	defer dr.db.Close()
+
+	lockedAt := time.Now().Add(-JobLockTimeout * 2)
+	insertTestRow(t, dr.db, "job", map[string]interface{}{
+		"id":          1,
+		"on_type":     "user",
+		"action_name": "import_data",
+		"request":     "",
+		"status":      JobStatusRunning,
+		"locked_by":   "worker-1",
+		"locked_at":   lockedAt,
+		"log_entries": "[]",
+	})
+
+	lockAge := func() time.Duration {
+		var at time.Time
+		err := dr.db.QueryRowx("select locked_at from job where id = 1").Scan(&at)
+		if err != nil {
+			t.Fatalf("Failed to read lock: %v", err)
+		}
+		return time.Since(at)
+	}
+
+	// a worker which lost the job does not keep it locked
+	newJobRun(dr.db, 1, "worker-2").Log("still running")
+	if lockAge() < JobLockTimeout {
+		t.Errorf("Another worker should not refresh the lock")
+	}
+
+	newJobRun(dr.db, 1, "worker-1").Progress(50)
+	if lockAge() > JobLockTimeout {
+		t.Errorf("Progress should refresh the lock")
+	}
+}
@@ -0,0 +1,250 @@
+package resource
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ldap.v2"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrLdapInvalidCredentials = errors.New("Invalid username or password")
+
+// default values of the ldap settings in _config, ldap sign in is off until ldap.enabled is set to true
+var ldapConfigDefaults = map[string]string{
+	"ldap.enabled":         "false",
+	"ldap.url":             "ldap://localhost:389",
+	"ldap.start_tls":       "false",
+	"ldap.skip_tls_verify": "false",
+	"ldap.bind_dn":         "",
+	"ldap.bind_password":   "",
+	"ldap.base_dn":         "dc=example,dc=com",
+	"ldap.user_filter":     "(&(objectClass=person)(mail=%s))",
+	"ldap.email_attribute": "mail",
+	"ldap.name_attribute":  "cn",
+	"ldap.group_attribute": "memberOf",
+	"ldap.group_mapping":   "{}",
+}
+
+// CheckLdapConfig adds the ldap settings which are missing in _config, so they can be seen and edited there
+func CheckLdapConfig(store *ConfigStore) {
+	for key, defaultValue := range ldapConfigDefaults {
+		_, err := store.GetConfigValueFor(key, "backend")
+		if err != nil {
+			err = store.SetConfigValueFor(key, defaultValue, "backend")
+			CheckErr(err, "Failed to store default value for [%v]", key)
+		}
+	}
+}
+
+type LdapConfig struct {
+	Enabled        bool
+	Url            string
+	StartTls       bool
+	SkipTlsVerify  bool
+	BindDn         string
+	BindPassword   string
+	BaseDn         string
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	// ldap group dn => usergroup name
+	GroupMapping map[string]string
+}
+
+func LoadLdapConfig(store *ConfigStore) LdapConfig {
+
+	values := make(map[string]string)
+	for key, defaultValue := range ldapConfigDefaults {
+		val, err := store.GetConfigValueFor(key, "backend")
+		if err != nil {
+			val = defaultValue
+		}
+		values[key] = val
+	}
+
+	config := LdapConfig{
+		Enabled:        IsTruthy(values["ldap.enabled"]),
+		Url:            values["ldap.url"],
+		StartTls:       IsTruthy(values["ldap.start_tls"]),
+		SkipTlsVerify:  IsTruthy(values["ldap.skip_tls_verify"]),
+		BindDn:         values["ldap.bind_dn"],
+		BindPassword:   values["ldap.bind_password"],
+		BaseDn:         values["ldap.base_dn"],
+		UserFilter:     values["ldap.user_filter"],
+		EmailAttribute: values["ldap.email_attribute"],
+		NameAttribute:  values["ldap.name_attribute"],
+		GroupAttribute: values["ldap.group_attribute"],
+		GroupMapping:   make(map[string]string),
+	}
+
+	err := json.Unmarshal([]byte(values["ldap.group_mapping"]), &config.GroupMapping)
+	if err != nil {
+		log.Errorf("Invalid ldap.group_mapping, expected a json object of group dn to usergroup name: %v", err)
+	}
+
+	return config
+}
+
+type LdapUser struct {
+	Dn     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// LdapAuthenticator checks a user's password against the directory: bind with the service account,
+// search for the user by email, then bind as the user with the given password
+type LdapAuthenticator struct {
+	config LdapConfig
+}
+
+func NewLdapAuthenticator(config LdapConfig) *LdapAuthenticator {
+	return &LdapAuthenticator{
+		config: config,
+	}
+}
+
+func (a *LdapAuthenticator) dial() (*ldap.Conn, error) {
+
+	u, err := url.Parse(a.config.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: a.config.SkipTlsVerify,
+	}
+
+	host := u.Host
+	var conn *ldap.Conn
+
+	switch u.Scheme {
+	case "ldaps":
+		if u.Port() == "" {
+			host = host + ":636"
+		}
+		conn, err = ldap.DialTLS("tcp", host, tlsConfig)
+	case "ldap":
+		if u.Port() == "" {
+			host = host + ":389"
+		}
+		conn, err = ldap.Dial("tcp", host)
+		if err == nil && a.config.StartTls {
+			err = conn.StartTLS(tlsConfig)
+			if err != nil {
+				conn.Close()
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unknown ldap url scheme [%v]", u.Scheme)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(10 * time.Second)
+	return conn, nil
+}
+
+// Authenticate returns ErrLdapInvalidCredentials if the user does not exist in the directory or the password is wrong
+func (a *LdapAuthenticator) Authenticate(email string, password string) (*LdapUser, error) {
+
+	// an empty password is an anonymous bind for most servers, which would always succeed
+	if email == "" || password == "" {
+		return nil, ErrLdapInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		log.Errorf("Failed to connect to ldap server [%v]: %v", a.config.Url, err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.config.BindDn != "" {
+		err = conn.Bind(a.config.BindDn, a.config.BindPassword)
+		if err != nil {
+			log.Errorf("Failed to bind to ldap as [%v]: %v", a.config.BindDn, err)
+			return nil, err
+		}
+	}
+
+	attributes := []string{"dn", a.config.EmailAttribute, a.config.NameAttribute, a.config.GroupAttribute}
+	searchRequest := ldap.NewSearchRequest(
+		a.config.BaseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(email)),
+		attributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		log.Errorf("Failed to search ldap for [%v]: %v", email, err)
+		return nil, err
+	}
+
+	if len(result.Entries) != 1 {
+		log.Infof("Found %d ldap entries for [%v]", len(result.Entries), email)
+		return nil, ErrLdapInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLdapInvalidCredentials
+		}
+		log.Errorf("Failed to bind to ldap as [%v]: %v", entry.DN, err)
+		return nil, err
+	}
+
+	user := &LdapUser{
+		Dn:     entry.DN,
+		Email:  entry.GetAttributeValue(a.config.EmailAttribute),
+		Name:   entry.GetAttributeValue(a.config.NameAttribute),
+		Groups: entry.GetAttributeValues(a.config.GroupAttribute),
+	}
+
+	if user.Email == "" {
+		user.Email = email
+	}
+	if user.Name == "" {
+		user.Name = strings.Split(user.Email, "@")[0]
+	}
+
+	return user, nil
+}
+
+// MappedGroups returns the usergroup names for the ldap groups of the user, group dns are compared case insensitively
+func (a *LdapAuthenticator) MappedGroups(user *LdapUser) []string {
+
+	names := make([]string, 0)
+	for _, groupDn := range user.Groups {
+		for mappedDn, usergroupName := range a.config.GroupMapping {
+			if strings.EqualFold(strings.TrimSpace(mappedDn), strings.TrimSpace(groupDn)) {
+				names = append(names, usergroupName)
+			}
+		}
+	}
+
+	return names
+}
+
+// ManagedGroups are all the usergroups the ldap mapping controls membership of
+func (a *LdapAuthenticator) ManagedGroups() []string {
+	names := make([]string, 0)
+	for _, usergroupName := range a.config.GroupMapping {
+		names = append(names, usergroupName)
+	}
+	return names
+}
@@ -0,0 +1,251 @@
+package resource
+
+import (
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+	"net"
+	"strings"
+	"testing"
+)
+
+type testLdapEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testLdapServer answers the bind and search requests the authenticator sends, enough of the protocol to
+// run sign ins against a directory without an ldap server installed
+type testLdapServer struct {
+	listener net.Listener
+	entries  []testLdapEntry
+}
+
+func newTestLdapServer(t *testing.T, entries ...testLdapEntry) *testLdapServer {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := &testLdapServer{
+		listener: listener,
+		entries:  entries,
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *testLdapServer) Url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLdapServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testLdapServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageId := packet.Children[0].Value
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, _ := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			for _, entry := range s.entries {
+				if strings.EqualFold(entry.dn, dn) && entry.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			conn.Write(testLdapResult(messageId, ldap.ApplicationBindResponse, code).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			filter := make(map[string]string)
+			testLdapEqualityMatches(request.Children[6], filter)
+			for _, entry := range s.entries {
+				if !entry.matches(filter) {
+					continue
+				}
+				conn.Write(testLdapSearchEntry(messageId, entry).Bytes())
+			}
+			conn.Write(testLdapResult(messageId, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+
+		default:
+			return
+		}
+	}
+}
+
+func (entry testLdapEntry) matches(filter map[string]string) bool {
+	for attribute, value := range filter {
+		if attribute == "objectClass" {
+			continue
+		}
+		found := false
+		for _, v := range entry.attributes[attribute] {
+			if strings.EqualFold(v, value) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// testLdapEqualityMatches collects the (attribute=value) parts of the filter, and and or are treated alike
+func testLdapEqualityMatches(filter *ber.Packet, matches map[string]string) {
+	if filter.ClassType == ber.ClassContext && filter.Tag == ldap.FilterEqualityMatch && len(filter.Children) == 2 {
+		attribute, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		matches[attribute] = value
+		return
+	}
+	for _, child := range filter.Children {
+		testLdapEqualityMatches(child, matches)
+	}
+}
+
+func testLdapEnvelope(messageId interface{}, response *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageId, "MessageID"))
+	packet.AppendChild(response)
+	return packet
+}
+
+func testLdapResult(messageId interface{}, tag ber.Tag, code int) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return testLdapEnvelope(messageId, response)
+}
+
+func testLdapSearchEntry(messageId interface{}, entry testLdapEntry) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	response.AppendChild(attributes)
+
+	return testLdapEnvelope(messageId, response)
+}
+
+var testLdapEntries = []testLdapEntry{
+	{
+		dn:       "cn=service,dc=example,dc=com",
+		password: "service password",
+	},
+	{
+		dn:       "uid=ada,ou=people,dc=example,dc=com",
+		password: "ada password",
+		attributes: map[string][]string{
+			"mail":     {"ada@example.com"},
+			"cn":       {"Ada"},
+			"memberOf": {"cn=engineers,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		},
+	},
+}
+
+func testLdapConfig(url string) LdapConfig {
+	return LdapConfig{
+		Enabled:        true,
+		Url:            url,
+		BindDn:         "cn=service,dc=example,dc=com",
+		BindPassword:   "service password",
+		BaseDn:         "dc=example,dc=com",
+		UserFilter:     ldapConfigDefaults["ldap.user_filter"],
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		GroupAttribute: "memberOf",
+		GroupMapping: map[string]string{
+			"CN=Engineers,OU=Groups,DC=example,DC=com": "engineering",
+			"cn=admins,ou=groups,dc=example,dc=com":    "operations",
+		},
+	}
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+
+	server := newTestLdapServer(t, testLdapEntries...)
+	defer server.Close()
+
+	authenticator := NewLdapAuthenticator(testLdapConfig(server.Url()))
+
+	user, err := authenticator.Authenticate("ada@example.com", "ada password")
+	if err != nil {
+		t.Fatalf("Failed to sign in: %v", err)
+	}
+	if user.Dn != "uid=ada,ou=people,dc=example,dc=com" || user.Email != "ada@example.com" || user.Name != "Ada" || len(user.Groups) != 2 {
+		t.Errorf("Unexpected user %v", user)
+	}
+
+	cases := []struct {
+		email    string
+		password string
+	}{
+		{"ada@example.com", "wrong password"},
+		{"ada@example.com", ""},
+		{"grace@example.com", "ada password"},
+		{"*", "ada password"},
+	}
+	for _, c := range cases {
+		_, err = authenticator.Authenticate(c.email, c.password)
+		if err != ErrLdapInvalidCredentials {
+			t.Errorf("[%v] [%v]: expected invalid credentials, got %v", c.email, c.password, err)
+		}
+	}
+
+	config := testLdapConfig(server.Url())
+	config.BindPassword = "wrong password"
+	_, err = NewLdapAuthenticator(config).Authenticate("ada@example.com", "ada password")
+	if err == nil || err == ErrLdapInvalidCredentials {
+		t.Errorf("A failing service account bind should be an error, got %v", err)
+	}
+}
+
+func TestLdapMappedGroups(t *testing.T) {
+
+	authenticator := NewLdapAuthenticator(testLdapConfig("ldap://localhost"))
+
+	groups := authenticator.MappedGroups(&LdapUser{
+		Groups: testLdapEntries[1].attributes["memberOf"],
+	})
+	if len(groups) != 1 || groups[0] != "engineering" {
+		t.Errorf("Expected the engineering usergroup, got %v", groups)
+	}
+
+	if managed := authenticator.ManagedGroups(); len(managed) != 2 {
+		t.Errorf("Expected both mapped usergroups to be managed, got %v", managed)
+	}
+}
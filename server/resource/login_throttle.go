@@ -0,0 +1,312 @@
+package resource
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"math"
+	"sync"
+	"time"
+)
+
+// failed sign ins allowed before every next attempt has to wait
+const LoginFreeAttempts = 3
+
+// wait after the first throttled failure, doubled on every failure after that
+const LoginBackoffBase = time.Second
+
+const LoginBackoffMax = 5 * time.Minute
+
+// an account is locked after these many consecutive failed sign ins
+const AccountLockoutThreshold = 10
+
+const AccountLockoutDuration = 30 * time.Minute
+
+// a client ip is allowed more failures since it can be shared by many users (NAT, proxies)
+const IpLoginFreeAttempts = 10
+
+const IpBlockThreshold = 50
+
+const IpBlockDuration = time.Hour
+
+// failures from an ip older than this are forgotten
+const IpFailureWindow = time.Hour
+
+func LoginBackoff(failures int, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	wait := float64(LoginBackoffBase) * math.Pow(2, float64(failures-freeAttempts))
+	if wait > float64(LoginBackoffMax) {
+		return LoginBackoffMax
+	}
+	return time.Duration(wait)
+}
+
+type ipLoginFailures struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// IpLoginThrottle keeps failed sign in counts per client ip in memory. The counts are per instance and are
+// lost on a restart, the failure counts and locks of accounts are kept in the database.
+type IpLoginThrottle struct {
+	lock     sync.Mutex
+	failures map[string]*ipLoginFailures
+}
+
+func NewIpLoginThrottle() *IpLoginThrottle {
+	return &IpLoginThrottle{
+		failures: make(map[string]*ipLoginFailures),
+	}
+}
+
+var ipLoginThrottle = NewIpLoginThrottle()
+
+// Wait returns how long the ip has to wait before it can try to sign in again
+func (t *IpLoginThrottle) Wait(ip string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	f, ok := t.failures[ip]
+	if !ok {
+		return 0
+	}
+
+	now := time.Now()
+	if now.Before(f.blockedUntil) {
+		return f.blockedUntil.Sub(now)
+	}
+	if now.Sub(f.lastFailure) > IpFailureWindow {
+		delete(t.failures, ip)
+		return 0
+	}
+
+	wait := f.lastFailure.Add(LoginBackoff(f.count, IpLoginFreeAttempts)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// RecordFailure returns true if the ip got blocked by this failure
+func (t *IpLoginThrottle) RecordFailure(ip string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	f, ok := t.failures[ip]
+	if !ok || now.Sub(f.lastFailure) > IpFailureWindow {
+		f = &ipLoginFailures{}
+		t.failures[ip] = f
+	}
+
+	f.count += 1
+	f.lastFailure = now
+
+	if f.count == IpBlockThreshold {
+		f.blockedUntil = now.Add(IpBlockDuration)
+		return true
+	}
+	return false
+}
+
+func (t *IpLoginThrottle) Reset(ip string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.failures, ip)
+}
+
+func ToTime(val interface{}) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return *v, true
+	case []uint8:
+		return ToTime(string(v))
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+			t, err := time.Parse(layout, v)
+			if err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func toInt(val interface{}) int {
+	switch v := val.(type) {
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case []uint8:
+		var i int
+		fmt.Sscanf(string(v), "%d", &i)
+		return i
+	case string:
+		var i int
+		fmt.Sscanf(v, "%d", &i)
+		return i
+	}
+	return 0
+}
+
+// AccountLoginWait returns how long the user has to wait before the next sign in attempt is checked,
+// either because the account is locked or because of the backoff after the last failure
+func AccountLoginWait(user map[string]interface{}) time.Duration {
+
+	now := time.Now()
+
+	lockedUntil, ok := ToTime(user["locked_until"])
+	if ok && now.Before(lockedUntil) {
+		return lockedUntil.Sub(now)
+	}
+
+	lastFailure, ok := ToTime(user["last_failed_login_at"])
+	if !ok {
+		return 0
+	}
+
+	wait := lastFailure.Add(LoginBackoff(toInt(user["failed_login_count"]), LoginFreeAttempts)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// CheckLoginThrottle returns a response for the client if sign in attempts from this ip or for this user
+// are currently being throttled, user can be nil if no account matched
+func (dr *DbResource) CheckLoginThrottle(user map[string]interface{}, clientIp string) []ActionResponse {
+
+	wait := ipLoginThrottle.Wait(clientIp)
+
+	if user != nil {
+		accountWait := AccountLoginWait(user)
+		if accountWait > wait {
+			wait = accountWait
+		}
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	return []ActionResponse{
+		NewActionResponse("client.notify", NewClientNotification("error",
+			fmt.Sprintf("Too many failed attempts, try again in %d seconds", seconds), "Failed")),
+	}
+}
+
+// RecordLoginFailure counts a failed sign in against the ip and the user (if an account matched), locking
+// the account or blocking the ip once they cross their threshold
+func (dr *DbResource) RecordLoginFailure(user map[string]interface{}, email interface{}, clientIp string) {
+
+	if ipLoginThrottle.RecordFailure(clientIp) {
+		log.Infof("Blocking sign in from [%v] for %v", clientIp, IpBlockDuration)
+		dr.AddTimelineEvent("user", "login.ip.blocked", "Sign in blocked for "+clientIp, map[string]interface{}{
+			"email":     email,
+			"client_ip": clientIp,
+		})
+	}
+
+	if user == nil {
+		return
+	}
+
+	now := time.Now()
+
+	// counted in the database, so parallel attempts are all counted
+	s, v, err := squirrel.Update("user").
+		Set("failed_login_count", squirrel.Expr("failed_login_count + 1")).
+		Set("last_failed_login_at", now).
+		Where(squirrel.Eq{"id": user["id"]}).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create login failure query: %v", err)
+		return
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to record login failure for [%v]: %v", email, err)
+		return
+	}
+
+	s, v, err = squirrel.Select("failed_login_count").From("user").Where(squirrel.Eq{"id": user["id"]}).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create login failure count query: %v", err)
+		return
+	}
+
+	var failures int
+	err = dr.db.QueryRowx(s, v...).Scan(&failures)
+	if err != nil {
+		log.Errorf("Failed to read login failures of [%v]: %v", email, err)
+		return
+	}
+
+	payload := map[string]interface{}{
+		"email":     email,
+		"client_ip": clientIp,
+		"failures":  failures,
+	}
+
+	if failures >= AccountLockoutThreshold {
+		if dr.lockAccount(user["id"], now) {
+			log.Infof("Locking account [%v] after %d failed sign ins", email, failures)
+			dr.AddTimelineEvent("user", "login.account.locked", fmt.Sprintf("Account locked after %d failures", failures), payload)
+		}
+	} else if failures > LoginFreeAttempts {
+		dr.AddTimelineEvent("user", "login.failed", fmt.Sprintf("%d consecutive failed sign ins", failures), payload)
+	}
+}
+
+// lockAccount locks an account which is not locked already, it returns true for the attempt which locked it
+func (dr *DbResource) lockAccount(userId interface{}, now time.Time) bool {
+
+	s, v, err := squirrel.Update("user").
+		Set("locked_until", now.Add(AccountLockoutDuration)).
+		Where(squirrel.Eq{"id": userId}).
+		Where(squirrel.Or{
+			squirrel.Eq{"locked_until": nil},
+			squirrel.Expr("locked_until < ?", now),
+		}).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create account lock query: %v", err)
+		return false
+	}
+
+	res, err := dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to lock account [%v]: %v", userId, err)
+		return false
+	}
+
+	count, _ := res.RowsAffected()
+	return count == 1
+}
+
+// ResetLoginFailures clears the failure count and any lock on the account
+func (dr *DbResource) ResetLoginFailures(userId int64) error {
+
+	s, v, err := squirrel.Update("user").
+		Set("failed_login_count", 0).
+		Set("last_failed_login_at", nil).
+		Set("locked_until", nil).
+		Where(squirrel.Eq{"id": userId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+
+	cases := []struct {
+		failures     int
+		freeAttempts int
+		wait         time.Duration
+	}{
+		{0, LoginFreeAttempts, 0},
+		{LoginFreeAttempts - 1, LoginFreeAttempts, 0},
+		{LoginFreeAttempts, LoginFreeAttempts, LoginBackoffBase},
+		{LoginFreeAttempts + 1, LoginFreeAttempts, 2 * LoginBackoffBase},
+		{LoginFreeAttempts + 3, LoginFreeAttempts, 8 * LoginBackoffBase},
+		{LoginFreeAttempts + 100, LoginFreeAttempts, LoginBackoffMax},
+		{IpLoginFreeAttempts - 1, IpLoginFreeAttempts, 0},
+		{IpLoginFreeAttempts, IpLoginFreeAttempts, LoginBackoffBase},
+	}
+
+	for _, c := range cases {
+		wait := LoginBackoff(c.failures, c.freeAttempts)
+		if wait != c.wait {
+			t.Errorf("%d failures with %d free attempts: expected %v, got %v", c.failures, c.freeAttempts, c.wait, wait)
+		}
+	}
+}
+
+func TestAccountLoginWait(t *testing.T) {
+
+	now := time.Now()
+
+	if wait := AccountLoginWait(map[string]interface{}{}); wait != 0 {
+		t.Errorf("A user without failures should not wait, got %v", wait)
+	}
+
+	locked := map[string]interface{}{
+		"locked_until": now.Add(time.Minute),
+	}
+	if wait := AccountLoginWait(locked); wait <= 0 || wait > time.Minute {
+		t.Errorf("A locked user should wait for the lock, got %v", wait)
+	}
+
+	backoff := map[string]interface{}{
+		"failed_login_count":   int64(LoginFreeAttempts + 2),
+		"last_failed_login_at": now.Format("2006-01-02 15:04:05.999999999-07:00"),
+	}
+	if wait := AccountLoginWait(backoff); wait <= 0 || wait > 4*LoginBackoffBase {
+		t.Errorf("Expected a backoff of up to %v, got %v", 4*LoginBackoffBase, wait)
+	}
+
+	expired := map[string]interface{}{
+		"failed_login_count":   int64(LoginFreeAttempts),
+		"last_failed_login_at": now.Add(-time.Hour),
+		"locked_until":         now.Add(-time.Minute),
+	}
+	if wait := AccountLoginWait(expired); wait != 0 {
+		t.Errorf("An expired backoff and lock should not wait, got %v", wait)
+	}
+}
+
+func TestIpLoginThrottle(t *testing.T) {
+
+	throttle := NewIpLoginThrottle()
+	ip := "10.0.0.1"
+
+	for i := 0; i < IpLoginFreeAttempts-1; i++ {
+		if throttle.RecordFailure(ip) {
+			t.Fatalf("Blocked after %d failures", i+1)
+		}
+	}
+	if wait := throttle.Wait(ip); wait != 0 {
+		t.Errorf("Free attempts should not wait, got %v", wait)
+	}
+
+	throttle.RecordFailure(ip)
+	if wait := throttle.Wait(ip); wait <= 0 {
+		t.Errorf("Failures beyond the free attempts should wait")
+	}
+	if wait := throttle.Wait("10.0.0.2"); wait != 0 {
+		t.Errorf("Another ip should not wait, got %v", wait)
+	}
+
+	blocked := false
+	for i := IpLoginFreeAttempts; i < IpBlockThreshold; i++ {
+		blocked = throttle.RecordFailure(ip)
+	}
+	if !blocked {
+		t.Errorf("The ip should be blocked after %d failures", IpBlockThreshold)
+	}
+	if wait := throttle.Wait(ip); wait < IpBlockDuration-time.Minute {
+		t.Errorf("A blocked ip should wait for the block, got %v", wait)
+	}
+
+	throttle.Reset(ip)
+	if wait := throttle.Wait(ip); wait != 0 {
+		t.Errorf("A reset ip should not wait, got %v", wait)
+	}
+}
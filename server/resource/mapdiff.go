@@ -13,9 +13,10 @@ func Diff(a, b interface{}) (desc []Change) {
 
 // Fdiff writes to w a description of the differences between a and b.
 func Fdiff(a, b interface{}) []Change {
-	writer := diffWriter{}
+	changes := make([]Change, 0)
+	writer := diffWriter{changes: &changes}
 	writer.diff(reflect.ValueOf(a), reflect.ValueOf(b))
-	return writer.changes
+	return changes
 }
 
 type changeType int
@@ -27,20 +28,40 @@ const (
 	ChangedValue
 )
 
+func (c changeType) String() string {
+	switch c {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case ChangedType:
+		return "changed_type"
+	default:
+		return "changed_value"
+	}
+}
+
+func (c changeType) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
 type Change struct {
-	ChangeType changeType
-	OldValue   interface{}
-	NewValue   interface{}
+	// the path to the changed value, eg ["name"] for a key of a map
+	Field      string      `json:"field"`
+	ChangeType changeType  `json:"change_type"`
+	OldValue   interface{} `json:"old_value"`
+	NewValue   interface{} `json:"new_value"`
 }
 
 type diffWriter struct {
 	l       string // label
-	changes []Change
+	changes *[]Change
 }
 
 func (w diffWriter) addDiff(diffType changeType, oldValue interface{}, newValue interface{}) {
 
-	w.changes = append(w.changes, Change{
+	*w.changes = append(*w.changes, Change{
+		Field:      w.l,
 		ChangeType: diffType,
 		OldValue:   oldValue,
 		NewValue:   newValue,
@@ -59,12 +80,12 @@ func (w diffWriter) addDiff(diffType changeType, oldValue interface{}, newValue
 func (w diffWriter) diff(beforeValue, afterValue reflect.Value) {
 	if !beforeValue.IsValid() && afterValue.IsValid() {
 		//w.addDiff("nil != %#v", bv.Interface())
-		w.addDiff(Added, nil, afterValue.Elem())
+		w.addDiff(Added, nil, afterValue.Interface())
 		return
 	}
 	if beforeValue.IsValid() && !afterValue.IsValid() {
 		//w.addDiff("%#v != nil", av.Interface())
-		w.addDiff(Removed, beforeValue.Elem(), nil)
+		w.addDiff(Removed, beforeValue.Interface(), nil)
 		return
 	}
 	if !beforeValue.IsValid() && !afterValue.IsValid() {
@@ -103,6 +124,15 @@ func (w diffWriter) diff(beforeValue, afterValue reflect.Value) {
 			w.diff(beforeValue.Elem(), afterValue.Elem())
 		}
 	case reflect.Struct:
+		// structs with unexported fields, like time.Time, are compared as a whole
+		for i := 0; i < at.NumField(); i++ {
+			if at.Field(i).PkgPath != "" {
+				if !reflect.DeepEqual(beforeValue.Interface(), afterValue.Interface()) {
+					w.addDiff(ChangedValue, beforeValue.Interface(), afterValue.Interface())
+				}
+				return
+			}
+		}
 		for i := 0; i < beforeValue.NumField(); i++ {
 			w.relabel(at.Field(i).Name).diff(beforeValue.Field(i), afterValue.Field(i))
 		}
@@ -111,7 +141,7 @@ func (w diffWriter) diff(beforeValue, afterValue reflect.Value) {
 		for _, k := range ak {
 			w := w.relabel(fmt.Sprintf("[%#v]", k.Interface()))
 			//w.printf("%q != (missing)", beforeValue.MapIndex(k))
-			w.addDiff(Removed, beforeValue.MapIndex(k), nil)
+			w.addDiff(Removed, beforeValue.MapIndex(k).Interface(), nil)
 		}
 		for _, k := range both {
 			w := w.relabel(fmt.Sprintf("[%#v]", k.Interface()))
@@ -120,7 +150,7 @@ func (w diffWriter) diff(beforeValue, afterValue reflect.Value) {
 		for _, k := range bk {
 			w := w.relabel(fmt.Sprintf("[%#v]", k.Interface()))
 			//w.printf("(missing) != %q", afterValue.MapIndex(k))
-			w.addDiff(Added, nil, afterValue.MapIndex(k))
+			w.addDiff(Added, nil, afterValue.MapIndex(k).Interface())
 		}
 	case reflect.Interface:
 		w.diff(reflect.ValueOf(beforeValue.Interface()), reflect.ValueOf(afterValue.Interface()))
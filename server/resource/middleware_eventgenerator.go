@@ -1,34 +1,62 @@
 package resource
 
 import (
-	"context"
 	"github.com/artpar/api2go"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
+// EventListener is told about rows created, updated or deleted through the api and actions, once the
+// change is stored
+type EventListener interface {
+	ListensTo(tableName string, event string) bool
+	// row is the row after the change, or the deleted row. previous is the row before an update.
+	OnEvent(dr *DbResource, event string, req *api2go.Request, row map[string]interface{}, previous map[string]interface{})
+}
+
+// eventHandlerMiddleware tells the listeners about one kind of change. The row before an update or delete
+// is loaded before the change, the listeners are called after it.
 type eventHandlerMiddleware struct {
+	event     string
+	listeners []EventListener
 }
 
 func (pc eventHandlerMiddleware) String() string {
 	return "EventGenerator"
 }
 
+func (pc *eventHandlerMiddleware) listenersFor(tableName string) []EventListener {
+	listeners := make([]EventListener, 0)
+	for _, listener := range pc.listeners {
+		if listener.ListensTo(tableName, pc.event) {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
 func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
 
-	switch strings.ToLower(req.PlainRequest.Method) {
-	case "get":
-		break
-	case "post":
-		break
-	case "update":
-		break
-	case "delete":
-		break
-	case "patch":
-		break
-	default:
-		log.Errorf("Invalid method: %v", req.PlainRequest.Method)
+	if pc.event == "" {
+		return results, nil
+	}
+
+	for _, listener := range pc.listenersFor(dr.model.GetName()) {
+		switch pc.event {
+		case TriggerEventCreate:
+			for _, result := range results {
+				listener.OnEvent(dr, pc.event, req, result, nil)
+			}
+		case TriggerEventUpdate:
+			for _, result := range results {
+				listener.OnEvent(dr, pc.event, req, result, previousRowOf(req))
+			}
+		case TriggerEventDelete:
+			if previous := previousRowOf(req); previous != nil {
+				listener.OnEvent(dr, pc.event, req, previous, nil)
+			}
+		default:
+			log.Errorf("Invalid event: %v", pc.event)
+		}
 	}
 
 	return results, nil
@@ -37,29 +65,28 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 
 func (pc *eventHandlerMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
 
-	var err error
+	if pc.event != TriggerEventUpdate && pc.event != TriggerEventDelete {
+		return objects, nil
+	}
 
-	reqmethod := req.PlainRequest.Method
-	//log.Infof("Generate events for objects", reqmethod)
-	switch reqmethod {
-	case "GET":
-		break
-	case "POST":
-		break
-	case "UPDATE":
-		break
-	case "DELETE":
-		break
-	case "PATCH":
-		break
-	default:
-		log.Errorf("Invalid method: %v", reqmethod)
+	tableName := dr.model.GetName()
+	if len(pc.listenersFor(tableName)) == 0 || len(objects) == 0 {
+		return objects, nil
 	}
 
-	//currentUserId := context.Get(req.PlainRequest, "user_id").(string)
-	//currentUserGroupId := context.Get(req.PlainRequest, "usergroup_id").([]string)
+	referenceId, ok := objects[0]["reference_id"].(string)
+	if !ok {
+		return objects, nil
+	}
+
+	previous, err := dr.GetReferenceIdToObject(tableName, referenceId)
+	if err != nil {
+		log.Errorf("Failed to load [%v][%v] for event listeners: %v", tableName, referenceId, err)
+		return objects, nil
+	}
+	withPreviousRow(req, previous)
 
-	return objects, err
+	return objects, nil
 
 }
 
@@ -67,6 +94,7 @@ type exchangeMiddleware struct {
 	cmsConfig   *CmsConfig
 	exchangeMap map[string][]ExchangeContract
 	cruds       *map[string]*DbResource
+	outbox      *ExchangeOutbox
 }
 
 func (em *exchangeMiddleware) String() string {
@@ -74,7 +102,7 @@ func (em *exchangeMiddleware) String() string {
 }
 
 // Creates a new exchange middleware which is responsible for calling external apis on data updates
-func NewExchangeMiddleware(cmsConfig *CmsConfig, cruds *map[string]*DbResource) DatabaseRequestInterceptor {
+func NewExchangeMiddleware(cmsConfig *CmsConfig, cruds *map[string]*DbResource, outbox *ExchangeOutbox) DatabaseRequestInterceptor {
 
 	exchangeMap := make(map[string][]ExchangeContract)
 
@@ -86,6 +114,14 @@ func NewExchangeMiddleware(cmsConfig *CmsConfig, cruds *map[string]*DbResource)
 				continue
 			}
 
+			if exc.TargetType == "self" {
+				err := CheckSelfExchange(exc, cmsConfig.Tables)
+				if err != nil {
+					log.Errorf("Exchange [%v] is left out: %v", exc.Name, err)
+					continue
+				}
+			}
+
 			m, ok := exchangeMap[exc.SourceAttributes["name"].(string)]
 			if !ok {
 				m = make([]ExchangeContract, 0)
@@ -109,6 +145,7 @@ func NewExchangeMiddleware(cmsConfig *CmsConfig, cruds *map[string]*DbResource)
 		cmsConfig:   cmsConfig,
 		exchangeMap: exchangeMap,
 		cruds:       cruds,
+		outbox:      outbox,
 	}
 }
 
@@ -128,83 +165,108 @@ func (em *exchangeMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request
 		break
 	case "POST":
 
-		if len(results) > 0 {
+		for _, result := range results {
 
-			for _, result := range results {
+			typ, ok := result["__type"]
 
-				typ, ok := result["__type"]
+			if !ok || typ == nil {
+				continue
+			}
 
-				if !ok || typ == nil {
-					continue
-				}
-				resultType := result["__type"].(string)
+			err := em.runExchanges(dr, req, typ.(string), result, false)
+			if err != nil {
+				return results, err
+			}
+		}
 
-				exchanges, ok := em.exchangeMap[resultType]
+		break
+	case "UPDATE":
+		break
+	case "DELETE":
+		break
+	case "PUT":
+		fallthrough
+	case "PATCH":
 
-				if ok {
-					log.Infof("Got %d exchanges for [%v]", len(exchanges), resultType)
-				}
+		// copies in daptin tables are kept up to date, external apis only get new rows
+		for _, result := range results {
+			if result == nil {
+				continue
+			}
+			err := em.runExchanges(dr, req, dr.model.GetName(), result, true)
+			if err != nil {
+				return results, err
+			}
+		}
 
-				for _, exchange := range exchanges {
+		break
+	default:
+		log.Errorf("Invalid method: %v", reqmethod)
+	}
 
-					if exchange.OauthTokenId == nil {
-						log.Infof("Oauth token for exchange [%v] is not set", exchange.Name)
-						continue
-					}
+	return results, nil
+}
 
-					token, err := dr.GetTokenByTokenId(*exchange.OauthTokenId)
-					if err != nil {
-						log.Errorf("No token selected for [%v][%v]: %v", exchange.Name, exchange.OauthTokenId, err)
-					}
+// exchangeRow is the row as it is stored, without its password and hidden columns. The results passed to
+// the after middlewares are masked and trimmed for the user who made the change, so the row is loaded again.
+func exchangeRow(dr *DbResource, typeName string, result map[string]interface{}) (map[string]interface{}, error) {
 
-					oauthDesc, err := dr.GetOauthDescriptionByTokenId(*exchange.OauthTokenId)
+	referenceId, _ := result["reference_id"].(string)
+	stored, err := dr.GetReferenceIdToObject(typeName, referenceId)
+	if err != nil {
+		return nil, err
+	}
 
-					if err != nil {
-						log.Errorf("No oauth description for [%v][%v]: %v", exchange.Name, exchange.OauthTokenId, err)
-					}
-					ctx := context.Background()
+	columnMap := dr.cruds[typeName].model.GetColumnMap()
+	row := make(map[string]interface{})
+	for key, value := range stored {
+		if column, ok := columnMap[key]; ok && (column.ExcludeFromApi || column.ColumnType == "password") {
+			continue
+		}
+		row[key] = value
+	}
+	return row, nil
+}
 
-					if !token.Valid() {
-						tokenSource := oauthDesc.TokenSource(ctx, token)
-						token, err = tokenSource.Token()
-						CheckErr(err, "Failed to get new access token")
-						if err != nil {
-							return results, err
-						}
+// runExchanges queues the row for the exchanges of the table, only the ones to daptin tables when selfOnly.
+// The outbox runs them in the background and tries failed ones again.
+func (em *exchangeMiddleware) runExchanges(dr *DbResource, req *api2go.Request, resultType string, result map[string]interface{}, selfOnly bool) error {
 
-						err = dr.UpdateAccessTokenByTokenId(*exchange.OauthTokenId, token.AccessToken, token.Expiry.Unix())
-						CheckErr(err, "failed to update access token")
-					}
+	exchanges, ok := em.exchangeMap[resultType]
 
-					if err != nil {
-						return results, err
-					}
+	if ok {
+		log.Infof("Got %d exchanges for [%v]", len(exchanges), resultType)
+	}
 
-					//client := oauthDesc.Client(ctx, token)
+	tenant := TenantFromRequest(*req)
+	depth := exchangeDepth(req.PlainRequest.Context())
+	var row map[string]interface{}
 
-					exchangeExecution := NewExchangeExecution(exchange, token, oauthDesc)
+	for _, exchange := range exchanges {
 
-					inFields := make(map[string]interface{})
+		// exchanges from other sources into the table do not run on its changes
+		if exchange.SourceType != "self" {
+			continue
+		}
+		if selfOnly && exchange.TargetType != "self" {
+			continue
+		}
 
-					err = exchangeExecution.Execute(inFields, []map[string]interface{}{result})
-					if err != nil {
-						log.Errorf("Failed to execute exchange: %v", err)
-						//errors = append(errors, err)
-					}
-				}
+		if row == nil {
+			var err error
+			row, err = exchangeRow(dr, resultType, result)
+			if err != nil {
+				log.Errorf("Failed to load [%v] for exchange [%v]: %v", resultType, exchange.Name, err)
+				return err
 			}
 		}
 
-		break
-	case "UPDATE":
-		break
-	case "DELETE":
-		break
-	case "PATCH":
-		break
-	default:
-		log.Errorf("Invalid method: %v", reqmethod)
+		err := em.outbox.Enqueue(exchange, row, tenant, depth)
+		if err != nil {
+			log.Errorf("Failed to queue [%v] for exchange [%v]: %v", resultType, exchange.Name, err)
+			return err
+		}
 	}
 
-	return results, nil
+	return nil
 }
@@ -0,0 +1,253 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// FieldPermission restricts who can read or write a column of a table. Each entry of ReadableBy/WritableBy is one of
+//   - role:<role name>
+//   - usergroup:<usergroup name>
+//   - owner, the owner of the row
+//   - admin, the administrator (who is always allowed)
+//   - none, nobody, not even the administrator, for columns only the server sets
+//
+// An empty list does not restrict the column. Users who cannot read a column get it masked if MaskShowLast
+// is set (eg ****1234 for 4), otherwise the column is left out.
+type FieldPermission struct {
+	ColumnName   string
+	ReadableBy   []string
+	WritableBy   []string
+	MaskShowLast int
+}
+
+type FieldPermissionMiddleware struct {
+	tableInfoMap map[string]TableInfo
+}
+
+func (fpm FieldPermissionMiddleware) String() string {
+	return "FieldPermissionMiddleware"
+}
+
+type fieldAccessContext struct {
+	sessionUser auth.SessionUser
+	roles       RolePermissions
+	isAdmin     bool
+}
+
+func (fpm *FieldPermissionMiddleware) accessContext(dr *DbResource, req *api2go.Request) fieldAccessContext {
+	return newFieldAccessContext(dr, req)
+}
+
+func newFieldAccessContext(dr *DbResource, req *api2go.Request) fieldAccessContext {
+
+	sessionUser := auth.SessionUser{}
+	user := req.PlainRequest.Context().Value("user")
+	if user != nil {
+		sessionUser = user.(auth.SessionUser)
+	}
+
+	return fieldAccessContext{
+		sessionUser: sessionUser,
+		roles:       dr.GetRolePermissions(sessionUser),
+		isAdmin:     dr.IsAdmin(sessionUser.UserReferenceId),
+	}
+}
+
+// isAllowed checks the entries of ReadableBy/WritableBy, the owner is checked only when needed since it
+// can take a query
+func (ctx fieldAccessContext) isAllowed(allowedBy []string, isOwner func() bool) bool {
+
+	if len(allowedBy) == 0 {
+		return true
+	}
+
+	for _, entry := range allowedBy {
+		if entry == "none" {
+			return false
+		}
+	}
+
+	if ctx.isAdmin {
+		return true
+	}
+
+	for _, entry := range allowedBy {
+		switch {
+		case entry == "owner":
+			if isOwner() {
+				return true
+			}
+		case strings.HasPrefix(entry, "role:"):
+			if ctx.roles.HasRole(entry[len("role:"):]) {
+				return true
+			}
+		case strings.HasPrefix(entry, "usergroup:"):
+			if ctx.roles.InUserGroup(entry[len("usergroup:"):]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func MaskValue(value interface{}, showLast int) string {
+	str := fmt.Sprintf("%v", value)
+	runes := []rune(str)
+	if len(runes) <= showLast {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-showLast) + string(runes[len(runes)-showLast:])
+}
+
+// InterceptAfter removes or masks the columns the user cannot read, from the results and the included objects
+func (fpm *FieldPermissionMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
+
+	if results == nil || len(results) < 1 {
+		return results, nil
+	}
+
+	var ctx *fieldAccessContext
+
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+
+		typeName, _ := result["__type"].(string)
+		fieldPermissions := fpm.tableInfoMap[typeName].FieldPermissions
+		if len(fieldPermissions) == 0 {
+			continue
+		}
+
+		if ctx == nil {
+			c := fpm.accessContext(dr, req)
+			ctx = &c
+		}
+
+		row := result
+		isOwner := func() bool {
+			return dr.GetRowPermission(row).UserId == ctx.sessionUser.UserReferenceId && ctx.sessionUser.UserReferenceId != ""
+		}
+
+		for _, fieldPermission := range fieldPermissions {
+			value, ok := result[fieldPermission.ColumnName]
+			if !ok || ctx.isAllowed(fieldPermission.ReadableBy, isOwner) {
+				continue
+			}
+
+			if fieldPermission.MaskShowLast > 0 && value != nil {
+				result[fieldPermission.ColumnName] = MaskValue(value, fieldPermission.MaskShowLast)
+			} else {
+				delete(result, fieldPermission.ColumnName)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// InterceptBefore rejects creates and updates which set a column the user cannot write. On update, values
+// which are unchanged or are the masked value sent back by a client are dropped instead.
+func (fpm *FieldPermissionMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
+
+	method := strings.ToLower(req.PlainRequest.Method)
+	if method != "post" && method != "patch" && method != "put" {
+		return objects, nil
+	}
+
+	fieldPermissions := fpm.tableInfoMap[dr.model.GetName()].FieldPermissions
+	if len(fieldPermissions) == 0 {
+		return objects, nil
+	}
+
+	ctx := fpm.accessContext(dr, req)
+
+	for _, obj := range objects {
+
+		var existing map[string]interface{}
+		referenceId, _ := obj["reference_id"].(string)
+		if method != "post" && referenceId != "" {
+			existing, _ = dr.GetReferenceIdToObject(dr.model.GetName(), referenceId)
+		}
+
+		isOwner := func() bool {
+			// the user creating a row becomes its owner
+			if method == "post" {
+				return ctx.sessionUser.UserReferenceId != ""
+			}
+			if existing == nil {
+				return false
+			}
+			existing["__type"] = dr.model.GetName()
+			return dr.GetRowPermission(existing).UserId == ctx.sessionUser.UserReferenceId && ctx.sessionUser.UserReferenceId != ""
+		}
+
+		for _, fieldPermission := range fieldPermissions {
+			value, ok := obj[fieldPermission.ColumnName]
+			if !ok || ctx.isAllowed(fieldPermission.WritableBy, isOwner) {
+				continue
+			}
+
+			if existing != nil {
+				current := existing[fieldPermission.ColumnName]
+				if fmt.Sprintf("%v", current) == fmt.Sprintf("%v", value) ||
+					(fieldPermission.MaskShowLast > 0 && current != nil && MaskValue(current, fieldPermission.MaskShowLast) == fmt.Sprintf("%v", value)) {
+					delete(obj, fieldPermission.ColumnName)
+					continue
+				}
+			}
+
+			log.Infof("User [%v] cannot write [%v] on [%v]", ctx.sessionUser.UserReferenceId, fieldPermission.ColumnName, dr.model.GetName())
+			return nil, errors.New(fmt.Sprintf("Not allowed to change %v", fieldPermission.ColumnName))
+		}
+	}
+
+	return objects, nil
+}
+
+func (dr *DbResource) SetFieldPermissions(fieldPermissions []FieldPermission) {
+	dr.fieldPermissions = fieldPermissions
+}
+
+// unreadableColumns are the columns of the table the user cannot read on every row, they are not used to
+// filter or sort rows either. Masked columns and columns readable by the owner only count as unreadable,
+// filtering on them would give away the values.
+func (dr *DbResource) unreadableColumns(req api2go.Request) map[string]bool {
+
+	columns := make(map[string]bool)
+	if len(dr.fieldPermissions) == 0 {
+		return columns
+	}
+
+	ctx := newFieldAccessContext(dr, &req)
+	notOwner := func() bool {
+		return false
+	}
+
+	for _, fieldPermission := range dr.fieldPermissions {
+		if !ctx.isAllowed(fieldPermission.ReadableBy, notOwner) {
+			columns[fieldPermission.ColumnName] = true
+		}
+	}
+
+	return columns
+}
+
+func NewFieldPermissionMiddleware(cmsConfig *CmsConfig) DatabaseRequestInterceptor {
+
+	tableInfoMap := make(map[string]TableInfo)
+
+	for _, tabInfo := range cmsConfig.Tables {
+		tableInfoMap[tabInfo.TableName] = tabInfo
+	}
+
+	return &FieldPermissionMiddleware{
+		tableInfoMap: tableInfoMap,
+	}
+}
@@ -0,0 +1,124 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaskValue(t *testing.T) {
+
+	cases := []struct {
+		value    interface{}
+		showLast int
+		masked   string
+	}{
+		{"DE89370400440532013000", 4, "******************3000"},
+		{"123", 4, "***"},
+		{int64(123456), 2, "****56"},
+	}
+
+	for _, c := range cases {
+		if masked := MaskValue(c.value, c.showLast); masked != c.masked {
+			t.Errorf("MaskValue(%v, %d): expected %v, got %v", c.value, c.showLast, c.masked, masked)
+		}
+	}
+}
+
+// a client reads a row with a masked column and sends it back with another change, the masked value is
+// dropped instead of rejected
+func TestFieldPermissionMaskedValueRoundTrip(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("create table account (id integer primary key, reference_id varchar(40), name varchar(100), iban varchar(40))")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	_, err = db.Exec("insert into account (reference_id, name, iban) values ('account-1', 'savings', 'DE89370400440532013000')")
+	if err != nil {
+		t.Fatalf("Failed to create row: %v", err)
+	}
+
+	columns := []api2go.ColumnInfo{
+		{Name: "name", ColumnName: "name", ColumnType: "label", DataType: "varchar(100)"},
+		{Name: "iban", ColumnName: "iban", ColumnType: "label", DataType: "varchar(40)"},
+	}
+	dr := &DbResource{
+		db:    db,
+		model: api2go.NewApi2GoModel("account", columns, 0, nil),
+		cruds: make(map[string]*DbResource),
+	}
+	dr.cruds["account"] = dr
+
+	fieldPermissions := []FieldPermission{
+		{
+			ColumnName:   "iban",
+			ReadableBy:   []string{"role:finance"},
+			WritableBy:   []string{"role:finance"},
+			MaskShowLast: 4,
+		},
+	}
+	middleware := NewFieldPermissionMiddleware(&CmsConfig{
+		Tables: []TableInfo{
+			{TableName: "account", Columns: columns, FieldPermissions: fieldPermissions},
+		},
+	})
+
+	req := api2go.Request{
+		PlainRequest: httptest.NewRequest("GET", "/api/account/account-1", nil),
+	}
+	read, err := middleware.InterceptAfter(dr, &req, []map[string]interface{}{
+		{"__type": "account", "reference_id": "account-1", "name": "savings", "iban": "DE89370400440532013000"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	masked := read[0]["iban"]
+	if masked != "******************3000" {
+		t.Fatalf("Expected the iban to be masked, got %v", masked)
+	}
+
+	req = api2go.Request{
+		PlainRequest: httptest.NewRequest("PATCH", "/api/account/account-1", nil),
+	}
+	updated, err := middleware.InterceptBefore(dr, &req, []map[string]interface{}{
+		{"reference_id": "account-1", "name": "checking", "iban": masked},
+	})
+	if err != nil {
+		t.Fatalf("The masked value sent back should be dropped, got %v", err)
+	}
+	if _, ok := updated[0]["iban"]; ok || updated[0]["name"] != "checking" {
+		t.Errorf("Expected only the name to be changed, got %v", updated[0])
+	}
+
+	_, err = middleware.InterceptBefore(dr, &req, []map[string]interface{}{
+		{"reference_id": "account-1", "iban": "GB29NWBK60161331926819"},
+	})
+	if err == nil {
+		t.Errorf("A new value of a column the user cannot write should be rejected")
+	}
+}
+
+func TestFieldPermissionNone(t *testing.T) {
+
+	owner := func() bool {
+		return true
+	}
+
+	admin := fieldAccessContext{isAdmin: true}
+	if !admin.isAllowed([]string{"role:finance"}, owner) {
+		t.Errorf("The administrator should be allowed")
+	}
+	if admin.isAllowed([]string{"none"}, owner) {
+		t.Errorf("Nobody should be allowed a column kept by the server, not even the administrator")
+	}
+	if (fieldAccessContext{}).isAllowed([]string{"owner", "none"}, owner) {
+		t.Errorf("The owner should not be allowed a column kept by the server")
+	}
+}
@@ -34,6 +34,9 @@ func (pc *ObjectAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api
 
 	notIncludedMapCache := make(map[string]bool)
 	includedMapCache := make(map[string]bool)
+	roles := dr.GetRolePermissions(sessionUser)
+	tableName := dr.model.GetName()
+	policyApplied := req.PlainRequest.Context().Value("row_policy_applied") == true
 
 	for _, result := range results {
 		//log.Infof("Result: %v", result)
@@ -52,17 +55,24 @@ func (pc *ObjectAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api
 			continue
 		}
 
+		// rows selected through the row security policy of the table are readable by definition
+		if policyApplied && req.PlainRequest.Method == "GET" && result["__type"] == tableName {
+			returnMap = append(returnMap, result)
+			includedMapCache[referenceId] = true
+			continue
+		}
+
 		permission := dr.GetRowPermission(result)
 		//log.Infof("Row Permission for [%v] for [%v]", permission, result)
 
 		if req.PlainRequest.Method == "GET" {
-			if permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+			if permission.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.ReadStrict) {
 				returnMap = append(returnMap, result)
 				includedMapCache[referenceId] = true
 			} else {
 				notIncludedMapCache[referenceId] = true
 			}
-		} else if permission.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+		} else if permission.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.Peek) {
 			returnMap = append(returnMap, result)
 			includedMapCache[referenceId] = true
 		} else {
@@ -96,6 +106,8 @@ func (pc *ObjectAccessPermissionChecker) InterceptBefore(dr *DbResource, req *ap
 
 	notIncludedMapCache := make(map[string]bool)
 	includedMapCache := make(map[string]bool)
+	roles := dr.GetRolePermissions(sessionUser)
+	tableName := dr.model.GetName()
 
 	for _, result := range results {
 		//log.Infof("Result: %v", result)
@@ -127,7 +139,7 @@ func (pc *ObjectAccessPermissionChecker) InterceptBefore(dr *DbResource, req *ap
 		//log.Infof("Row Permission for [%v] for [%v]", permission, result)
 
 		if req.PlainRequest.Method == "GET" {
-			if permission.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+			if permission.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.Peek) {
 				returnMap = append(returnMap, result)
 				includedMapCache[referenceId] = true
 			} else {
@@ -136,7 +148,7 @@ func (pc *ObjectAccessPermissionChecker) InterceptBefore(dr *DbResource, req *ap
 
 			}
 		} else if req.PlainRequest.Method == "PUT" || req.PlainRequest.Method == "PATCH" {
-			if permission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) {
+			if permission.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.UpdateStrict) {
 				returnMap = append(returnMap, result)
 				includedMapCache[referenceId] = true
 			} else {
@@ -144,7 +156,7 @@ func (pc *ObjectAccessPermissionChecker) InterceptBefore(dr *DbResource, req *ap
 				notIncludedMapCache[referenceId] = true
 			}
 		} else if req.PlainRequest.Method == "DELETE" {
-			if permission.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) {
+			if permission.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.DeleteStrict) {
 				returnMap = append(returnMap, result)
 				includedMapCache[referenceId] = true
 			} else {
@@ -35,10 +35,12 @@ func (pc *TableAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api2
 	}
 
 	tableOwnership := dr.GetObjectPermissionByWhereClause("world", "table_name", dr.model.GetName())
+	roles := dr.GetRolePermissions(sessionUser)
+	tableName := dr.model.GetName()
 
 	//log.Infof("Row Permission for [%v] for [%v]", permission, result)
 	if req.PlainRequest.Method == "GET" {
-		if tableOwnership.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+		if tableOwnership.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.ReadStrict) {
 			//returnMap = append(returnMap, result)
 			//includedMapCache[referenceId] = true
 			return results, nil
@@ -46,7 +48,7 @@ func (pc *TableAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api2
 			//notIncludedMapCache[referenceId] = true
 			return nil, ErrUnauthorized
 		}
-	} else if tableOwnership.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+	} else if tableOwnership.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) || roles.CanOnTable(tableName, auth.Peek) {
 		//log.Infof("[TableAccessPermissionChecker] Result not to be included: %v", result["reference_id"])
 		//returnMap = append(returnMap, result)
 		//includedMapCache[referenceId] = true
@@ -76,24 +78,26 @@ func (pc *TableAccessPermissionChecker) InterceptBefore(dr *DbResource, req *api
 	}
 
 	tableOwnership := dr.GetObjectPermissionByWhereClause("world", "table_name", dr.model.GetName())
+	roles := dr.GetRolePermissions(sessionUser)
+	tableName := dr.model.GetName()
 
 	//log.Infof("[TableAccessPermissionChecker] PermissionInstance check for type: [%v] on [%v] @%v", req.PlainRequest.Method, dr.model.GetName(), tableOwnership.PermissionInstance)
 	if req.PlainRequest.Method == "GET" {
-		if !tableOwnership.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+		if !tableOwnership.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) && !roles.CanOnTable(tableName, auth.Peek) {
 			return nil, ErrUnauthorized
 		}
 	} else if req.PlainRequest.Method == "PUT" || req.PlainRequest.Method == "PATCH" {
-		if !tableOwnership.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) {
+		if !tableOwnership.CanUpdate(sessionUser.UserReferenceId, sessionUser.Groups) && !roles.CanOnTable(tableName, auth.UpdateStrict) {
 			return nil, ErrUnauthorized
 
 		}
 	} else if req.PlainRequest.Method == "POST" {
-		if !tableOwnership.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups) {
+		if !tableOwnership.CanCreate(sessionUser.UserReferenceId, sessionUser.Groups) && !roles.CanOnTable(tableName, auth.CreateStrict) {
 			return nil, ErrUnauthorized
 
 		}
 	} else if req.PlainRequest.Method == "DELETE" {
-		if !tableOwnership.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) {
+		if !tableOwnership.CanDelete(sessionUser.UserReferenceId, sessionUser.Groups) && !roles.CanOnTable(tableName, auth.DeleteStrict) {
 			return nil, ErrUnauthorized
 
 		}
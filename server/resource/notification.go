@@ -0,0 +1,24 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/satori/go.uuid"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"time"
+)
+
+// NotifyUser leaves a notification for the user, owned by them so only they (and the administrator) can
+// read it. Work done in the background, like scheduled actions, reports to its owner this way.
+func (dr *DbResource) NotifyUser(userId int64, kind string, title string, message string) error {
+
+	s, v, err := squirrel.Insert("notification").
+		Columns("reference_id", "permission", "created_at", "user_id", "kind", "title", "message", "is_read").
+		Values(uuid.NewV4().String(), auth.NewPermission(auth.None, auth.None, auth.Update|auth.Delete).IntValue(),
+			time.Now(), userId, kind, title, message, false).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
@@ -0,0 +1,387 @@
+package resource
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// number of single use recovery codes handed out when a user enrolls for two factor authentication
+const OtpRecoveryCodeCount = 10
+
+// the challenge token issued after a successful password check is only valid for this long
+const OtpChallengeValidity = 5 * time.Minute
+
+const otpChallengeTokenType = "otp-challenge"
+
+// totp codes change every OtpPeriod seconds, a code of the period before or after is accepted for clock drift
+const OtpPeriod = 30
+const otpSkew = 1
+
+// challenge tokens are signed with a key derived from the jwt secret, so that the jwt middleware
+// never accepts a half finished sign in as a logged in user
+func otpChallengeSecret(secret []byte) []byte {
+	return append(append([]byte{}, secret...), []byte(".otp.challenge")...)
+}
+
+func GenerateOtpKey(email string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      "daptin",
+		AccountName: email,
+	})
+}
+
+// OtpKeyUrl is the otpauth uri of a secret, which authenticator apps read from a qr code
+func OtpKeyUrl(email string, secret string) string {
+
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", "daptin")
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/daptin:" + email,
+		RawQuery: values.Encode(),
+	}
+
+	return u.String()
+}
+
+// OtpCodeStep returns the time step the code was generated for, the number of periods since the epoch, if
+// it is a code of the secret for now or a neighbouring period
+func OtpCodeStep(code string, secret string, now time.Time) (int64, bool) {
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return 0, false
+	}
+
+	current := now.Unix() / OtpPeriod
+	for step := current - otpSkew; step <= current+otpSkew; step++ {
+		expected, err := totp.GenerateCode(secret, time.Unix(step*OtpPeriod, 0))
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// ValidateOtpCode checks a totp code of the secret of the user. A code is accepted only once: the time step
+// of the last accepted code is stored, and codes of that step or earlier are refused.
+func (dr *DbResource) ValidateOtpCode(userId int64, secret string, code string) bool {
+
+	step, ok := OtpCodeStep(code, secret, time.Now())
+	if !ok {
+		return false
+	}
+
+	s, v, err := squirrel.Update("user").
+		Set("otp_last_step", step).
+		Where(squirrel.Eq{"id": userId}).
+		Where(squirrel.Or{
+			squirrel.Eq{"otp_last_step": nil},
+			squirrel.Expr("otp_last_step < ?", step),
+		}).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create otp step update query: %v", err)
+		return false
+	}
+
+	res, err := dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to store otp step for user [%v]: %v", userId, err)
+		return false
+	}
+
+	count, _ := res.RowsAffected()
+	if count != 1 {
+		log.Infof("Otp code of user [%v] was already used", userId)
+		return false
+	}
+	return true
+}
+
+// GenerateRecoveryCodes returns the plain text codes to be shown once to the user, and a json list
+// of their bcrypt hashes which is stored against the user
+func GenerateRecoveryCodes(count int) ([]string, string, error) {
+
+	codes := make([]string, 0)
+	hashes := make([]string, 0)
+
+	for i := 0; i < count; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, "", err
+		}
+		code := hex.EncodeToString(b)
+		code = code[0:5] + "-" + code[5:]
+
+		hash, err := BcryptHashString(code)
+		if err != nil {
+			return nil, "", err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+
+	hashJson, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return codes, string(hashJson), nil
+}
+
+func NewOtpChallengeToken(email string, secret []byte) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"email": email,
+		"typ":   otpChallengeTokenType,
+		"nbf":   time.Now().Unix(),
+		"exp":   time.Now().Add(OtpChallengeValidity).Unix(),
+		"iss":   "daptin",
+	})
+	return token.SignedString(otpChallengeSecret(secret))
+}
+
+// ParseOtpChallengeToken validates a challenge token issued by NewOtpChallengeToken and returns the email it was issued for
+func ParseOtpChallengeToken(tokenString string, secret []byte) (string, error) {
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return otpChallengeSecret(secret), nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["typ"] != otpChallengeTokenType {
+		return "", errors.New("Invalid challenge")
+	}
+
+	email, ok := claims["email"].(string)
+	if !ok || email == "" {
+		return "", errors.New("Invalid challenge")
+	}
+
+	return email, nil
+}
+
+func IsTruthy(val interface{}) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v == "1" || strings.ToLower(v) == "true"
+	case []uint8:
+		s := string(v)
+		return s == "1" || strings.ToLower(s) == "true"
+	}
+	return false
+}
+
+// StoreOtpSecret saves the encrypted totp secret and hashed recovery codes for the user. The secret
+// is not used at sign in until SetOtpEnabled is called after the user has proved they can generate codes
+func (dr *DbResource) StoreOtpSecret(userId int64, secret string, recoveryCodesJson string) error {
+
+	encryptionSecret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return err
+	}
+
+	encryptedSecret, err := Encrypt([]byte(encryptionSecret), secret)
+	if err != nil {
+		return err
+	}
+
+	var recoveryCodes interface{}
+	if recoveryCodesJson != "" {
+		recoveryCodes = recoveryCodesJson
+	}
+
+	s, v, err := squirrel.Update("user").
+		Set("otp_secret", encryptedSecret).
+		Set("otp_recovery_codes", recoveryCodes).
+		Set("otp_enabled", false).
+		Where(squirrel.Eq{"id": userId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
+
+// StoreOtpRecoveryCodes replaces the hashed recovery codes of the user
+func (dr *DbResource) StoreOtpRecoveryCodes(userId int64, recoveryCodesJson string) error {
+
+	s, v, err := squirrel.Update("user").
+		Set("otp_recovery_codes", recoveryCodesJson).
+		Where(squirrel.Eq{"id": userId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
+
+func (dr *DbResource) SetOtpEnabled(userId int64, enabled bool) error {
+
+	builder := squirrel.Update("user").Set("otp_enabled", enabled)
+	if !enabled {
+		builder = builder.Set("otp_secret", nil).Set("otp_recovery_codes", nil)
+	}
+
+	s, v, err := builder.Where(squirrel.Eq{"id": userId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
+
+func (dr *DbResource) GetOtpSecret(user map[string]interface{}) (string, error) {
+
+	encryptedSecret, ok := user["otp_secret"].(string)
+	if !ok || encryptedSecret == "" {
+		return "", errors.New("Two factor authentication is not set up")
+	}
+
+	encryptionSecret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return "", err
+	}
+
+	return Decrypt([]byte(encryptionSecret), encryptedSecret)
+}
+
+// IsOtpRequiredForUser is true if the user belongs to any usergroup which has requires_2fa set
+func (dr *DbResource) IsOtpRequiredForUser(userId int64) bool {
+
+	s, v, err := squirrel.Select("count(*)").
+		From("usergroup ug").
+		Join("user_user_id_has_usergroup_usergroup_id uug on uug.usergroup_id = ug.id").
+		Where(squirrel.Eq{"uug.user_id": userId}).
+		Where(squirrel.Eq{"ug.requires_2fa": true}).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create otp requirement query: %v", err)
+		return false
+	}
+
+	var count int
+	err = dr.db.QueryRowx(s, v...).Scan(&count)
+	if err != nil {
+		log.Errorf("Failed to check otp requirement for user [%v]: %v", userId, err)
+		return false
+	}
+
+	return count > 0
+}
+
+// VerifyOtpCode checks the code against the users totp secret, and falls back to the recovery
+// codes. A recovery code which matched is removed so it cannot be used again.
+func (dr *DbResource) VerifyOtpCode(user map[string]interface{}, code string) bool {
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false
+	}
+
+	secret, err := dr.GetOtpSecret(user)
+	if err != nil {
+		log.Errorf("Failed to get otp secret for [%v]: %v", user["email"], err)
+		return false
+	}
+
+	if dr.ValidateOtpCode(user["id"].(int64), secret, code) {
+		return true
+	}
+
+	recoveryCodesJson, ok := user["otp_recovery_codes"].(string)
+	if !ok || recoveryCodesJson == "" {
+		return false
+	}
+
+	var hashes []string
+	err = json.Unmarshal([]byte(recoveryCodesJson), &hashes)
+	if err != nil {
+		log.Errorf("Failed to read recovery codes for [%v]: %v", user["email"], err)
+		return false
+	}
+
+	for i, hash := range hashes {
+		if !BcryptCheckStringHash(code, hash) {
+			continue
+		}
+
+		remaining := append(hashes[:i], hashes[i+1:]...)
+		remainingJson, err := json.Marshal(remaining)
+		if err != nil {
+			return false
+		}
+
+		s, v, err := squirrel.Update("user").
+			Set("otp_recovery_codes", string(remainingJson)).
+			Where(squirrel.Eq{"id": user["id"]}).ToSql()
+		if err != nil {
+			return false
+		}
+
+		_, err = dr.db.Exec(s, v...)
+		if err != nil {
+			log.Errorf("Failed to remove used recovery code: %v", err)
+			return false
+		}
+		log.Infof("Recovery code used by [%v], %d remaining", user["email"], len(remaining))
+		return true
+	}
+
+	return false
+}
+
+// NewOtpEnrollmentResponse carries everything the client needs to show to set up an authenticator app.
+// The recovery codes are never retrievable again after this response. Empty values are left out, the
+// recovery codes of an enrollment started at sign in are only handed out once it is verified.
+func NewOtpEnrollmentResponse(uri string, secret string, recoveryCodes []string) ActionResponse {
+
+	responseAttrs := make(map[string]interface{})
+	if uri != "" {
+		responseAttrs["uri"] = uri
+	}
+	if secret != "" {
+		responseAttrs["secret"] = secret
+	}
+	if len(recoveryCodes) > 0 {
+		responseAttrs["recovery_codes"] = recoveryCodes
+	}
+
+	return NewActionResponse("otp.enrollment", responseAttrs)
+}
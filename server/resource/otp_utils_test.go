@@ -0,0 +1,140 @@
+package resource
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pquerna/otp/totp"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testOtpSecret = "JBSWY3DPEHPK3PXP"
+
+func TestOtpCodeStep(t *testing.T) {
+
+	now := time.Unix(1500000000, 0)
+	current := now.Unix() / OtpPeriod
+
+	cases := []struct {
+		at   time.Time
+		step int64
+		ok   bool
+	}{
+		{now, current, true},
+		{now.Add(-OtpPeriod * time.Second), current - 1, true},
+		{now.Add(OtpPeriod * time.Second), current + 1, true},
+		{now.Add(-3 * OtpPeriod * time.Second), 0, false},
+	}
+
+	for _, c := range cases {
+		code, err := totp.GenerateCode(testOtpSecret, c.at)
+		if err != nil {
+			t.Fatalf("Failed to generate code: %v", err)
+		}
+		step, ok := OtpCodeStep(code, testOtpSecret, now)
+		if ok != c.ok || step != c.step {
+			t.Errorf("Code of %v: expected step %v %v, got %v %v", c.at, c.step, c.ok, step, ok)
+		}
+	}
+
+	if _, ok := OtpCodeStep("", testOtpSecret, now); ok {
+		t.Errorf("An empty code should not be accepted")
+	}
+}
+
+func TestValidateOtpCodeOnlyOnce(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("create table user (id integer primary key, otp_last_step integer)")
+	if err != nil {
+		t.Fatalf("Failed to create user table: %v", err)
+	}
+	_, err = db.Exec("insert into user (id) values (1)")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	dr := &DbResource{db: db}
+
+	code, err := totp.GenerateCode(testOtpSecret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+
+	if !dr.ValidateOtpCode(1, testOtpSecret, code) {
+		t.Fatalf("A fresh code should be accepted")
+	}
+	if dr.ValidateOtpCode(1, testOtpSecret, code) {
+		t.Errorf("A code should not be accepted twice")
+	}
+
+	earlier, _ := totp.GenerateCode(testOtpSecret, time.Now().Add(-OtpPeriod*time.Second))
+	if earlier != code && dr.ValidateOtpCode(1, testOtpSecret, earlier) {
+		t.Errorf("A code older than the last accepted one should not be accepted")
+	}
+}
+
+func TestOtpKeyUrl(t *testing.T) {
+
+	parsed, err := url.Parse(OtpKeyUrl("user@example.com", testOtpSecret))
+	if err != nil {
+		t.Fatalf("Invalid url: %v", err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/daptin:user@example.com" {
+		t.Errorf("Unexpected url %v", parsed)
+	}
+	if parsed.Query().Get("secret") != testOtpSecret || parsed.Query().Get("issuer") != "daptin" {
+		t.Errorf("Unexpected parameters %v", parsed.RawQuery)
+	}
+}
+
+func TestOtpChallengeToken(t *testing.T) {
+
+	secret := []byte("jwt secret")
+
+	challenge, err := NewOtpChallengeToken("user@example.com", secret)
+	if err != nil {
+		t.Fatalf("Failed to create challenge: %v", err)
+	}
+
+	email, err := ParseOtpChallengeToken(challenge, secret)
+	if err != nil || email != "user@example.com" {
+		t.Errorf("Expected the email of the challenge, got %v %v", email, err)
+	}
+
+	if _, err = ParseOtpChallengeToken(challenge, []byte("other secret")); err == nil {
+		t.Errorf("A challenge signed with another secret should not be accepted")
+	}
+
+	token, err := CreateJwtToken(map[string]interface{}{"email": "user@example.com", "name": "user"}, secret)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if _, err = ParseOtpChallengeToken(token, secret); err == nil {
+		t.Errorf("A session token should not be accepted as a challenge")
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+
+	codes, hashJson, err := GenerateRecoveryCodes(3)
+	if err != nil {
+		t.Fatalf("Failed to generate codes: %v", err)
+	}
+	if len(codes) != 3 {
+		t.Fatalf("Expected 3 codes, got %v", codes)
+	}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected code format %v", code)
+		}
+	}
+	if hashJson == "" || hashJson == "[]" {
+		t.Errorf("Expected the hashes of the codes")
+	}
+}
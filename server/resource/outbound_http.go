@@ -0,0 +1,178 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/go-playground/validator.v9"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrDisallowedAddress is returned for urls which point into the network daptin runs in. Users can set the
+// urls daptin posts to, so without this check they could reach services which are not public.
+var ErrDisallowedAddress = errors.New("Address is not a public address")
+
+var disallowedNetworks = parseNetworks(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier grade nat
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link local, cloud metadata services live here
+	"172.16.0.0/12",  // private
+	"192.168.0.0/16", // private
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link local
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// OutboundAllowedNetworksConfig is the _config entry with the networks daptin may post to even though they are
+// not public, like a service next to it in a private network. It is a comma separated list like
+// "10.1.0.0/16, 192.168.1.20/32", empty by default.
+const OutboundAllowedNetworksConfig = "webhook.allowed_networks"
+
+var allowedNetworks []*net.IPNet
+var allowedNetworksLock sync.RWMutex
+
+// CheckOutboundAllowedNetworks loads the allowed networks at startup, adding the empty setting to _config
+// when it is missing so it can be seen and edited there
+func CheckOutboundAllowedNetworks(store *ConfigStore) {
+	value, err := store.GetConfigValueFor(OutboundAllowedNetworksConfig, "backend")
+	if err != nil {
+		err = store.SetConfigValueFor(OutboundAllowedNetworksConfig, "", "backend")
+		CheckErr(err, "Failed to store default value for [%v]", OutboundAllowedNetworksConfig)
+	}
+	SetOutboundAllowedNetworks(value)
+}
+
+// SetOutboundAllowedNetworks replaces the allowed networks, invalid entries are logged and left out
+func SetOutboundAllowedNetworks(value string) {
+
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Errorf("Ignoring invalid network [%v] in %v: %v", cidr, OutboundAllowedNetworksConfig, err)
+			continue
+		}
+		log.Infof("Allowing outbound requests to %v", network)
+		networks = append(networks, network)
+	}
+
+	allowedNetworksLock.Lock()
+	allowedNetworks = networks
+	allowedNetworksLock.Unlock()
+}
+
+func isAllowedIp(ip net.IP) bool {
+	allowedNetworksLock.RLock()
+	defer allowedNetworksLock.RUnlock()
+	for _, network := range allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisallowedIp is true for loopback, private, link local and other addresses which are not public, unless
+// they are in the allowed networks
+func IsDisallowedIp(ip net.IP) bool {
+	if ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	if isAllowedIp(ip) {
+		return false
+	}
+	for _, network := range disallowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOutboundUrl checks that the url is http or https and that every address its host resolves to is
+// public. The addresses are checked again when connecting, the name can resolve differently by then.
+func CheckOutboundUrl(rawUrl string) error {
+
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("Url should be http or https")
+	}
+
+	host := parsed.Hostname()
+	if host == "" {
+		return fmt.Errorf("Url has no host")
+	}
+
+	ips, err := net.LookupIP(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if IsDisallowedIp(ip) {
+			return ErrDisallowedAddress
+		}
+	}
+	return nil
+}
+
+// ValidatePublicUrl is the "public_url" validation, for urls daptin posts to
+func ValidatePublicUrl(fl validator.FieldLevel) bool {
+	return CheckOutboundUrl(fl.Field().String()) == nil
+}
+
+// NewOutboundHttpClient is a client for requests to urls set by users. Every connection, including those
+// of redirects, is checked against the address actually dialed, so a name resolving to a public address
+// when it was checked and to a private one when it is used is still refused.
+func NewOutboundHttpClient(timeout time.Duration) *http.Client {
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || IsDisallowedIp(ip) {
+				return ErrDisallowedAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
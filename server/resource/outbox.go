@@ -0,0 +1,136 @@
+package resource
+
+import (
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// OutboxWorker runs the work queued in a table in the background, like webhook deliveries, exchange
+// deliveries and jobs. Workers goroutines run Next until nothing is due and then wait PollInterval, so a
+// slow item holds up one worker only. Another goroutine runs Requeue every RequeueInterval, to queue again
+// the work claimed by an instance which stopped.
+type OutboxWorker struct {
+	Name            string
+	Workers         int
+	PollInterval    time.Duration
+	RequeueInterval time.Duration
+	// Next claims and runs the next due item, it returns false when there is none
+	Next    func() bool
+	Requeue func()
+}
+
+func (ow OutboxWorker) Start() {
+	log.Infof("Starting %d %v workers", ow.Workers, ow.Name)
+	for i := 0; i < ow.Workers; i++ {
+		go func() {
+			for {
+				for ow.Next() {
+				}
+				time.Sleep(ow.PollInterval)
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			ow.Requeue()
+			time.Sleep(ow.RequeueInterval)
+		}
+	}()
+}
+
+// deliveryTable is a table of deliveries, like webhook_delivery and exchange_outbox, with the status,
+// attempts, next_attempt_at, response_code, response_body, last_error and delivered_at columns. Deliveries
+// are claimed with a conditional update, so several instances can share them, and a failed delivery is
+// tried again after retryBackoff, doubled on every attempt up to maxRetryBackoff.
+type deliveryTable struct {
+	name            string
+	maxAttempts     int
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+	// a delivery still sending after this long was abandoned by an instance which stopped
+	sendTimeout time.Duration
+}
+
+func (dt deliveryTable) requeueAbandoned(db *sqlx.DB) {
+
+	s, v, err := squirrel.Update(dt.name).
+		Set("status", DeliveryPending).
+		Where(squirrel.Eq{"status": DeliverySending}).
+		Where(squirrel.Expr("next_attempt_at < ?", time.Now().Add(-dt.sendTimeout))).ToSql()
+	CheckErr(err, "Failed to create abandoned delivery update query")
+
+	_, err = db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to queue abandoned deliveries of [%v] again: %v", dt.name, err)
+	}
+}
+
+// claim marks the delivery as sending, it is false when another instance got it first
+func (dt deliveryTable) claim(db *sqlx.DB, id int64, attempts int) (bool, error) {
+
+	s, v, err := squirrel.Update(dt.name).
+		Set("status", DeliverySending).
+		Set("next_attempt_at", time.Now()).
+		Set("attempts", attempts+1).
+		Where(squirrel.Eq{"id": id}).
+		Where(squirrel.Eq{"status": DeliveryPending}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.Exec(s, v...)
+	if err != nil {
+		return false, err
+	}
+	count, _ := res.RowsAffected()
+	return count == 1, nil
+}
+
+// finish stores the outcome of an attempt, the delivery is queued again after a failure until it has failed
+// maxAttempts times. It returns true when the delivery failed for good.
+func (dt deliveryTable) finish(db *sqlx.DB, id int64, description string, attempts int, statusCode interface{}, responseBody interface{}, deliveryErr error) bool {
+
+	failed := false
+	update := squirrel.Update(dt.name).
+		Set("response_code", statusCode).
+		Set("response_body", responseBody)
+
+	if deliveryErr == nil {
+		update = update.Set("status", DeliveryDelivered).
+			Set("delivered_at", time.Now()).
+			Set("last_error", nil)
+	} else if attempts < dt.maxAttempts {
+		backoff := dt.retryBackoff << uint(attempts-1)
+		if backoff > dt.maxRetryBackoff {
+			backoff = dt.maxRetryBackoff
+		}
+		log.Infof("%v failed, attempt %d of %d, trying again in %v: %v", description, attempts, dt.maxAttempts, backoff, deliveryErr)
+		update = update.Set("status", DeliveryPending).
+			Set("next_attempt_at", time.Now().Add(backoff)).
+			Set("last_error", deliveryErr.Error())
+	} else {
+		log.Errorf("%v failed after %d attempts: %v", description, attempts, deliveryErr)
+		failed = true
+		update = update.Set("status", DeliveryFailed).
+			Set("last_error", deliveryErr.Error())
+	}
+
+	s, v, err := update.Where(squirrel.Eq{"id": id}).ToSql()
+	CheckErr(err, "Failed to create delivery update query")
+	_, err = db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to store outcome of %v: %v", description, err)
+	}
+
+	return failed
+}
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"errors"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTableAttempts(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("create table delivery (id integer primary key, status varchar(20), attempts int, next_attempt_at timestamp," +
+		" response_code int, response_body text, last_error text, delivered_at timestamp)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	_, err = db.Exec("insert into delivery (id, status, attempts, next_attempt_at) values (1, ?, 0, ?)", DeliveryPending, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to insert delivery: %v", err)
+	}
+
+	deliveries := deliveryTable{
+		name:            "delivery",
+		maxAttempts:     2,
+		retryBackoff:    time.Minute,
+		maxRetryBackoff: time.Hour,
+		sendTimeout:     time.Minute,
+	}
+
+	status := func() (string, int) {
+		var status string
+		var attempts int
+		err := db.QueryRowx("select status, attempts from delivery where id = 1").Scan(&status, &attempts)
+		if err != nil {
+			t.Fatalf("Failed to read delivery: %v", err)
+		}
+		return status, attempts
+	}
+
+	claimed, err := deliveries.claim(db, 1, 0)
+	if err != nil || !claimed {
+		t.Fatalf("Expected the delivery to be claimed, got %v %v", claimed, err)
+	}
+	claimed, _ = deliveries.claim(db, 1, 0)
+	if claimed {
+		t.Errorf("A delivery being sent should not be claimed again")
+	}
+
+	if deliveries.finish(db, 1, "Delivery [1]", 1, 500, "error", errors.New("Responded with 500")) {
+		t.Errorf("The first failure should be tried again")
+	}
+	if state, attempts := status(); state != DeliveryPending || attempts != 1 {
+		t.Errorf("Expected a pending delivery after one attempt, got %v %d", state, attempts)
+	}
+
+	// abandoned while sending, it is queued again
+	_, err = db.Exec("update delivery set status = ?, next_attempt_at = ? where id = 1", DeliverySending, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to update delivery: %v", err)
+	}
+	deliveries.requeueAbandoned(db)
+	if state, _ := status(); state != DeliveryPending {
+		t.Errorf("An abandoned delivery should be pending again, got %v", state)
+	}
+
+	deliveries.claim(db, 1, 1)
+	if !deliveries.finish(db, 1, "Delivery [1]", 2, 500, "error", errors.New("Responded with 500")) {
+		t.Errorf("The delivery should have failed for good after the last attempt")
+	}
+	if state, attempts := status(); state != DeliveryFailed || attempts != 2 {
+		t.Errorf("Expected a failed delivery after two attempts, got %v %d", state, attempts)
+	}
+}
@@ -81,6 +81,19 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 			continue
 		}
 
+		if col.ColumnName == TenantColumnName && dr.tenantScoped {
+			continue
+		}
+
+		// a user signing up on a tenant belongs to that tenant
+		if col.ColumnName == TenantColumnName && dr.model.GetName() == "user" {
+			if tenant := TenantFromRequest(req); tenant != nil {
+				colsList = append(colsList, TenantColumnName)
+				valsList = append(valsList, tenant.Id)
+				continue
+			}
+		}
+
 		//log.Infof("Check column: %v", col.ColumnName)
 
 		val, ok := attrs[col.ColumnName]
@@ -101,6 +114,10 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 			if valString == "" {
 				uId = nil
 			} else {
+				if !dr.isReferableInTenant(col.ForeignKeyData.TableName, valString, req) {
+					return nil, fmt.Errorf("No such object [%v][%v]", col.ForeignKeyData.TableName, valString)
+				}
+
 				foreignObject, err := dr.GetReferenceIdToObject(col.ForeignKeyData.TableName, valString)
 				if err != nil {
 					return nil, err
@@ -202,10 +219,25 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 	colsList = append(colsList, "created_at")
 	valsList = append(valsList, time.Now())
 
+	// the tenant of a row is always the tenant of the request which created it
+	if dr.tenantScoped {
+		colsList = append(colsList, TenantColumnName)
+		if tenant := TenantFromRequest(req); tenant != nil {
+			valsList = append(valsList, tenant.Id)
+		} else {
+			valsList = append(valsList, nil)
+		}
+	}
+
 	if sessionUser.UserId != 0 && dr.model.HasColumn("user_id") && dr.model.GetName() != "user_user_id_has_usergroup_usergroup_id" {
 
 		colsList = append(colsList, "user_id")
-		valsList = append(valsList, sessionUser.UserId)
+		if sessionUser.Impersonator != nil && EndsWithCheck(dr.model.GetName(), "_audit") {
+			// changes made while impersonating are attributed to the administrator
+			valsList = append(valsList, sessionUser.Impersonator.UserId)
+		} else {
+			valsList = append(valsList, sessionUser.UserId)
+		}
 	}
 
 	query, vals, err := squirrel.Insert(dr.model.GetName()).Columns(colsList...).Values(valsList...).ToSql()
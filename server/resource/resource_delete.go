@@ -20,6 +20,12 @@ import (
 func (dr *DbResource) Delete(id string, req api2go.Request) (api2go.Responder, error) {
 
 	log.Infof("Delete [%v][%v]", dr.model.GetTableName(), id)
+
+	tenantWhere := dr.GetTenantWhere(req)
+	if tenantWhere != nil && !dr.IsRowVisibleByPolicy(id, tenantWhere) {
+		return nil, errors.New("Cannot delete this object")
+	}
+
 	for _, bf := range dr.ms.BeforeDelete {
 		//log.Infof("[Before][%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 		r, err := bf.InterceptBefore(dr, &req, []map[string]interface{}{
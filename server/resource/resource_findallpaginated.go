@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"context"
 	"fmt"
 	"github.com/artpar/api2go"
 	log "github.com/sirupsen/logrus"
@@ -22,6 +23,22 @@ func (dr *DbResource) GetTotalCount() uint64 {
 	return count
 }
 
+// GetCountByQuery runs a count(*) select built with the same where clauses as the page query
+func (dr *DbResource) GetCountByQuery(countBuilder squirrel.SelectBuilder) uint64 {
+	s, v, err := countBuilder.ToSql()
+	if err != nil {
+		log.Errorf("Failed to generate count query for %v: %v", dr.model.GetName(), err)
+		return 0
+	}
+
+	var count uint64
+	err = dr.db.QueryRowx(s, v...).Scan(&count)
+	if err != nil {
+		log.Errorf("Failed to count [%v]: %v", dr.model.GetName(), err)
+	}
+	return count
+}
+
 // PaginatedFindAll(req Request) (totalCount uint, response Responder, err error)
 func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, response api2go.Responder, err error) {
 
@@ -109,9 +126,25 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		}
 	}
 
-	queryBuilder := squirrel.Select(finalCols...).From(m.GetTableName()).Offset(pageNumber).Limit(pageSize)
+	// columns, offset and limit are added at the end so the same where clauses are used for the count
+	queryBuilder := squirrel.Select().From(m.GetTableName())
+
+	policyWhere, err := dr.GetRowSecurityWhere(req)
+	if err != nil {
+		log.Errorf("Failed to apply row security policy on [%v]: %v", dr.model.GetName(), err)
+		return 0, nil, err
+	}
+	if policyWhere != nil {
+		queryBuilder = queryBuilder.Where(policyWhere)
+	}
+
+	tenantWhere := dr.GetTenantWhere(req)
+	if tenantWhere != nil {
+		queryBuilder = queryBuilder.Where(tenantWhere)
+	}
 
 	infos := dr.model.GetColumns()
+	unreadableColumns := dr.unreadableColumns(req)
 
 	// todo: fix search in findall operation. currently no way to do an " or " query
 	if len(queries) > 0 {
@@ -120,6 +153,9 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		wheres := make([]interface{}, 0)
 
 		for _, col := range infos {
+			if unreadableColumns[col.ColumnName] {
+				continue
+			}
 			if col.IsIndexed && col.ColumnType == "name" || col.ColumnType == "label" {
 				colsToAdd = append(colsToAdd, col.ColumnName)
 			}
@@ -261,17 +297,29 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		}
 	}
 
+	countBuilder := queryBuilder.Columns("count(*)")
+	queryBuilder = queryBuilder.Columns(finalCols...).Offset(pageNumber).Limit(pageSize)
+
+	columnMap := dr.model.GetColumnMap()
 	for _, so := range sortOrder {
 
 		if len(so) < 1 {
 			continue
 		}
 		//log.Infof("Sort order: %v", so)
+		direction := " asc"
 		if so[0] == '-' {
-			queryBuilder = queryBuilder.OrderBy(prefix + so[1:] + " desc")
-		} else {
-			queryBuilder = queryBuilder.OrderBy(prefix + so + " asc")
+			so = so[1:]
+			direction = " desc"
 		}
+
+		// only the columns the user can read are sorted on
+		col, ok := columnMap[so]
+		if !ok || col.ExcludeFromApi || unreadableColumns[so] {
+			log.Infof("Not sorting [%v] on [%v]", dr.model.GetName(), so)
+			continue
+		}
+		queryBuilder = queryBuilder.OrderBy(prefix + col.ColumnName + direction)
 	}
 
 	sql1, args, err := queryBuilder.ToSql()
@@ -303,11 +351,23 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		return 0, nil, err
 	}
 
+	// a row can refer to rows of other tenants, those are not included
+	for i := range includes {
+		includes[i] = dr.filterIncludesByTenant(req, includes[i])
+	}
+
+	// rows already filtered by the row security policy are marked, so the object permission check does not
+	// filter them again. includes are not covered by the policy and go through the usual checks
+	resultsReq := req
+	if policyWhere != nil {
+		resultsReq.PlainRequest = req.PlainRequest.WithContext(context.WithValue(req.PlainRequest.Context(), "row_policy_applied", true))
+	}
+
 	// todo: handle fetching of usergroups, because world permission
 	for _, bf := range dr.ms.AfterFindAll {
 		//log.Infof("Invoke AfterFindAll [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 
-		results, err = bf.InterceptAfter(dr, &req, results)
+		results, err = bf.InterceptAfter(dr, &resultsReq, results)
 		if err != nil {
 			//log.Errorf("Error from findall paginated create middleware: %v", err)
 			log.Errorf("Error from AfterFindAll[%v] middleware: %v", bf.String(), err)
@@ -353,7 +413,7 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		result = append(result, a)
 	}
 
-	total1 := dr.GetTotalCount()
+	total1 := dr.GetCountByQuery(countBuilder)
 	total := total1
 	if total < pageSize {
 		total = pageSize
@@ -363,7 +423,7 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 	}
 	//log.Infof("Offset, limit: %v, %v", pageNumber, pageSize)
 
-	return uint(dr.GetTotalCount()), NewResponse(nil, result, 200, &api2go.Pagination{
+	return uint(total1), NewResponse(nil, result, 200, &api2go.Pagination{
 		Next:        map[string]string{"limit": fmt.Sprintf("%v", pageSize), "offset": fmt.Sprintf("%v", pageSize+pageNumber)},
 		Prev:        map[string]string{"limit": fmt.Sprintf("%v", pageSize), "offset": fmt.Sprintf("%v", pageNumber-pageSize)},
 		First:       map[string]string{},
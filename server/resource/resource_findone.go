@@ -1,9 +1,11 @@
 package resource
 
 import (
+	"context"
 	"github.com/artpar/api2go"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
 )
 
 // FindOne returns an object by its ID
@@ -29,12 +31,32 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 
 	log.Infof("Find [%s] by id [%s]", dr.model.GetName(), referenceId)
 
+	tenantWhere := dr.GetTenantWhere(req)
+	if tenantWhere != nil && !dr.IsRowVisibleByPolicy(referenceId, tenantWhere) {
+		return nil, errors.New("Cannot find this object")
+	}
+
+	policyWhere, err := dr.GetRowSecurityWhere(req)
+	if err != nil {
+		log.Errorf("Failed to apply row security policy on [%v]: %v", dr.model.GetName(), err)
+		return nil, err
+	}
+
+	resultReq := req
+	if policyWhere != nil {
+		if !dr.IsRowVisibleByPolicy(referenceId, policyWhere) {
+			return nil, errors.New("Cannot find this object")
+		}
+		resultReq.PlainRequest = req.PlainRequest.WithContext(context.WithValue(req.PlainRequest.Context(), "row_policy_applied", true))
+	}
+
 	data, include, err := dr.GetSingleRowByReferenceId(dr.model.GetName(), referenceId)
+	include = dr.filterIncludesByTenant(req, include)
 
 	for _, bf := range dr.ms.AfterFindOne {
 		//log.Infof("Invoke AfterFindOne [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 
-		results, err := bf.InterceptAfter(dr, &req, []map[string]interface{}{data})
+		results, err := bf.InterceptAfter(dr, &resultReq, []map[string]interface{}{data})
 		if len(results) != 0 {
 			data = results[0]
 		} else {
@@ -70,3 +92,24 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 
 	return NewResponse(nil, a, 200, nil), err
 }
+
+// IsRowVisibleByPolicy checks if the row matches the row security policy condition
+func (dr *DbResource) IsRowVisibleByPolicy(referenceId string, policyWhere squirrel.Sqlizer) bool {
+
+	s, v, err := squirrel.Select("count(*)").From(dr.model.GetName()).
+		Where(squirrel.Eq{dr.model.GetName() + ".reference_id": referenceId}).
+		Where(policyWhere).ToSql()
+	if err != nil {
+		log.Errorf("Failed to create row security policy query: %v", err)
+		return false
+	}
+
+	var count int
+	err = dr.db.QueryRowx(s, v...).Scan(&count)
+	if err != nil {
+		log.Errorf("Failed to check row security policy on [%v]: %v", dr.model.GetName(), err)
+		return false
+	}
+
+	return count > 0
+}
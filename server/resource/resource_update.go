@@ -21,6 +21,16 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 	data, ok := obj.(*api2go.Api2GoModel)
 	log.Infof("Update object request: [%v]", dr.model.GetTableName(), data.GetID())
 
+	tenantWhere := dr.GetTenantWhere(req)
+	if tenantWhere != nil && !dr.IsRowVisibleByPolicy(data.GetID(), tenantWhere) {
+		return nil, errors.New("Cannot find this object")
+	}
+
+	// the middlewares look up the row being changed by its reference id
+	if _, ok := data.Data["reference_id"]; !ok && data.GetID() != "" {
+		data.Data["reference_id"] = data.GetID()
+	}
+
 	for _, bf := range dr.ms.BeforeUpdate {
 		//log.Infof("Invoke BeforeUpdate [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 
@@ -95,6 +105,11 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 				continue
 			}
 
+			// rows stay with the tenant they were created in
+			if col.ColumnName == TenantColumnName && dr.tenantScoped {
+				continue
+			}
+
 			change, ok := allChanges[col.ColumnName]
 			if !ok {
 				continue
@@ -112,6 +127,10 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 
 					valString := val.(string)
 
+					if !dr.isReferableInTenant(col.ForeignKeyData.TableName, valString, req) {
+						return nil, fmt.Errorf("No such object [%v][%v]", col.ForeignKeyData.TableName, valString)
+					}
+
 					foreignObject, err := dr.GetReferenceIdToObject(col.ForeignKeyData.TableName, valString)
 					if err != nil {
 						return nil, err
@@ -234,7 +253,7 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 				pr := &http.Request{
 					Method: "POST",
 				}
-				pr = pr.WithContext(req.Context)
+				pr = pr.WithContext(req.PlainRequest.Context())
 				auditCreateRequest := api2go.Request{
 					PlainRequest: pr,
 				}
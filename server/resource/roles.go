@@ -0,0 +1,155 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// grants on this table name apply to every table
+const RoleGrantAllTables = "*"
+
+// role grants of a user are cached for this long, so changes to roles take effect within this duration
+const RolePermissionCacheDuration = 30 * time.Second
+
+// RolePermissions is the union of all grants from the roles assigned to a user, directly or through
+// one of their usergroups. Grants only add to what the owner/group/guest bits already allow.
+type RolePermissions struct {
+	tables     map[string]auth.AuthPermission
+	actions    map[string]bool
+	roleNames  map[string]bool
+	groupNames map[string]bool
+}
+
+// ids of the roles assigned to a user directly or through their usergroups, takes the user id twice
+const userRoleIdsQuery = "select ur.role_id from user_user_id_has_role_role_id ur where ur.user_id = ?" +
+	" union select ugr.role_id from usergroup_usergroup_id_has_role_role_id ugr" +
+	" join user_user_id_has_usergroup_usergroup_id uug on uug.usergroup_id = ugr.usergroup_id where uug.user_id = ?"
+
+func (r RolePermissions) CanOnTable(tableName string, bit auth.AuthPermission) bool {
+	if bit == auth.None {
+		return false
+	}
+	if r.tables[tableName]&bit == bit {
+		return true
+	}
+	return r.tables[RoleGrantAllTables]&bit == bit
+}
+
+func (r RolePermissions) HasRole(roleName string) bool {
+	return r.roleNames[roleName]
+}
+
+func (r RolePermissions) InUserGroup(groupName string) bool {
+	return r.groupNames[groupName]
+}
+
+// CanExecuteAction is true when a grant allows the action on the table, by name or with *, on the table or
+// on all tables. The grant covers every row of the table, the execute permission of the subject row and of
+// the action itself are not checked.
+func (r RolePermissions) CanExecuteAction(tableName string, actionName string) bool {
+	for _, table := range []string{tableName, RoleGrantAllTables} {
+		if r.actions[table+":"+actionName] || r.actions[table+":*"] {
+			return true
+		}
+	}
+	return r.CanOnTable(tableName, auth.ExecuteStrict)
+}
+
+type rolePermissionCacheEntry struct {
+	permissions RolePermissions
+	loadedAt    time.Time
+}
+
+var rolePermissionCacheLock sync.Mutex
+var rolePermissionCache = make(map[int64]rolePermissionCacheEntry)
+
+// GetRolePermissions loads the grants and names of all the roles of the session user, along with the
+// names of their usergroups
+func (dr *DbResource) GetRolePermissions(sessionUser auth.SessionUser) RolePermissions {
+
+	permissions := RolePermissions{
+		tables:     make(map[string]auth.AuthPermission),
+		actions:    make(map[string]bool),
+		roleNames:  make(map[string]bool),
+		groupNames: make(map[string]bool),
+	}
+
+	if sessionUser.UserId < 1 {
+		return permissions
+	}
+
+	rolePermissionCacheLock.Lock()
+	cached, ok := rolePermissionCache[sessionUser.UserId]
+	rolePermissionCacheLock.Unlock()
+	if ok && time.Since(cached.loadedAt) < RolePermissionCacheDuration {
+		return cached.permissions
+	}
+
+	rows, err := dr.db.Queryx("select rg.table_name, rg.action_name, rg.grant_permission from role_grant rg"+
+		" where rg.role_id in ("+userRoleIdsQuery+")", sessionUser.UserId, sessionUser.UserId)
+	if err != nil {
+		log.Errorf("Failed to query role grants for [%v]: %v", sessionUser.UserReferenceId, err)
+		return permissions
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tableName string
+		var actionName *string
+		var grant *int64
+
+		err = rows.Scan(&tableName, &actionName, &grant)
+		if err != nil {
+			log.Errorf("Failed to scan role grant: %v", err)
+			continue
+		}
+
+		if actionName != nil && *actionName != "" {
+			permissions.actions[tableName+":"+*actionName] = true
+			continue
+		}
+
+		if grant != nil {
+			permissions.tables[tableName] = permissions.tables[tableName] | auth.AuthPermission(*grant)
+		}
+	}
+
+	permissions.roleNames = dr.getUserNames("select r.name from role r where r.id in ("+userRoleIdsQuery+")", sessionUser.UserId, sessionUser.UserId)
+	permissions.groupNames = dr.getUserNames("select ug.name from usergroup ug"+
+		" join user_user_id_has_usergroup_usergroup_id uug on uug.usergroup_id = ug.id where uug.user_id = ?", sessionUser.UserId)
+
+	rolePermissionCacheLock.Lock()
+	rolePermissionCache[sessionUser.UserId] = rolePermissionCacheEntry{
+		permissions: permissions,
+		loadedAt:    time.Now(),
+	}
+	rolePermissionCacheLock.Unlock()
+
+	return permissions
+}
+
+func (dr *DbResource) getUserNames(query string, args ...interface{}) map[string]bool {
+
+	names := make(map[string]bool)
+
+	rows, err := dr.db.Queryx(query, args...)
+	if err != nil {
+		log.Errorf("Failed to query names: %v", err)
+		return names
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			log.Errorf("Failed to scan name: %v", err)
+			continue
+		}
+		names[name] = true
+	}
+
+	return names
+}
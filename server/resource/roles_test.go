@@ -0,0 +1,35 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"testing"
+)
+
+func TestRoleCanExecuteAction(t *testing.T) {
+
+	cases := []struct {
+		actions map[string]bool
+		tables  map[string]auth.AuthPermission
+		allowed bool
+	}{
+		{map[string]bool{"order:refund": true}, nil, true},
+		{map[string]bool{"order:*": true}, nil, true},
+		{map[string]bool{"*:refund": true}, nil, true},
+		{map[string]bool{"*:*": true}, nil, true},
+		{map[string]bool{"order:cancel": true}, nil, false},
+		{map[string]bool{"invoice:refund": true}, nil, false},
+		{nil, map[string]auth.AuthPermission{"order": auth.ExecuteStrict}, true},
+		{nil, map[string]auth.AuthPermission{RoleGrantAllTables: auth.ExecuteStrict}, true},
+		{nil, map[string]auth.AuthPermission{"order": auth.ReadStrict}, false},
+	}
+
+	for _, c := range cases {
+		roles := RolePermissions{
+			actions: c.actions,
+			tables:  c.tables,
+		}
+		if roles.CanExecuteAction("order", "refund") != c.allowed {
+			t.Errorf("Grants %v %v: expected %v for order:refund", c.actions, c.tables, c.allowed)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// RowSecurityPolicy is a compiled row level security policy of a table. Policies are written like a sql
+// where clause over the columns of the table, and can refer to the current user
+//
+//	owner = $user OR region IN $user.regions
+//
+// - owner is the user_id column of the row
+// - $user is the id of the current user, and $user.<column> is a column of the current user's row
+// - operators: AND, OR, NOT, =, !=, <>, <, <=, >, >=, IN, NOT IN, IS NULL, IS NOT NULL
+// - values: 'strings', numbers, true, false, null and lists ('a', 'b')
+//
+// The policy is added to the where clause of PaginatedFindAll and FindOne, so rows which do not match are never selected
+type RowSecurityPolicy struct {
+	tableName   string
+	source      string
+	root        policyNode
+	usesUserRow bool
+	denyAll     bool
+}
+
+type policyContext struct {
+	tableName string
+	userId    int64
+	user      map[string]interface{}
+}
+
+type policyNode interface {
+	toSql(ctx *policyContext) (string, []interface{}, error)
+}
+
+type policyLogical struct {
+	op    string
+	left  policyNode
+	right policyNode
+}
+
+type policyNot struct {
+	node policyNode
+}
+
+type policyComparison struct {
+	left  policyOperand
+	op    string
+	right policyOperand
+}
+
+const (
+	policyOperandColumn = iota
+	policyOperandUser
+	policyOperandUserField
+	policyOperandLiteral
+	policyOperandList
+)
+
+type policyOperand struct {
+	kind    int
+	name    string
+	literal interface{}
+	list    []policyOperand
+}
+
+func (p *RowSecurityPolicy) String() string {
+	return p.source
+}
+
+// CompileRowSecurityPolicy parses the policy and checks that all the columns it refers to exist in the table
+func CompileRowSecurityPolicy(tableName string, source string, columns []api2go.ColumnInfo) (*RowSecurityPolicy, error) {
+
+	columnNames := make(map[string]bool)
+	for _, col := range columns {
+		columnNames[col.ColumnName] = true
+	}
+
+	tokens, err := tokenizePolicy(source)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &policyParser{
+		tokens:  tokens,
+		columns: columnNames,
+	}
+
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("Unexpected [%v] in policy", parser.tokens[parser.pos])
+	}
+
+	return &RowSecurityPolicy{
+		tableName:   tableName,
+		source:      source,
+		root:        root,
+		usesUserRow: parser.usesUserRow,
+	}, nil
+}
+
+// DenyAllRowSecurityPolicy is used in place of a policy which failed to compile, so that a broken policy
+// does not expose the table
+func DenyAllRowSecurityPolicy(tableName string) *RowSecurityPolicy {
+	return &RowSecurityPolicy{
+		tableName: tableName,
+		source:    "deny all",
+		denyAll:   true,
+	}
+}
+
+// Where returns the condition to be added to queries on the table for the session user
+func (p *RowSecurityPolicy) Where(dr *DbResource, sessionUser auth.SessionUser) (squirrel.Sqlizer, error) {
+
+	if p.denyAll {
+		return squirrel.Expr("1 = 0"), nil
+	}
+
+	ctx := &policyContext{
+		tableName: p.tableName,
+		userId:    sessionUser.UserId,
+	}
+
+	if p.usesUserRow && sessionUser.UserId > 0 {
+		user, err := dr.GetIdToObject("user", sessionUser.UserId)
+		if err != nil {
+			log.Errorf("Failed to load user [%v] for row security policy: %v", sessionUser.UserReferenceId, err)
+			return nil, err
+		}
+		ctx.user = user
+	}
+
+	sql, args, err := p.root.toSql(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return squirrel.Expr("("+sql+")", args...), nil
+}
+
+func (n policyLogical) toSql(ctx *policyContext) (string, []interface{}, error) {
+	leftSql, leftArgs, err := n.left.toSql(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	rightSql, rightArgs, err := n.right.toSql(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("(%s %s %s)", leftSql, n.op, rightSql), append(leftArgs, rightArgs...), nil
+}
+
+func (n policyNot) toSql(ctx *policyContext) (string, []interface{}, error) {
+	sql, args, err := n.node.toSql(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	return "(NOT " + sql + ")", args, nil
+}
+
+func (n policyComparison) toSql(ctx *policyContext) (string, []interface{}, error) {
+
+	leftSql, leftArgs, err := n.left.value(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch n.op {
+	case "IS NULL", "IS NOT NULL":
+		return leftSql + " " + n.op, leftArgs, nil
+	case "IN", "NOT IN":
+		values, err := n.right.values(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(values) == 0 {
+			// nothing is in an empty list
+			if n.op == "IN" {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		placeholders := make([]string, len(values))
+		for i := range values {
+			placeholders[i] = "?"
+		}
+		return fmt.Sprintf("%s %s (%s)", leftSql, n.op, strings.Join(placeholders, ", ")), append(leftArgs, values...), nil
+	}
+
+	rightSql, rightArgs, err := n.right.value(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("%s %s %s", leftSql, n.op, rightSql), append(leftArgs, rightArgs...), nil
+}
+
+func (o policyOperand) value(ctx *policyContext) (string, []interface{}, error) {
+	switch o.kind {
+	case policyOperandColumn:
+		return ctx.tableName + "." + o.name, nil, nil
+	case policyOperandUser:
+		return "?", []interface{}{ctx.userId}, nil
+	case policyOperandUserField:
+		if ctx.user == nil {
+			return "?", []interface{}{nil}, nil
+		}
+		return "?", []interface{}{ctx.user[o.name]}, nil
+	case policyOperandLiteral:
+		return "?", []interface{}{o.literal}, nil
+	}
+	return "", nil, errors.New("A list can only be used with IN")
+}
+
+// values returns the list of values for the right side of an IN. A user column can hold a json array
+// or a comma separated list.
+func (o policyOperand) values(ctx *policyContext) ([]interface{}, error) {
+	switch o.kind {
+	case policyOperandList:
+		values := make([]interface{}, 0)
+		for _, item := range o.list {
+			values = append(values, item.literal)
+		}
+		return values, nil
+	case policyOperandUser:
+		return []interface{}{ctx.userId}, nil
+	case policyOperandUserField:
+		if ctx.user == nil {
+			return []interface{}{}, nil
+		}
+		return splitPolicyValues(ctx.user[o.name]), nil
+	case policyOperandLiteral:
+		return []interface{}{o.literal}, nil
+	}
+	return nil, errors.New("Expected a list after IN")
+}
+
+func splitPolicyValues(val interface{}) []interface{} {
+
+	values := make([]interface{}, 0)
+
+	switch v := val.(type) {
+	case nil:
+		return values
+	case []interface{}:
+		return v
+	case []uint8:
+		return splitPolicyValues(string(v))
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return values
+		}
+		if strings.HasPrefix(v, "[") {
+			err := json.Unmarshal([]byte(v), &values)
+			if err == nil {
+				return values
+			}
+			log.Errorf("Failed to read list [%v] for row security policy: %v", v, err)
+			return make([]interface{}, 0)
+		}
+		for _, part := range strings.Split(v, ",") {
+			values = append(values, strings.TrimSpace(part))
+		}
+		return values
+	}
+
+	return append(values, val)
+}
+
+type policyToken struct {
+	kind  string // ident, user, string, number, op, punct
+	value string
+}
+
+func (t policyToken) String() string {
+	return t.value
+}
+
+func tokenizePolicy(source string) ([]policyToken, error) {
+
+	tokens := make([]policyToken, 0)
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		c := runes[i]
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, policyToken{"punct", string(c)})
+			i++
+		case c == '=':
+			tokens = append(tokens, policyToken{"op", "="})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (c == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, policyToken{"op", string(runes[i : i+2])})
+				i += 2
+			} else if c == '!' {
+				return nil, fmt.Errorf("Unexpected ! at %d", i)
+			} else {
+				tokens = append(tokens, policyToken{"op", string(c)})
+				i++
+			}
+		case c == '\'':
+			j := i + 1
+			var value []rune
+			for ; j < len(runes); j++ {
+				if runes[j] == '\'' {
+					if j+1 < len(runes) && runes[j+1] == '\'' {
+						value = append(value, '\'')
+						j++
+						continue
+					}
+					break
+				}
+				value = append(value, runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			tokens = append(tokens, policyToken{"string", string(value)})
+			i = j + 1
+		case c == '$':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{"user", string(runes[i+1 : j])})
+			i = j
+		case unicode.IsDigit(c) || c == '-':
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, policyToken{"number", string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, policyToken{"ident", string(runes[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("Unexpected %c at %d", c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+type policyParser struct {
+	tokens      []policyToken
+	pos         int
+	columns     map[string]bool
+	usesUserRow bool
+}
+
+func (p *policyParser) peek() *policyToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *policyParser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t != nil && t.kind == "ident" && strings.ToUpper(t.value) == keyword
+}
+
+func (p *policyParser) isPunct(punct string) bool {
+	t := p.peek()
+	return t != nil && t.kind == "punct" && t.value == punct
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = policyLogical{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = policyLogical{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parseNot() (policyNode, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return policyNot{node: node}, nil
+	}
+
+	if p.isPunct("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isPunct(")") {
+			return nil, errors.New("Expected ) in policy")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *policyParser) parseComparison() (policyNode, error) {
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t == nil {
+		return nil, errors.New("Expected an operator at the end of policy")
+	}
+
+	if t.kind == "op" {
+		p.pos++
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		op := t.value
+		if op == "!=" {
+			op = "<>"
+		}
+		return policyComparison{left: left, op: op, right: right}, nil
+	}
+
+	if p.isKeyword("IS") {
+		p.pos++
+		op := "IS NULL"
+		if p.isKeyword("NOT") {
+			p.pos++
+			op = "IS NOT NULL"
+		}
+		if !p.isKeyword("NULL") {
+			return nil, errors.New("Expected NULL after IS")
+		}
+		p.pos++
+		return policyComparison{left: left, op: op}, nil
+	}
+
+	op := "IN"
+	if p.isKeyword("NOT") {
+		p.pos++
+		op = "NOT IN"
+	}
+	if !p.isKeyword("IN") {
+		return nil, fmt.Errorf("Unexpected [%v] in policy", t)
+	}
+	p.pos++
+
+	if p.isPunct("(") {
+		p.pos++
+		list := make([]policyOperand, 0)
+		for !p.isPunct(")") {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if item.kind != policyOperandLiteral {
+				return nil, errors.New("Only values can be used in a list")
+			}
+			list = append(list, item)
+			if p.isPunct(",") {
+				p.pos++
+			} else if !p.isPunct(")") {
+				return nil, errors.New("Expected , or ) in list")
+			}
+		}
+		p.pos++
+		return policyComparison{left: left, op: op, right: policyOperand{kind: policyOperandList, list: list}}, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return policyComparison{left: left, op: op, right: right}, nil
+}
+
+func (p *policyParser) parseOperand() (policyOperand, error) {
+
+	t := p.peek()
+	if t == nil {
+		return policyOperand{}, errors.New("Unexpected end of policy")
+	}
+	p.pos++
+
+	switch t.kind {
+	case "string":
+		return policyOperand{kind: policyOperandLiteral, literal: t.value}, nil
+	case "number":
+		if strings.Contains(t.value, ".") {
+			f, err := strconv.ParseFloat(t.value, 64)
+			if err != nil {
+				return policyOperand{}, err
+			}
+			return policyOperand{kind: policyOperandLiteral, literal: f}, nil
+		}
+		i, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return policyOperand{}, err
+		}
+		return policyOperand{kind: policyOperandLiteral, literal: i}, nil
+	case "user":
+		parts := strings.Split(t.value, ".")
+		if parts[0] != "user" || len(parts) > 2 {
+			return policyOperand{}, fmt.Errorf("Unknown variable $%v, only $user and $user.<column> can be used", t.value)
+		}
+		if len(parts) == 1 {
+			return policyOperand{kind: policyOperandUser}, nil
+		}
+		p.usesUserRow = true
+		return policyOperand{kind: policyOperandUserField, name: parts[1]}, nil
+	case "ident":
+		switch strings.ToUpper(t.value) {
+		case "TRUE":
+			return policyOperand{kind: policyOperandLiteral, literal: true}, nil
+		case "FALSE":
+			return policyOperand{kind: policyOperandLiteral, literal: false}, nil
+		case "NULL":
+			return policyOperand{kind: policyOperandLiteral, literal: nil}, nil
+		}
+		name := t.value
+		if name == "owner" {
+			name = "user_id"
+		}
+		if !p.columns[name] {
+			return policyOperand{}, fmt.Errorf("Unknown column [%v] in policy", t.value)
+		}
+		return policyOperand{kind: policyOperandColumn, name: name}, nil
+	}
+
+	return policyOperand{}, fmt.Errorf("Unexpected [%v] in policy", t)
+}
+
+// GetRowSecurityWhere returns the policy condition for the request, or nil if there is no policy to apply.
+// The administrator is not restricted by policies.
+func (dr *DbResource) GetRowSecurityWhere(req api2go.Request) (squirrel.Sqlizer, error) {
+
+	if dr.rowSecurityPolicy == nil {
+		return nil, nil
+	}
+
+	sessionUser := auth.SessionUser{}
+	user := req.PlainRequest.Context().Value("user")
+	if user != nil {
+		sessionUser = user.(auth.SessionUser)
+	}
+
+	if dr.IsAdmin(sessionUser.UserReferenceId) {
+		return nil, nil
+	}
+
+	return dr.rowSecurityPolicy.Where(dr, sessionUser)
+}
+
+func (dr *DbResource) SetRowSecurityPolicy(policy *RowSecurityPolicy) {
+	dr.rowSecurityPolicy = policy
+}
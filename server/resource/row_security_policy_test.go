@@ -0,0 +1,172 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"testing"
+)
+
+var policyTestColumns = []api2go.ColumnInfo{
+	{ColumnName: "user_id"},
+	{ColumnName: "status"},
+	{ColumnName: "region"},
+	{ColumnName: "deleted_at"},
+	{ColumnName: "score"},
+	{ColumnName: "title"},
+	{ColumnName: "published"},
+}
+
+func TestRowSecurityPolicySql(t *testing.T) {
+
+	cases := []struct {
+		policy string
+		user   map[string]interface{}
+		sql    string
+		args   string
+	}{
+		{"owner = $user", nil, "blog.user_id = ?", "[7]"},
+		{"owner = $user OR status = 'public'", nil, "(blog.user_id = ? OR blog.status = ?)", "[7 public]"},
+		{"owner = $user or status = 'public'", nil, "(blog.user_id = ? OR blog.status = ?)", "[7 public]"},
+		{"NOT (status = 'draft' AND owner != $user)", nil, "(NOT (blog.status = ? AND blog.user_id <> ?))", "[draft 7]"},
+		{"status = 'a' OR status = 'b' AND owner = $user", nil, "(blog.status = ? OR (blog.status = ? AND blog.user_id = ?))", "[a b 7]"},
+		{"(status = 'a' OR status = 'b') AND owner = $user", nil, "((blog.status = ? OR blog.status = ?) AND blog.user_id = ?)", "[a b 7]"},
+		{"region IN ('eu', 'us')", nil, "blog.region IN (?, ?)", "[eu us]"},
+		{"region NOT IN ('eu')", nil, "blog.region NOT IN (?)", "[eu]"},
+		{"region IN ()", nil, "1 = 0", "[]"},
+		{"region NOT IN ()", nil, "1 = 1", "[]"},
+		{"deleted_at IS NULL", nil, "blog.deleted_at IS NULL", "[]"},
+		{"deleted_at is not null", nil, "blog.deleted_at IS NOT NULL", "[]"},
+		{"score >= 10 AND score < 2.5", nil, "(blog.score >= ? AND blog.score < ?)", "[10 2.5]"},
+		{"score <> -1", nil, "blog.score <> ?", "[-1]"},
+		{"title = 'it''s'", nil, "blog.title = ?", "[it's]"},
+		{"published = true", nil, "blog.published = ?", "[true]"},
+		{"region = $user.region", map[string]interface{}{"region": "eu"}, "blog.region = ?", "[eu]"},
+		{"region IN $user.regions", map[string]interface{}{"regions": `["eu","us"]`}, "blog.region IN (?, ?)", "[eu us]"},
+		{"region IN $user.regions", map[string]interface{}{"regions": "eu, us"}, "blog.region IN (?, ?)", "[eu us]"},
+		{"region IN $user.regions", map[string]interface{}{"regions": ""}, "1 = 0", "[]"},
+		{"region IN $user.regions", nil, "1 = 0", "[]"},
+		{"owner IN $user", nil, "blog.user_id IN (?)", "[7]"},
+	}
+
+	for _, c := range cases {
+
+		policy, err := CompileRowSecurityPolicy("blog", c.policy, policyTestColumns)
+		if err != nil {
+			t.Errorf("[%v] failed to compile: %v", c.policy, err)
+			continue
+		}
+
+		sql, args, err := policy.root.toSql(&policyContext{
+			tableName: "blog",
+			userId:    7,
+			user:      c.user,
+		})
+		if err != nil {
+			t.Errorf("[%v] failed to build sql: %v", c.policy, err)
+			continue
+		}
+		if args == nil {
+			args = []interface{}{}
+		}
+
+		if sql != c.sql || fmt.Sprintf("%v", args) != c.args {
+			t.Errorf("[%v] expected %v %v, got %v %v", c.policy, c.sql, c.args, sql, args)
+		}
+	}
+}
+
+func TestRowSecurityPolicyUsesUserRow(t *testing.T) {
+
+	cases := map[string]bool{
+		"owner = $user":                            false,
+		"region IN $user.regions":                  true,
+		"owner = $user OR region = $user.region":   true,
+		"status = 'public' AND deleted_at IS NULL": false,
+	}
+
+	for source, usesUserRow := range cases {
+		policy, err := CompileRowSecurityPolicy("blog", source, policyTestColumns)
+		if err != nil {
+			t.Errorf("[%v] failed to compile: %v", source, err)
+			continue
+		}
+		if policy.usesUserRow != usesUserRow {
+			t.Errorf("[%v] expected usesUserRow %v", source, usesUserRow)
+		}
+	}
+}
+
+func TestRowSecurityPolicyMalformed(t *testing.T) {
+
+	policies := []string{
+		"",
+		"status",
+		"status =",
+		"status = 'open",
+		"unknown = 1",
+		"owner = $admin",
+		"owner = $user.a.b",
+		"status = 1 extra",
+		"(status = 1",
+		"status = 1)",
+		"status ! 1",
+		"status == 1",
+		"status = 1; drop table blog",
+		"status IN (owner)",
+		"status IN ('a' 'b')",
+		"status IN ('a',",
+		"status IS 1",
+		"status = 1 AND",
+		"NOT",
+		"owner = $user -- comment",
+		"status = \"open\"",
+	}
+
+	for _, source := range policies {
+		_, err := CompileRowSecurityPolicy("blog", source, policyTestColumns)
+		if err == nil {
+			t.Errorf("[%v] should not compile", source)
+		}
+	}
+}
+
+func TestRowSecurityPolicyWhere(t *testing.T) {
+
+	policy, err := CompileRowSecurityPolicy("blog", "owner = $user", policyTestColumns)
+	if err != nil {
+		t.Fatalf("Failed to compile: %v", err)
+	}
+
+	where, err := policy.Where(nil, auth.SessionUser{UserId: 7})
+	if err != nil {
+		t.Fatalf("Failed to build where: %v", err)
+	}
+	sql, args, err := where.ToSql()
+	if err != nil || sql != "(blog.user_id = ?)" || fmt.Sprintf("%v", args) != "[7]" {
+		t.Errorf("Unexpected where %v %v %v", sql, args, err)
+	}
+
+	// guests have no user id, they match no owned rows
+	where, _ = policy.Where(nil, auth.SessionUser{})
+	_, args, _ = where.ToSql()
+	if fmt.Sprintf("%v", args) != "[0]" {
+		t.Errorf("Unexpected guest arguments %v", args)
+	}
+}
+
+func TestDenyAllRowSecurityPolicy(t *testing.T) {
+
+	policy := DenyAllRowSecurityPolicy("blog")
+
+	for _, user := range []auth.SessionUser{{}, {UserId: 1, UserReferenceId: "user-1"}} {
+		where, err := policy.Where(nil, user)
+		if err != nil {
+			t.Fatalf("Failed to build where: %v", err)
+		}
+		sql, args, err := where.ToSql()
+		if err != nil || sql != "1 = 0" || len(args) != 0 {
+			t.Errorf("Deny all should match no rows, got %v %v %v", sql, args, err)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package resource
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"github.com/crewjam/saml"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// time the user has at the identity provider to complete a sign in
+const SamlRequestValidity = 10 * time.Minute
+
+const samlRequestTokenType = "saml-request"
+
+// SamlExternalUrl is the url the browser reaches daptin at (eg https://example.com), from saml.sp.external_url
+// in _config. It is configured rather than taken from the request, the Host and X-Forwarded-Proto headers
+// are sent by the client and would let it choose the audience and assertion consumer url.
+func SamlExternalUrl(store *ConfigStore) (string, error) {
+
+	externalUrl, err := store.GetConfigValueFor("saml.sp.external_url", "backend")
+	if err != nil || externalUrl == "" {
+		return "", errors.New("saml.sp.external_url is not set")
+	}
+
+	u, err := url.Parse(externalUrl)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("Invalid saml.sp.external_url [%v], expected a url like https://example.com", externalUrl)
+	}
+
+	return strings.TrimRight(externalUrl, "/"), nil
+}
+
+// CheckSamlServiceProviderKey creates the key and self signed certificate daptin signs its authentication
+// requests with, the certificate is published in the metadata of every saml_connect. An empty
+// saml.sp.external_url is added so it can be seen and set in _config.
+func CheckSamlServiceProviderKey(store *ConfigStore) error {
+
+	_, err := store.GetConfigValueFor("saml.sp.external_url", "backend")
+	if err != nil {
+		err = store.SetConfigValueFor("saml.sp.external_url", "", "backend")
+		CheckErr(err, "Failed to store default value for [saml.sp.external_url]")
+	}
+
+	_, keyErr := store.GetConfigValueFor("saml.sp.key", "backend")
+	_, certErr := store.GetConfigValueFor("saml.sp.certificate", "backend")
+	if keyErr == nil && certErr == nil {
+		return nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "daptin", Organization: []string{"daptin"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+
+	certDer, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+
+	err = store.SetConfigValueFor("saml.sp.key", string(keyPem), "backend")
+	if err != nil {
+		return err
+	}
+	return store.SetConfigValueFor("saml.sp.certificate", string(certPem), "backend")
+}
+
+func loadSamlServiceProviderKey(store *ConfigStore) (*rsa.PrivateKey, *x509.Certificate, error) {
+
+	keyPem, err := store.GetConfigValueFor("saml.sp.key", "backend")
+	if err != nil {
+		return nil, nil, err
+	}
+	certPem, err := store.GetConfigValueFor("saml.sp.certificate", "backend")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode([]byte(keyPem))
+	if keyBlock == nil {
+		return nil, nil, errors.New("Invalid saml.sp.key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode([]byte(certPem))
+	if certBlock == nil {
+		return nil, nil, errors.New("Invalid saml.sp.certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+// SamlConnection is a service provider for one identity provider configured in saml_connect
+type SamlConnection struct {
+	Name            string
+	ServiceProvider *saml.ServiceProvider
+	EmailAttribute  string
+	NameAttribute   string
+	GroupAttribute  string
+	// group value sent by the identity provider => usergroup name
+	GroupMapping map[string]string
+}
+
+// GetSamlConnection loads the saml_connect with this name. The metadata and assertion consumer urls are built
+// from the configured external url of daptin.
+func GetSamlConnection(name string, dbResource *DbResource) (*SamlConnection, error) {
+
+	baseUrl, err := SamlExternalUrl(dbResource.configStore)
+	if err != nil {
+		log.Errorf("Failed to get saml connection [%v]: %v", name, err)
+		return nil, err
+	}
+
+	rows, _, err := dbResource.cruds["saml_connect"].GetRowsByWhereClause("saml_connect", squirrel.Eq{"name": name})
+	if err != nil {
+		log.Errorf("Failed to get saml connection [%v]: %v", name, err)
+		return nil, err
+	}
+
+	if len(rows) < 1 {
+		return nil, errors.New(fmt.Sprintf("No such saml connection [%v]", name))
+	}
+	row := rows[0]
+
+	idpMetadataXml, _ := row["idp_metadata"].(string)
+	idpMetadata := &saml.EntityDescriptor{}
+	err = xml.Unmarshal([]byte(idpMetadataXml), idpMetadata)
+	if err != nil {
+		log.Errorf("Invalid identity provider metadata for [%v]: %v", name, err)
+		return nil, err
+	}
+
+	key, cert, err := loadSamlServiceProviderKey(dbResource.configStore)
+	if err != nil {
+		log.Errorf("Failed to load saml service provider key: %v", err)
+		return nil, err
+	}
+
+	metadataUrl, err := url.Parse(baseUrl + "/saml/" + url.PathEscape(name) + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsUrl, err := url.Parse(baseUrl + "/saml/" + url.PathEscape(name) + "/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &SamlConnection{
+		Name: name,
+		ServiceProvider: &saml.ServiceProvider{
+			Key:         key,
+			Certificate: cert,
+			MetadataURL: *metadataUrl,
+			AcsURL:      *acsUrl,
+			IDPMetadata: idpMetadata,
+		},
+		EmailAttribute: stringOrDefault(row["email_attribute"], "email"),
+		NameAttribute:  stringOrDefault(row["name_attribute"], "displayName"),
+		GroupAttribute: stringOrDefault(row["group_attribute"], "groups"),
+		GroupMapping:   make(map[string]string),
+	}
+
+	groupMapping, _ := row["group_mapping"].(string)
+	if groupMapping != "" {
+		err = json.Unmarshal([]byte(groupMapping), &connection.GroupMapping)
+		if err != nil {
+			log.Errorf("Invalid group_mapping for saml connection [%v]: %v", name, err)
+		}
+	}
+
+	return connection, nil
+}
+
+func stringOrDefault(val interface{}, defaultValue string) string {
+	str, ok := val.(string)
+	if !ok || str == "" {
+		return defaultValue
+	}
+	return str
+}
+
+// SamlUser is the identity asserted by the identity provider
+type SamlUser struct {
+	NameId string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// UserFromAssertion reads the mapped attributes of a validated assertion, an attribute matches on
+// either its name or friendly name. The name id is used as the email if there is no email attribute.
+func (c *SamlConnection) UserFromAssertion(assertion *saml.Assertion) (*SamlUser, error) {
+
+	user := &SamlUser{
+		Groups: make([]string, 0),
+	}
+
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		user.NameId = assertion.Subject.NameID.Value
+	}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0)
+			for _, value := range attribute.Values {
+				values = append(values, strings.TrimSpace(value.Value))
+			}
+			if len(values) == 0 {
+				continue
+			}
+
+			switch {
+			case attribute.Name == c.EmailAttribute || attribute.FriendlyName == c.EmailAttribute:
+				user.Email = values[0]
+			case attribute.Name == c.NameAttribute || attribute.FriendlyName == c.NameAttribute:
+				user.Name = values[0]
+			case attribute.Name == c.GroupAttribute || attribute.FriendlyName == c.GroupAttribute:
+				user.Groups = append(user.Groups, values...)
+			}
+		}
+	}
+
+	if user.Email == "" && strings.Index(user.NameId, "@") > 0 {
+		user.Email = user.NameId
+	}
+	if user.Email == "" {
+		return nil, errors.New("No email in saml assertion")
+	}
+	if user.Name == "" {
+		user.Name = strings.Split(user.Email, "@")[0]
+	}
+
+	return user, nil
+}
+
+func (c *SamlConnection) MappedGroups(user *SamlUser) []string {
+	names := make([]string, 0)
+	for _, group := range user.Groups {
+		if usergroupName, ok := c.GroupMapping[group]; ok {
+			names = append(names, usergroupName)
+		}
+	}
+	return names
+}
+
+func (c *SamlConnection) ManagedGroups() []string {
+	names := make([]string, 0)
+	for _, usergroupName := range c.GroupMapping {
+		names = append(names, usergroupName)
+	}
+	return names
+}
+
+func samlRequestSecret(secret []byte) []byte {
+	return append(append([]byte{}, secret...), []byte(".saml.request")...)
+}
+
+// NewSamlRequestToken signs the id of an authentication request, it is kept in a cookie on the browser so the
+// response from the identity provider can be matched to the request without keeping state on the server
+func NewSamlRequestToken(connectionName string, requestId string, secret []byte) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"connection": connectionName,
+		"request_id": requestId,
+		"typ":        samlRequestTokenType,
+		"nbf":        time.Now().Unix(),
+		"exp":        time.Now().Add(SamlRequestValidity).Unix(),
+		"iss":        "daptin",
+	})
+	return token.SignedString(samlRequestSecret(secret))
+}
+
+// ParseSamlRequestToken returns the request id from a token issued by NewSamlRequestToken for this connection
+func ParseSamlRequestToken(tokenString string, connectionName string, secret []byte) (string, error) {
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return samlRequestSecret(secret), nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["typ"] != samlRequestTokenType || claims["connection"] != connectionName {
+		return "", errors.New("Invalid saml request")
+	}
+
+	requestId, ok := claims["request_id"].(string)
+	if !ok || requestId == "" {
+		return "", errors.New("Invalid saml request")
+	}
+
+	return requestId, nil
+}
@@ -0,0 +1,137 @@
+package resource
+
+import (
+	"github.com/crewjam/saml"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+func TestSamlRequestToken(t *testing.T) {
+
+	secret := []byte("jwt secret")
+
+	token, err := NewSamlRequestToken("okta", "request-1", secret)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	requestId, err := ParseSamlRequestToken(token, "okta", secret)
+	if err != nil || requestId != "request-1" {
+		t.Errorf("Expected the request id, got %v %v", requestId, err)
+	}
+
+	if _, err = ParseSamlRequestToken(token, "azure", secret); err == nil {
+		t.Errorf("A request to another connection should not be accepted")
+	}
+	if _, err = ParseSamlRequestToken(token, "okta", []byte("other secret")); err == nil {
+		t.Errorf("A request signed with another secret should not be accepted")
+	}
+
+	session, err := CreateJwtToken(map[string]interface{}{"email": "user@example.com", "name": "user"}, secret)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	if _, err = ParseSamlRequestToken(session, "okta", secret); err == nil {
+		t.Errorf("A session token should not be accepted as a request")
+	}
+}
+
+func samlTestAttribute(name string, friendlyName string, values ...string) saml.Attribute {
+	attribute := saml.Attribute{
+		Name:         name,
+		FriendlyName: friendlyName,
+	}
+	for _, value := range values {
+		attribute.Values = append(attribute.Values, saml.AttributeValue{Value: value})
+	}
+	return attribute
+}
+
+func TestSamlUserFromAssertion(t *testing.T) {
+
+	connection := &SamlConnection{
+		EmailAttribute: "email",
+		NameAttribute:  "displayName",
+		GroupAttribute: "groups",
+		GroupMapping: map[string]string{
+			"engineers": "engineering",
+			"admins":    "operations",
+		},
+	}
+
+	assertion := &saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "ada"}},
+		AttributeStatements: []saml.AttributeStatement{
+			{
+				Attributes: []saml.Attribute{
+					samlTestAttribute("urn:oid:0.9.2342.19200300.100.1.3", "email", " ada@example.com "),
+					samlTestAttribute("displayName", "", "Ada"),
+					samlTestAttribute("groups", "", "engineers", "staff"),
+				},
+			},
+		},
+	}
+
+	user, err := connection.UserFromAssertion(assertion)
+	if err != nil {
+		t.Fatalf("Failed to read user: %v", err)
+	}
+	if user.Email != "ada@example.com" || user.Name != "Ada" || len(user.Groups) != 2 {
+		t.Errorf("Unexpected user %v", user)
+	}
+	if groups := connection.MappedGroups(user); len(groups) != 1 || groups[0] != "engineering" {
+		t.Errorf("Expected the engineering usergroup, got %v", groups)
+	}
+
+	// the name id is the email when there is no email attribute
+	user, err = connection.UserFromAssertion(&saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "grace@example.com"}},
+	})
+	if err != nil || user.Email != "grace@example.com" || user.Name != "grace" {
+		t.Errorf("Expected the name id as email, got %v %v", user, err)
+	}
+
+	_, err = connection.UserFromAssertion(&saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "grace"}},
+	})
+	if err == nil {
+		t.Errorf("An assertion without an email should be refused")
+	}
+}
+
+func TestSamlExternalUrl(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	store, err := NewConfigStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create config store: %v", err)
+	}
+
+	if _, err = SamlExternalUrl(store); err == nil {
+		t.Errorf("A missing external url should be an error")
+	}
+
+	cases := map[string]string{
+		"https://example.com/":       "https://example.com",
+		"https://example.com/daptin": "https://example.com/daptin",
+		"":                           "",
+		"example.com":                "",
+		"javascript:alert(1)":        "",
+	}
+	for value, expected := range cases {
+		err = store.SetConfigValueFor("saml.sp.external_url", value, "backend")
+		if err != nil {
+			t.Fatalf("Failed to set external url: %v", err)
+		}
+		externalUrl, err := SamlExternalUrl(store)
+		if externalUrl != expected || (expected == "") != (err != nil) {
+			t.Errorf("[%v]: expected [%v], got [%v] %v", value, expected, externalUrl, err)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package resource
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"gopkg.in/go-playground/validator.v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScheduleRunStatusCompleted = "completed"
+	ScheduleRunStatusFailed    = "failed"
+)
+
+const SchedulerPollInterval = 15 * time.Second
+
+// a schedule failing this many times in a row is disabled, someone has to look at it
+const ScheduleMaxConsecutiveFailures = 10
+
+// ValidateCronExpression is the "cron" validation, for the five field cron expressions and the
+// descriptors like @hourly and @every 5m
+func ValidateCronExpression(fl validator.FieldLevel) bool {
+	_, err := cron.ParseStandard(fl.Field().String())
+	return err == nil
+}
+
+// NextScheduledRun is the first time after the given time the cron expression is due
+func NextScheduledRun(cronExpression string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(cronExpression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(after), nil
+}
+
+type scheduledAction struct {
+	Id                int64          `db:"id"`
+	ReferenceId       string         `db:"reference_id"`
+	Name              string         `db:"name"`
+	CronExpression    string         `db:"cron_expression"`
+	OnType            string         `db:"on_type"`
+	ActionName        string         `db:"action_name"`
+	TargetReferenceId sql.NullString `db:"target_reference_id"`
+	Attributes        sql.NullString `db:"attributes"`
+	NextRunAt         *time.Time     `db:"next_run_at"`
+	FailureCount      int            `db:"failure_count"`
+	UserId            sql.NullInt64  `db:"user_id"`
+}
+
+// ActionScheduler runs the actions of the enabled schedules when their cron expression is due. Each due
+// run is claimed by moving next_run_at forward with a conditional update, so when several daptin
+// instances share the database only one of them runs it.
+type ActionScheduler struct {
+	cruds            map[string]*DbResource
+	actionHandlerMap map[string]ActionPerformerInterface
+}
+
+func NewActionScheduler(cruds map[string]*DbResource, actionPerformers []ActionPerformerInterface) *ActionScheduler {
+	return &ActionScheduler{
+		cruds:            cruds,
+		actionHandlerMap: ActionPerformerMap(actionPerformers),
+	}
+}
+
+func (as *ActionScheduler) db() *sqlx.DB {
+	return as.cruds["schedule"].db
+}
+
+func (as *ActionScheduler) Start() {
+	log.Infof("Starting action scheduler")
+	go func() {
+		for {
+			as.runDueSchedules()
+			time.Sleep(SchedulerPollInterval)
+		}
+	}()
+}
+
+func (as *ActionScheduler) runDueSchedules() {
+
+	now := time.Now()
+
+	s, v, err := squirrel.Select("id", "reference_id", "name", "cron_expression", "on_type", "action_name",
+		"target_reference_id", "attributes", "next_run_at", "failure_count", "user_id").
+		From("schedule").
+		Where(squirrel.Eq{"enabled": true}).
+		Where(squirrel.Or{squirrel.Eq{"next_run_at": nil}, squirrel.Expr("next_run_at <= ?", now)}).ToSql()
+	CheckErr(err, "Failed to create schedule select query")
+
+	schedules := make([]scheduledAction, 0)
+	err = as.db().Select(&schedules, s, v...)
+	if err != nil {
+		log.Errorf("Failed to load due schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+
+		next, err := NextScheduledRun(schedule.CronExpression, now)
+		if err != nil {
+			log.Errorf("Schedule [%v] has an invalid cron expression [%v]: %v", schedule.Name, schedule.CronExpression, err)
+			continue
+		}
+
+		// a new or edited schedule is not run right away, its first run is the next time it is due
+		if schedule.NextRunAt == nil {
+			as.setNextRun(schedule.Id, next)
+			continue
+		}
+
+		if !as.claim(schedule, next, now) {
+			continue
+		}
+
+		go as.run(schedule, now)
+	}
+}
+
+func (as *ActionScheduler) setNextRun(scheduleId int64, next time.Time) {
+	s, v, err := squirrel.Update("schedule").Set("next_run_at", next).
+		Where(squirrel.Eq{"id": scheduleId}).
+		Where(squirrel.Eq{"next_run_at": nil}).ToSql()
+	CheckErr(err, "Failed to create schedule update query")
+
+	_, err = as.db().Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to set next run of schedule [%v]: %v", scheduleId, err)
+	}
+}
+
+// claim moves the schedule to its next run, the update only succeeds for one instance if several found
+// the schedule due
+func (as *ActionScheduler) claim(schedule scheduledAction, next time.Time, now time.Time) bool {
+
+	s, v, err := squirrel.Update("schedule").
+		Set("next_run_at", next).
+		Set("last_run_at", now).
+		Where(squirrel.Eq{"id": schedule.Id}).
+		Where(squirrel.Eq{"next_run_at": *schedule.NextRunAt}).ToSql()
+	CheckErr(err, "Failed to create schedule claim query")
+
+	res, err := as.db().Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to claim schedule [%v]: %v", schedule.Name, err)
+		return false
+	}
+
+	count, _ := res.RowsAffected()
+	return count == 1
+}
+
+func (as *ActionScheduler) run(schedule scheduledAction, startedAt time.Time) {
+
+	log.Infof("Running schedule [%v]: [%v][%v]", schedule.Name, schedule.OnType, schedule.ActionName)
+
+	responses, err := as.execute(schedule)
+
+	responsesJson, _ := json.Marshal(responses)
+	status := ScheduleRunStatusCompleted
+	errorMessage := ""
+	if err != nil {
+		status = ScheduleRunStatusFailed
+		errorMessage = err.Error()
+		log.Errorf("Schedule [%v] failed: %v", schedule.Name, err)
+	}
+
+	cols := []string{"reference_id", "permission", "created_at", "schedule_id", "status", "started_at", "completed_at", "responses", "error_message"}
+	vals := []interface{}{uuid.NewV4().String(), auth.NewPermission(auth.None, auth.None, auth.Read|auth.Delete).IntValue(), startedAt, schedule.Id, status, startedAt, time.Now(), string(responsesJson), errorMessage}
+	if schedule.UserId.Valid {
+		cols = append(cols, "user_id")
+		vals = append(vals, schedule.UserId.Int64)
+	}
+
+	s, v, queryErr := squirrel.Insert("schedule_run").Columns(cols...).Values(vals...).ToSql()
+	CheckErr(queryErr, "Failed to create schedule run insert query")
+	_, queryErr = as.db().Exec(s, v...)
+	if queryErr != nil {
+		log.Errorf("Failed to record run of schedule [%v]: %v", schedule.Name, queryErr)
+	}
+
+	update := squirrel.Update("schedule").Set("last_status", status)
+	if err == nil {
+		update = update.Set("failure_count", 0)
+	} else {
+		update = update.Set("failure_count", squirrel.Expr("failure_count + 1"))
+	}
+	s, v, queryErr = update.Where(squirrel.Eq{"id": schedule.Id}).ToSql()
+	CheckErr(queryErr, "Failed to create schedule update query")
+	_, queryErr = as.db().Exec(s, v...)
+	if queryErr != nil {
+		log.Errorf("Failed to update status of schedule [%v]: %v", schedule.Name, queryErr)
+	}
+
+	if err != nil {
+		as.notifyFailure(schedule, err)
+	}
+}
+
+// execute runs the action as the owner of the schedule, with the permissions they have now
+func (as *ActionScheduler) execute(schedule scheduledAction) (responses []ActionResponse, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Schedule [%v] panicked: %v", schedule.Name, r)
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	if _, ok := as.cruds[schedule.OnType]; !ok {
+		return nil, fmt.Errorf("No such entity [%v]", schedule.OnType)
+	}
+
+	attributes := make(map[string]interface{})
+	if schedule.Attributes.Valid && schedule.Attributes.String != "" {
+		err = json.Unmarshal([]byte(schedule.Attributes.String), &attributes)
+		if err != nil {
+			return nil, fmt.Errorf("Attributes are not valid json: %v", err)
+		}
+	}
+
+	var sessionUser auth.SessionUser
+	var tenant *Tenant
+	if schedule.UserId.Valid {
+		userReferenceId, err := as.cruds["user"].GetIdToReferenceId("user", schedule.UserId.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("Owner of the schedule not found: %v", err)
+		}
+		sessionUser, _, err = as.cruds["user"].GetSessionUser(userReferenceId)
+		if err != nil {
+			return nil, err
+		}
+		tenant = as.cruds["tenant"].GetTenantOfUser(sessionUser.UserId)
+	}
+
+	var subject map[string]interface{}
+	targetReferenceId := schedule.TargetReferenceId.String
+	if targetReferenceId != "" {
+		subject, err = as.cruds[schedule.OnType].GetReferenceIdToObjectInContext(NewTenantContext(sessionUser, tenant), schedule.OnType, targetReferenceId)
+		if err != nil {
+			return nil, fmt.Errorf("Target [%v] not found: %v", targetReferenceId, err)
+		}
+		subject["__type"] = schedule.OnType
+		attributes[schedule.OnType+"_id"] = targetReferenceId
+	}
+
+	if !CanExecuteAction(as.cruds, sessionUser, schedule.OnType, schedule.ActionName, subject) {
+		return nil, fmt.Errorf("Owner of the schedule is not allowed to run [%v][%v]", schedule.OnType, schedule.ActionName)
+	}
+
+	actionRequest := ActionRequest{
+		Type:       schedule.OnType,
+		Action:     schedule.ActionName,
+		Attributes: attributes,
+	}
+
+	return ExecuteActionAs(as.cruds, as.actionHandlerMap, actionRequest, sessionUser.UserReferenceId, targetReferenceId, tenant)
+}
+
+// notifyFailure records the failure in the timeline and notifies the owner of the schedule, and disables
+// the schedule when it keeps failing
+func (as *ActionScheduler) notifyFailure(schedule scheduledAction, err error) {
+
+	dr := as.cruds["schedule"]
+	dr.AddTimelineEvent("schedule", "schedule.failed", "Scheduled action failed", map[string]interface{}{
+		"schedule_id": schedule.ReferenceId,
+		"name":        schedule.Name,
+		"action":      schedule.ActionName,
+		"on_type":     schedule.OnType,
+		"error":       err.Error(),
+	})
+	as.notifyOwner(schedule, "schedule.failed", fmt.Sprintf("Schedule %v failed", schedule.Name),
+		fmt.Sprintf("[%v][%v] failed: %v", schedule.OnType, schedule.ActionName, err))
+
+	if schedule.FailureCount+1 < ScheduleMaxConsecutiveFailures {
+		return
+	}
+
+	s, v, queryErr := squirrel.Update("schedule").Set("enabled", false).Where(squirrel.Eq{"id": schedule.Id}).ToSql()
+	CheckErr(queryErr, "Failed to create schedule disable query")
+	_, queryErr = as.db().Exec(s, v...)
+	if queryErr != nil {
+		log.Errorf("Failed to disable schedule [%v]: %v", schedule.Name, queryErr)
+		return
+	}
+
+	log.Infof("Disabled schedule [%v] after %d failures in a row", schedule.Name, schedule.FailureCount+1)
+	dr.AddTimelineEvent("schedule", "schedule.disabled", "Scheduled action disabled", map[string]interface{}{
+		"schedule_id": schedule.ReferenceId,
+		"name":        schedule.Name,
+		"failures":    schedule.FailureCount + 1,
+	})
+	as.notifyOwner(schedule, "schedule.disabled", fmt.Sprintf("Schedule %v disabled", schedule.Name),
+		fmt.Sprintf("Disabled after %d failures in a row, enable it again once it is fixed", schedule.FailureCount+1))
+}
+
+func (as *ActionScheduler) notifyOwner(schedule scheduledAction, kind string, title string, message string) {
+	if !schedule.UserId.Valid {
+		return
+	}
+	err := as.cruds["schedule"].NotifyUser(schedule.UserId.Int64, kind, title, message)
+	if err != nil {
+		log.Errorf("Failed to notify owner of schedule [%v]: %v", schedule.Name, err)
+	}
+}
+
+// ScheduleListener resets the run state of a schedule when its cron expression is changed or it is
+// enabled again, so it is next due by the new expression and starts over with no failures
+type ScheduleListener struct {
+}
+
+func NewScheduleListener() *ScheduleListener {
+	return &ScheduleListener{}
+}
+
+func (sl *ScheduleListener) ListensTo(tableName string, event string) bool {
+	return tableName == "schedule" && event == TriggerEventUpdate
+}
+
+func (sl *ScheduleListener) OnEvent(dr *DbResource, event string, req *api2go.Request, row map[string]interface{}, previous map[string]interface{}) {
+
+	if previous == nil {
+		return
+	}
+
+	cronExpression := fmt.Sprintf("%v", row["cron_expression"])
+	cronChanged := cronExpression != fmt.Sprintf("%v", previous["cron_expression"])
+	enabledAgain := isTrueValue(row["enabled"]) && !isTrueValue(previous["enabled"])
+	if !cronChanged && !enabledAgain {
+		return
+	}
+
+	next, err := NextScheduledRun(cronExpression, time.Now())
+	if err != nil {
+		log.Errorf("Schedule [%v] has an invalid cron expression [%v]: %v", row["name"], cronExpression, err)
+		return
+	}
+
+	update := squirrel.Update("schedule").Set("next_run_at", next)
+	if enabledAgain {
+		update = update.Set("failure_count", 0)
+	}
+	s, v, err := update.Where(squirrel.Eq{"reference_id": row["reference_id"]}).ToSql()
+	CheckErr(err, "Failed to create schedule reset query")
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to reset schedule [%v]: %v", row["name"], err)
+	}
+}
+
+// isTrueValue reads a truefalse column, which comes back as a bool, a number or a string depending on the database
+func isTrueValue(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return typed
+	case []uint8:
+		value = string(typed)
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(fmt.Sprintf("%v", value)))
+	return err == nil && parsed
+}
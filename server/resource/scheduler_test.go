@@ -0,0 +1,82 @@
+package resource
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+	"time"
+)
+
+func TestScheduleListenerResetsRunState(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	lastYear := time.Now().AddDate(-1, 0, 0)
+	statements := []string{
+		"create table schedule (id integer primary key, reference_id varchar(40), name varchar(100), cron_expression varchar(100)," +
+			" enabled bool, next_run_at timestamp, failure_count int)",
+		"insert into schedule (reference_id, name, cron_expression, enabled, failure_count) values ('schedule-1', 'nightly', '@daily', 0, 10)",
+	}
+	for _, statement := range statements {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to set up the database [%v]: %v", statement, err)
+		}
+	}
+	db.Exec("update schedule set next_run_at = ?", lastYear)
+
+	dr := &DbResource{db: db}
+	listener := NewScheduleListener()
+
+	state := func() (time.Time, int) {
+		var next time.Time
+		var failures int
+		err := db.QueryRowx("select next_run_at, failure_count from schedule").Scan(&next, &failures)
+		if err != nil {
+			t.Fatalf("Failed to read schedule: %v", err)
+		}
+		return next, failures
+	}
+
+	previous := map[string]interface{}{"reference_id": "schedule-1", "name": "nightly", "cron_expression": "@daily", "enabled": int64(0)}
+
+	// a change to the name alone keeps the run state
+	listener.OnEvent(dr, TriggerEventUpdate, nil, map[string]interface{}{
+		"reference_id": "schedule-1", "name": "every night", "cron_expression": "@daily", "enabled": int64(0),
+	}, previous)
+	if next, failures := state(); next.After(time.Now()) || failures != 10 {
+		t.Errorf("The run state should be kept, got %v %v", next, failures)
+	}
+
+	listener.OnEvent(dr, TriggerEventUpdate, nil, map[string]interface{}{
+		"reference_id": "schedule-1", "name": "nightly", "cron_expression": "@hourly", "enabled": int64(0),
+	}, previous)
+	if next, failures := state(); !next.After(time.Now()) || next.After(time.Now().Add(time.Hour)) || failures != 10 {
+		t.Errorf("A new expression should be due within the hour, with the failures kept, got %v %v", next, failures)
+	}
+
+	listener.OnEvent(dr, TriggerEventUpdate, nil, map[string]interface{}{
+		"reference_id": "schedule-1", "name": "nightly", "cron_expression": "@daily", "enabled": true,
+	}, previous)
+	if _, failures := state(); failures != 0 {
+		t.Errorf("A schedule enabled again should start with no failures, got %v", failures)
+	}
+}
+
+func TestIsTrueValue(t *testing.T) {
+
+	for _, value := range []interface{}{true, int64(1), "1", "true", []uint8("1")} {
+		if !isTrueValue(value) {
+			t.Errorf("[%v] should be true", value)
+		}
+	}
+	for _, value := range []interface{}{nil, false, int64(0), "0", "false", "", []uint8("0")} {
+		if isTrueValue(value) {
+			t.Errorf("[%v] should be false", value)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package resource
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dop251/goja"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a script running longer than this is interrupted
+const ScriptTimeout = 500 * time.Millisecond
+
+// a script is interrupted when the heap grows by more than this while it runs. This is best effort: the
+// heap is measured for the whole process, so other work can count against a script and a script can
+// allocate a lot between two samples. It is a guard against runaway scripts, not a budget.
+const ScriptMaxHeapGrowth = 64 << 20
+
+// how often the heap is looked at while a script runs. Reading the heap stops the world, so it is done
+// rarely, scripts finishing sooner are never sampled.
+const scriptWatchInterval = 100 * time.Millisecond
+
+// compiled scripts are kept, expressions are the same on every run of an action
+const scriptCacheSize = 1000
+
+// ScriptError is a script which failed to compile or run, with the position of the failure when goja
+// reports it
+type ScriptError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("script error at line %d, column %d: %v", e.Line, e.Column, e.Message)
+	}
+	return "script error: " + e.Message
+}
+
+// syntax errors read "Line 1:5 Unexpected token", exceptions carry a stack with "<eval>:1:5(3)"
+var scriptSyntaxPosition = regexp.MustCompile(`Line (\d+):(\d+)`)
+var scriptStackPosition = regexp.MustCompile(`(?:at )?<eval>:(\d+):(\d+)(?:\(\d+\))?`)
+
+var scriptSlugSeparator = regexp.MustCompile(`[^a-z0-9]+`)
+
+func newScriptError(err error) *ScriptError {
+
+	message := err.Error()
+	scriptError := &ScriptError{
+		Message: strings.TrimSpace(strings.Split(message, "\n")[0]),
+	}
+
+	position := scriptSyntaxPosition.FindStringSubmatch(message)
+	if position == nil {
+		position = scriptStackPosition.FindStringSubmatch(message)
+	}
+	if position != nil {
+		scriptError.Line, _ = strconv.Atoi(position[1])
+		scriptError.Column, _ = strconv.Atoi(position[2])
+		scriptError.Message = strings.Join(strings.Fields(strings.Replace(scriptError.Message, position[0], "", 1)), " ")
+	}
+
+	return scriptError
+}
+
+var scriptCache = struct {
+	sync.RWMutex
+	programs map[string]*goja.Program
+}{programs: make(map[string]*goja.Program)}
+
+func compileScript(source string) (*goja.Program, error) {
+
+	scriptCache.RLock()
+	program, ok := scriptCache.programs[source]
+	scriptCache.RUnlock()
+	if ok {
+		return program, nil
+	}
+
+	program, err := goja.Compile("<eval>", source, false)
+	if err != nil {
+		return nil, newScriptError(err)
+	}
+
+	scriptCache.Lock()
+	if len(scriptCache.programs) >= scriptCacheSize {
+		scriptCache.programs = make(map[string]*goja.Program)
+	}
+	scriptCache.programs[source] = program
+	scriptCache.Unlock()
+
+	return program, nil
+}
+
+// the builtins and the library are frozen, so a script cannot change them for the scripts run after it
+// on the same vm. __reset removes the globals added after this point, globals declared with var cannot be
+// deleted and are set to undefined. Globals which were there from the start, but were replaced by a value
+// of the context or by the script, are put back.
+const scriptPrepareVm = `(function (g) {
+	var names = ["Object", "Function", "Array", "String", "Number", "Boolean", "Date", "RegExp", "Error",
+		"TypeError", "RangeError", "SyntaxError", "ReferenceError", "Math", "JSON", "crypto", "date", "strings"];
+	for (var i = 0; i < names.length; i++) {
+		var value = g[names[i]];
+		if (!value) {
+			continue;
+		}
+		Object.freeze(value);
+		if (value.prototype) {
+			Object.freeze(value.prototype);
+		}
+	}
+	var initial = Object.create(null);
+	var initialNames = Object.getOwnPropertyNames(g);
+	for (var i = 0; i < initialNames.length; i++) {
+		initial[initialNames[i]] = g[initialNames[i]];
+	}
+	Object.defineProperty(g, "__reset", {
+		enumerable: false,
+		configurable: false,
+		writable: false,
+		value: function () {
+			var current = Object.getOwnPropertyNames(g);
+			for (var i = 0; i < current.length; i++) {
+				if (current[i] in initial || current[i] === "__reset") {
+					continue;
+				}
+				if (!delete g[current[i]]) {
+					g[current[i]] = undefined;
+				}
+			}
+			for (var i = 0; i < initialNames.length; i++) {
+				if (g[initialNames[i]] !== initial[initialNames[i]]) {
+					g[initialNames[i]] = initial[initialNames[i]];
+				}
+			}
+		}
+	});
+})(this)`
+
+// globals which cannot be replaced, values of the context with these names are refused. Other globals, like
+// the library, can be shadowed by the context and are put back by __reset.
+var scriptReservedNames = map[string]bool{
+	"undefined": true,
+	"NaN":       true,
+	"Infinity":  true,
+	"__reset":   true,
+}
+
+var scriptResetProgram = func() *goja.Program {
+	program, err := goja.Compile("<reset>", "__reset()", false)
+	CheckErr(err, "Failed to compile script vm reset")
+	return program
+}()
+
+type scriptVm struct {
+	runtime *goja.Runtime
+}
+
+var scriptVmPool = sync.Pool{
+	New: func() interface{} {
+		return newScriptVm()
+	},
+}
+
+func newScriptVm() *scriptVm {
+
+	vm := goja.New()
+	installScriptLibrary(vm)
+
+	_, err := vm.RunString(scriptPrepareVm)
+	if err != nil {
+		log.Errorf("Failed to prepare script vm: %v", err)
+	}
+
+	return &scriptVm{
+		runtime: vm,
+	}
+}
+
+// reset removes what the last script left on the global object, it returns false if the vm cannot be
+// reused
+func (svm *scriptVm) reset() bool {
+	_, err := svm.runtime.RunProgram(scriptResetProgram)
+	if err != nil {
+		log.Errorf("Failed to reset script vm: %v", err)
+		return false
+	}
+	return true
+}
+
+func releaseScriptVm(svm *scriptVm) {
+	if svm.reset() {
+		scriptVmPool.Put(svm)
+	}
+}
+
+// installScriptLibrary adds the helpers scripts can use on top of the javascript builtins
+func installScriptLibrary(vm *goja.Runtime) {
+
+	cryptoLib := vm.NewObject()
+	cryptoLib.Set("md5", func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	})
+	cryptoLib.Set("sha1", func(value string) string {
+		sum := sha1.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	})
+	cryptoLib.Set("sha256", func(value string) string {
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])
+	})
+	cryptoLib.Set("hmacSha256", func(key string, value string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	})
+	cryptoLib.Set("base64Encode", func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	})
+	cryptoLib.Set("base64Decode", func(value string) string {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			panic(vm.ToValue("invalid base64: " + err.Error()))
+		}
+		return string(decoded)
+	})
+	cryptoLib.Set("uuid", func() string {
+		return uuid.NewV4().String()
+	})
+	vm.Set("crypto", cryptoLib)
+
+	// dates are passed around as RFC3339 strings, layouts are go time layouts
+	dateLib := vm.NewObject()
+	dateLib.Set("now", func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	})
+	dateLib.Set("unix", func() int64 {
+		return time.Now().Unix()
+	})
+	dateLib.Set("format", func(value string, layout string) string {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(vm.ToValue("invalid date: " + value))
+		}
+		return t.Format(layout)
+	})
+	dateLib.Set("add", func(value string, duration string) string {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(vm.ToValue("invalid date: " + value))
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			panic(vm.ToValue("invalid duration: " + duration))
+		}
+		return t.Add(d).Format(time.RFC3339)
+	})
+	vm.Set("date", dateLib)
+
+	stringsLib := vm.NewObject()
+	stringsLib.Set("slug", func(value string) string {
+		return strings.Trim(scriptSlugSeparator.ReplaceAllString(strings.ToLower(value), "-"), "-")
+	})
+	stringsLib.Set("title", func(value string) string {
+		return strings.Title(value)
+	})
+	stringsLib.Set("contains", func(value string, part string) bool {
+		return strings.Contains(value, part)
+	})
+	stringsLib.Set("split", func(value string, separator string) []string {
+		return strings.Split(value, separator)
+	})
+	stringsLib.Set("join", func(values []string, separator string) string {
+		return strings.Join(values, separator)
+	})
+	vm.Set("strings", stringsLib)
+}
+
+// scriptValue is a copy of a value passed to a script as plain json values, scripts cannot reach the go
+// values behind the action context or change them
+func scriptValue(value interface{}) (interface{}, error) {
+
+	switch value.(type) {
+	case nil, string, bool, int, int64, float64:
+		return value, nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied interface{}
+	err = json.Unmarshal(encoded, &copied)
+	return copied, err
+}
+
+// runSandboxedJavascript evaluates a script with the values of contextMap as globals. The script runs on a
+// pooled vm, is interrupted after ScriptTimeout or when the heap grows by ScriptMaxHeapGrowth, and fails
+// with a ScriptError.
+func runSandboxedJavascript(source string, contextMap map[string]interface{}) (interface{}, error) {
+	return runScript(source, contextMap, nil, ScriptTimeout)
+}
+
+// runScript is runSandboxedJavascript with a timeout, install adds globals which are not copies, like the
+// functions of the script performers. They are removed with the other globals once the script is done.
+func runScript(source string, contextMap map[string]interface{}, install func(vm *goja.Runtime), timeout time.Duration) (interface{}, error) {
+
+	program, err := compileScript(source)
+	if err != nil {
+		return nil, err
+	}
+
+	svm := scriptVmPool.Get().(*scriptVm)
+	vm := svm.runtime
+
+	for key, val := range contextMap {
+		// only the values the script mentions are copied in, the context can hold whole files
+		if !strings.Contains(source, key) {
+			continue
+		}
+		if scriptReservedNames[key] {
+			releaseScriptVm(svm)
+			return nil, &ScriptError{Message: fmt.Sprintf("cannot pass [%v] to the script, the name is reserved", key)}
+		}
+		copied, err := scriptValue(val)
+		if err != nil {
+			releaseScriptVm(svm)
+			return nil, &ScriptError{Message: fmt.Sprintf("cannot pass [%v] to the script: %v", key, err)}
+		}
+		vm.Set(key, copied)
+	}
+	if install != nil {
+		install(vm)
+	}
+
+	done := make(chan struct{})
+	watchResult := make(chan string, 1)
+	go watchScript(vm, timeout, done, watchResult)
+
+	value, err := vm.RunProgram(program)
+
+	close(done)
+	interruptReason := <-watchResult
+
+	// an interrupted vm is not reused, it might still carry the interrupt
+	if interruptReason == "" {
+		releaseScriptVm(svm)
+	}
+
+	if interruptReason != "" {
+		return nil, &ScriptError{Message: interruptReason}
+	}
+	if err != nil {
+		return nil, newScriptError(err)
+	}
+
+	return value.Export(), nil
+}
+
+// watchScript interrupts the vm when the script takes too long or allocates too much, and tells the
+// reason once the script is done
+func watchScript(vm *goja.Runtime, timeout time.Duration, done chan struct{}, result chan string) {
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(scriptWatchInterval)
+	defer ticker.Stop()
+
+	var startHeap uint64
+	var memStats runtime.MemStats
+	reason := ""
+
+	for {
+		select {
+		case <-done:
+			result <- reason
+			return
+		case <-deadline.C:
+			if reason == "" {
+				reason = fmt.Sprintf("script did not finish in %v", timeout)
+				vm.Interrupt(reason)
+			}
+		case <-ticker.C:
+			if reason != "" {
+				continue
+			}
+			runtime.ReadMemStats(&memStats)
+			if startHeap == 0 {
+				startHeap = memStats.HeapAlloc
+				continue
+			}
+			if memStats.HeapAlloc > startHeap && memStats.HeapAlloc-startHeap > ScriptMaxHeapGrowth {
+				reason = fmt.Sprintf("script allocated more than %d MB", ScriptMaxHeapGrowth>>20)
+				vm.Interrupt(reason)
+			}
+		}
+	}
+}
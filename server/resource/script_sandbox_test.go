@@ -0,0 +1,84 @@
+package resource
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewScriptErrorPosition(t *testing.T) {
+
+	cases := []struct {
+		message string
+		line    int
+		column  int
+		text    string
+	}{
+		{"SyntaxError: (anonymous): Line 2:7 Unexpected token )", 2, 7, "SyntaxError: (anonymous): Unexpected token )"},
+		{"ReferenceError: total is not defined at <eval>:3:12(4)\n\tat other", 3, 12, "ReferenceError: total is not defined"},
+		{"script failed", 0, 0, "script failed"},
+	}
+
+	for _, c := range cases {
+		scriptError := newScriptError(errors.New(c.message))
+		if scriptError.Line != c.line || scriptError.Column != c.column || scriptError.Message != c.text {
+			t.Errorf("[%v]: expected %d:%d [%v], got %d:%d [%v]", c.message, c.line, c.column, c.text,
+				scriptError.Line, scriptError.Column, scriptError.Message)
+		}
+	}
+
+	_, err := runSandboxedJavascript("var a = 1;\nvar b = ;", nil)
+	scriptError, ok := err.(*ScriptError)
+	if !ok || scriptError.Line != 2 {
+		t.Errorf("Expected a syntax error on line 2, got %v", err)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+
+	started := time.Now()
+	_, err := runScript("while (true) {}", nil, nil, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Errorf("Expected the script to be interrupted, got %v", err)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("The script should have been interrupted soon after the timeout, took %v", time.Since(started))
+	}
+
+	// the pool still gives working vms
+	value, err := runSandboxedJavascript("1 + 1", nil)
+	if err != nil || value != int64(2) {
+		t.Errorf("Expected 2, got %v %v", value, err)
+	}
+}
+
+func TestScriptContextDoesNotLeak(t *testing.T) {
+
+	// a value of the context named like the library shadows it for this script only
+	value, err := runSandboxedJavascript("date + 1", map[string]interface{}{"date": int64(41)})
+	if err != nil || value != int64(42) {
+		t.Fatalf("Expected the value of the context, got %v %v", value, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		value, err = runSandboxedJavascript("typeof date.now", nil)
+		if err != nil || value != "function" {
+			t.Errorf("The library should be back for the next script, got %v %v", value, err)
+		}
+	}
+
+	_, err = runSandboxedJavascript("strings = 1; leaked = 2; 0", nil)
+	if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	value, err = runSandboxedJavascript("typeof strings.slug + typeof leaked", nil)
+	if err != nil || value != "functionundefined" {
+		t.Errorf("Globals set by a script should not reach the next one, got %v %v", value, err)
+	}
+
+	_, err = runSandboxedJavascript("__reset", map[string]interface{}{"__reset": "value"})
+	if err == nil {
+		t.Errorf("A reserved name should be refused")
+	}
+}
@@ -0,0 +1,400 @@
+package resource
+
+import (
+	"context"
+	"database/sql"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"gopkg.in/gin-gonic/gin.v1"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenant scoped tables get this column, it is never read or written through the api
+const TenantColumnName = "tenant_id"
+
+// the tenant can be asked for by name in this header when the hostname is shared
+const TenantHeader = "X-Daptin-Tenant"
+
+// tenants are cached by the resolver, a new or changed tenant is picked up within this duration
+const TenantCacheValidity = time.Minute
+
+type Tenant struct {
+	Id          int64
+	ReferenceId string
+	Name        string
+	Hostname    string
+}
+
+// CheckTenantColumns adds the tenant column to the tables marked as tenant scoped. The user table is
+// not scoped, the tenant of a user is set through its tenant relation.
+func CheckTenantColumns(config *CmsConfig) {
+
+	for i, table := range config.Tables {
+
+		if !table.IsTenantScoped {
+			continue
+		}
+
+		if table.TableName == "user" || table.TableName == "tenant" {
+			log.Errorf("[%v] cannot be tenant scoped", table.TableName)
+			config.Tables[i].IsTenantScoped = false
+			continue
+		}
+
+		hasColumn := false
+		for _, col := range table.Columns {
+			if col.ColumnName == TenantColumnName {
+				hasColumn = true
+				break
+			}
+		}
+
+		if hasColumn {
+			continue
+		}
+
+		log.Infof("Add tenant column to [%v]", table.TableName)
+		config.Tables[i].Columns = append(config.Tables[i].Columns, api2go.ColumnInfo{
+			Name:           TenantColumnName,
+			ColumnName:     TenantColumnName,
+			DataType:       "INTEGER",
+			ColumnType:     "measurement",
+			IsNullable:     true,
+			IsIndexed:      true,
+			ExcludeFromApi: true,
+		})
+	}
+
+}
+
+// TenantFromRequest is the tenant resolved by the TenantResolver for this request, nil if there is none
+func TenantFromRequest(req api2go.Request) *Tenant {
+	if req.PlainRequest == nil {
+		return nil
+	}
+	tenant, ok := req.PlainRequest.Context().Value("tenant").(Tenant)
+	if !ok {
+		return nil
+	}
+	return &tenant
+}
+
+func (dr *DbResource) IsTenantScoped() bool {
+	return dr.tenantScoped
+}
+
+func (dr *DbResource) SetTenantScoped(tenantScoped bool) {
+	dr.tenantScoped = tenantScoped
+}
+
+// tenantScope is the tenant work done in ctx is for, ctx has the "user" and "tenant" values the same way a
+// request context does. unrestricted is true for the administrator outside of any tenant.
+func (dr *DbResource) tenantScope(ctx context.Context) (*Tenant, bool) {
+
+	if ctx == nil {
+		return nil, false
+	}
+
+	tenant, ok := ctx.Value("tenant").(Tenant)
+	if ok {
+		return &tenant, false
+	}
+
+	user, ok := ctx.Value("user").(auth.SessionUser)
+	return nil, ok && dr.IsAdmin(user.UserReferenceId)
+}
+
+// TenantWhereFor is the condition every query on a tenant scoped table is restricted with. Rows of a
+// tenant are only visible to work done for that tenant, and rows without a tenant only to work done
+// without one. The administrator outside of any tenant sees everything.
+func (dr *DbResource) TenantWhereFor(ctx context.Context) squirrel.Sqlizer {
+
+	if !dr.tenantScoped {
+		return nil
+	}
+
+	column := dr.model.GetName() + "." + TenantColumnName
+
+	tenant, unrestricted := dr.tenantScope(ctx)
+	if tenant != nil {
+		return squirrel.Eq{column: tenant.Id}
+	}
+	if unrestricted {
+		return nil
+	}
+
+	return squirrel.Eq{column: nil}
+}
+
+// tenantWhereOf is the TenantWhereFor of the table, nil for tables without a resource
+func (dr *DbResource) tenantWhereOf(ctx context.Context, typeName string) squirrel.Sqlizer {
+	target, ok := dr.cruds[typeName]
+	if !ok {
+		return nil
+	}
+	return target.TenantWhereFor(ctx)
+}
+
+// GetTenantWhere is TenantWhereFor the tenant and user of the request
+func (dr *DbResource) GetTenantWhere(req api2go.Request) squirrel.Sqlizer {
+	if req.PlainRequest == nil {
+		return dr.TenantWhereFor(nil)
+	}
+	return dr.TenantWhereFor(req.PlainRequest.Context())
+}
+
+// IsRowInTenant checks a row which is already loaded the same way TenantWhereFor restricts queries
+func (dr *DbResource) IsRowInTenant(ctx context.Context, row map[string]interface{}) bool {
+
+	if !dr.tenantScoped {
+		return true
+	}
+
+	tenant, unrestricted := dr.tenantScope(ctx)
+	rowTenant := row[TenantColumnName]
+	if tenant != nil {
+		return rowTenant != nil && int64(toInt(rowTenant)) == tenant.Id
+	}
+
+	return unrestricted || rowTenant == nil
+}
+
+// filterIncludesByTenant drops the included rows of tenant scoped tables which are not in the tenant of the request
+func (dr *DbResource) filterIncludesByTenant(req api2go.Request, includes []map[string]interface{}) []map[string]interface{} {
+
+	var ctx context.Context
+	if req.PlainRequest != nil {
+		ctx = req.PlainRequest.Context()
+	}
+
+	filtered := make([]map[string]interface{}, 0, len(includes))
+	for _, include := range includes {
+		typeName, _ := include["__type"].(string)
+		target, ok := dr.cruds[typeName]
+		if ok && !target.IsRowInTenant(ctx, include) {
+			continue
+		}
+		filtered = append(filtered, include)
+	}
+
+	return filtered
+}
+
+// NewTenantContext is the context for work done for the user in the tenant outside of a request, with the
+// "user" and "tenant" values a request context has. Either can be empty.
+func NewTenantContext(sessionUser auth.SessionUser, tenant *Tenant) context.Context {
+
+	ctx := context.Background()
+	if sessionUser.UserReferenceId != "" {
+		ctx = context.WithValue(ctx, "user", sessionUser)
+	}
+	if tenant != nil {
+		ctx = context.WithValue(ctx, "tenant", *tenant)
+	}
+
+	return ctx
+}
+
+// GetTenantOfUser is the tenant the user belongs to, for work done on their behalf outside of a request.
+// It is nil when the user has no tenant or their tenant is disabled.
+func (dr *DbResource) GetTenantOfUser(userId int64) *Tenant {
+
+	s, v, err := squirrel.Select("t.id", "t.reference_id", "t.name", "t.hostname").
+		From("user u").
+		Join("tenant t on t.id = u." + TenantColumnName).
+		Where(squirrel.Eq{"u.id": userId}).
+		Where(squirrel.Eq{"t.enabled": true}).ToSql()
+	CheckErr(err, "Failed to create user tenant select query")
+
+	var tenant Tenant
+	var hostname sql.NullString
+	err = dr.db.QueryRowx(s, v...).Scan(&tenant.Id, &tenant.ReferenceId, &tenant.Name, &hostname)
+	if err != nil {
+		return nil
+	}
+	tenant.Hostname = strings.ToLower(hostname.String)
+	return &tenant
+}
+
+// isReferableInTenant checks that an object referred to in a create or update belongs to the tenant of
+// the request, when its table is tenant scoped
+func (dr *DbResource) isReferableInTenant(typeName string, referenceId string, req api2go.Request) bool {
+
+	target, ok := dr.cruds[typeName]
+	if !ok {
+		return true
+	}
+
+	tenantWhere := target.GetTenantWhere(req)
+	if tenantWhere == nil {
+		return true
+	}
+
+	return target.IsRowVisibleByPolicy(referenceId, tenantWhere)
+}
+
+// TenantResolver finds the tenant of each request. For a signed in user it is the tenant they belong
+// to, otherwise the tenant named in the X-Daptin-Tenant header or the one with the requested hostname.
+// A signed in user cannot reach a tenant other than their own.
+type TenantResolver struct {
+	db       *sqlx.DB
+	lock     sync.RWMutex
+	tenants  []Tenant
+	loadedAt time.Time
+}
+
+func NewTenantResolver(db *sqlx.DB) *TenantResolver {
+	return &TenantResolver{
+		db: db,
+	}
+}
+
+func (tr *TenantResolver) getTenants() []Tenant {
+
+	tr.lock.RLock()
+	if time.Since(tr.loadedAt) < TenantCacheValidity {
+		defer tr.lock.RUnlock()
+		return tr.tenants
+	}
+	tr.lock.RUnlock()
+
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+
+	s, v, err := squirrel.Select("id", "reference_id", "name", "hostname").
+		From("tenant").
+		Where(squirrel.Eq{"enabled": true}).ToSql()
+	CheckErr(err, "Failed to create tenant select query")
+
+	rows, err := tr.db.Queryx(s, v...)
+	if err != nil {
+		log.Errorf("Failed to load tenants: %v", err)
+		return tr.tenants
+	}
+	defer rows.Close()
+
+	tenants := make([]Tenant, 0)
+	for rows.Next() {
+		var tenant Tenant
+		var hostname sql.NullString
+		err = rows.Scan(&tenant.Id, &tenant.ReferenceId, &tenant.Name, &hostname)
+		if err != nil {
+			log.Errorf("Failed to scan tenant: %v", err)
+			continue
+		}
+		tenant.Hostname = strings.ToLower(hostname.String)
+		tenants = append(tenants, tenant)
+	}
+
+	tr.tenants = tenants
+	tr.loadedAt = time.Now()
+	return tenants
+}
+
+func (tr *TenantResolver) findTenant(match func(tenant Tenant) bool) *Tenant {
+	for _, tenant := range tr.getTenants() {
+		if match(tenant) {
+			t := tenant
+			return &t
+		}
+	}
+	return nil
+}
+
+// requestedTenant is the tenant asked for by the header or the hostname, ok is false when the header
+// names a tenant which does not exist
+func (tr *TenantResolver) requestedTenant(r *http.Request) (*Tenant, bool) {
+
+	name := strings.TrimSpace(r.Header.Get(TenantHeader))
+	if name != "" {
+		tenant := tr.findTenant(func(tenant Tenant) bool {
+			return tenant.Name == name
+		})
+		return tenant, tenant != nil
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return nil, true
+	}
+
+	return tr.findTenant(func(tenant Tenant) bool {
+		return tenant.Hostname == host
+	}), true
+}
+
+func (tr *TenantResolver) TenantMiddleware(c *gin.Context) {
+
+	requested, ok := tr.requestedTenant(c.Request)
+	if !ok {
+		log.Infof("Unknown tenant [%v]", c.Request.Header.Get(TenantHeader))
+		c.AbortWithStatus(404)
+		return
+	}
+
+	tenant := requested
+
+	user, isSignedIn := c.Request.Context().Value("user").(auth.SessionUser)
+	if isSignedIn && user.UserId != 0 {
+
+		// the tenant of a user can be changed after the token was issued, so it is checked against the
+		// user every time
+		var userTenantId sql.NullInt64
+		err := tr.db.QueryRowx("select "+TenantColumnName+" from user where id = ?", user.UserId).Scan(&userTenantId)
+		if err != nil {
+			log.Errorf("Failed to get tenant of user [%v]: %v", user.UserReferenceId, err)
+			c.AbortWithStatus(401)
+			return
+		}
+
+		var userTenant *Tenant
+		if userTenantId.Valid {
+			userTenant = tr.findTenant(func(tenant Tenant) bool {
+				return tenant.Id == userTenantId.Int64
+			})
+			if userTenant == nil {
+				log.Infof("Tenant of user [%v] is disabled", user.UserReferenceId)
+				c.AbortWithStatus(403)
+				return
+			}
+		}
+
+		if user.Tenant != "" && (userTenant == nil || userTenant.ReferenceId != user.Tenant) {
+			log.Infof("Token of [%v] was issued for another tenant", user.UserReferenceId)
+			c.AbortWithStatus(401)
+			return
+		}
+
+		if userTenant != nil {
+			if requested != nil && requested.Id != userTenant.Id {
+				log.Infof("User [%v] of tenant [%v] asked for tenant [%v]", user.UserReferenceId, userTenant.Name, requested.Name)
+				c.AbortWithStatus(403)
+				return
+			}
+			tenant = userTenant
+		} else if requested != nil {
+			adminUserId, _ := GetAdminUserIdAndUserGroupId(tr.db)
+			if adminUserId != user.UserId {
+				log.Infof("User [%v] is not a member of tenant [%v]", user.UserReferenceId, requested.Name)
+				c.AbortWithStatus(403)
+				return
+			}
+		}
+	}
+
+	if tenant != nil {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "tenant", *tenant))
+	}
+	c.Next()
+}
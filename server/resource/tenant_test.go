@@ -0,0 +1,108 @@
+package resource
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"testing"
+)
+
+func newTenantTestResource(t *testing.T) *DbResource {
+
+	dr := newTestDbResources(t, TableInfo{
+		TableName:      "note",
+		IsTenantScoped: true,
+		Columns: []api2go.ColumnInfo{
+			{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(80)"},
+		},
+	})["note"]
+
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "admin", "email": "admin@example.com", "reference_id": "admin-1"})
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "ada", "email": "ada@example.com", "reference_id": "user-2"})
+	insertTestRow(t, dr.db, "usergroup", map[string]interface{}{"name": "administrators", "reference_id": "usergroup-1"})
+	insertTestRow(t, dr.db, "note", map[string]interface{}{"title": "first", "reference_id": "note-1", TenantColumnName: 1})
+	insertTestRow(t, dr.db, "note", map[string]interface{}{"title": "second", "reference_id": "note-2", TenantColumnName: 2})
+	insertTestRow(t, dr.db, "note", map[string]interface{}{"title": "third", "reference_id": "note-3"})
+
+	return dr
+}
+
+func TestTenantWhereFor(t *testing.T) {
+
+	dr := newTenantTestResource(t)
+	defer dr.db.Close()
+
+	tenant := &Tenant{Id: 1, Name: "first"}
+	admin := auth.SessionUser{UserId: 1, UserReferenceId: "admin-1"}
+	user := auth.SessionUser{UserId: 2, UserReferenceId: "user-2"}
+
+	cases := []struct {
+		name     string
+		ctx      context.Context
+		expected []string
+	}{
+		{"user in the tenant", NewTenantContext(user, tenant), []string{"note-1"}},
+		{"admin in the tenant", NewTenantContext(admin, tenant), []string{"note-1"}},
+		{"user without a tenant", NewTenantContext(user, nil), []string{"note-3"}},
+		{"admin without a tenant", NewTenantContext(admin, nil), []string{"note-1", "note-2", "note-3"}},
+		{"nobody", nil, []string{"note-3"}},
+	}
+
+	for _, c := range cases {
+		rows, _, err := dr.GetRowsByWhereClauseInContext(c.ctx, "note")
+		if err != nil {
+			t.Fatalf("[%v]: failed to load rows: %v", c.name, err)
+		}
+		found := make([]string, 0)
+		for _, row := range rows {
+			found = append(found, row["reference_id"].(string))
+			if !dr.IsRowInTenant(c.ctx, row) {
+				t.Errorf("[%v]: a loaded row should be in the tenant %v", c.name, row)
+			}
+		}
+		if len(found) != len(c.expected) {
+			t.Errorf("[%v]: expected %v, got %v", c.name, c.expected, found)
+			continue
+		}
+		for i := range found {
+			if found[i] != c.expected[i] {
+				t.Errorf("[%v]: expected %v, got %v", c.name, c.expected, found)
+			}
+		}
+	}
+
+	ctx := NewTenantContext(user, tenant)
+	if _, err := dr.GetReferenceIdToObjectInContext(ctx, "note", "note-2"); err == nil {
+		t.Errorf("A row of another tenant should not be found")
+	}
+	if _, err := dr.GetReferenceIdToObjectInContext(ctx, "note", "note-1"); err != nil {
+		t.Errorf("A row of the tenant should be found: %v", err)
+	}
+	if _, err := dr.GetReferenceIdToObject("note", "note-2"); err != nil {
+		t.Errorf("System work should find every row: %v", err)
+	}
+}
+
+func TestFilterIncludesByTenant(t *testing.T) {
+
+	dr := newTenantTestResource(t)
+	defer dr.db.Close()
+
+	includes := []map[string]interface{}{
+		{"__type": "note", "reference_id": "note-1", TenantColumnName: int64(1)},
+		{"__type": "note", "reference_id": "note-2", TenantColumnName: int64(2)},
+		{"__type": "note", "reference_id": "note-3", TenantColumnName: nil},
+		{"__type": "user", "reference_id": "user-2"},
+	}
+
+	req := api2go.Request{}
+	filtered := dr.filterIncludesByTenant(req, includes)
+	if len(filtered) != 2 || filtered[0]["reference_id"] != "note-3" || filtered[1]["reference_id"] != "user-2" {
+		t.Errorf("Expected the rows without a tenant and the user, got %v", filtered)
+	}
+
+	ctx := NewTenantContext(auth.SessionUser{UserId: 2, UserReferenceId: "user-2"}, &Tenant{Id: 2, Name: "second"})
+	if dr.IsRowInTenant(ctx, includes[0]) || !dr.IsRowInTenant(ctx, includes[1]) || dr.IsRowInTenant(ctx, includes[2]) {
+		t.Errorf("Only the row of the second tenant should be in it")
+	}
+}
@@ -0,0 +1,54 @@
+package resource
+
+import (
+	"encoding/json"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"time"
+)
+
+// AddTimelineEvent records an event which happened on a table in the timeline. The rows are owned by
+// the administrator and are not readable by anyone else.
+func (dr *DbResource) AddTimelineEvent(tableName string, eventType string, title string, payload map[string]interface{}) error {
+
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(title) > 50 {
+		title = title[0:50]
+	}
+
+	insertMap := map[string]interface{}{}
+	insertMap["event_type"] = eventType
+	insertMap["title"] = title
+	insertMap["payload"] = string(payloadJson)
+	insertMap["reference_id"] = uuid.NewV4().String()
+	insertMap["permission"] = auth.NewPermission(auth.None, auth.None, auth.Read).IntValue()
+	insertMap["created_at"] = time.Now()
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+	if adminUserId > 0 {
+		insertMap["user_id"] = adminUserId
+	}
+
+	var worldId int64
+	s, v, err := squirrel.Select("id").From("world").Where(squirrel.Eq{"table_name": tableName}).ToSql()
+	if err == nil && dr.db.QueryRowx(s, v...).Scan(&worldId) == nil {
+		insertMap["world_id"] = worldId
+	}
+
+	s, v, err = squirrel.Insert("timeline").SetMap(insertMap).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to add timeline event [%v] %v: %v", eventType, title, err)
+	}
+	return err
+}
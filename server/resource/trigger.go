@@ -0,0 +1,247 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	TriggerEventCreate = "create"
+	TriggerEventUpdate = "update"
+	TriggerEventDelete = "delete"
+)
+
+// writes made by a triggered action can fire triggers again, up to this depth. Deeper writes are not
+// triggered, so triggers updating each other's tables do not go on forever.
+const MaxTriggerDepth = 3
+
+// Trigger runs an action when rows of a table are created, updated or deleted. Triggers are declared in
+// the schema files.
+//
+//	Triggers:
+//	- Name: notify_on_big_order
+//	  OnType: order
+//	  Events: ["create"]
+//	  Condition: "!subject.total > 1000"
+//	  ActionOnType: order
+//	  Action: notify_sales
+//	  Attributes:
+//	    order_id: "~subject.reference_id"
+//	  IsAsync: true
+type Trigger struct {
+	Name   string
+	OnType string
+	// create, update and delete
+	Events []string
+	// a javascript expression, the action is run only if it is true. It can use subject, the row after the
+	// change (before the change for delete), and previous, the row before an update
+	Condition string
+	Action    string
+	// the type the action is defined on, the table of the trigger if empty
+	ActionOnType string
+	// the input fields of the action, with the same ~ and ! syntax as outcome attributes
+	Attributes map[string]interface{}
+	// run the action as a job instead of before the response is sent
+	IsAsync bool
+}
+
+func (t Trigger) actionOnType() string {
+	if t.ActionOnType == "" {
+		return t.OnType
+	}
+	return t.ActionOnType
+}
+
+func (t Trigger) firesOn(event string) bool {
+	for _, e := range t.Events {
+		if strings.ToLower(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// TriggerSet holds the triggers of each table and runs them
+type TriggerSet struct {
+	cruds            *map[string]*DbResource
+	triggers         map[string][]Trigger
+	actionHandlerMap map[string]ActionPerformerInterface
+}
+
+// NewTriggerSet checks the triggers in the config, the ones on unknown tables, events or actions are
+// reported and left out
+func NewTriggerSet(cmsConfig *CmsConfig, cruds *map[string]*DbResource) *TriggerSet {
+
+	tables := make(map[string]bool)
+	for _, table := range cmsConfig.Tables {
+		tables[table.TableName] = true
+	}
+
+	actions := make(map[string]bool)
+	for _, action := range cmsConfig.Actions {
+		actions[action.OnType+"."+action.Name] = true
+	}
+
+	triggers := make(map[string][]Trigger)
+
+	for _, trigger := range cmsConfig.Triggers {
+
+		if !tables[trigger.OnType] {
+			log.Errorf("Trigger [%v] is on unknown table [%v]", trigger.Name, trigger.OnType)
+			continue
+		}
+
+		if !actions[trigger.actionOnType()+"."+trigger.Action] {
+			log.Errorf("Trigger [%v] runs unknown action [%v][%v]", trigger.Name, trigger.actionOnType(), trigger.Action)
+			continue
+		}
+
+		validEvents := len(trigger.Events) > 0
+		for _, event := range trigger.Events {
+			switch strings.ToLower(event) {
+			case TriggerEventCreate, TriggerEventUpdate, TriggerEventDelete:
+			default:
+				validEvents = false
+			}
+		}
+		if !validEvents {
+			log.Errorf("Trigger [%v] has invalid events %v, expected create, update or delete", trigger.Name, trigger.Events)
+			continue
+		}
+
+		log.Infof("Trigger [%v] on %v of [%v] runs [%v][%v]", trigger.Name, trigger.Events, trigger.OnType, trigger.actionOnType(), trigger.Action)
+		triggers[trigger.OnType] = append(triggers[trigger.OnType], trigger)
+	}
+
+	return &TriggerSet{
+		cruds:    cruds,
+		triggers: triggers,
+	}
+}
+
+// SetActionPerformers gives the triggers the performers to run actions with, they are created after the
+// middlewares
+func (ts *TriggerSet) SetActionPerformers(actionPerformers []ActionPerformerInterface) {
+	ts.actionHandlerMap = ActionPerformerMap(actionPerformers)
+}
+
+func (ts *TriggerSet) ListensTo(tableName string, event string) bool {
+	for _, trigger := range ts.triggers[tableName] {
+		if trigger.firesOn(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func triggerDepthOf(req *api2go.Request) int {
+	depth, _ := req.PlainRequest.Context().Value("trigger_depth").(int)
+	return depth
+}
+
+// OnEvent runs the triggers for a change to a row, once the change is stored. A failing trigger does not
+// fail the change, it is logged.
+func (ts *TriggerSet) OnEvent(dr *DbResource, event string, req *api2go.Request, row map[string]interface{}, previous map[string]interface{}) {
+
+	if row == nil {
+		return
+	}
+
+	tableName := dr.model.GetName()
+	depth := triggerDepthOf(req)
+
+	for _, trigger := range ts.triggers[tableName] {
+
+		if !trigger.firesOn(event) {
+			continue
+		}
+
+		if depth >= MaxTriggerDepth {
+			log.Errorf("Not running trigger [%v], the change was made %d triggers deep", trigger.Name, depth)
+			continue
+		}
+
+		err := ts.run(trigger, event, req, row, previous, depth)
+		if err != nil {
+			log.Errorf("Trigger [%v] failed on %v of [%v][%v]: %v", trigger.Name, event, tableName, row["reference_id"], err)
+		}
+	}
+}
+
+func (ts *TriggerSet) run(trigger Trigger, event string, req *api2go.Request, row map[string]interface{}, previous map[string]interface{}, depth int) error {
+
+	triggerContext := map[string]interface{}{
+		"subject":  row,
+		"previous": previous,
+		"event":    event,
+	}
+
+	if trigger.Condition != "" {
+		condition := strings.TrimPrefix(trigger.Condition, "!")
+		result, err := runSandboxedJavascript(condition, triggerContext)
+		if err != nil {
+			return fmt.Errorf("Failed to evaluate condition: %v", err)
+		}
+		if isTrue, ok := result.(bool); !ok || !isTrue {
+			return nil
+		}
+	}
+
+	attributes := make(map[string]interface{})
+	if len(trigger.Attributes) > 0 {
+		mapped, err := buildActionContext(trigger.Attributes, triggerContext)
+		if err != nil {
+			return fmt.Errorf("Failed to map attributes: %v", err)
+		}
+		attributes = mapped.(map[string]interface{})
+	}
+
+	actionRequest := ActionRequest{
+		Type:         trigger.actionOnType(),
+		Action:       trigger.Action,
+		Attributes:   attributes,
+		TriggerDepth: depth + 1,
+	}
+
+	// the action runs on the changed row when it is defined on its table, a deleted row is passed only
+	// through the attributes
+	subjectReferenceId := ""
+	if trigger.actionOnType() == trigger.OnType && event != TriggerEventDelete {
+		subjectReferenceId, _ = row["reference_id"].(string)
+		attributes[trigger.OnType+"_id"] = subjectReferenceId
+	}
+
+	sessionUser, _ := req.PlainRequest.Context().Value("user").(auth.SessionUser)
+	tenant := TenantFromRequest(*req)
+	cruds := *ts.cruds
+
+	if trigger.IsAsync {
+		jobId, err := cruds["job"].EnqueueActionJob(actionRequest, sessionUser, subjectReferenceId, tenant)
+		if err != nil {
+			return err
+		}
+		log.Infof("Trigger [%v] queued job [%v]", trigger.Name, jobId)
+		return nil
+	}
+
+	// triggers are declared by the administrator in the schema files, so the action is not checked with
+	// CanExecuteAction for the user who made the change. It runs as that user, the rows it reads and
+	// writes are still checked with their permissions.
+	_, err := ExecuteActionAs(cruds, ts.actionHandlerMap, actionRequest, sessionUser.UserReferenceId, subjectReferenceId, tenant)
+	return err
+}
+
+// withPreviousRow keeps the row as it was before an update or delete on the request, for the event
+// listeners called after the change
+func withPreviousRow(req *api2go.Request, row map[string]interface{}) {
+	req.PlainRequest = req.PlainRequest.WithContext(context.WithValue(req.PlainRequest.Context(), "trigger_previous", row))
+}
+
+func previousRowOf(req *api2go.Request) map[string]interface{} {
+	row, _ := req.PlainRequest.Context().Value("trigger_previous").(map[string]interface{})
+	return row
+}
@@ -0,0 +1,182 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"net/http/httptest"
+	"testing"
+)
+
+var testTriggerNoteTable = TableInfo{
+	TableName: "note",
+	Columns: []api2go.ColumnInfo{
+		{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(80)"},
+	},
+}
+
+var testTriggerAction = Action{
+	Name:             "record",
+	OnType:           "note",
+	InstanceOptional: true,
+	InFields: []api2go.ColumnInfo{
+		{Name: "title", ColumnName: "title", ColumnType: "label", IsNullable: true},
+	},
+	OutFields: []Outcome{
+		{
+			Type:   "test.record",
+			Method: "EXECUTE",
+			Attributes: map[string]interface{}{
+				"title":   "~title",
+				"note_id": "~note_id",
+			},
+		},
+	},
+}
+
+func newTriggerTestResources(t *testing.T) map[string]*DbResource {
+
+	cruds := newTestDbResources(t, testTriggerNoteTable)
+
+	insertTestRow(t, cruds["note"].db, "user", map[string]interface{}{"name": "admin", "email": "admin@example.com", "reference_id": "admin-1"})
+	insertTestRow(t, cruds["note"].db, "user", map[string]interface{}{"name": "ada", "email": "ada@example.com", "reference_id": "user-2"})
+	insertTestRow(t, cruds["note"].db, "usergroup", map[string]interface{}{"name": "administrators", "reference_id": "usergroup-1"})
+	insertTestRow(t, cruds["note"].db, "note", map[string]interface{}{"title": "run", "reference_id": "note-1", "user_id": 2})
+	insertTestAction(t, cruds["note"].db, testTriggerAction)
+
+	return cruds
+}
+
+func triggerTestRequest(user auth.SessionUser) *api2go.Request {
+	plainRequest := httptest.NewRequest("PATCH", "/api/note/note-1", nil)
+	return &api2go.Request{
+		PlainRequest: plainRequest.WithContext(context.WithValue(plainRequest.Context(), "user", user)),
+	}
+}
+
+func TestNewTriggerSetLeavesOutInvalidTriggers(t *testing.T) {
+
+	cruds := make(map[string]*DbResource)
+	triggers := NewTriggerSet(&CmsConfig{
+		Tables:  []TableInfo{testTriggerNoteTable},
+		Actions: []Action{testTriggerAction},
+		Triggers: []Trigger{
+			{Name: "on_change", OnType: "note", Events: []string{"Create", "update"}, Action: "record"},
+			{Name: "unknown_table", OnType: "missing", Events: []string{"delete"}, Action: "record", ActionOnType: "note"},
+			{Name: "unknown_action", OnType: "note", Events: []string{"delete"}, Action: "missing"},
+			{Name: "unknown_event", OnType: "note", Events: []string{"delete", "read"}, Action: "record"},
+		},
+	}, &cruds)
+
+	cases := []struct {
+		tableName string
+		event     string
+		expected  bool
+	}{
+		{"note", TriggerEventCreate, true},
+		{"note", TriggerEventUpdate, true},
+		{"note", TriggerEventDelete, false},
+		{"missing", TriggerEventDelete, false},
+	}
+	for _, c := range cases {
+		if triggers.ListensTo(c.tableName, c.event) != c.expected {
+			t.Errorf("Expected triggers on %v of [%v] to be %v", c.event, c.tableName, c.expected)
+		}
+	}
+}
+
+func TestTriggerRunsActionAsTheUserWhoMadeTheChange(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+
+	triggers := NewTriggerSet(&CmsConfig{
+		Tables:  []TableInfo{testTriggerNoteTable},
+		Actions: []Action{testTriggerAction},
+		Triggers: []Trigger{
+			{
+				Name:       "record_titles",
+				OnType:     "note",
+				Events:     []string{"update"},
+				Condition:  "!subject.title == 'run'",
+				Action:     "record",
+				Attributes: map[string]interface{}{"title": "~subject.title"},
+			},
+		},
+	}, &cruds)
+	performer := &recordingPerformer{}
+	triggers.SetActionPerformers([]ActionPerformerInterface{performer})
+
+	req := triggerTestRequest(auth.SessionUser{UserId: 2, UserReferenceId: "user-2"})
+	row := map[string]interface{}{"reference_id": "note-1", "title": "run"}
+
+	triggers.OnEvent(cruds["note"], TriggerEventUpdate, req, row, nil)
+	if len(performer.calls) != 1 {
+		t.Fatalf("Expected the action to run once, got %v", performer.calls)
+	}
+	call := performer.calls[0]
+	if call.userReferenceId != "user-2" {
+		t.Errorf("Expected the action to run as the user who made the change, got [%v]", call.userReferenceId)
+	}
+	if call.inFields["title"] != "run" || call.inFields["note_id"] != "note-1" {
+		t.Errorf("Expected the title and the changed row, got %v", call.inFields)
+	}
+
+	triggers.OnEvent(cruds["note"], TriggerEventDelete, req, row, nil)
+	triggers.OnEvent(cruds["note"], TriggerEventUpdate, req, map[string]interface{}{"reference_id": "note-1", "title": "skip"}, nil)
+	if len(performer.calls) != 1 {
+		t.Errorf("Only an update matching the condition should run the action, got %v", performer.calls)
+	}
+}
+
+func TestAsyncTriggerQueuesJobAsTheUserWhoMadeTheChange(t *testing.T) {
+
+	cruds := newTriggerTestResources(t)
+	defer cruds["note"].db.Close()
+
+	secret := "0123456789abcdef0123456789abcdef"
+	err := cruds["job"].configStore.SetConfigValueFor("encryption.secret", secret, "backend")
+	if err != nil {
+		t.Fatalf("Failed to set encryption secret: %v", err)
+	}
+
+	triggers := NewTriggerSet(&CmsConfig{
+		Tables:  []TableInfo{testTriggerNoteTable},
+		Actions: []Action{testTriggerAction},
+		Triggers: []Trigger{
+			{
+				Name:       "record_titles",
+				OnType:     "note",
+				Events:     []string{"create"},
+				Action:     "record",
+				Attributes: map[string]interface{}{"title": "~subject.title"},
+				IsAsync:    true,
+			},
+		},
+	}, &cruds)
+
+	req := triggerTestRequest(auth.SessionUser{UserId: 2, UserReferenceId: "user-2"})
+	triggers.OnEvent(cruds["note"], TriggerEventCreate, req, map[string]interface{}{"reference_id": "note-1", "title": "run"}, nil)
+
+	var stored string
+	err = cruds["job"].db.QueryRowx("select request from job").Scan(&stored)
+	if err != nil {
+		t.Fatalf("Expected a queued job: %v", err)
+	}
+	decrypted, err := Decrypt([]byte(secret), stored)
+	if err != nil {
+		t.Fatalf("Failed to decrypt job request: %v", err)
+	}
+	var payload actionJobPayload
+	err = json.Unmarshal([]byte(decrypted), &payload)
+	if err != nil {
+		t.Fatalf("Failed to read job request: %v", err)
+	}
+	if payload.UserReferenceId != "user-2" || payload.SubjectReferenceId != "note-1" {
+		t.Errorf("Expected the job to run as the user on the created row, got [%v] on [%v]", payload.UserReferenceId, payload.SubjectReferenceId)
+	}
+	if payload.Request.Action != "record" || payload.Request.Attributes["title"] != "run" {
+		t.Errorf("Expected the record action with the title, got %v", payload.Request)
+	}
+}
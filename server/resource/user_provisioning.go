@@ -0,0 +1,228 @@
+package resource
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"time"
+)
+
+var ErrExternalUserNotLinked = errors.New("The user with this email is not linked to the identity provider")
+
+// ProvisionExternalUser returns the user with this email, creating it along with its home usergroup on the first
+// sign in through an external identity provider (ldap, saml). An existing user is only used when its provider
+// column is this provider: users created by signing up locally are never taken over by a directory entry with
+// the same email, an administrator links them by setting their provider. Membership of the managedGroups is then
+// synced to groupNames: the user is added to the groups in groupNames (creating missing groups) and removed from
+// the other managed groups. Groups which are not managed are left alone.
+func (dr *DbResource) ProvisionExternalUser(provider string, email string, name string, groupNames []string, managedGroups []string) (map[string]interface{}, error) {
+
+	var userId int64
+	var userProvider sql.NullString
+	err := dr.db.QueryRowx("select u.id, u.provider from user u where u.email = ?", email).Scan(&userId, &userProvider)
+	if err == sql.ErrNoRows {
+		log.Infof("Creating user [%v] on first sign in through [%v]", email, provider)
+		userId, err = dr.createExternalUser(provider, email, name)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if userProvider.String != provider {
+		log.Errorf("Refusing [%v] sign in for user [%v] which belongs to provider [%v]", provider, email, userProvider.String)
+		return nil, ErrExternalUserNotLinked
+	}
+
+	err = dr.syncUserGroups(userId, groupNames, managedGroups)
+	if err != nil {
+		log.Errorf("Failed to sync usergroups of [%v]: %v", email, err)
+	}
+
+	// role permissions cache the usergroup names
+	rolePermissionCacheLock.Lock()
+	delete(rolePermissionCache, userId)
+	rolePermissionCacheLock.Unlock()
+
+	users, _, err := dr.GetRowsByWhereClause("user", squirrel.Eq{"id": userId})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) < 1 {
+		return nil, errors.New("Failed to load provisioned user")
+	}
+	return users[0], nil
+}
+
+// createExternalUser creates the user and its home usergroup, the same way a new user signing in with
+// an oauth token is set up, without a password so it can only sign in through the provider
+func (dr *DbResource) createExternalUser(provider string, email string, name string) (int64, error) {
+
+	adminUserId, _ := GetAdminUserIdAndUserGroupId(dr.db)
+
+	userReferenceId := uuid.NewV4().String()
+	s, v, err := squirrel.Insert("user").
+		Columns("name", "email", "provider", "reference_id", "permission", "user_id", "created_at").
+		Values(name, email, provider, userReferenceId, auth.DEFAULT_PERMISSION, adminUserId, time.Now()).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		log.Errorf("Failed to create user [%v]: %v", email, err)
+		return 0, err
+	}
+
+	var userId int64
+	err = dr.db.QueryRowx("select id from user where reference_id = ?", userReferenceId).Scan(&userId)
+	if err != nil {
+		return 0, err
+	}
+
+	userGroupId, err := dr.createUserGroup("Home group of " + name)
+	if err != nil {
+		log.Errorf("Failed to create home usergroup of [%v]: %v", email, err)
+		return userId, nil
+	}
+
+	err = dr.addUserToGroup(userId, userGroupId)
+	if err != nil {
+		log.Errorf("Failed to add [%v] to home usergroup: %v", email, err)
+	}
+
+	return userId, nil
+}
+
+func (dr *DbResource) createUserGroup(name string) (int64, error) {
+
+	referenceId := uuid.NewV4().String()
+	s, v, err := squirrel.Insert("usergroup").
+		Columns("name", "reference_id", "permission", "created_at").
+		Values(name, referenceId, auth.DEFAULT_PERMISSION, time.Now()).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		return 0, err
+	}
+
+	var userGroupId int64
+	err = dr.db.QueryRowx("select id from usergroup where reference_id = ?", referenceId).Scan(&userGroupId)
+	return userGroupId, err
+}
+
+func (dr *DbResource) addUserToGroup(userId int64, userGroupId int64) error {
+
+	s, v, err := squirrel.Insert("user_user_id_has_usergroup_usergroup_id").
+		Columns("user_id", "usergroup_id", "reference_id", "permission").
+		Values(userId, userGroupId, uuid.NewV4().String(), auth.DEFAULT_PERMISSION).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
+
+// findUserGroup looks up a mapped usergroup by its reference id, or by its name when the name is unique.
+// Usergroup names are not unique, a name shared by several usergroups is refused instead of picking one.
+func (dr *DbResource) findUserGroup(key string) (int64, bool, error) {
+
+	var userGroupId int64
+	err := dr.db.QueryRowx("select id from usergroup where reference_id = ?", key).Scan(&userGroupId)
+	if err == nil {
+		return userGroupId, true, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	ids := make([]int64, 0)
+	err = dr.db.Select(&ids, "select id from usergroup where name = ?", key)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return ids[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("%d usergroups are named [%v], map the group to a usergroup reference id", len(ids), key)
+	}
+}
+
+func (dr *DbResource) syncUserGroups(userId int64, groupNames []string, managedGroups []string) error {
+
+	if len(managedGroups) == 0 {
+		return nil
+	}
+
+	current := make(map[int64]bool)
+	currentIds := make([]int64, 0)
+	err := dr.db.Select(&currentIds, "select usergroup_id from user_user_id_has_usergroup_usergroup_id where user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+	for _, id := range currentIds {
+		current[id] = true
+	}
+
+	wanted := make(map[int64]bool)
+	for _, name := range groupNames {
+
+		userGroupId, found, err := dr.findUserGroup(name)
+		if err != nil {
+			log.Errorf("Failed to find usergroup [%v]: %v", name, err)
+			continue
+		}
+		if !found {
+			log.Infof("Creating usergroup [%v] for mapped group", name)
+			userGroupId, err = dr.createUserGroup(name)
+			if err != nil {
+				log.Errorf("Failed to create usergroup [%v]: %v", name, err)
+				continue
+			}
+		}
+
+		if wanted[userGroupId] {
+			continue
+		}
+		wanted[userGroupId] = true
+		if current[userGroupId] {
+			continue
+		}
+
+		err = dr.addUserToGroup(userId, userGroupId)
+		if err != nil {
+			log.Errorf("Failed to add user [%v] to usergroup [%v]: %v", userId, name, err)
+		}
+	}
+
+	for _, name := range managedGroups {
+
+		userGroupId, found, err := dr.findUserGroup(name)
+		if err != nil {
+			log.Errorf("Failed to find usergroup [%v]: %v", name, err)
+			continue
+		}
+		if !found || !current[userGroupId] || wanted[userGroupId] {
+			continue
+		}
+
+		s, v, err := squirrel.Delete("user_user_id_has_usergroup_usergroup_id").
+			Where(squirrel.Eq{"user_id": userId, "usergroup_id": userGroupId}).ToSql()
+		if err != nil {
+			return err
+		}
+		_, err = dr.db.Exec(s, v...)
+		if err != nil {
+			log.Errorf("Failed to remove user [%v] from usergroup [%v]: %v", userId, name, err)
+		}
+	}
+
+	return nil
+}
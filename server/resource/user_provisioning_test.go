@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"sort"
+	"testing"
+)
+
+func newProvisioningTestResource(t *testing.T) *DbResource {
+
+	dr := newTestDbResources(t)["user"]
+
+	insertTestRow(t, dr.db, "user", map[string]interface{}{"name": "admin", "email": "admin@example.com", "reference_id": "admin-1"})
+	insertTestRow(t, dr.db, "usergroup", map[string]interface{}{"name": "administrators", "reference_id": "usergroup-1"})
+	insertTestRow(t, dr.db, "usergroup", map[string]interface{}{"name": "staff", "reference_id": "usergroup-2"})
+	insertTestRow(t, dr.db, "usergroup", map[string]interface{}{"name": "staff", "reference_id": "usergroup-3"})
+
+	return dr
+}
+
+func userGroupNames(t *testing.T, dr *DbResource, email string) []string {
+	names := make([]string, 0)
+	err := dr.db.Select(&names, "select ug.reference_id from usergroup ug"+
+		" join user_user_id_has_usergroup_usergroup_id uug on uug.usergroup_id = ug.id"+
+		" join user u on u.id = uug.user_id where u.email = ? and ug.name not like 'Home group of %'", email)
+	if err != nil {
+		t.Fatalf("Failed to read usergroups: %v", err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestProvisionLdapUser(t *testing.T) {
+
+	server := newTestLdapServer(t, testLdapEntries...)
+	defer server.Close()
+
+	config := testLdapConfig(server.Url())
+	config.GroupMapping["cn=staff,ou=groups,dc=example,dc=com"] = "usergroup-3"
+	authenticator := NewLdapAuthenticator(config)
+
+	ldapUser, err := authenticator.Authenticate("ada@example.com", "ada password")
+	if err != nil {
+		t.Fatalf("Failed to sign in: %v", err)
+	}
+
+	dr := newProvisioningTestResource(t)
+	defer dr.db.Close()
+
+	user, err := dr.ProvisionExternalUser("ldap", ldapUser.Email, ldapUser.Name,
+		authenticator.MappedGroups(ldapUser), authenticator.ManagedGroups())
+	if err != nil {
+		t.Fatalf("Failed to provision user: %v", err)
+	}
+	if user["email"] != "ada@example.com" || user["name"] != "Ada" || user["provider"] != "ldap" {
+		t.Errorf("Unexpected user %v", user)
+	}
+
+	var engineeringId string
+	err = dr.db.QueryRowx("select reference_id from usergroup where name = 'engineering'").Scan(&engineeringId)
+	if err != nil {
+		t.Fatalf("The engineering usergroup should have been created: %v", err)
+	}
+
+	// staff is mapped by reference id, the other usergroup with the same name is left alone
+	expected := []string{engineeringId, "usergroup-3"}
+	sort.Strings(expected)
+	groups := userGroupNames(t, dr, "ada@example.com")
+	if len(groups) != 2 || groups[0] != expected[0] || groups[1] != expected[1] {
+		t.Errorf("Expected the engineering and staff usergroups %v, got %v", expected, groups)
+	}
+
+	// ada left the engineers group in the directory
+	_, err = dr.ProvisionExternalUser("ldap", "ada@example.com", "Ada", []string{"usergroup-3"}, authenticator.ManagedGroups())
+	if err != nil {
+		t.Fatalf("Failed to sign in again: %v", err)
+	}
+	if groups = userGroupNames(t, dr, "ada@example.com"); len(groups) != 1 || groups[0] != "usergroup-3" {
+		t.Errorf("Expected only the staff usergroup, got %v", groups)
+	}
+
+	var count int
+	dr.db.QueryRowx("select count(*) from user where email = 'ada@example.com'").Scan(&count)
+	if count != 1 {
+		t.Errorf("Signing in again should not create another user, found %d", count)
+	}
+}
+
+func TestProvisionExternalUserDoesNotTakeOverLocalUsers(t *testing.T) {
+
+	dr := newProvisioningTestResource(t)
+	defer dr.db.Close()
+
+	_, err := dr.ProvisionExternalUser("ldap", "admin@example.com", "admin", nil, nil)
+	if err != ErrExternalUserNotLinked {
+		t.Errorf("A local user should not be signed in through ldap, got %v", err)
+	}
+
+	_, err = dr.ProvisionExternalUser("saml", "ada@example.com", "Ada", nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to provision user: %v", err)
+	}
+	_, err = dr.ProvisionExternalUser("ldap", "ada@example.com", "Ada", nil, nil)
+	if err != ErrExternalUserNotLinked {
+		t.Errorf("A saml user should not be signed in through ldap, got %v", err)
+	}
+
+	// an administrator links the local user
+	dr.db.Exec("update user set provider = 'ldap' where email = 'admin@example.com'")
+	_, err = dr.ProvisionExternalUser("ldap", "admin@example.com", "admin", nil, nil)
+	if err != nil {
+		t.Errorf("A linked user should be signed in through ldap, got %v", err)
+	}
+}
+
+func TestFindUserGroup(t *testing.T) {
+
+	dr := newProvisioningTestResource(t)
+	defer dr.db.Close()
+
+	id, found, err := dr.findUserGroup("usergroup-2")
+	if err != nil || !found || id != 2 {
+		t.Errorf("Expected usergroup 2 by reference id, got %v %v %v", id, found, err)
+	}
+
+	id, found, err = dr.findUserGroup("administrators")
+	if err != nil || !found || id != 1 {
+		t.Errorf("Expected usergroup 1 by its unique name, got %v %v %v", id, found, err)
+	}
+
+	_, found, err = dr.findUserGroup("staff")
+	if err == nil || found {
+		t.Errorf("A name shared by two usergroups should be refused")
+	}
+
+	_, found, err = dr.findUserGroup("missing")
+	if err != nil || found {
+		t.Errorf("Expected no usergroup, got %v %v", found, err)
+	}
+}
@@ -0,0 +1,252 @@
+package resource
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"strings"
+	"time"
+)
+
+// member roles in a usergroup, kept on the user_user_id_has_usergroup_usergroup_id row. The owner of the
+// usergroup row is its owner and does not need a member role.
+const UserGroupMemberRoleMember = "member"
+const UserGroupMemberRoleAdmin = "admin"
+
+const UserGroupInvitationValidity = 7 * 24 * time.Hour
+
+var ErrInvitationExpired = errors.New("Invitation has expired")
+var ErrInvitationUsed = errors.New("Invitation was already answered")
+
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusDeclined = "declined"
+	// a newer invitation was sent to the same email for the same usergroup
+	InvitationStatusRevoked = "revoked"
+)
+
+func IsValidUserGroupMemberRole(role string) bool {
+	return role == UserGroupMemberRoleMember || role == UserGroupMemberRoleAdmin
+}
+
+// NewInvitationToken returns the token sent to the invitee and its hash, only the hash is stored
+func NewInvitationToken() (string, string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, HashInvitationToken(token), nil
+}
+
+func HashInvitationToken(token string) string {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(hash[:])
+}
+
+// GetUserGroupMemberRole returns the role of the user in the usergroup, false if the user is not a member
+func (dr *DbResource) GetUserGroupMemberRole(userId int64, userGroupId int64) (string, bool) {
+
+	var role *string
+	err := dr.db.QueryRowx("select member_role from user_user_id_has_usergroup_usergroup_id where user_id = ? and usergroup_id = ?",
+		userId, userGroupId).Scan(&role)
+	if err != nil {
+		return "", false
+	}
+	if role == nil || *role == "" {
+		return UserGroupMemberRoleMember, true
+	}
+	return *role, true
+}
+
+func (dr *DbResource) GetUserGroupOwnerId(userGroupId int64) int64 {
+	var ownerId *int64
+	err := dr.db.QueryRowx("select user_id from usergroup where id = ?", userGroupId).Scan(&ownerId)
+	if err != nil || ownerId == nil {
+		return 0
+	}
+	return *ownerId
+}
+
+// CanManageUserGroup is true for the administrator, the owner of the usergroup and its members with the admin role
+func (dr *DbResource) CanManageUserGroup(userReferenceId string, userId int64, userGroupId int64) bool {
+
+	if dr.IsAdmin(userReferenceId) {
+		return true
+	}
+
+	if dr.GetUserGroupOwnerId(userGroupId) == userId {
+		return true
+	}
+
+	role, isMember := dr.GetUserGroupMemberRole(userId, userGroupId)
+	return isMember && role == UserGroupMemberRoleAdmin
+}
+
+func (dr *DbResource) SetUserGroupMemberRole(userId int64, userGroupId int64, role string) error {
+
+	s, v, err := squirrel.Update("user_user_id_has_usergroup_usergroup_id").
+		Set("member_role", role).
+		Where(squirrel.Eq{"user_id": userId, "usergroup_id": userGroupId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	return err
+}
+
+func (dr *DbResource) RemoveUserFromGroup(userId int64, userGroupId int64) error {
+
+	s, v, err := squirrel.Delete("user_user_id_has_usergroup_usergroup_id").
+		Where(squirrel.Eq{"user_id": userId, "usergroup_id": userGroupId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(s, v...)
+	if err != nil {
+		return err
+	}
+
+	// role permissions cache the usergroup names
+	rolePermissionCacheLock.Lock()
+	delete(rolePermissionCache, userId)
+	rolePermissionCacheLock.Unlock()
+
+	return nil
+}
+
+// AddUserToGroupWithRole adds the membership, or updates the role if the user is already a member
+func (dr *DbResource) AddUserToGroupWithRole(userId int64, userGroupId int64, role string) error {
+
+	_, isMember := dr.GetUserGroupMemberRole(userId, userGroupId)
+	if !isMember {
+		err := dr.addUserToGroup(userId, userGroupId)
+		if err != nil {
+			return err
+		}
+	}
+
+	rolePermissionCacheLock.Lock()
+	delete(rolePermissionCache, userId)
+	rolePermissionCacheLock.Unlock()
+
+	return dr.SetUserGroupMemberRole(userId, userGroupId, role)
+}
+
+// GetPendingInvitation finds the pending invitation with this token, expired invitations are not returned
+func (dr *DbResource) GetPendingInvitation(token string) (map[string]interface{}, error) {
+
+	var id int64
+	var email string
+	var userGroupId int64
+	var memberRole *string
+	var expiresAt interface{}
+
+	err := dr.db.QueryRowx("select id, email, usergroup_id, member_role, expires_at from usergroup_invitation"+
+		" where token_hash = ? and status = ?", HashInvitationToken(token), InvitationStatusPending).
+		Scan(&id, &email, &userGroupId, &memberRole, &expiresAt)
+	if err != nil {
+		log.Infof("No pending invitation for token: %v", err)
+		return nil, err
+	}
+
+	expiry, ok := ToTime(expiresAt)
+	if !ok || time.Now().After(expiry) {
+		return nil, ErrInvitationExpired
+	}
+
+	role := UserGroupMemberRoleMember
+	if memberRole != nil && IsValidUserGroupMemberRole(*memberRole) {
+		role = *memberRole
+	}
+
+	return map[string]interface{}{
+		"id":           id,
+		"email":        email,
+		"usergroup_id": userGroupId,
+		"member_role":  role,
+	}, nil
+}
+
+// RespondToInvitation moves a pending invitation to the status, it is false if the invitation was answered or
+// revoked in the meantime. The status is only changed while it is pending so an invitation is used once even
+// when two responses race.
+func (dr *DbResource) RespondToInvitation(invitationId int64, status string) (bool, error) {
+	return dr.setInvitationStatus(invitationId, InvitationStatusPending, status)
+}
+
+func (dr *DbResource) setInvitationStatus(invitationId int64, fromStatus string, toStatus string) (bool, error) {
+
+	s, v, err := squirrel.Update("usergroup_invitation").
+		Set("status", toStatus).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": invitationId, "status": fromStatus}).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := dr.db.Exec(s, v...)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count == 1, err
+}
+
+// AcceptInvitation adds the user to the usergroup of the invitation. The invitation is marked accepted first,
+// and put back to pending if the membership cannot be added, so it can be tried again.
+func (dr *DbResource) AcceptInvitation(invitationId int64, userId int64, userGroupId int64, role string) error {
+
+	ok, err := dr.RespondToInvitation(invitationId, InvitationStatusAccepted)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvitationUsed
+	}
+
+	err = dr.AddUserToGroupWithRole(userId, userGroupId, role)
+	if err != nil {
+		_, resetErr := dr.setInvitationStatus(invitationId, InvitationStatusAccepted, InvitationStatusPending)
+		if resetErr != nil {
+			log.Errorf("Failed to reset invitation [%v] to pending: %v", invitationId, resetErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// actionUserAndGroup returns the ids of the signed in user and the usergroup an action is invoked on
+func actionUserAndGroup(cruds map[string]*DbResource, inFieldMap map[string]interface{}) (map[string]interface{}, int64, int64, error) {
+
+	user, ok := inFieldMap["user"].(map[string]interface{})
+	if !ok {
+		return nil, 0, 0, errors.New("Sign in to manage usergroups")
+	}
+
+	userId, err := cruds["user"].GetReferenceIdToId("user", user["reference_id"].(string))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	userGroup, ok := inFieldMap["usergroup"].(map[string]interface{})
+	if !ok {
+		return user, int64(userId), 0, errors.New("No usergroup")
+	}
+
+	userGroupId, err := cruds["usergroup"].GetReferenceIdToId("usergroup", userGroup["reference_id"].(string))
+	if err != nil {
+		return user, int64(userId), 0, err
+	}
+
+	return user, int64(userId), int64(userGroupId), nil
+}
@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+func TestAcceptInvitationOnlyOnce(t *testing.T) {
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	statements := []string{
+		"create table usergroup_invitation (id integer primary key, status varchar(20), updated_at timestamp)",
+		"create table user_user_id_has_usergroup_usergroup_id (id integer primary key, user_id int, usergroup_id int," +
+			" reference_id varchar(40), permission int, member_role varchar(20))",
+		"insert into usergroup_invitation (status) values ('pending')",
+	}
+	for _, statement := range statements {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatalf("Failed to set up the database [%v]: %v", statement, err)
+		}
+	}
+
+	dr := &DbResource{db: db}
+
+	err = dr.AcceptInvitation(1, 7, 3, UserGroupMemberRoleAdmin)
+	if err != nil {
+		t.Fatalf("Failed to accept invitation: %v", err)
+	}
+	if role, isMember := dr.GetUserGroupMemberRole(7, 3); !isMember || role != UserGroupMemberRoleAdmin {
+		t.Errorf("Expected an admin member, got %v %v", role, isMember)
+	}
+
+	if err = dr.AcceptInvitation(1, 8, 3, UserGroupMemberRoleAdmin); err != ErrInvitationUsed {
+		t.Errorf("An accepted invitation should not be used again, got %v", err)
+	}
+	if ok, _ := dr.RespondToInvitation(1, InvitationStatusDeclined); ok {
+		t.Errorf("An accepted invitation should not be declined")
+	}
+	if _, isMember := dr.GetUserGroupMemberRole(8, 3); isMember {
+		t.Errorf("The second user should not have been added")
+	}
+}
+
+func TestMergeDeclaredJoinTable(t *testing.T) {
+
+	declared := TableInfo{
+		TableName: "user_user_id_has_usergroup_usergroup_id",
+		Columns: []api2go.ColumnInfo{
+			{ColumnName: "member_role"},
+			{ColumnName: "user_id"},
+		},
+		FieldPermissions: []FieldPermission{{ColumnName: "member_role", WritableBy: []string{"admin"}}},
+	}
+	tables := []TableInfo{{TableName: "user"}, declared}
+
+	joinTable := TableInfo{
+		TableName:   "user_user_id_has_usergroup_usergroup_id",
+		IsJoinTable: true,
+		Columns: []api2go.ColumnInfo{
+			{ColumnName: "user_id", IsForeignKey: true},
+			{ColumnName: "usergroup_id", IsForeignKey: true},
+		},
+	}
+
+	tables = mergeDeclaredJoinTable(tables, &joinTable)
+
+	if len(tables) != 1 || tables[0].TableName != "user" {
+		t.Errorf("The declaration should have been replaced, got %v", tables)
+	}
+	if len(joinTable.Columns) != 3 || joinTable.Columns[2].ColumnName != "member_role" || !joinTable.Columns[0].IsForeignKey {
+		t.Errorf("Expected the generated columns and member_role, got %v", joinTable.Columns)
+	}
+	if len(joinTable.FieldPermissions) != 1 {
+		t.Errorf("Expected the field permissions of the declaration, got %v", joinTable.FieldPermissions)
+	}
+
+	// a join table loaded from an earlier start stays
+	existing := joinTable
+	tables = mergeDeclaredJoinTable([]TableInfo{existing}, &TableInfo{TableName: existing.TableName})
+	if len(tables) != 1 {
+		t.Errorf("An existing join table should be kept, got %v", tables)
+	}
+}
@@ -0,0 +1,497 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/Masterminds/squirrel.v1"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookDeliveryPending   = DeliveryPending
+	WebhookDeliverySending   = DeliverySending
+	WebhookDeliveryDelivered = DeliveryDelivered
+	WebhookDeliveryFailed    = DeliveryFailed
+)
+
+// the payload is signed with the secret of the webhook, the signature is sent in this header as
+// sha256=<hex hmac>
+const WebhookSignatureHeader = "X-Daptin-Signature"
+const WebhookEventHeader = "X-Daptin-Event"
+const WebhookDeliveryHeader = "X-Daptin-Delivery"
+
+// a failed delivery is tried again after WebhookRetryBackoff, doubled on every attempt up to
+// WebhookMaxRetryBackoff
+const WebhookMaxAttempts = 6
+const WebhookRetryBackoff = 30 * time.Second
+const WebhookMaxRetryBackoff = time.Hour
+
+const WebhookPollInterval = 5 * time.Second
+const WebhookRequestTimeout = 10 * time.Second
+const WebhookWorkerCount = 4
+
+// a delivery still sending after this long was abandoned by an instance which stopped
+const WebhookSendTimeout = 5 * time.Minute
+
+var webhookDeliveries = deliveryTable{
+	name:            "webhook_delivery",
+	maxAttempts:     WebhookMaxAttempts,
+	retryBackoff:    WebhookRetryBackoff,
+	maxRetryBackoff: WebhookMaxRetryBackoff,
+	sendTimeout:     WebhookSendTimeout,
+}
+
+// webhooks are cached, a change to a webhook made on another instance is picked up within this duration
+const WebhookCacheValidity = 30 * time.Second
+
+// only this much of the response body is kept in the delivery log
+const webhookResponseLogLimit = 1000
+
+type webhook struct {
+	Id          int64
+	ReferenceId string
+	Url         string
+	Tables      map[string]bool
+	Events      map[string]bool
+	Owner       auth.SessionUser
+	OwnerTenant *Tenant
+	IsAdmin     bool
+}
+
+// WebhookPayload is the body posted to the webhook url
+type WebhookPayload struct {
+	Id        string                 `json:"id"`
+	Event     string                 `json:"event"`
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+	// the changed columns, for updates
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// SignWebhookPayload is the signature sent with a payload, receivers compute it over the raw body with
+// their copy of the secret
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher records a delivery for every change a webhook asked for, and posts them to the webhook
+// urls in the background. Deliveries are kept in the database, so they survive a restart, and are claimed
+// with a conditional update so several instances can share them.
+type WebhookDispatcher struct {
+	cruds             *map[string]*DbResource
+	restrictedColumns map[string]map[string]bool
+	client            *http.Client
+	lock              sync.RWMutex
+	webhooks          []webhook
+	loadedAt          time.Time
+}
+
+func NewWebhookDispatcher(cmsConfig *CmsConfig, cruds *map[string]*DbResource) *WebhookDispatcher {
+
+	// columns readable only by some users are not sent, the webhook could be owned by anyone who can
+	// read the row
+	restrictedColumns := make(map[string]map[string]bool)
+	for _, table := range cmsConfig.Tables {
+		restrictedColumns[table.TableName] = make(map[string]bool)
+		for _, fieldPermission := range table.FieldPermissions {
+			if len(fieldPermission.ReadableBy) > 0 {
+				restrictedColumns[table.TableName][fieldPermission.ColumnName] = true
+			}
+		}
+	}
+
+	return &WebhookDispatcher{
+		cruds:             cruds,
+		restrictedColumns: restrictedColumns,
+		client:            NewOutboundHttpClient(WebhookRequestTimeout),
+	}
+}
+
+func (wd *WebhookDispatcher) db() *sqlx.DB {
+	return (*wd.cruds)["webhook"].db
+}
+
+func (wd *WebhookDispatcher) invalidate() {
+	wd.lock.Lock()
+	wd.loadedAt = time.Time{}
+	wd.lock.Unlock()
+}
+
+func splitWebhookList(list string) map[string]bool {
+	values := make(map[string]bool)
+	for _, value := range strings.Split(list, ",") {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" {
+			values[value] = true
+		}
+	}
+	return values
+}
+
+func (wd *WebhookDispatcher) getWebhooks() []webhook {
+
+	wd.lock.RLock()
+	if time.Since(wd.loadedAt) < WebhookCacheValidity {
+		defer wd.lock.RUnlock()
+		return wd.webhooks
+	}
+	wd.lock.RUnlock()
+
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	s, v, err := squirrel.Select("id", "reference_id", "url", "table_names", "events", "user_id").
+		From("webhook").
+		Where(squirrel.Eq{"enabled": true}).ToSql()
+	CheckErr(err, "Failed to create webhook select query")
+
+	rows, err := wd.db().Queryx(s, v...)
+	if err != nil {
+		log.Errorf("Failed to load webhooks: %v", err)
+		return wd.webhooks
+	}
+
+	type webhookRow struct {
+		id, userId       sql.NullInt64
+		referenceId, url string
+		tables, events   sql.NullString
+	}
+	webhookRows := make([]webhookRow, 0)
+	for rows.Next() {
+		var row webhookRow
+		err = rows.Scan(&row.id, &row.referenceId, &row.url, &row.tables, &row.events, &row.userId)
+		if err != nil {
+			log.Errorf("Failed to scan webhook: %v", err)
+			continue
+		}
+		webhookRows = append(webhookRows, row)
+	}
+	rows.Close()
+
+	cruds := *wd.cruds
+	webhooks := make([]webhook, 0)
+	for _, row := range webhookRows {
+
+		// webhooks without an owner would get everything, they are not sent
+		if !row.userId.Valid {
+			continue
+		}
+
+		ownerReferenceId, err := cruds["user"].GetIdToReferenceId("user", row.userId.Int64)
+		if err != nil {
+			log.Errorf("Owner of webhook [%v] not found: %v", row.referenceId, err)
+			continue
+		}
+		owner, _, err := cruds["user"].GetSessionUser(ownerReferenceId)
+		if err != nil {
+			log.Errorf("Owner of webhook [%v] not found: %v", row.referenceId, err)
+			continue
+		}
+
+		webhooks = append(webhooks, webhook{
+			Id:          row.id.Int64,
+			ReferenceId: row.referenceId,
+			Url:         row.url,
+			Tables:      splitWebhookList(row.tables.String),
+			Events:      splitWebhookList(row.events.String),
+			Owner:       owner,
+			OwnerTenant: cruds["tenant"].GetTenantOfUser(owner.UserId),
+			IsAdmin:     cruds["user"].IsAdmin(ownerReferenceId),
+		})
+	}
+
+	wd.webhooks = webhooks
+	wd.loadedAt = time.Now()
+	return webhooks
+}
+
+func (wd *WebhookDispatcher) ListensTo(tableName string, event string) bool {
+
+	// changes to the webhooks themselves are seen to refresh the cache
+	if tableName == "webhook" {
+		return true
+	}
+
+	for _, hook := range wd.getWebhooks() {
+		if hook.Tables[tableName] && hook.Events[event] {
+			return true
+		}
+	}
+	return false
+}
+
+// OnEvent records a delivery for each webhook which asked for the change and whose owner can read the row
+func (wd *WebhookDispatcher) OnEvent(dr *DbResource, event string, req *api2go.Request, row map[string]interface{}, previous map[string]interface{}) {
+
+	tableName := dr.model.GetName()
+
+	if tableName == "webhook" {
+		wd.invalidate()
+		return
+	}
+
+	if row == nil {
+		return
+	}
+
+	referenceId, ok := row["reference_id"].(string)
+	if !ok {
+		return
+	}
+
+	// the results passed to the after middlewares are shaped for the user who made the change, the row
+	// is loaded again so the payload is the same for every webhook
+	current := row
+	if event != TriggerEventDelete {
+		var err error
+		current, err = dr.GetReferenceIdToObject(tableName, referenceId)
+		if err != nil {
+			log.Errorf("Failed to load [%v][%v] for webhooks: %v", tableName, referenceId, err)
+			return
+		}
+	}
+
+	data := wd.payloadRow(dr, current)
+
+	var changes []Change
+	if event == TriggerEventUpdate && previous != nil {
+		changes = Diff(wd.payloadRow(dr, previous), data)
+		if len(changes) == 0 {
+			return
+		}
+	}
+
+	requestTenant := TenantFromRequest(*req)
+
+	for _, hook := range wd.getWebhooks() {
+
+		if !hook.Tables[tableName] || !hook.Events[event] {
+			continue
+		}
+
+		if !wd.canReceive(dr, hook, current, requestTenant) {
+			continue
+		}
+
+		err := wd.enqueue(hook, tableName, event, referenceId, data, changes)
+		if err != nil {
+			log.Errorf("Failed to queue delivery of [%v][%v] to webhook [%v]: %v", tableName, referenceId, hook.ReferenceId, err)
+		}
+	}
+}
+
+// payloadRow leaves out the internal id and the columns which are not shown through the api
+func (wd *WebhookDispatcher) payloadRow(dr *DbResource, row map[string]interface{}) map[string]interface{} {
+
+	tableName := dr.model.GetName()
+	columnMap := dr.model.GetColumnMap()
+
+	data := make(map[string]interface{})
+	for key, value := range row {
+		if key == "id" || key == "__type" || wd.restrictedColumns[tableName][key] {
+			continue
+		}
+		if column, ok := columnMap[key]; ok && (column.ExcludeFromApi || column.ColumnType == "password") {
+			continue
+		}
+		data[key] = value
+	}
+	return data
+}
+
+// canReceive checks that the owner of the webhook can read the table and the row, in the tenant the change
+// was made in
+func (wd *WebhookDispatcher) canReceive(dr *DbResource, hook webhook, row map[string]interface{}, requestTenant *Tenant) bool {
+
+	if dr.IsTenantScoped() && (hook.OwnerTenant != nil || !hook.IsAdmin) {
+		if (hook.OwnerTenant == nil) != (requestTenant == nil) {
+			return false
+		}
+		if hook.OwnerTenant != nil && hook.OwnerTenant.Id != requestTenant.Id {
+			return false
+		}
+	}
+
+	if hook.IsAdmin {
+		return true
+	}
+
+	tableName := dr.model.GetName()
+	owner := hook.Owner
+
+	tablePermission := dr.GetObjectPermissionByWhereClause("world", "table_name", tableName)
+	roles := dr.GetRolePermissions(owner)
+	if !tablePermission.CanRead(owner.UserReferenceId, owner.Groups) && !roles.CanOnTable(tableName, auth.ReadStrict) {
+		return false
+	}
+
+	typedRow := make(map[string]interface{})
+	for key, value := range row {
+		typedRow[key] = value
+	}
+	typedRow["__type"] = tableName
+
+	return dr.GetRowPermission(typedRow).CanRead(owner.UserReferenceId, owner.Groups) || roles.CanOnTable(tableName, auth.ReadStrict)
+}
+
+func (wd *WebhookDispatcher) enqueue(hook webhook, tableName string, event string, objectReferenceId string, data map[string]interface{}, changes []Change) error {
+
+	deliveryId := uuid.NewV4().String()
+	now := time.Now()
+
+	payload, err := json.Marshal(WebhookPayload{
+		Id:        deliveryId,
+		Event:     event,
+		Type:      tableName,
+		CreatedAt: now,
+		Data:      data,
+		Changes:   changes,
+	})
+	if err != nil {
+		return err
+	}
+
+	s, v, err := squirrel.Insert("webhook_delivery").
+		Columns("reference_id", "permission", "created_at", "user_id", "webhook_id", "event", "table_name",
+			"object_reference_id", "payload", "status", "attempts", "next_attempt_at").
+		Values(deliveryId, auth.NewPermission(auth.None, auth.None, auth.Read|auth.Delete).IntValue(), now, hook.Owner.UserId, hook.Id, event, tableName,
+			objectReferenceId, string(payload), WebhookDeliveryPending, 0, now).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = wd.db().Exec(s, v...)
+	return err
+}
+
+// Start starts the workers sending the pending deliveries, a slow webhook holds up one worker only
+func (wd *WebhookDispatcher) Start() {
+	OutboxWorker{
+		Name:            "webhook",
+		Workers:         WebhookWorkerCount,
+		PollInterval:    WebhookPollInterval,
+		RequeueInterval: WebhookPollInterval,
+		Next:            wd.sendNextDelivery,
+		Requeue: func() {
+			webhookDeliveries.requeueAbandoned(wd.db())
+		},
+	}.Start()
+}
+
+type webhookDelivery struct {
+	Id         int64          `db:"id"`
+	DeliveryId string         `db:"reference_id"`
+	Event      string         `db:"event"`
+	TableName  string         `db:"table_name"`
+	Payload    string         `db:"payload"`
+	Attempts   int            `db:"attempts"`
+	Url        string         `db:"url"`
+	Secret     sql.NullString `db:"secret"`
+}
+
+// sendNextDelivery claims and sends the next due delivery, it returns false when there is none
+func (wd *WebhookDispatcher) sendNextDelivery() bool {
+
+	db := wd.db()
+	now := time.Now()
+
+	s, v, err := squirrel.Select("d.id", "d.reference_id", "d.event", "d.table_name", "d.payload", "d.attempts", "w.url", "w.secret").
+		From("webhook_delivery d").
+		Join("webhook w on w.id = d.webhook_id").
+		Where(squirrel.Eq{"d.status": WebhookDeliveryPending}).
+		Where(squirrel.Expr("d.next_attempt_at <= ?", now)).
+		OrderBy("d.next_attempt_at").Limit(1).ToSql()
+	CheckErr(err, "Failed to create webhook delivery select query")
+
+	var delivery webhookDelivery
+	err = db.QueryRowx(s, v...).StructScan(&delivery)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("Failed to load webhook deliveries: %v", err)
+		}
+		return false
+	}
+
+	claimed, err := webhookDeliveries.claim(db, delivery.Id, delivery.Attempts)
+	if err != nil {
+		log.Errorf("Failed to claim webhook delivery [%v]: %v", delivery.DeliveryId, err)
+		return false
+	}
+	if !claimed {
+		// another instance got it first
+		return true
+	}
+
+	var statusCode int
+	var responseBody string
+	// the secret is stored encrypted
+	if delivery.Secret.String != "" {
+		delivery.Secret.String, err = wd.decryptSecret(delivery.Secret.String)
+	}
+	if err == nil {
+		statusCode, responseBody, err = wd.send(delivery)
+	}
+	webhookDeliveries.finish(db, delivery.Id, fmt.Sprintf("Webhook delivery [%v]", delivery.DeliveryId), delivery.Attempts+1, statusCode, responseBody, err)
+
+	return true
+}
+
+func (wd *WebhookDispatcher) decryptSecret(encrypted string) (string, error) {
+	encryptionSecret, err := (*wd.cruds)["webhook"].configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return "", err
+	}
+	return Decrypt([]byte(encryptionSecret), encrypted)
+}
+
+// send posts the payload, any response other than 2xx is a failure
+func (wd *WebhookDispatcher) send(delivery webhookDelivery) (int, string, error) {
+
+	body := []byte(delivery.Payload)
+
+	request, err := http.NewRequest("POST", delivery.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "daptin-webhook")
+	request.Header.Set(WebhookEventHeader, delivery.TableName+"."+delivery.Event)
+	request.Header.Set(WebhookDeliveryHeader, delivery.DeliveryId)
+	if delivery.Secret.String != "" {
+		request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(delivery.Secret.String, body))
+	}
+
+	response, err := wd.client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if len(responseBody) > webhookResponseLogLimit {
+		responseBody = responseBody[:webhookResponseLogLimit]
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, string(responseBody), fmt.Errorf("Webhook responded with %d", response.StatusCode)
+	}
+
+	return response.StatusCode, string(responseBody), nil
+}
@@ -0,0 +1,180 @@
+package resource
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+
+	payload := `{"id":"delivery-1","event":"update","type":"order","data":{"total":10}}`
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	wd := &WebhookDispatcher{
+		client: server.Client(),
+	}
+
+	delivery := webhookDelivery{
+		DeliveryId: "delivery-1",
+		Event:      "update",
+		TableName:  "order",
+		Payload:    payload,
+		Url:        server.URL,
+		Secret:     sql.NullString{String: "secret", Valid: true},
+	}
+
+	code, body, err := wd.send(delivery)
+	if err != nil {
+		t.Fatalf("Delivery failed: %v", err)
+	}
+	if code != 200 || body != "ok" {
+		t.Errorf("Unexpected response [%v] %v", code, body)
+	}
+
+	if string(receivedBody) != payload {
+		t.Errorf("Payload changed on the way: %v", string(receivedBody))
+	}
+	if received.Header.Get(WebhookSignatureHeader) != SignWebhookPayload("secret", []byte(payload)) {
+		t.Errorf("Invalid signature: %v", received.Header.Get(WebhookSignatureHeader))
+	}
+	if received.Header.Get(WebhookEventHeader) != "order.update" {
+		t.Errorf("Invalid event header: %v", received.Header.Get(WebhookEventHeader))
+	}
+	if received.Header.Get(WebhookDeliveryHeader) != "delivery-1" {
+		t.Errorf("Invalid delivery header: %v", received.Header.Get(WebhookDeliveryHeader))
+	}
+}
+
+func TestWebhookSendFailure(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	wd := &WebhookDispatcher{
+		client: server.Client(),
+	}
+
+	code, _, err := wd.send(webhookDelivery{Url: server.URL, Payload: "{}"})
+	if err == nil {
+		t.Errorf("A 503 response should fail the delivery")
+	}
+	if code != 503 {
+		t.Errorf("Response code should be kept, got %v", code)
+	}
+}
+
+func TestDiffOfRows(t *testing.T) {
+
+	changes := Diff(map[string]interface{}{
+		"name":  "old",
+		"total": 10,
+	}, map[string]interface{}{
+		"name":  "new",
+		"total": 10,
+	})
+
+	if len(changes) != 1 {
+		t.Fatalf("Expected one change, got %v", changes)
+	}
+	if changes[0].Field != `["name"]` || changes[0].OldValue != "old" || changes[0].NewValue != "new" {
+		t.Errorf("Unexpected change: %v", changes[0])
+	}
+}
+
+func TestIsDisallowedIp(t *testing.T) {
+
+	disallowed := []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fe80::1", "fd00::1", "100.64.0.1"}
+	for _, address := range disallowed {
+		if !IsDisallowedIp(net.ParseIP(address)) {
+			t.Errorf("[%v] should not be allowed", address)
+		}
+	}
+
+	allowed := []string{"93.184.216.34", "8.8.8.8", "2606:4700:4700::1111", "172.32.0.1"}
+	for _, address := range allowed {
+		if IsDisallowedIp(net.ParseIP(address)) {
+			t.Errorf("[%v] should be allowed", address)
+		}
+	}
+}
+
+func TestCheckOutboundUrl(t *testing.T) {
+
+	cases := map[string]bool{
+		"https://93.184.216.34/hook":     true,
+		"http://127.0.0.1:8080/hook":     false,
+		"http://[::1]/hook":              false,
+		"http://169.254.169.254/latest/": false,
+		"http://localhost/hook":          false,
+		"ftp://93.184.216.34/hook":       false,
+		"file:///etc/passwd":             false,
+		"https:///hook":                  false,
+	}
+
+	for rawUrl, allowed := range cases {
+		if err := CheckOutboundUrl(rawUrl); (err == nil) != allowed {
+			t.Errorf("[%v]: expected allowed %v, got %v", rawUrl, allowed, err)
+		}
+	}
+}
+
+// the address is checked when connecting, a name which resolved to a public address when the webhook was
+// created can point to a private one later
+func TestOutboundHttpClientRefusesPrivateAddresses(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	_, err := NewOutboundHttpClient(time.Second).Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), ErrDisallowedAddress.Error()) {
+		t.Errorf("A request to a loopback address should be refused, got %v", err)
+	}
+}
+
+func TestOutboundAllowedNetworks(t *testing.T) {
+
+	SetOutboundAllowedNetworks("10.1.0.0/16, not a network,127.0.0.1/32")
+	defer SetOutboundAllowedNetworks("")
+
+	cases := map[string]bool{
+		"10.1.2.3":  false,
+		"10.2.0.1":  true,
+		"127.0.0.1": false,
+		"127.0.0.2": true,
+		"0.0.0.0":   true,
+	}
+	for address, disallowed := range cases {
+		if IsDisallowedIp(net.ParseIP(address)) != disallowed {
+			t.Errorf("[%v]: expected disallowed %v", address, disallowed)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	response, err := NewOutboundHttpClient(time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("A request to an allowed network should be sent, got %v", err)
+	}
+	response.Body.Close()
+}
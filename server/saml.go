@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/crewjam/saml"
+	"github.com/daptin/daptin/server/resource"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/gin-gonic/gin.v1"
+	"net/http"
+)
+
+const samlRequestCookieName = "daptin_saml_request"
+
+// CreateSamlMetadataHandler serves the service provider metadata, to be registered at the identity provider
+func CreateSamlMetadataHandler(cruds map[string]*resource.DbResource) func(c *gin.Context) {
+	return func(c *gin.Context) {
+
+		connection, err := resource.GetSamlConnection(c.Param("name"), cruds["saml_connect"])
+		if err != nil {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		metadata, err := xml.MarshalIndent(connection.ServiceProvider.Metadata(), "", "  ")
+		if err != nil {
+			log.Errorf("Failed to create saml metadata: %v", err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		c.Data(200, "application/samlmetadata+xml", metadata)
+	}
+}
+
+// CreateSamlLoginHandler starts a sign in by redirecting the browser to the identity provider with a
+// signed authentication request
+func CreateSamlLoginHandler(configStore *resource.ConfigStore, cruds map[string]*resource.DbResource) func(c *gin.Context) {
+	return func(c *gin.Context) {
+
+		name := c.Param("name")
+		connection, err := resource.GetSamlConnection(name, cruds["saml_connect"])
+		if err != nil {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		sp := connection.ServiceProvider
+		authnRequest, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding))
+		if err != nil {
+			log.Errorf("Failed to create saml authentication request for [%v]: %v", name, err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
+		requestToken, err := resource.NewSamlRequestToken(name, authnRequest.ID, []byte(secret))
+		if err != nil {
+			log.Errorf("Failed to sign saml request: %v", err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		// the identity provider posts the response from its own site, so the cookie has to be sent on cross
+		// site requests, which browsers only do for secure cookies. TLS is often ended at a proxy in front of
+		// daptin, so c.Request.TLS does not say whether the browser uses https.
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     samlRequestCookieName,
+			Value:    requestToken,
+			Path:     "/saml/" + name,
+			MaxAge:   int(resource.SamlRequestValidity.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		})
+
+		c.Redirect(302, authnRequest.Redirect("").String())
+	}
+}
+
+// CreateSamlAcsHandler takes the response posted back by the identity provider. The signature, audience,
+// validity and the request it answers are checked before the user is provisioned and signed in with the
+// same jwt token as a password sign in.
+func CreateSamlAcsHandler(configStore *resource.ConfigStore, cruds map[string]*resource.DbResource) func(c *gin.Context) {
+	return func(c *gin.Context) {
+
+		name := c.Param("name")
+		connection, err := resource.GetSamlConnection(name, cruds["saml_connect"])
+		if err != nil {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		secret, _ := configStore.GetConfigValueFor("jwt.secret", "backend")
+
+		possibleRequestIds := make([]string, 0)
+		requestCookie, err := c.Request.Cookie(samlRequestCookieName)
+		if err == nil {
+			requestId, err := resource.ParseSamlRequestToken(requestCookie.Value, name, []byte(secret))
+			if err == nil {
+				possibleRequestIds = append(possibleRequestIds, requestId)
+			}
+		}
+
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     samlRequestCookieName,
+			Path:     "/saml/" + name,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		})
+
+		assertion, err := connection.ServiceProvider.ParseResponse(c.Request, possibleRequestIds)
+		if err != nil {
+			if invalidResponse, ok := err.(*saml.InvalidResponseError); ok {
+				log.Errorf("Invalid saml response for [%v]: %v", name, invalidResponse.PrivateErr)
+			} else {
+				log.Errorf("Invalid saml response for [%v]: %v", name, err)
+			}
+			c.AbortWithStatus(403)
+			return
+		}
+
+		samlUser, err := connection.UserFromAssertion(assertion)
+		if err != nil {
+			log.Errorf("Failed to read user from saml assertion for [%v]: %v", name, err)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		user, err := cruds["user"].ProvisionExternalUser("saml", samlUser.Email, samlUser.Name,
+			connection.MappedGroups(samlUser), connection.ManagedGroups())
+		if err != nil {
+			log.Errorf("Failed to provision saml user [%v]: %v", samlUser.Email, err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		// the identity provider vouches for the password only, a locked account and the second factor are
+		// checked here the same way as for a password sign in
+		if resource.AccountLoginWait(user) > 0 {
+			log.Infof("Refusing saml sign in of locked user [%v]", samlUser.Email)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		userId := user["id"].(int64)
+		if resource.IsTruthy(user["otp_enabled"]) {
+			challenge, err := resource.NewOtpChallengeToken(samlUser.Email, []byte(secret))
+			if err != nil {
+				log.Errorf("Failed to sign otp challenge: %v", err)
+				c.AbortWithStatus(500)
+				return
+			}
+			challengeJson, _ := json.Marshal(challenge)
+
+			// same as the client.store.set and client.redirect responses of sign in for a user with otp
+			c.Data(200, "text/html; charset=utf-8", []byte(fmt.Sprintf(`<!DOCTYPE html>
+<html><head><title>Signing in</title></head><body><script>
+window.localStorage.setItem("otp_challenge", %s);
+window.location = "/act/user/signin_2fa";
+</script></body></html>`, challengeJson)))
+			return
+		}
+		if cruds["user"].IsOtpRequiredForUser(userId) {
+			// enrolling needs the authenticator key shown to the user, which is done on a password sign in
+			log.Infof("Refusing saml sign in of [%v], two factor authentication is required but not set up", samlUser.Email)
+			c.AbortWithStatus(403)
+			return
+		}
+
+		token, err := resource.CreateJwtToken(user, []byte(secret))
+		if err != nil {
+			c.AbortWithStatus(500)
+			return
+		}
+
+		tokenJson, _ := json.Marshal(token)
+
+		// the dashboard keeps the token in local storage, same as the client.store.set response of sign in
+		c.Data(200, "text/html; charset=utf-8", []byte(fmt.Sprintf(`<!DOCTYPE html>
+<html><head><title>Signing in</title></head><body><script>
+var token = %s;
+window.localStorage.setItem("token", token);
+window.localStorage.setItem("user", atob(token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/")));
+window.location = "/";
+</script></body></html>`, tokenJson)))
+	}
+}
@@ -78,6 +78,11 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 				existableTable.AddRelation(tableBeingModified.Relations...)
 				//existableTable.Relations = append(existableTable.Relations, tableBeingModified.Relations...)
 			}
+			if tableBeingModified.IsTenantScoped {
+				existableTable.IsTenantScoped = true
+			}
+			// field permissions come from the schema, changes to them apply to tables created before
+			existableTable.FieldPermissions = tableBeingModified.FieldPermissions
 			existingTables[j] = existableTable
 		}
 		allTables = append(allTables, existableTable)
@@ -96,6 +101,7 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	fs.Config.LogLevel = 200
 	fs.Config.StatsLogLevel = 200
 
+	resource.CheckTenantColumns(&initConfig)
 	resource.CheckRelations(&initConfig, db)
 	resource.CheckAuditTables(&initConfig, db)
 
@@ -109,6 +115,7 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	resource.UpdateWorldColumnTable(&initConfig, db)
 	resource.UpdateStateMachineDescriptions(&initConfig, db)
 	resource.UpdateExchanges(&initConfig, db)
+	resource.LoadRestExchanges(&initConfig)
 	resource.UpdateStreams(&initConfig, db)
 	resource.UpdateMarketplaces(&initConfig, db)
 
@@ -141,13 +148,24 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	resource.CheckError(err, "Failed to get config store")
 	err = CheckSystemSecrets(configStore)
 	resource.CheckErr(err, "Failed to initialise system secrets")
-
-	r.GET("/config", CreateConfigHandler(configStore))
+	resource.CheckLdapConfig(configStore)
+	resource.CheckOutboundAllowedNetworks(configStore)
+	err = resource.CheckSamlServiceProviderKey(configStore)
+	resource.CheckErr(err, "Failed to initialise saml service provider key")
 
 	authMiddleware := auth.NewAuthMiddlewareBuilder(db)
 	auth.InitJwtMiddleware([]byte(jwtSecret))
 	r.Use(authMiddleware.AuthCheckMiddleware)
 
+	tenantResolver := resource.NewTenantResolver(db)
+	r.Use(tenantResolver.TenantMiddleware)
+
+	idempotencyStore := resource.NewIdempotencyStore(db, configStore)
+	idempotencyStore.Start()
+	r.Use(idempotencyStore.IdempotencyMiddleware)
+
+	r.GET("/config", CreateConfigHandler(configStore))
+
 	r.GET("/actions", resource.CreateGuestActionListHandler(&initConfig, cruds))
 
 	api := api2go.NewAPIWithRouting(
@@ -156,7 +174,10 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 		gingonic.New(r),
 	)
 
-	ms := BuildMiddlewareSet(&initConfig)
+	triggers := resource.NewTriggerSet(&initConfig, &cruds)
+	webhooks := resource.NewWebhookDispatcher(&initConfig, &cruds)
+	exchangeOutbox := resource.NewExchangeOutbox(&initConfig, &cruds)
+	ms := BuildMiddlewareSet(&initConfig, []resource.EventListener{triggers, webhooks, resource.NewScheduleListener()}, exchangeOutbox)
 	cruds = AddResourcesToApi2Go(api, initConfig.Tables, db, &ms, configStore)
 
 	streamProcessors := GetStreamProcessors(&initConfig, configStore, cruds)
@@ -170,6 +191,7 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	authMiddleware.SetUserCrud(cruds["user"])
 	authMiddleware.SetUserGroupCrud(cruds["usergroup"])
 	authMiddleware.SetUserUserGroupCrud(cruds["user_user_id_has_usergroup_usergroup_id"])
+	authMiddleware.SetAdminCheck(cruds["user"].IsAdmin)
 
 	fsmManager := resource.NewFsmManager(db, cruds)
 
@@ -191,6 +213,7 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	r.OPTIONS("/recline_model", modelHandler)
 
 	actionPerformers := GetActionPerformers(&initConfig, configStore)
+	triggers.SetActionPerformers(actionPerformers)
 	//actionPerforMap := make(map[string]resource.ActionPerformerInterface)
 	//for _, actionPerformer := range actionPerformers {
 	//	actionPerforMap[actionPerformer.Name()] = actionPerformer
@@ -200,6 +223,24 @@ func Main(boxRoot, boxStatic http.FileSystem, db *sqlx.DB, wg *sync.WaitGroup, l
 	r.POST("/action/:typename/:actionName", resource.CreatePostActionHandler(&initConfig, configStore, cruds, actionPerformers))
 	r.GET("/action/:typename/:actionName", resource.CreatePostActionHandler(&initConfig, configStore, cruds, actionPerformers))
 
+	jobQueue := resource.NewJobQueue(cruds, actionPerformers)
+	jobQueue.Start()
+
+	actionScheduler := resource.NewActionScheduler(cruds, actionPerformers)
+	actionScheduler.Start()
+
+	webhooks.Start()
+	exchangeOutbox.Start()
+
+	r.GET("/job/:referenceId", resource.CreateJobStatusHandler(cruds))
+	r.GET("/job/:referenceId/stream", resource.CreateJobStreamHandler(cruds))
+
+	r.POST("/webhook/:name", resource.CreateIncomingWebhookHandler(cruds, actionPerformers))
+
+	r.GET("/saml/:name/metadata", CreateSamlMetadataHandler(cruds))
+	r.GET("/saml/:name/login", CreateSamlLoginHandler(configStore, cruds))
+	r.POST("/saml/:name/acs", CreateSamlAcsHandler(configStore, cruds))
+
 	r.POST("/track/start/:stateMachineId", CreateEventStartHandler(fsmManager, cruds, db))
 	r.POST("/track/event/:typename/:objectStateId/:eventName", CreateEventHandler(&initConfig, fsmManager, cruds, db))
 
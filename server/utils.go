@@ -48,6 +48,17 @@ func AddResourcesToApi2Go(api *api2go.API, tables []resource.TableInfo, db *sqlx
 
 		res := resource.NewDbResource(model, db, ms, cruds, configStore)
 
+		if table.RowSecurityPolicy != "" {
+			policy, err := resource.CompileRowSecurityPolicy(table.TableName, table.RowSecurityPolicy, table.Columns)
+			if err != nil {
+				log.Errorf("Invalid row security policy [%v] on [%v], no rows will be readable: %v", table.RowSecurityPolicy, table.TableName, err)
+				policy = resource.DenyAllRowSecurityPolicy(table.TableName)
+			}
+			res.SetRowSecurityPolicy(policy)
+		}
+		res.SetTenantScoped(table.IsTenantScoped)
+		res.SetFieldPermissions(table.FieldPermissions)
+
 		cruds[table.TableName] = res
 		api.AddResource(model, res)
 	}
@@ -105,20 +116,22 @@ func GetTablesFromWorld(db *sqlx.DB) ([]resource.TableInfo, error) {
 
 }
 
-func BuildMiddlewareSet(cmsConfig *resource.CmsConfig) resource.MiddlewareSet {
+func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, eventListeners []resource.EventListener, exchangeOutbox *resource.ExchangeOutbox) resource.MiddlewareSet {
 
 	var ms resource.MiddlewareSet
 
-	exchangeMiddleware := resource.NewExchangeMiddleware(cmsConfig, &cruds)
+	exchangeMiddleware := resource.NewExchangeMiddleware(cmsConfig, &cruds, exchangeOutbox)
 
 	tablePermissionChecker := &resource.TableAccessPermissionChecker{}
 	objectPermissionChecker := &resource.ObjectAccessPermissionChecker{}
 	dataValidationMiddleware := resource.NewDataValidationMiddleware(cmsConfig, &cruds)
+	fieldPermissionMiddleware := resource.NewFieldPermissionMiddleware(cmsConfig)
+	impersonationAuditMiddleware := resource.NewImpersonationAuditMiddleware()
 
 	findOneHandler := resource.NewFindOneEventHandler()
-	createEventHandler := resource.NewCreateEventHandler()
-	updateEventHandler := resource.NewUpdateEventHandler()
-	deleteEventHandler := resource.NewDeleteEventHandler()
+	createEventHandler := resource.NewCreateEventHandler(eventListeners)
+	updateEventHandler := resource.NewUpdateEventHandler(eventListeners)
+	deleteEventHandler := resource.NewDeleteEventHandler(eventListeners)
 
 	ms.BeforeFindAll = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
@@ -128,18 +141,22 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig) resource.MiddlewareSet {
 	ms.AfterFindAll = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 	}
 
 	ms.BeforeCreate = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 		dataValidationMiddleware,
 		createEventHandler,
 	}
 	ms.AfterCreate = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 		createEventHandler,
+		impersonationAuditMiddleware,
 		exchangeMiddleware,
 	}
 
@@ -147,6 +164,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig) resource.MiddlewareSet {
 		tablePermissionChecker,
 		objectPermissionChecker,
 		deleteEventHandler,
+		impersonationAuditMiddleware,
 	}
 	ms.AfterDelete = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
@@ -157,13 +175,17 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig) resource.MiddlewareSet {
 	ms.BeforeUpdate = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 		dataValidationMiddleware,
 		updateEventHandler,
 	}
 	ms.AfterUpdate = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 		updateEventHandler,
+		impersonationAuditMiddleware,
+		exchangeMiddleware,
 	}
 
 	ms.BeforeFindOne = []resource.DatabaseRequestInterceptor{
@@ -174,6 +196,7 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig) resource.MiddlewareSet {
 	ms.AfterFindOne = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
 		objectPermissionChecker,
+		fieldPermissionMiddleware,
 		findOneHandler,
 	}
 	return ms